@@ -1,10 +1,16 @@
 package consolekit
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
 
+	"github.com/kballard/go-shellquote"
 	"github.com/spf13/cobra"
 )
 
@@ -26,16 +32,44 @@ func AddAlias(exec *CommandExecutor) func(cmd *cobra.Command) {
 			Use:     "add [alias] [command]",
 			Short:   "Add a new alias",
 			Aliases: []string{"a"},
-			Long:    `Add a new alias to the system.`,
-			Args:    cobra.ExactArgs(2),
-			Run: func(cmd *cobra.Command, args []string) {
+			Long: `Add a new alias to the system.
+
+An alias replaces the first word of a command. Without placeholders, the
+arguments follow its value. With placeholders, they are put in their place:
+  $1 .. $9        - An argument; the alias fails if it is missing
+  ${1:-default}   - An argument, or default if it is missing
+  $@              - All the arguments
+Placeholders in single quotes are left alone. An alias may start with another
+alias, which is expanded in turn.
+
+Examples:
+  alias add ll "ls -la"
+  alias add deploy 'run deploy.run --env $1 --tag ${2:-latest}'
+  alias add each 'for x in $@ do "print item @x"'
+
+In a remote session (SSH, WebSocket, socket) the alias is local to the session
+unless --global is given. Global aliases are saved to the aliases file.`,
+			Args:         cobra.ExactArgs(2),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				global, _ := cmd.Flags().GetBool("global")
+				if s := remoteSession(cmd.Context()); s != nil && !global {
+					s.Aliases.Set(args[0], args[1])
+					cmd.Printf("Setting session alias, `%s` command: `%s`\n", args[0], args[1])
+					return nil
+				}
+				if err := exec.authorizeUse(cmd.Context(), "alias add --global", "aliases.manage"); err != nil {
+					return err
+				}
+
 				exec.aliases.Set(args[0], args[1])
 				err := exec.SaveAliases()
 				if err != nil {
 					cmd.Printf("error saving aliases, %v\n", err)
-					return
+					return nil
 				}
 				cmd.Printf("Setting alias, `%s` command: `%s`\n", args[0], args[1])
+				return nil
 			},
 		}
 
@@ -65,19 +99,19 @@ func AddAlias(exec *CommandExecutor) func(cmd *cobra.Command) {
 			Args:    cobra.MaximumNArgs(1),
 			Run: func(cmd *cobra.Command, args []string) {
 				if len(args) == 0 {
-					if exec.aliases.Len() == 0 {
+					aliases := exec.VisibleAliases(cmd.Context())
+					if len(aliases) == 0 {
 						cmd.Printf("No aliases defined\n")
 						return
 					}
 					cmd.Printf("Aliases:\n----------------------------------------\n")
-					exec.aliases.ForEach(func(k string, v string) bool {
-						cmd.Printf("%s=%s\n", k, v)
-						return false
-					})
+					for _, k := range sortedKeys(aliases) {
+						cmd.Printf("%s=%s\n", k, aliases[k])
+					}
 					return
 				}
 				alias := args[0]
-				value, ok := exec.aliases.Get(alias)
+				value, ok := exec.GetAlias(cmd.Context(), alias)
 				if !ok {
 					cmd.Printf("alias `%s` not found\n", alias)
 					return
@@ -101,21 +135,37 @@ func AddAlias(exec *CommandExecutor) func(cmd *cobra.Command) {
 
 		// aliasDeleteCmd alias delete subcommand
 		var aliasDeleteCmd = &cobra.Command{
-			Use:     "delete [alias]",
-			Aliases: []string{"del"},
-			Short:   "Delete an alias",
-			Long:    `Delete an existing alias from the system.`,
-			Args:    cobra.ExactArgs(1),
-			Run: func(cmd *cobra.Command, args []string) {
+			Use:          "delete [alias]",
+			Aliases:      []string{"del"},
+			Short:        "Delete an alias",
+			Long:         `Delete an existing alias from the system.`,
+			Args:         cobra.ExactArgs(1),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
 				alias := args[0]
+				global, _ := cmd.Flags().GetBool("global")
+				if s := remoteSession(cmd.Context()); s != nil && !global {
+					if _, ok := s.Aliases.Get(alias); !ok {
+						cmd.Printf("session alias `%s` not found (use --global for global aliases)\n", alias)
+						return nil
+					}
+					s.Aliases.Delete(alias)
+					cmd.Printf("removed session alias `%s`\n", alias)
+					return nil
+				}
+				if err := exec.authorizeUse(cmd.Context(), "alias delete --global", "aliases.manage"); err != nil {
+					return err
+				}
+
 				cmd.Printf("removing alias `%s`\n", alias)
 				exec.aliases.Delete(alias)
 				err := exec.SaveAliases()
 				if err != nil {
 					cmd.Printf("error saving aliases, %v\n", err)
-					return
+					return nil
 				}
 				cmd.Printf("saved aliases\n")
+				return nil
 			},
 		}
 
@@ -127,10 +177,10 @@ func AddAlias(exec *CommandExecutor) func(cmd *cobra.Command) {
 			Long:    `List all aliases currently available in the system.`,
 			Run: func(cmd *cobra.Command, args []string) {
 				cmd.Printf("Aliases:\n----------------------------------------\n")
-				exec.aliases.SortedForEach(func(k string, v string) bool {
-					cmd.Printf("%s=%s\n", k, v)
-					return false
-				})
+				aliases := exec.VisibleAliases(cmd.Context())
+				for _, k := range sortedKeys(aliases) {
+					cmd.Printf("%s=%s\n", k, aliases[k])
+				}
 			},
 		}
 
@@ -150,10 +200,13 @@ func AddAlias(exec *CommandExecutor) func(cmd *cobra.Command) {
 			},
 		}
 
+		AliasAddCmd.Flags().BoolP("global", "g", false, "Add the alias for all sessions")
+		aliasDeleteCmd.Flags().BoolP("global", "g", false, "Delete a global alias")
+
 		aliasCmd.AddCommand(AliasAddCmd)
 		aliasCmd.AddCommand(aliasDeleteCmd)
 		aliasCmd.AddCommand(aliasDefaultsCmd)
-		aliasCmd.AddCommand(aliasSaveCmd)
+		aliasCmd.AddCommand(RequirePermission(aliasSaveCmd, "aliases.manage"))
 
 		aliasCmd.AddCommand(aliasListCmd)
 		aliasCmd.AddCommand(aliasPrintCmd)
@@ -163,3 +216,196 @@ func AddAlias(exec *CommandExecutor) func(cmd *cobra.Command) {
 
 	}
 }
+
+// maxAliasDepth limits the number of aliases a command expands through.
+const maxAliasDepth = 16
+
+// expandAliases expands the alias that line starts with: the whole line, or its
+// first word. An alias whose value starts with another alias is expanded again;
+// one starting with its own name runs the command of that name. Returns an
+// error for missing arguments and alias cycles.
+func (e *CommandExecutor) expandAliases(ctx context.Context, line string) (string, error) {
+	var chain []string
+	for {
+		name, rest := line, ""
+		value, ok := e.GetAlias(ctx, line)
+		if !ok {
+			idx := strings.IndexAny(line, " \t|>;&<")
+			if idx <= 0 {
+				return line, nil
+			}
+			name, rest = line[:idx], line[idx:]
+			if value, ok = e.GetAlias(ctx, name); !ok {
+				return line, nil
+			}
+		}
+
+		switch {
+		case len(chain) > 0 && chain[len(chain)-1] == name:
+			return line, nil
+		case slices.Contains(chain, name):
+			return "", fmt.Errorf("alias cycle: %s", strings.Join(append(chain, name), " -> "))
+		case len(chain) == maxAliasDepth:
+			return "", fmt.Errorf("alias %s: more than %d nested aliases", chain[0], maxAliasDepth)
+		}
+		chain = append(chain, name)
+
+		var err error
+		line, err = applyAlias(name, value, rest)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// applyAlias returns the command of alias name with the given value, followed
+// by rest, the text after the alias in the command line.
+func applyAlias(name, value, rest string) (string, error) {
+	words, tail := aliasArgs(rest)
+	body, used, err := substituteArgs(name, value, words)
+	if err != nil {
+		return "", err
+	}
+	if !used {
+		// A plain alias: the arguments follow its value
+		return value + rest, nil
+	}
+	if tail = strings.TrimLeft(tail, " \t"); tail != "" {
+		body += " " + tail
+	}
+	return body, nil
+}
+
+// aliasArgs splits the arguments at the start of rest, up to the first
+// unquoted operator, into words as they were typed. It returns the words and
+// the text from the operator on.
+func aliasArgs(rest string) (words []string, tail string) {
+	runes := []rune(rest)
+	var word []rune
+	inSingleQuote, inDoubleQuote, escaped := false, false, false
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !inSingleQuote:
+			escaped = true
+		case c == '\'' && !inDoubleQuote:
+			inSingleQuote = !inSingleQuote
+		case c == '"' && !inSingleQuote:
+			inDoubleQuote = !inDoubleQuote
+		case inSingleQuote || inDoubleQuote:
+		case c == '$' && i+1 < len(runes) && runes[i+1] == '(':
+			// A command substitution is part of the word
+			if end := closingParen(runes, i+1); end != -1 {
+				word = append(word, runes[i:end+1]...)
+				i = end
+				continue
+			}
+		case unicode.IsSpace(c):
+			flush()
+			continue
+		case strings.ContainsRune("|;&<>()", c):
+			flush()
+			return words, string(runes[i:])
+		}
+		word = append(word, c)
+	}
+	flush()
+	return words, ""
+}
+
+// substituteArgs replaces the placeholders of an alias value with the words of
+// its arguments. Outside quotes a word is inserted as typed; inside double
+// quotes its unquoted text is. Reports whether the value had placeholders.
+func substituteArgs(name, value string, words []string) (string, bool, error) {
+	if !strings.Contains(value, "$") {
+		return value, false, nil
+	}
+
+	arg := func(n int, inDoubleQuote bool) (string, bool) {
+		if n < 1 || n > len(words) {
+			return "", false
+		}
+		if !inDoubleQuote {
+			return words[n-1], true
+		}
+		if unquoted, err := shellquote.Split(words[n-1]); err == nil {
+			return doubleQuoteEscaper.Replace(strings.Join(unquoted, " ")), true
+		}
+		return doubleQuoteEscaper.Replace(words[n-1]), true
+	}
+
+	var b strings.Builder
+	used := false
+	inSingleQuote, inDoubleQuote, escaped := false, false, false
+	runes := []rune(value)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !inSingleQuote:
+			escaped = true
+		case c == '\'' && !inDoubleQuote:
+			inSingleQuote = !inSingleQuote
+		case c == '"' && !inSingleQuote:
+			inDoubleQuote = !inDoubleQuote
+		case inSingleQuote || c != '$' || i+1 == len(runes):
+		case runes[i+1] >= '1' && runes[i+1] <= '9':
+			n := int(runes[i+1] - '0')
+			text, ok := arg(n, inDoubleQuote)
+			if !ok {
+				return "", false, fmt.Errorf("alias %s: missing argument $%d", name, n)
+			}
+			b.WriteString(text)
+			used = true
+			i++
+			continue
+		case runes[i+1] == '@':
+			texts := make([]string, len(words))
+			for n := range words {
+				texts[n], _ = arg(n+1, inDoubleQuote)
+			}
+			b.WriteString(strings.Join(texts, " "))
+			used = true
+			i++
+			continue
+		case runes[i+1] == '{':
+			// "${1}" or "${1:-default}"; "${NAME}" is left for expansion
+			end := i + 2
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end == len(runes) {
+				break
+			}
+			inner := string(runes[i+2 : end])
+			num, def, hasDefault := strings.Cut(inner, ":-")
+			n, err := strconv.Atoi(num)
+			if err != nil || n < 1 {
+				break
+			}
+			text, ok := arg(n, inDoubleQuote)
+			if !ok && !hasDefault {
+				return "", false, fmt.Errorf("alias %s: missing argument $%d", name, n)
+			}
+			if !ok {
+				text = def
+			}
+			b.WriteString(text)
+			used = true
+			i = end
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String(), used, nil
+}
@@ -0,0 +1,97 @@
+package consolekit
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExpandAliases(t *testing.T) {
+	exec, err := NewCommandExecutor("test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	aliases := map[string]string{
+		"ll":      "ls -la",
+		"ls":      "ls --color",
+		"deploy":  "run deploy.run --env $1 --tag ${2:-latest}",
+		"greet":   `print "hello $1!"`,
+		"twice":   "print $1 $1",
+		"all":     "print [$@]",
+		"literal": `print '$1' \$1 ${HOME}`,
+		"outer":   "inner $1",
+		"inner":   "print in:$1",
+		"a":       "b",
+		"b":       "c",
+		"c":       "a",
+	}
+	for k, v := range aliases {
+		exec.aliases.Set(k, v)
+	}
+
+	tests := []struct {
+		line    string
+		want    string
+		wantErr string
+	}{
+		{line: "print ll", want: "print ll"},
+		{line: "ll /tmp", want: "ls --color -la /tmp"},
+		{line: "ls", want: "ls --color"},
+		{line: "deploy prod", want: "run deploy.run --env prod --tag latest"},
+		{line: "deploy prod v2 | grep ok", want: "run deploy.run --env prod --tag v2 | grep ok"},
+		{line: `deploy "my env"; print done`, want: `run deploy.run --env "my env" --tag latest ; print done`},
+		{line: "deploy $(print x) @tag", want: "run deploy.run --env $(print x) --tag @tag"},
+		{line: `greet "big 'world'"`, want: `print "hello big 'world'!"`},
+		{line: `greet "say \"hi\""`, want: `print "hello say \"hi\"!"`},
+		{line: "twice x", want: "print x x"},
+		{line: "all", want: "print []"},
+		{line: "all a 'b c'", want: "print [a 'b c']"},
+		{line: "literal x", want: `print '$1' \$1 ${HOME} x`},
+		{line: "outer z", want: "print in:z"},
+		{line: "deploy", wantErr: "alias deploy: missing argument $1"},
+		{line: "outer", wantErr: "alias outer: missing argument $1"},
+		{line: "a", wantErr: "alias cycle: a -> b -> c -> a"},
+	}
+	for _, tt := range tests {
+		got, err := exec.expandAliases(context.Background(), tt.line)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("expandAliases(%q) error = %v, want %q", tt.line, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expandAliases(%q) = %q, %v; want %q", tt.line, got, err, tt.want)
+		}
+	}
+}
+
+func TestAliasExecution(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	executor.aliases.Set("swap", "print $2 $1")
+	executor.aliases.Set("each", `for x in $@ do "print item-@x"`)
+	executor.aliases.Set("need", "print $1")
+
+	executor.Variables.Set("@v", "val")
+	out, err := executor.Execute("each 1 'a b'", nil)
+	if err != nil || out != "item-1\nitem-a b\n" {
+		t.Errorf("each = %q, %v", out, err)
+	}
+
+	// Arguments are expanded after the alias
+	out, err = executor.Execute("swap a @v", nil)
+	if err != nil || out != "val a\n" {
+		t.Errorf("swap a @v = %q, %v", out, err)
+	}
+
+	_, err = executor.Execute("need", nil)
+	if err == nil || ExitStatus(err) != 2 || !strings.Contains(err.Error(), "missing argument $1") {
+		t.Errorf("missing argument: err = %v (status %d)", err, ExitStatus(err))
+	}
+}
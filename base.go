@@ -1,6 +1,8 @@
 package consolekit
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -39,6 +41,15 @@ func AddBaseCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 			if len(args) > 0 {
 				code, _ = strconv.Atoi(args[0])
 			}
+
+			// In a script or function, exit only stops the script
+			if f := frameFrom(cmd.Context()); f != nil {
+				f.exit(code)
+				return
+			}
+			if err := exec.RunHook(cmd.Context(), HookOnExit, cmd.OutOrStdout(), nil); err != nil {
+				cmd.PrintErrln(err)
+			}
 			os.Exit(code)
 
 		} //exitCmdFunc
@@ -46,7 +57,7 @@ func AddBaseCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 			Use:     "exit {code}",
 			Short:   "Exit the program",
 			Aliases: []string{"x", "quit", "q"},
-			Long:    `exit the program`,
+			Long:    "exit the program. In a script, stop the script with the status code instead",
 			Args:    cobra.MaximumNArgs(1),
 			Run:     exitCmdFunc,
 		}
@@ -54,12 +65,8 @@ func AddBaseCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 
 		// print command - display message
 		var printCmdFunc = func(cmd *cobra.Command, args []string) {
-			line := strings.Join(args, " ")
-
-			// Use ExpandVariables instead of ExpandCommand to avoid alias expansion in arguments
-			line = exec.ExpandVariables(cmd, nil, line)
-
-			cmd.Printf("%s\n", line)
+			// The arguments were expanded with the line; single-quoted ones stay literal
+			cmd.Printf("%s\n", strings.Join(args, " "))
 		}
 
 		var printCmd = &cobra.Command{
@@ -90,6 +97,22 @@ func AddBaseCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 func AddNetworkCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return func(rootCmd *cobra.Command) {
 		var httpCmdFunc = func(cmd *cobra.Command, args []string) {
+			jsonBody, _ := cmd.Flags().GetBool("json")
+			if jsonBody {
+				// Only the body, so "let resp=$(http --json url)" stores it
+				data, respCode, err := FetchURLContent(cmd, args[0])
+				if err != nil {
+					cmd.PrintErrf("error fetching url: %v resp code: %d err: %v\n", args[0], respCode, err)
+					return
+				}
+				if !json.Valid([]byte(data)) {
+					cmd.PrintErrf("response of %v is not valid JSON\n", args[0])
+					return
+				}
+				cmd.Println(strings.TrimSpace(data))
+				return
+			}
+
 			cmd.Printf("http call to %s\n", args[0])
 			data, respCode, err := FetchURLContent(cmd, args[0])
 			if err != nil {
@@ -108,6 +131,7 @@ func AddNetworkCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 		httpCmd.Flags().Bool("show-headers", false, "Show HTTP response headers")
 		httpCmd.Flags().Bool("show-status-code", false, "Show HTTP status code")
 		httpCmd.Flags().Bool("show-details", false, "Show detailed HTTP response information")
+		httpCmd.Flags().Bool("json", false, "Print only the response body, which must be JSON")
 
 		rootCmd.AddCommand(httpCmd)
 	}
@@ -137,7 +161,7 @@ func AddTimeCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 					if !quiet {
 						cmd.Printf("Sleeping for %d seconds\n", delay)
 					}
-					time.Sleep(time.Duration(delay) * time.Second)
+					_ = SleepContext(cmd.Context(), time.Duration(delay)*time.Second)
 					return
 				}
 
@@ -165,6 +189,9 @@ func AddTimeCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 
 				for {
 					select {
+					case <-cmd.Context().Done():
+						cmd.Printf("✗ Sleep interrupted after %s\n", HumanizeDuration(time.Since(startTime), false))
+						return
 					case <-done:
 						elapsed := time.Since(startTime)
 						cmd.Printf("✓ Sleep completed - waited %s\n", HumanizeDuration(elapsed, false))
@@ -191,6 +218,7 @@ func AddTimeCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 If the specified time is earlier than the current time, the command will wait until that time on the next day.`,
 			Example: `  wait --time 14:30  # Waits until 2:30 PM today or the next day if past
   wait --time 08:00  # Waits until 8:00 AM`,
+			SilenceUsage: true,
 			RunE: func(cmd *cobra.Command, args []string) error {
 				targetTime, err := cmd.Flags().GetString("time")
 				if err != nil {
@@ -209,7 +237,9 @@ If the specified time is earlier than the current time, the command will wait un
 				}
 
 				cmd.Printf("Waiting until %v\n", next)
-				time.Sleep(time.Until(next))
+				if err := SleepContext(cmd.Context(), time.Until(next)); err != nil {
+					return err
+				}
 
 				cmd.Printf("Time reached!\n")
 				return nil
@@ -224,7 +254,8 @@ If the specified time is earlier than the current time, the command will wait un
 			Short: "Waits until a specified condition is met",
 			Long: `This command waits until a specific condition is met.
 In this example, it waits until a counter reaches or exceeds a target value.`,
-			Example: ` waitfor --target 10 --interval 2  # Waits until counter reaches 10, checking every 2 seconds`,
+			Example:      ` waitfor --target 10 --interval 2  # Waits until counter reaches 10, checking every 2 seconds`,
+			SilenceUsage: true,
 			RunE: func(cmd *cobra.Command, args []string) error {
 				target, err := cmd.Flags().GetInt("target")
 				if err != nil {
@@ -246,7 +277,9 @@ In this example, it waits until a counter reaches or exceeds a target value.`,
 					}
 
 					cmd.Printf("Counter at %d, waiting %d seconds before next check...\n", counter, interval)
-					time.Sleep(time.Duration(interval) * time.Second)
+					if err := SleepContext(cmd.Context(), time.Duration(interval)*time.Second); err != nil {
+						return err
+					}
 					counter++
 				}
 
@@ -300,32 +333,40 @@ repeat --background --count 5 --sleep 1 'client im "uid 11122757" 11122757 hello
 
 				cmdLine := strings.Join(args, " ")
 
-				doExec := func() {
+				doExec := func(ctx context.Context, out io.Writer) {
 					i := 0
 					for count == -1 || i < count {
+						if ctx.Err() != nil {
+							return
+						}
 
-						res, err := exec.Execute(cmdLine, nil)
+						res, err := exec.ExecuteWithContext(ctx, cmdLine, nil)
 						if err != nil {
-							cmd.Printf("Error executing command: %s err: %v\n", cmdLine, err)
+							_, _ = fmt.Fprintf(out, "Error executing command: %s err: %v\n", cmdLine, err)
 							continue
 						}
 
-						cmd.Printf("Result: %s\n", res)
+						_, _ = fmt.Fprintf(out, "Result: %s\n", res)
 
 						if count != -1 {
 							i++
 						}
 						if sleep > 0 {
-							time.Sleep(time.Duration(sleep) * time.Second)
+							_ = SleepContext(ctx, time.Duration(sleep)*time.Second)
 						}
 					}
 				}
 
 				if bg {
-					go doExec()
+					// Tracked as a job: its output is kept for "job <id> logs"
+					id := exec.JobManager.AddFunc(cmd.Context(), "repeat "+cmdLine, func(ctx context.Context, out io.Writer) error {
+						doExec(ctx, out)
+						return nil
+					})
+					cmd.Printf("Started in background (Job ID: %d)\n", id)
 					return nil
 				}
-				doExec()
+				doExec(cmd.Context(), cmd.OutOrStdout())
 				return nil
 			},
 		}
@@ -337,34 +378,47 @@ repeat --background --count 5 --sleep 1 'client im "uid 11122757" 11122757 hello
 
 		// set command - sets a default value for a script param
 		var defaultCmd = &cobra.Command{
-			Use:     "set [token [value] ]",
-			Short:   "set or view default values. If no token is provides, will list all defaults tokens out. If no value is provided, it will print the current default value.",
-			Aliases: []string{"default", "def", "block", "set"},
+			Use:   "set [token [value] ]",
+			Short: "set or view default values. If no token is provides, will list all defaults tokens out. If no value is provided, it will print the current default value.",
+			Long: `Set or view default values. If no token is provided, lists all default tokens.
+If no value is provided, prints the current default value.
 
-			Run: func(cmd *cobra.Command, args []string) {
+With -o, an existing default is overwritten.
+
+In a script or function, set also changes shell options (see setopt):
+  set -e, set +e  errexit: stop at the first line that fails
+  set -u, set +u  nounset: fail lines that reference undefined variables
+  set -x, set +x  xtrace: echo commands after expansion`,
+			Aliases:      []string{"default", "def", "block", "set"},
+			SilenceUsage: true,
+
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if ok, err := setShellOptions(cmd, args); ok {
+					return err
+				}
 
 				if len(args) == 0 {
-					cmd.Printf("defaults: %d\n", exec.Variables.Len())
-					exec.Variables.ForEach(func(s string, s2 string) bool {
-						cmd.Printf("    %-20s %s\n", s, s2)
-						return false
-					})
-					return
+					vars := exec.VisibleVariables(cmd.Context())
+					cmd.Printf("defaults: %d\n", len(vars))
+					for _, k := range sortedKeys(vars) {
+						cmd.Printf("    %-20s %s\n", k, vars[k])
+					}
+					return nil
 				}
 
 				if len(args) == 1 {
-					val, ok := exec.Variables.Get(args[0])
+					val, ok := exec.GetVariable(cmd.Context(), args[0])
 					if !ok {
 						cmd.Printf("default not found: %s\n", args[0])
-						return
+						return nil
 					}
 					cmd.Printf("default: %s = %s\n", args[0], val)
-					return
+					return nil
 				}
 
 				if strings.HasPrefix(args[0], "@") {
 					cmd.Printf("default cannot start with @\n")
-					return
+					return nil
 				}
 
 				key := args[0]
@@ -378,18 +432,22 @@ repeat --background --count 5 --sleep 1 'client im "uid 11122757" 11122757 hello
 				if overwrite {
 					cmd.Printf("overwriting default: %s\n", key)
 				} else {
-					_, ok := exec.Variables.Get(key)
+					_, ok := exec.GetVariable(cmd.Context(), key)
 					if ok {
 						cmd.Printf("default already set key: %s\n", key)
-						return
+						return nil
 					}
 					cmd.Printf("setting default: %s\n", key)
 				}
 
-				exec.Variables.Set(key, value)
+				exec.SetVariable(cmd.Context(), key, value)
+				return nil
 			},
 		}
 		defaultCmd.Flags().BoolP("overwrite", "o", false, "Overwrite existing default value")
+		defaultCmd.Flags().BoolP(optErrexit, "e", false, "Stop the script at the first line that fails")
+		defaultCmd.Flags().BoolP(optNounset, "u", false, "Fail lines of the script that reference undefined variables")
+		defaultCmd.Flags().BoolP(optXtrace, "x", false, "Echo the commands of the script after expansion")
 
 		// if command - conditional execution
 		var IfCmdFunc = func(cmd *cobra.Command, args []string) {
@@ -401,7 +459,7 @@ repeat --background --count 5 --sleep 1 'client im "uid 11122757" 11122757 hello
 
 			if iff && ifTrue != "" {
 				cmd.Printf("Condition true (%s == %s), running: `%s`\n", args[0], args[1], ifTrue)
-				res, err := exec.Execute(ifTrue, nil)
+				res, err := exec.ExecuteWithContext(cmd.Context(), ifTrue, nil)
 				if err != nil {
 					cmd.Printf("Error executing command: %s err: %v\n", ifTrue, err)
 					return
@@ -414,7 +472,7 @@ repeat --background --count 5 --sleep 1 'client im "uid 11122757" 11122757 hello
 
 			if !iff && ifFalse != "" {
 				cmd.Printf("Condition false (%s != %s), running: `%s`\n", args[0], args[1], ifFalse)
-				res, err := exec.Execute(ifFalse, nil)
+				res, err := exec.ExecuteWithContext(cmd.Context(), ifFalse, nil)
 				if err != nil {
 					cmd.Printf("Error executing command: %s err: %v\n", ifFalse, err)
 					return
@@ -457,11 +515,15 @@ var FetchURLContent = func(cmd *cobra.Command, url string) (string, int, error)
 	showDetails, _ := cmd.Flags().GetBool("show-details")
 	showStatusCode, _ := cmd.Flags().GetBool("show-status-code")
 
-	// Make the HTTP GET request with timeout
+	// Make the HTTP GET request with timeout; cancelling the command aborts it
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 	}
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(cmdContext(cmd), http.MethodGet, url, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid URL: %v err: %v", url, err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to fetch URL: %v err: %v", url, err)
 	}
@@ -23,11 +23,13 @@ func AddAllCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 		// Scripting & Control Flow
 		AddScriptingCmds(exec)(rootCmd)
 		AddControlFlowCmds(exec)(rootCmd)
+		AddFunctionCmds(exec)(rootCmd)
 
 		// OS Integration
 		AddOSExecCmds(exec)(rootCmd)
 		AddJobCmds(exec)(rootCmd)
 		AddScheduleCmds(exec)(rootCmd)
+		AddPluginCmds(exec)(rootCmd)
 
 		// File & Data
 		AddFileUtilCmds(exec)(rootCmd)
@@ -71,6 +73,7 @@ func AddStandardCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 		// Scripting & Control Flow
 		AddScriptingCmds(exec)(rootCmd)
 		AddControlFlowCmds(exec)(rootCmd)
+		AddFunctionCmds(exec)(rootCmd)
 
 		// OS Integration
 		AddOSExecCmds(exec)(rootCmd)
@@ -101,6 +104,7 @@ func AddMinimalCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 		AddVariableCmds(exec)(rootCmd)
 		AddScriptingCmds(exec)(rootCmd)
 		AddControlFlowCmds(exec)(rootCmd)
+		AddFunctionCmds(exec)(rootCmd)
 	}
 }
 
@@ -133,6 +137,7 @@ func AddAutomationCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 		// Scripting
 		AddScriptingCmds(exec)(rootCmd)
 		AddControlFlowCmds(exec)(rootCmd)
+		AddFunctionCmds(exec)(rootCmd)
 		AddTemplateCmds(exec)(rootCmd)
 
 		// OS & Jobs
@@ -182,17 +187,23 @@ func AddConfigCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return AddConfigCommands(exec) // Implemented in configcmds.go
 }
 
-// AddScriptingCmds registers script execution commands: run
+// AddScriptingCmds registers script commands: scripts, test-scripts
 // Note: The run command requires an embed.FS parameter, so applications must call
 // AddRun(exec, scripts) directly when they have embedded scripts.
 func AddScriptingCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
-	// No-op placeholder - run command is added via AddRun(exec, scripts embed.FS)
+	// run is added via AddRun(exec, scripts embed.FS)
 	return func(rootCmd *cobra.Command) {
-		// Applications should call AddRun(exec, scripts) separately
+		AddScriptCommands(exec)(rootCmd)     // Implemented in scriptcmds.go
+		AddScriptTestCommands(exec)(rootCmd) // Implemented in scripttestcmds.go
 	}
 }
 
-// AddControlFlowCmds registers all control flow commands: if, repeat, while, for, case, test
+// AddFunctionCmds registers the func command and user-defined functions
+func AddFunctionCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
+	return AddFunctions(exec) // Implemented in functions.go
+}
+
+// AddControlFlowCmds registers all control flow commands: if, repeat, while, for, case, test, return, trap
 func AddControlFlowCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return func(rootCmd *cobra.Command) {
 		// Basic control flow commands from base.go
@@ -207,6 +218,12 @@ func AddOSExecCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return AddOSExec(exec) // Implemented in exec.go
 }
 
+// AddPluginCmds registers the plugin command and the plugins: executables named
+// <app>-<name> in ~/.<app>/plugins or PATH
+func AddPluginCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
+	return AddPlugins(exec) // Implemented in plugincmds.go
+}
+
 // AddJobCmds registers job management commands: jobs, job, killall, jobclean
 func AddJobCmds(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return AddJobCommands(exec) // Implemented in jobcmds.go
@@ -27,6 +27,7 @@ type SettingsConfig struct {
 	Prompt      string `toml:"prompt"`
 	Color       bool   `toml:"color"`
 	Pager       string `toml:"pager"`
+	ScriptPath  string `toml:"script_path"` // Directories searched for script commands, separated like PATH
 }
 
 // HooksConfig contains lifecycle hooks
@@ -54,12 +55,12 @@ type NotificationConfig struct {
 
 // NewConfig creates a new config with defaults
 func NewConfig(appName string) (*Config, error) {
-	currentUser, err := user.Current()
+	home, err := homeDir()
 	if err != nil {
-		return nil, fmt.Errorf("unable to get current user: %w", err)
+		return nil, fmt.Errorf("unable to get home directory: %w", err)
 	}
 
-	configDir := filepath.Join(currentUser.HomeDir, fmt.Sprintf(".%s", strings.ToLower(appName)))
+	configDir := filepath.Join(home, fmt.Sprintf(".%s", strings.ToLower(appName)))
 	configPath := filepath.Join(configDir, "config.toml")
 
 	config := &Config{
@@ -68,6 +69,7 @@ func NewConfig(appName string) (*Config, error) {
 			Prompt:      "%s > ",
 			Color:       true,
 			Pager:       "less -R",
+			ScriptPath:  filepath.Join(configDir, "scripts"),
 		},
 		Aliases:   make(map[string]string),
 		Variables: make(map[string]string),
@@ -94,6 +96,20 @@ func NewConfig(appName string) (*Config, error) {
 	return config, nil
 }
 
+// homeDir returns the directory of the app's files: $HOME (%USERPROFILE% on
+// Windows) when it is set, so tests can isolate them, or the user's home
+// directory.
+func homeDir() (string, error) {
+	if dir, err := os.UserHomeDir(); err == nil {
+		return dir, nil
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return currentUser.HomeDir, nil
+}
+
 // Load reads the configuration from file
 func (c *Config) Load() error {
 	data, err := os.ReadFile(c.filePath)
@@ -155,6 +171,8 @@ func (c *Config) GetString(path string) (string, error) {
 			return fmt.Sprintf("%t", c.Settings.Color), nil
 		case "pager":
 			return c.Settings.Pager, nil
+		case "script_path":
+			return c.Settings.ScriptPath, nil
 		}
 	case "hooks":
 		switch key {
@@ -222,6 +240,9 @@ func (c *Config) SetString(path string, value string) error {
 		case "pager":
 			c.Settings.Pager = value
 			return nil
+		case "script_path":
+			c.Settings.ScriptPath = value
+			return nil
 		}
 	case "hooks":
 		switch key {
@@ -72,6 +72,7 @@ Actions:
 					cmd.PrintErrf("Error saving config: %v\n", err)
 					return
 				}
+				exec.applyConfigKey(args[0])
 
 				cmd.Printf("Set %s = %s\n", args[0], args[1])
 			},
@@ -129,8 +130,10 @@ Actions:
 					return
 				}
 
-				// Apply configuration
-				applyConfig(exec)
+				// Apply configuration; hooks and the prompt use the new values from now on
+				exec.applyLoggingConfig()
+				exec.applyNotificationConfig()
+				exec.applyConfig()
 
 				cmd.Println("Configuration reloaded")
 			},
@@ -154,6 +157,7 @@ Actions:
 				cmd.Printf("  prompt = %q\n", exec.Config.Settings.Prompt)
 				cmd.Printf("  color = %t\n", exec.Config.Settings.Color)
 				cmd.Printf("  pager = %q\n", exec.Config.Settings.Pager)
+				cmd.Printf("  script_path = %q\n", exec.Config.Settings.ScriptPath)
 
 				if len(exec.Config.Aliases) > 0 {
 					cmd.Println("\n[aliases]")
@@ -233,47 +237,17 @@ Actions:
 		}
 
 		configCmd.AddCommand(getCmd)
-		configCmd.AddCommand(setCmd)
-		configCmd.AddCommand(editCmd)
-		configCmd.AddCommand(reloadCmd)
+		configCmd.AddCommand(RequirePermission(setCmd, "config.manage"))
+		configCmd.AddCommand(RequirePermission(editCmd, "config.manage"))
+		configCmd.AddCommand(RequirePermission(reloadCmd, "config.manage"))
 		configCmd.AddCommand(showCmd)
 		configCmd.AddCommand(pathCmd)
-		configCmd.AddCommand(saveCmd)
+		configCmd.AddCommand(RequirePermission(saveCmd, "config.manage"))
 
 		rootCmd.AddCommand(configCmd)
 	}
 }
 
-// applyConfig applies configuration settings to the CLI
-func applyConfig(exec *CommandExecutor) {
-	if exec.Config == nil {
-		return
-	}
-
-	// Apply aliases from config
-	for k, v := range exec.Config.Aliases {
-		exec.aliases.Set(k, v)
-	}
-
-	// Apply variables from config
-	for k, v := range exec.Config.Variables {
-		if !strings.HasPrefix(k, "@") {
-			k = "@" + k
-		}
-		exec.Variables.Set(k, v)
-	}
-
-	// Apply color setting
-	if !exec.Config.Settings.Color {
-		exec.NoColor = true
-	}
-
-	// Execute startup hook if configured
-	if exec.Config.Hooks.OnStartup != "" {
-		_, _ = exec.Execute(exec.Config.Hooks.OnStartup, nil)
-	}
-}
-
 // syncStateToConfig syncs current CLI state to config
 func syncStateToConfig(exec *CommandExecutor) {
 	if exec.Config == nil {
@@ -0,0 +1,142 @@
+// Package consolekittest helps test consolekit applications: custom commands
+// and the transports that serve them.
+//
+// New creates an executor whose files (config, history, aliases, functions,
+// templates and audit log) are in a temporary home directory, with helpers to
+// run command lines and check their output, error and exit status:
+//
+//	func TestGreet(t *testing.T) {
+//		exec := consolekittest.New(t, "myapp", func(exec *consolekit.CommandExecutor) error {
+//			exec.AddBuiltinCommands()
+//			exec.AddCommands(AddGreetCmd(exec))
+//			return nil
+//		})
+//		exec.AssertOutput("greet bob", "hello bob\n")
+//		exec.AssertStatus("greet --nosuch", 1)
+//	}
+//
+// StartSSH, StartHTTP and StartSocket serve an executor on a loopback
+// listener and drive it with real clients: golang.org/x/crypto/ssh, a
+// gorilla/websocket connection to the web terminal and the NDJSON socket
+// protocol. Servers and clients are closed when the test ends.
+package consolekittest
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/alexj212/consolekit"
+)
+
+// Executor is a CommandExecutor isolated in a temporary home directory, with
+// helpers that run command lines for a test.
+type Executor struct {
+	*consolekit.CommandExecutor
+	t    testing.TB
+	Home string // The temporary home directory, $HOME
+}
+
+// New creates an executor for the test, as consolekit.NewCommandExecutor does,
+// after setting $HOME to a temporary directory for the rest of the test. Like
+// t.Setenv, it can't be used in parallel tests. Background jobs still running
+// are killed when the test ends.
+func New(t testing.TB, appName string, customizer func(*consolekit.CommandExecutor) error) *Executor {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if runtime.GOOS == "windows" {
+		t.Setenv("USERPROFILE", home)
+	}
+
+	exec, err := consolekit.NewCommandExecutor(appName, customizer)
+	if err != nil {
+		t.Fatalf("consolekittest: %v", err)
+	}
+	t.Cleanup(func() { exec.JobManager.KillAll() })
+	return &Executor{CommandExecutor: exec, t: t, Home: home}
+}
+
+// Run runs a command line and returns its output, error and exit status.
+func (x *Executor) Run(line string) *consolekit.ExecutionResult {
+	return x.ExecuteResult(x.t.Context(), line, nil)
+}
+
+// MustRun runs a command line and returns its output. The test stops if the
+// command fails.
+func (x *Executor) MustRun(line string) string {
+	x.t.Helper()
+	res := x.Run(line)
+	if res.Error != nil {
+		x.t.Fatalf("%s: %v\n%s", line, res.Error, res.Output)
+	}
+	return res.Output
+}
+
+// AssertOutput runs a command line and checks its output is want.
+func (x *Executor) AssertOutput(line, want string) *consolekit.ExecutionResult {
+	x.t.Helper()
+	res := x.Run(line)
+	if res.Output != want {
+		x.t.Errorf("%s: output = %q, want %q%s", line, res.Output, want, errorSuffix(res.Error))
+	}
+	return res
+}
+
+// AssertContains runs a command line and checks its output contains each of
+// want.
+func (x *Executor) AssertContains(line string, want ...string) *consolekit.ExecutionResult {
+	x.t.Helper()
+	res := x.Run(line)
+	for _, s := range want {
+		if !strings.Contains(res.Output, s) {
+			x.t.Errorf("%s: output = %q, want it to contain %q%s", line, res.Output, s, errorSuffix(res.Error))
+		}
+	}
+	return res
+}
+
+// AssertStatus runs a command line and checks its exit status is want: 0 on
+// success, consolekit.ExitStatus of its error otherwise.
+func (x *Executor) AssertStatus(line string, want int) *consolekit.ExecutionResult {
+	x.t.Helper()
+	res := x.Run(line)
+	if res.ExitStatus != want {
+		x.t.Errorf("%s: exit status = %d, want %d%s", line, res.ExitStatus, want, errorSuffix(res.Error))
+	}
+	return res
+}
+
+// AssertError runs a command line and checks it fails with an error
+// containing want.
+func (x *Executor) AssertError(line, want string) *consolekit.ExecutionResult {
+	x.t.Helper()
+	res := x.Run(line)
+	if res.Error == nil || !strings.Contains(res.Error.Error(), want) {
+		x.t.Errorf("%s: error = %v, want an error containing %q", line, res.Error, want)
+	}
+	return res
+}
+
+// WriteFile writes a file in the home directory, such as a script for run,
+// and returns its path.
+func (x *Executor) WriteFile(name, content string) string {
+	x.t.Helper()
+	path := filepath.Join(x.Home, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		x.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		x.t.Fatal(err)
+	}
+	return path
+}
+
+func errorSuffix(err error) string {
+	if err == nil {
+		return ""
+	}
+	return " (error: " + err.Error() + ")"
+}
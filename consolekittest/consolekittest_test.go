@@ -0,0 +1,112 @@
+package consolekittest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexj212/consolekit"
+	"github.com/spf13/cobra"
+)
+
+// newExecutor creates an executor with the builtins and a greet command.
+func newExecutor(t *testing.T) *Executor {
+	return New(t, "kit-test", func(exec *consolekit.CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(consolekit.AddRun(exec, nil))
+		exec.AddCommands(func(root *cobra.Command) {
+			root.AddCommand(&cobra.Command{
+				Use:  "greet name",
+				Args: cobra.ExactArgs(1),
+				RunE: func(cmd *cobra.Command, args []string) error {
+					cmd.Printf("hello %s\n", args[0])
+					return nil
+				},
+			})
+		})
+		return nil
+	})
+}
+
+func TestExecutor(t *testing.T) {
+	exec := newExecutor(t)
+
+	if home, _ := os.UserHomeDir(); home != exec.Home {
+		t.Errorf("home = %q, want %q", home, exec.Home)
+	}
+	exec.AssertOutput("greet bob", "hello bob\n")
+	exec.AssertContains("greet bob | grep hello", "hello", "bob")
+	exec.AssertStatus("greet", 1)
+	exec.AssertStatus("test 1 -eq 2", 1)
+	exec.AssertError("nosuchcmd", "unknown command")
+	exec.MustRun("let x=5")
+	if out := exec.MustRun("print @x"); out != "5\n" {
+		t.Errorf("MustRun = %q", out)
+	}
+
+	// Scripts and the files of the executor are in the home directory
+	script := exec.WriteFile("scripts/hi.run", "greet @arg0\n")
+	exec.AssertOutput("run --quiet "+script+" ann", "hello ann\n")
+	exec.MustRun("func hi { greet you }")
+	if _, err := os.Stat(filepath.Join(exec.Home, ".kit-test.functions")); err != nil {
+		t.Errorf("functions file: %v", err)
+	}
+}
+
+func TestSSH(t *testing.T) {
+	srv := StartSSH(t, newExecutor(t).CommandExecutor)
+
+	if out, status := srv.Exec("greet bob"); out != "hello bob\n" || status != 0 {
+		t.Errorf("exec = %q, %d", out, status)
+	}
+	if out, status := srv.Exec("test 1 -eq 2"); status != 1 {
+		t.Errorf("exec test = %q, %d", out, status)
+	}
+
+	sh := srv.Shell()
+	if !strings.Contains(sh.Greeting, "Welcome to kit-test SSH console") {
+		t.Errorf("greeting = %q", sh.Greeting)
+	}
+	// The shell follows the output with the status of the command
+	if out := sh.Run("greet ann"); !strings.HasPrefix(out, "hello ann\n[OK]") {
+		t.Errorf("shell = %q", out)
+	}
+	// Variables last for the session
+	sh.Run("let x=1")
+	if out := sh.Run("print @x"); !strings.HasPrefix(out, "1\n[OK]") {
+		t.Errorf("shell = %q", out)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	srv := StartHTTP(t, newExecutor(t).CommandExecutor)
+
+	ws := srv.Connect()
+	if out, err := ws.Run("greet bob"); out != "hello bob\n" || err != nil {
+		t.Errorf("run = %q, %v", out, err)
+	}
+	if _, err := ws.Run("greet"); err == nil || !strings.Contains(err.Error(), "accepts 1 arg(s)") {
+		t.Errorf("run greet = %v", err)
+	}
+}
+
+func TestSocket(t *testing.T) {
+	srv := StartSocket(t, newExecutor(t).CommandExecutor)
+
+	c := srv.Dial()
+	if resp := c.Run("greet bob"); resp.Output != "hello bob\n" || !resp.Success {
+		t.Errorf("run = %+v", resp)
+	}
+	responses := c.Do(consolekit.SocketRequest{Command: "greet ann", Stream: true})
+	if last := responses[len(responses)-1]; !last.Success || last.Partial {
+		t.Errorf("streamed = %+v", responses)
+	}
+
+	// Other clients need the token
+	other := srv.Dial()
+	other.Token = "wrong"
+	if resp := other.Run("greet bob"); resp.Success || resp.Error != "authentication required" {
+		t.Errorf("wrong token = %+v", resp)
+	}
+}
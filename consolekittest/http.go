@@ -0,0 +1,135 @@
+package consolekittest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexj212/consolekit"
+	"github.com/gorilla/websocket"
+)
+
+// HTTPServer is an HTTPHandler serving an executor on a loopback port.
+type HTTPServer struct {
+	Handler  *consolekit.HTTPHandler
+	URL      string // http://host:port of the server
+	User     string // The login of the web terminal, "test"
+	Password string // "test"
+
+	t testing.TB
+}
+
+// StartHTTP serves exec over HTTP until the test ends, and waits for it to
+// answer. configure, if given, sets the options of the handler before it
+// starts.
+func StartHTTP(t testing.TB, exec *consolekit.CommandExecutor, configure ...func(*consolekit.HTTPHandler)) *HTTPServer {
+	t.Helper()
+	s := &HTTPServer{User: "test", Password: "test", t: t}
+	l := listen(t)
+	s.URL = "http://" + l.Addr().String()
+	s.Handler = consolekit.NewHTTPHandler(exec, l.Addr().String(), s.User, s.Password)
+	s.Handler.SetCustomListener(l)
+	for _, fn := range configure {
+		fn(s.Handler)
+	}
+
+	// Stop does nothing until the server runs
+	serve(t, "HTTP", s.Handler.Start, s.Handler.Stop)
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := http.Get(s.URL + "/config")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return s
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("consolekittest: the HTTP server didn't start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Connect logs in and opens the WebSocket of the web terminal, closed when the
+// test ends.
+func (s *HTTPServer) Connect() *WebSocket {
+	s.t.Helper()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, Timeout: timeout}
+	creds, _ := json.Marshal(map[string]string{"username": s.User, "password": s.Password})
+	resp, err := client.Post(s.URL+"/login", "application/json", strings.NewReader(string(creds)))
+	if err != nil {
+		s.t.Fatalf("consolekittest: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		s.t.Fatalf("consolekittest: login: %s", resp.Status)
+	}
+
+	dialer := websocket.Dialer{Jar: jar, HandshakeTimeout: timeout}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/repl", nil)
+	if err != nil {
+		s.t.Fatalf("consolekittest: %v", err)
+	}
+	s.t.Cleanup(func() { conn.Close() })
+	return &WebSocket{Conn: conn, t: s.t}
+}
+
+// WebSocket is a connection to the web terminal.
+type WebSocket struct {
+	Conn *websocket.Conn
+
+	t testing.TB
+}
+
+// Run sends a command line and reads the messages until it's done. It returns
+// the output, from "chunk" messages and any "output" message before them such
+// as the on_startup greeting, and the message of an "error" as an error.
+func (c *WebSocket) Run(line string) (string, error) {
+	c.t.Helper()
+	c.Send(consolekit.ReplMessage{Type: "input", Message: line})
+	var out strings.Builder
+	for {
+		msg := c.Read()
+		switch msg.Type {
+		case "chunk":
+			out.WriteString(msg.Message)
+		case "output":
+			out.WriteString(msg.Message + "\n")
+		case "done":
+			return out.String(), nil
+		case "error":
+			return out.String(), errors.New(msg.Message)
+		}
+	}
+}
+
+// Interrupt interrupts the running command, as Ctrl+C in the web terminal.
+func (c *WebSocket) Interrupt() {
+	c.t.Helper()
+	c.Send(consolekit.ReplMessage{Type: "interrupt"})
+}
+
+// Send sends a message to the server.
+func (c *WebSocket) Send(msg consolekit.ReplMessage) {
+	c.t.Helper()
+	if err := c.Conn.WriteJSON(msg); err != nil {
+		c.t.Fatalf("consolekittest: %v", err)
+	}
+}
+
+// Read reads the next message from the server.
+func (c *WebSocket) Read() consolekit.ReplMessage {
+	c.t.Helper()
+	var msg consolekit.ReplMessage
+	c.Conn.SetReadDeadline(time.Now().Add(timeout))
+	if err := c.Conn.ReadJSON(&msg); err != nil {
+		c.t.Fatalf("consolekittest: %v", err)
+	}
+	return msg
+}
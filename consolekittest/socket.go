@@ -0,0 +1,109 @@
+package consolekittest
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alexj212/consolekit"
+)
+
+// SocketServer is a SocketHandler serving an executor over TCP on a loopback
+// port.
+type SocketServer struct {
+	Handler *consolekit.SocketHandler
+	Addr    string // host:port of the server
+	Token   string // The auth token of the server and its clients, "test-token"
+
+	t testing.TB
+}
+
+// StartSocket serves exec over the socket protocol until the test ends, and
+// waits for it to answer. configure, if given, sets the options of the handler
+// (user tokens, limits, ...) before it starts.
+func StartSocket(t testing.TB, exec *consolekit.CommandExecutor, configure ...func(*consolekit.SocketHandler)) *SocketServer {
+	t.Helper()
+	s := &SocketServer{Token: "test-token", t: t}
+	l := listen(t)
+	s.Addr = l.Addr().String()
+	s.Handler = consolekit.NewSocketHandler(exec, "tcp", s.Addr)
+	s.Handler.SetAuthToken(s.Token)
+	s.Handler.SetCustomListener(l)
+	for _, fn := range configure {
+		fn(s.Handler)
+	}
+
+	// Stop does nothing until the server runs; any reply shows it does
+	serve(t, "socket", s.Handler.Start, s.Handler.Stop)
+	c := s.Dial()
+	c.Do(consolekit.SocketRequest{Command: "ping"})
+	c.Conn.Close()
+	return s
+}
+
+// Dial connects a client, closed when the test ends.
+func (s *SocketServer) Dial() *SocketClient {
+	s.t.Helper()
+	conn, err := net.DialTimeout("tcp", s.Addr, timeout)
+	if err != nil {
+		s.t.Fatalf("consolekittest: %v", err)
+	}
+	s.t.Cleanup(func() { conn.Close() })
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
+	return &SocketClient{Conn: conn, Token: s.Token, t: s.t, scanner: scanner}
+}
+
+// SocketClient is a connection to a socket server.
+type SocketClient struct {
+	Conn  net.Conn
+	Token string // Sent with requests that have none
+
+	t       testing.TB
+	scanner *bufio.Scanner
+	lastID  int
+}
+
+// Run runs a command line and returns the response.
+func (c *SocketClient) Run(line string) consolekit.SocketResponse {
+	c.t.Helper()
+	responses := c.Do(consolekit.SocketRequest{Command: line})
+	return responses[len(responses)-1]
+}
+
+// Do sends a request and returns its responses: the partial ones of a
+// streamed request, then the final one. Requests without an ID get one.
+func (c *SocketClient) Do(req consolekit.SocketRequest) []consolekit.SocketResponse {
+	c.t.Helper()
+	if req.ID == "" {
+		c.lastID++
+		req.ID = strconv.Itoa(c.lastID)
+	}
+	if req.Token == "" {
+		req.Token = c.Token
+	}
+	data, _ := json.Marshal(req)
+	if _, err := c.Conn.Write(append(data, '\n')); err != nil {
+		c.t.Fatalf("consolekittest: %v", err)
+	}
+
+	var responses []consolekit.SocketResponse
+	for {
+		c.Conn.SetReadDeadline(time.Now().Add(timeout))
+		if !c.scanner.Scan() {
+			c.t.Fatalf("consolekittest: no response to %q: %v", req.Command, c.scanner.Err())
+		}
+		var resp consolekit.SocketResponse
+		if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+			c.t.Fatalf("consolekittest: invalid response %q: %v", c.scanner.Text(), err)
+		}
+		responses = append(responses, resp)
+		if !resp.Partial {
+			return responses
+		}
+	}
+}
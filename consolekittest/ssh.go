@@ -0,0 +1,226 @@
+package consolekittest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexj212/consolekit"
+	"golang.org/x/crypto/ssh"
+)
+
+// timeout bounds each wait for a server: a start, a connection or a reply.
+const timeout = 10 * time.Second
+
+// listen returns a listener on a free loopback port.
+func listen(t testing.TB) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("consolekittest: %v", err)
+	}
+	return l
+}
+
+// serve runs start in the background until the test ends, when stop is
+// called and start must return.
+func serve(t testing.TB, name string, start, stop func() error) {
+	done := make(chan error, 1)
+	go func() { done <- start() }()
+	t.Cleanup(func() {
+		if err := stop(); err != nil {
+			t.Errorf("consolekittest: stopping the %s server: %v", name, err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("consolekittest: %s server: %v", name, err)
+			}
+		case <-time.After(timeout):
+			t.Errorf("consolekittest: the %s server didn't stop", name)
+		}
+	})
+}
+
+// SSHServer is an SSHHandler serving an executor on a loopback port.
+type SSHServer struct {
+	Handler  *consolekit.SSHHandler
+	Addr     string // host:port of the server
+	User     string // User of the clients, "test"
+	Password string // Password of the clients, accepted for any user; "test"
+	Prompt   string // Prompt of shells; "test@<app> > ", as DefaultPrompt gives
+
+	hostKey ssh.PublicKey
+	t       testing.TB
+}
+
+// StartSSH serves exec over SSH until the test ends, with a generated host
+// key and password authentication. configure, if given, sets the options of
+// the handler (banner, prompt, limits, authentication, ...) before it starts;
+// set Prompt too if it sets a PromptFunc.
+func StartSSH(t testing.TB, exec *consolekit.CommandExecutor, configure ...func(*consolekit.SSHHandler)) *SSHServer {
+	t.Helper()
+	signer, err := consolekit.GenerateHostKey()
+	if err != nil {
+		t.Fatalf("consolekittest: %v", err)
+	}
+
+	s := &SSHServer{
+		User:     "test",
+		Password: "test",
+		Prompt:   fmt.Sprintf("test@%s > ", exec.AppName),
+		hostKey:  signer.PublicKey(),
+		t:        t,
+	}
+	l := listen(t)
+	s.Addr = l.Addr().String()
+	s.Handler = consolekit.NewSSHHandler(exec, s.Addr, signer)
+	s.Handler.SetAuthConfig(&consolekit.SSHAuthConfig{
+		PasswordAuth: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != s.Password {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	})
+	s.Handler.SetCustomListener(l)
+	for _, fn := range configure {
+		fn(s.Handler)
+	}
+
+	serve(t, "SSH", s.Handler.Start, s.Handler.Stop)
+	return s
+}
+
+// Dial connects a client as User, closed when the test ends.
+func (s *SSHServer) Dial() *ssh.Client {
+	s.t.Helper()
+	client, err := ssh.Dial("tcp", s.Addr, &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            []ssh.AuthMethod{ssh.Password(s.Password)},
+		HostKeyCallback: ssh.FixedHostKey(s.hostKey),
+		Timeout:         timeout,
+	})
+	if err != nil {
+		s.t.Fatalf("consolekittest: %v", err)
+	}
+	s.t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// Exec runs a command line as an SSH exec request, like "ssh host line", and
+// returns its output and exit status.
+func (s *SSHServer) Exec(line string) (string, int) {
+	s.t.Helper()
+	session, err := s.Dial().NewSession()
+	if err != nil {
+		s.t.Fatalf("consolekittest: %v", err)
+	}
+	defer session.Close()
+
+	out, err := session.CombinedOutput(line)
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return string(out), exitErr.ExitStatus()
+	case err != nil:
+		s.t.Fatalf("consolekittest: %s: %v", line, err)
+	}
+	return string(out), 0
+}
+
+// Shell opens an interactive shell session, without a PTY, and reads up to its
+// first prompt.
+func (s *SSHServer) Shell() *SSHShell {
+	s.t.Helper()
+	session, err := s.Dial().NewSession()
+	if err != nil {
+		s.t.Fatalf("consolekittest: %v", err)
+	}
+	s.t.Cleanup(func() { session.Close() })
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		s.t.Fatalf("consolekittest: %v", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		s.t.Fatalf("consolekittest: %v", err)
+	}
+	if err := session.Shell(); err != nil {
+		s.t.Fatalf("consolekittest: %v", err)
+	}
+
+	sh := &SSHShell{t: s.t, prompt: s.Prompt, stdin: stdin, out: make(chan []byte)}
+	go func() {
+		defer close(sh.out)
+		for {
+			buf := make([]byte, 4096)
+			n, err := stdout.Read(buf)
+			if n > 0 {
+				sh.out <- buf[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	sh.Greeting = sh.readPrompt()
+	return sh
+}
+
+// SSHShell is an interactive SSH shell session.
+type SSHShell struct {
+	Greeting string // The welcome text before the first prompt
+
+	t      testing.TB
+	prompt string
+	stdin  io.Writer
+	out    chan []byte
+}
+
+// Run types a command line and returns what the shell wrote until its next
+// prompt, without the echo of the line. Line endings are "\n".
+func (sh *SSHShell) Run(line string) string {
+	sh.t.Helper()
+	sh.Type(line + "\r")
+	out := sh.readPrompt()
+	if _, after, ok := strings.Cut(out, "\n"); ok {
+		return after // The echo of the line
+	}
+	return out
+}
+
+// Type writes keystrokes to the shell, such as "\x03" for Ctrl+C.
+func (sh *SSHShell) Type(keys string) {
+	sh.t.Helper()
+	if _, err := io.WriteString(sh.stdin, keys); err != nil {
+		sh.t.Fatalf("consolekittest: %v", err)
+	}
+}
+
+// readPrompt reads the output of the shell up to the next prompt, and returns
+// it without the prompt.
+func (sh *SSHShell) readPrompt() string {
+	sh.t.Helper()
+	var buf bytes.Buffer
+	deadline := time.After(timeout)
+	for !bytes.HasSuffix(buf.Bytes(), []byte(sh.prompt)) {
+		select {
+		case b, ok := <-sh.out:
+			if !ok {
+				sh.t.Fatalf("consolekittest: the shell ended before the prompt %q:\n%s", sh.prompt, buf.String())
+			}
+			buf.Write(b)
+		case <-deadline:
+			sh.t.Fatalf("consolekittest: no prompt %q from the shell:\n%s", sh.prompt, buf.String())
+		}
+	}
+	out := strings.TrimSuffix(buf.String(), sh.prompt)
+	return strings.ReplaceAll(out, "\r\n", "\n")
+}
@@ -1,15 +1,16 @@
 package consolekit
 
 import (
+	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 
+	"github.com/kballard/go-shellquote"
 	"github.com/spf13/cobra"
 )
 
-// AddControlFlowCommands adds control flow commands (case, while, for)
+// AddControlFlowCommands adds control flow commands (case, while, for, test, return, trap, setopt)
 func AddControlFlowCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return func(rootCmd *cobra.Command) {
 		// case command - simple case/switch statement
@@ -40,7 +41,7 @@ Examples:
 
 					// Check if pattern matches
 					if pattern == "*" || pattern == value {
-						output, err := exec.Execute(command, nil)
+						output, err := exec.ExecuteWithContext(cmd.Context(), command, nil)
 						if err != nil {
 							cmd.PrintErrln(fmt.Sprintf("Error: %v", err))
 							return
@@ -82,17 +83,24 @@ Examples:
 				iteration := 0
 
 				for iteration < maxIterations {
+					// Stop when the command is interrupted
+					if cmd.Context().Err() != nil {
+						return
+					}
+
 					// Check condition
-					_, err := exec.Execute(condition, nil)
+					_, err := exec.ExecuteWithContext(cmd.Context(), condition, nil)
 					if err != nil {
 						// Condition failed, exit loop
 						break
 					}
 
 					// Execute body
-					output, err := exec.Execute(body, nil)
+					output, err := exec.ExecuteWithContext(cmd.Context(), body, nil)
 					if err != nil {
-						cmd.PrintErrln(fmt.Sprintf("Error in loop body: %v", err))
+						if cmd.Context().Err() == nil {
+							cmd.PrintErrln(fmt.Sprintf("Error in loop body: %v", err))
+						}
 						return
 					}
 
@@ -155,21 +163,28 @@ Examples:
 
 				// Execute command for each value
 				for _, value := range values {
+					// Stop when the command is interrupted
+					if cmd.Context().Err() != nil {
+						return
+					}
+
 					// Set the variable temporarily
-					oldValue, hasOld := exec.Variables.Get(varName)
-					exec.Variables.Set(varName, value)
+					oldValue, hasOld := exec.GetVariable(cmd.Context(), varName)
+					exec.SetVariable(cmd.Context(), varName, value)
 
-					output, err := exec.Execute(command, nil)
+					output, err := exec.ExecuteWithContext(cmd.Context(), command, nil)
 
 					// Restore old value
 					if hasOld {
-						exec.Variables.Set(varName, oldValue)
+						exec.SetVariable(cmd.Context(), varName, oldValue)
 					} else {
-						exec.Variables.Delete(varName)
+						exec.DeleteVariable(cmd.Context(), varName)
 					}
 
 					if err != nil {
-						cmd.PrintErrln(fmt.Sprintf("Error in loop body: %v", err))
+						if cmd.Context().Err() == nil {
+							cmd.PrintErrln(fmt.Sprintf("Error in loop body: %v", err))
+						}
 						return
 					}
 
@@ -187,16 +202,21 @@ Examples:
 		var testCmd = &cobra.Command{
 			Use:   "test [arg1] [operator] [arg2]",
 			Short: "Test conditions (for use with while/if)",
-			Long: `Test conditions and exit with 0 (true) or 1 (false).
+			Long: `Test conditions and exit with status 0 (true) or 1 (false).
+An unknown operator exits with status 2.
 Supports numeric comparisons: -eq, -ne, -lt, -le, -gt, -ge
 Supports string comparisons: =, !=
 
 Examples:
   test 5 -lt 10        # 5 less than 10
   test @count -eq 0    # count equals 0
-  test "$name" = "John"  # string equality`,
+  test "$name" = "John"  # string equality
+  test @count -gt 0 && print "has items" || print "empty"`,
 			Args: cobra.ExactArgs(3),
-			Run: func(cmd *cobra.Command, args []string) {
+			// Operators like -eq look like flags; a false result is a status, not a usage error
+			DisableFlagParsing: true,
+			SilenceUsage:       true,
+			RunE: func(cmd *cobra.Command, args []string) error {
 				arg1 := args[0]
 				operator := args[1]
 				arg2 := args[2]
@@ -223,9 +243,7 @@ Examples:
 					case "-ge":
 						result = num1 >= num2
 					default:
-						cmd.PrintErrln(fmt.Sprintf("Unknown numeric operator: %s", operator))
-						os.Exit(1)
-						return
+						return &ExitError{Status: 2, Err: fmt.Errorf("unknown numeric operator: %s", operator)}
 					}
 				} else {
 					// String comparison
@@ -235,21 +253,172 @@ Examples:
 					case "!=":
 						result = arg1 != arg2
 					default:
-						cmd.PrintErrln(fmt.Sprintf("Unknown string operator: %s", operator))
-						os.Exit(1)
-						return
+						return &ExitError{Status: 2, Err: fmt.Errorf("unknown string operator: %s", operator)}
 					}
 				}
 
 				if !result {
-					os.Exit(1)
+					return NewExitError(1)
+				}
+				return nil
+			},
+		}
+
+		// return command - stop a function or script
+		var returnCmd = &cobra.Command{
+			Use:   "return [status]",
+			Short: "Stop the running function or script",
+			Long: `Stop the running function or script with a status, by default that of
+the last command (@?). The caller carries on with the next command.
+
+Examples:
+  func check host { ping @host || return 2 }
+  test -z @arg0 && return`,
+			Args:         cobra.MaximumNArgs(1),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				f := frameFrom(cmd.Context())
+				if f == nil {
+					return errors.New("return: can only be used in a function or script")
+				}
+
+				last, _ := exec.GetVariable(cmd.Context(), lastStatusVar)
+				status, _ := strconv.Atoi(last)
+				if len(args) > 0 {
+					var err error
+					if status, err = strconv.Atoi(args[0]); err != nil {
+						return &ExitError{Status: 2, Err: fmt.Errorf("return: numeric argument required: %s", args[0])}
+					}
+				}
+				f.stop(status)
+				return nil
+			},
+		}
+
+		// breakpoint command - pause a script run with --debug
+		var breakpointCmd = &cobra.Command{
+			Use:   "breakpoint",
+			Short: "Pause the script here when debugging",
+			Long: `Pause the script at this command when it runs with "run --debug" or
+"run --break", and open the (debug) prompt. Otherwise it does nothing.
+
+Examples:
+  let total=@count
+  breakpoint
+  print @total`,
+			Args: cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				f := frameFrom(cmd.Context())
+				if d := debuggerFrom(cmd.Context()); d != nil && f != nil {
+					d.breakpoint(f)
 				}
 			},
 		}
 
+		// trap command - run commands when a script fails or finishes
+		var trapCmd = &cobra.Command{
+			Use:   "trap [command condition...]",
+			Short: "Run a command when a script fails or finishes",
+			Long: `Run a command when a line of the script fails (ERR) or when the script
+finishes, however it stops (EXIT). The functions a script calls share its
+traps. "trap - condition" removes a trap; trap with no arguments lists them.
+
+Examples:
+  trap 'print "failed with status @?"' ERR
+  trap 'rm @tmpfile' EXIT
+  trap - ERR`,
+			DisableFlagParsing: true,
+			SilenceUsage:       true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				f := frameFrom(cmd.Context())
+				if f == nil {
+					return errors.New("trap: can only be used in a function or script")
+				}
+
+				if len(args) == 0 {
+					for _, condition := range f.state.sortedTraps() {
+						cmd.Printf("trap -- %s %s\n", shellquote.Join(f.state.trap(condition)), condition)
+					}
+					return nil
+				}
+				if len(args) == 1 {
+					return &ExitError{Status: 2, Err: fmt.Errorf("trap: missing condition (%s or %s)", trapERR, trapEXIT)}
+				}
+
+				command := args[0]
+				if command == "-" {
+					command = ""
+				}
+				for _, condition := range args[1:] {
+					condition = strings.ToUpper(condition)
+					if condition != trapERR && condition != trapEXIT {
+						return &ExitError{Status: 2, Err: fmt.Errorf("trap: unsupported condition %s (%s or %s)", condition, trapERR, trapEXIT)}
+					}
+					f.state.setTrap(condition, command)
+				}
+				return nil
+			},
+		}
+
+		// setopt and unsetopt commands - shell options of a script, by name
+		var setoptCmd = &cobra.Command{
+			Use:   "setopt [option...]",
+			Short: "Turn shell options of a script on, or list them",
+			Long: `Turn shell options of the running script or function on by name, or list
+them with no arguments. unsetopt turns them off. The functions a script calls
+share its options.
+  errexit   stop at the first line that fails (set -e)
+  nounset   fail lines that reference undefined variables (set -u)
+  pipefail  fail pipelines if any stage fails
+  xtrace    echo commands after expansion (set -x)
+
+Examples:
+  setopt pipefail
+  setopt errexit nounset
+  unsetopt pipefail`,
+			ValidArgs:    shellOptions,
+			Args:         cobra.OnlyValidArgs,
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if len(args) > 0 {
+					return setOptions(cmd, optionChanges(args, true))
+				}
+
+				f := frameFrom(cmd.Context())
+				if f == nil {
+					return errors.New("setopt: can only be used in a function or script")
+				}
+				for _, name := range shellOptions {
+					state := "off"
+					if f.state.option(name) {
+						state = "on"
+					}
+					cmd.Printf("%-10s %s\n", name, state)
+				}
+				return nil
+			},
+		}
+
+		var unsetoptCmd = &cobra.Command{
+			Use:          "unsetopt option...",
+			Short:        "Turn shell options of a script off",
+			Long:         `Turn shell options of the running script or function off by name (see setopt).`,
+			ValidArgs:    shellOptions,
+			Args:         cobra.MatchAll(cobra.MinimumNArgs(1), cobra.OnlyValidArgs),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return setOptions(cmd, optionChanges(args, false))
+			},
+		}
+
 		rootCmd.AddCommand(caseCmd)
 		rootCmd.AddCommand(whileCmd)
 		rootCmd.AddCommand(forCmd)
 		rootCmd.AddCommand(testCmd)
+		rootCmd.AddCommand(returnCmd)
+		rootCmd.AddCommand(trapCmd)
+		rootCmd.AddCommand(setoptCmd)
+		rootCmd.AddCommand(unsetoptCmd)
+		rootCmd.AddCommand(breakpointCmd)
 	}
 }
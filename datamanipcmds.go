@@ -22,13 +22,14 @@ func AddDataManipulationCommands(exec *CommandExecutor) func(cmd *cobra.Command)
 		}
 
 		// json parse
-		var jsonPretty bool
 		var jsonParseCmd = &cobra.Command{
 			Use:   "parse [file]",
 			Short: "Parse and format JSON",
 			Long:  "Parse JSON from file or stdin and optionally pretty-print",
 			Args:  cobra.MaximumNArgs(1),
 			Run: func(cmd *cobra.Command, args []string) {
+				jsonPretty, _ := cmd.Flags().GetBool("pretty")
+
 				var data []byte
 				var err error
 
@@ -68,7 +69,7 @@ func AddDataManipulationCommands(exec *CommandExecutor) func(cmd *cobra.Command)
 				ResetAllFlags(cmd)
 			},
 		}
-		jsonParseCmd.Flags().BoolVar(&jsonPretty, "pretty", true, "Pretty-print JSON output")
+		jsonParseCmd.Flags().Bool("pretty", true, "Pretty-print JSON output")
 
 		// json get - extract value from JSON using path notation
 		var jsonGetCmd = &cobra.Command{
@@ -282,13 +283,14 @@ func AddDataManipulationCommands(exec *CommandExecutor) func(cmd *cobra.Command)
 		}
 
 		// csv parse
-		var csvHeader bool
 		var csvParseCmd = &cobra.Command{
 			Use:   "parse [file]",
 			Short: "Parse CSV file",
 			Long:  "Parse CSV from file or stdin and display as table",
 			Args:  cobra.MaximumNArgs(1),
 			Run: func(cmd *cobra.Command, args []string) {
+				csvHeader, _ := cmd.Flags().GetBool("header")
+
 				var file *os.File
 				var err error
 
@@ -329,7 +331,7 @@ func AddDataManipulationCommands(exec *CommandExecutor) func(cmd *cobra.Command)
 				ResetAllFlags(cmd)
 			},
 		}
-		csvParseCmd.Flags().BoolVar(&csvHeader, "header", true, "First row is header")
+		csvParseCmd.Flags().Bool("header", true, "First row is header")
 
 		// csv to-json
 		var csvToJSONCmd = &cobra.Command{
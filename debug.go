@@ -0,0 +1,297 @@
+package consolekit
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// How the debugger goes on after a pause.
+type debugMode int
+
+const (
+	debugContinue debugMode = iota // Run to the next breakpoint
+	debugStep                      // Pause at the next line, in any script or function
+	debugNext                      // Pause at the next line of the same script or its callers
+)
+
+// debugger pauses the scripts run with "run --debug" at breakpoints and lets
+// the user step through them, inspect and change variables, and see the call
+// stack at the terminal. The scripts, functions and sourced files the debugged
+// script runs are debugged too.
+type debugger struct {
+	e    *CommandExecutor
+	term Terminal
+	top  *scriptFrame // The script run with --debug
+
+	mu     sync.Mutex
+	breaks map[string]map[int]bool // Breakpoint lines by script
+	mode   debugMode
+	from   *scriptFrame // Frame "next" was entered in
+	last   string       // Last action, repeated by an empty line
+	paused ScriptLine   // Line the debugger last paused before
+	at     *scriptFrame // Frame of that line
+}
+
+type debuggerKey struct{}
+
+// debuggerFrom returns the debugger of the script that ctx belongs to, or nil.
+func debuggerFrom(ctx context.Context) *debugger {
+	d, _ := ctx.Value(debuggerKey{}).(*debugger)
+	return d
+}
+
+// newDebugger starts debugging frame f at term. It adds breakpoints for the
+// "[script:]line" specs; without any, it pauses at the first line.
+func (e *CommandExecutor) newDebugger(f *scriptFrame, term Terminal, breaks []string) (*debugger, error) {
+	d := &debugger{e: e, term: term, top: f, breaks: make(map[string]map[int]bool), mode: debugStep}
+	for _, spec := range breaks {
+		script, line, err := d.parseBreak(spec, f.name)
+		if err != nil {
+			return nil, err
+		}
+		d.setBreak(script, line, true)
+		d.mode = debugContinue
+	}
+	f.ctx = context.WithValue(f.ctx, debuggerKey{}, d)
+	return d, nil
+}
+
+// parseBreak parses a "[script:]line" breakpoint; script defaults to current.
+func (d *debugger) parseBreak(spec, current string) (string, int, error) {
+	script, lineText := current, spec
+	if i := strings.LastIndex(spec, ":"); i != -1 {
+		script, lineText = spec[:i], spec[i+1:]
+	}
+	line, err := strconv.Atoi(lineText)
+	if err != nil || line < 1 {
+		return "", 0, fmt.Errorf("invalid breakpoint %q: want [script:]line", spec)
+	}
+	return script, line, nil
+}
+
+func (d *debugger) setBreak(script string, line int, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if on {
+		if d.breaks[script] == nil {
+			d.breaks[script] = make(map[int]bool)
+		}
+		d.breaks[script][line] = true
+		return
+	}
+	delete(d.breaks[script], line)
+}
+
+// hasBreak reports whether a breakpoint is set on one of the lines of l in
+// script name. Scripts also match by their base name.
+func (d *debugger) hasBreak(name string, l ScriptLine) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	last := l.Line + strings.Count(strings.TrimRight(l.Text, "\n"), "\n")
+	for script, lines := range d.breaks {
+		if script != name && script != filepath.Base(name) {
+			continue
+		}
+		for line := range lines {
+			if line >= l.Line && line <= last {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// beforeLine is called before frame f runs l. It pauses at breakpoints and
+// while stepping.
+func (d *debugger) beforeLine(f *scriptFrame, l ScriptLine) {
+	d.mu.Lock()
+	mode, from := d.mode, d.from
+	d.mu.Unlock()
+
+	switch {
+	case d.hasBreak(f.name, l):
+		d.pauseBefore(f, l, "Breakpoint")
+	case mode == debugStep:
+		d.pauseBefore(f, l, "Step")
+	case mode == debugNext && from.within(f):
+		d.pauseBefore(f, l, "Step")
+	}
+}
+
+func (d *debugger) pauseBefore(f *scriptFrame, l ScriptLine, reason string) {
+	d.mu.Lock()
+	d.paused, d.at = l, f
+	d.mu.Unlock()
+	d.pause(f, reason)
+}
+
+// breakpoint pauses frame f at a "breakpoint" command, unless the debugger
+// already paused before the line it is on.
+func (d *debugger) breakpoint(f *scriptFrame) {
+	d.mu.Lock()
+	f.mu.Lock()
+	again := d.at == f && d.paused.Line == f.base
+	f.mu.Unlock()
+	d.mu.Unlock()
+	if !again {
+		d.pause(f, "Breakpoint")
+	}
+}
+
+// within reports whether g is f or one of the frames f was called from.
+func (f *scriptFrame) within(g *scriptFrame) bool {
+	for ; f != nil; f = f.parent {
+		if f == g {
+			return true
+		}
+	}
+	return false
+}
+
+// pause shows where frame f is and runs debugger commands typed at the
+// terminal until one resumes the script.
+func (d *debugger) pause(f *scriptFrame, reason string) {
+	line := f.currentLine()
+	_, _ = fmt.Fprintf(d.term, "%s at %s:%d\n", reason, f.name, line)
+	d.list(f, line, 0)
+
+	for {
+		input, err := d.term.ReadLine(f.ctx, "(debug) ")
+		if err != nil {
+			d.quit(f)
+			return
+		}
+		input = strings.TrimSpace(input)
+		if input == "" {
+			input = d.last
+		}
+		words := strings.Fields(input)
+		if len(words) == 0 {
+			continue
+		}
+
+		action := words[0]
+		switch action {
+		case "s", "step":
+			d.resume(debugStep, nil, action)
+			return
+		case "n", "next":
+			d.resume(debugNext, f, action)
+			return
+		case "c", "continue":
+			d.resume(debugContinue, nil, action)
+			return
+		case "q", "quit":
+			d.quit(f)
+			return
+		case "l", "list":
+			d.list(f, line, 5)
+		case "bt", "stack", "where":
+			d.stack(f)
+		case "b", "break":
+			d.breakCmd(f, words[1:], true)
+		case "d", "delete":
+			d.breakCmd(f, words[1:], false)
+		case "h", "help", "?":
+			_, _ = fmt.Fprint(d.term, debugHelp)
+		default:
+			// Anything else runs as a command in the script's context: vars, let x=1, print @x
+			err := d.e.ExecuteStream(f.ctx, input, f.scope, d.term)
+			if err != nil {
+				_, _ = fmt.Fprintln(d.term, err)
+			}
+		}
+	}
+}
+
+const debugHelp = `Debugger commands:
+  s, step             run the next line, stepping into functions and scripts
+  n, next             run the next line of this script
+  c, continue         run to the next breakpoint
+  l, list             show the lines around the current one
+  bt, stack           show the call stack
+  b, break [[script:]line]   set a breakpoint, or list them
+  d, delete [script:]line    remove a breakpoint
+  q, quit             stop the script
+Other input runs as a command in the script's context (vars, print @x, let x=1).
+An empty line repeats the last step, next or continue.
+`
+
+func (d *debugger) resume(mode debugMode, from *scriptFrame, action string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mode, d.from, d.last = mode, from, action
+}
+
+// quit stops the debugged script and the frames it runs.
+func (d *debugger) quit(f *scriptFrame) {
+	for g := f; g != nil; g = g.parent {
+		g.stop(130)
+		if g == d.top {
+			return
+		}
+	}
+}
+
+// list shows the lines of f within context lines of line, marking it.
+func (d *debugger) list(f *scriptFrame, line, context int) {
+	for _, l := range f.lines {
+		for i, text := range strings.Split(strings.TrimRight(l.Text, "\n"), "\n") {
+			n := l.Line + i
+			if n < line-context || n > line+context {
+				continue
+			}
+			marker := "  "
+			if n == line {
+				marker = "→ "
+			}
+			_, _ = fmt.Fprintf(d.term, "%s%4d  %s\n", marker, n, text)
+		}
+	}
+}
+
+// stack shows the call stack of f, innermost first.
+func (d *debugger) stack(f *scriptFrame) {
+	for i, g := 0, f; g != nil; i, g = i+1, g.parent {
+		_, _ = fmt.Fprintf(d.term, "#%d  %s:%d (%s)\n", i, g.name, g.currentLine(), g.kind)
+	}
+}
+
+// breakCmd sets or removes the breakpoint of args, or lists the breakpoints.
+func (d *debugger) breakCmd(f *scriptFrame, args []string, on bool) {
+	if len(args) == 0 {
+		if !on {
+			_, _ = fmt.Fprintln(d.term, "usage: delete [script:]line")
+			return
+		}
+		for _, b := range d.breakpoints() {
+			_, _ = fmt.Fprintln(d.term, b)
+		}
+		return
+	}
+	script, line, err := d.parseBreak(args[0], f.name)
+	if err != nil {
+		_, _ = fmt.Fprintln(d.term, err)
+		return
+	}
+	d.setBreak(script, line, on)
+}
+
+// breakpoints returns the breakpoints as "script:line", sorted.
+func (d *debugger) breakpoints() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var list []string
+	for script, lines := range d.breaks {
+		for line := range lines {
+			list = append(list, fmt.Sprintf("%s:%d", script, line))
+		}
+	}
+	sort.Strings(list)
+	return list
+}
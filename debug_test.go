@@ -0,0 +1,145 @@
+package consolekit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scriptedTerminal answers ReadLine with its lines, then io.EOF.
+type scriptedTerminal struct {
+	bytes.Buffer
+	lines []string
+}
+
+func (t *scriptedTerminal) ReadLine(ctx context.Context, prompt string) (string, error) {
+	if len(t.lines) == 0 {
+		return "", io.EOF
+	}
+	line := t.lines[0]
+	t.lines = t.lines[1:]
+	t.WriteString(prompt + line + "\n")
+	return line, nil
+}
+
+func TestRunTrace(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(AddRun(exec, nil))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "trace.run")
+	if err := os.WriteFile(path, []byte("let x=5\nprint @x && print  done\nset +x\nprint quiet\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := executor.Execute("run --quiet --trace "+path, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	want := "+ let x=5\nx = 5\n+ print 5\n5\n+ print done\ndone\n+ set +x\nquiet\n"
+	if out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestRunDebug(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // The functions file
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(AddRun(exec, nil))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	if _, err := executor.Execute("func greet { print hello; print bye }", nil); err != nil {
+		t.Fatalf("func failed: %v", err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "debug.run")
+	script := "let x=1\nprint x=@x\ngreet\nprint after\nbreakpoint\nprint end @x\n"
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		flags  string
+		input  []string
+		want   string   // Script output
+		term   []string // Expected in the terminal output
+		status int
+	}{
+		{
+			name:  "continue to the breakpoint command",
+			flags: "--break 2",
+			input: []string{"c", "let x=7", "c"},
+			want:  "x = 1\nx=1\nhello\nbye\nafter\nend 7\n",
+			term:  []string{"Breakpoint at debug.run:2", "→    2  print x=@x", "Breakpoint at debug.run:5"},
+		},
+		{
+			name:  "step into functions",
+			flags: "--debug",
+			input: []string{"s", "s", "s", "bt", "c", "c"},
+			want:  "x = 1\nx=1\nhello\nbye\nafter\nend 1\n",
+			term: []string{
+				"Step at debug.run:1", "Step at debug.run:3", "Step at greet:1",
+				"#0  greet:1 (function)\n#1  debug.run:3 (script)",
+			},
+		},
+		{
+			name:   "next steps over functions",
+			flags:  "--debug",
+			input:  []string{"n", "n", "n", "", "print x is @x", "q"},
+			want:   "x = 1\nx=1\nhello\nbye\nafter\n",
+			term:   []string{"Step at debug.run:4", "Step at debug.run:5", "x is 1"},
+			status: 130,
+		},
+		{
+			name:  "breakpoints in functions",
+			flags: "--break greet:1",
+			input: []string{"b", "d greet:1", "c", "c"},
+			want:  "x = 1\nx=1\nhello\nbye\nafter\nend 1\n",
+			term:  []string{"Breakpoint at greet:1", "greet:1\n", "Breakpoint at debug.run:5"},
+		},
+		{
+			name:   "end of input quits",
+			flags:  "--debug",
+			want:   "",
+			term:   []string{"Step at debug.run:1"},
+			status: 130,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := &scriptedTerminal{lines: tt.input}
+			ctx := WithTerminal(context.Background(), term)
+			out, err := executor.ExecuteWithContext(ctx, "run --quiet "+tt.flags+" "+path, nil)
+			if out != tt.want {
+				t.Errorf("output = %q, want %q", out, tt.want)
+			}
+			if status := ExitStatus(err); status != tt.status {
+				t.Errorf("status = %d (%v), want %d", status, err, tt.status)
+			}
+			got := strings.ReplaceAll(term.String(), dir+"/", "")
+			for _, want := range tt.term {
+				if !strings.Contains(got, want) {
+					t.Errorf("terminal output missing %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+
+	if _, err := executor.Execute("run --debug "+path, nil); err == nil {
+		t.Error("run --debug without a terminal succeeded")
+	}
+}
@@ -1,6 +1,8 @@
 package consolekit
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -43,3 +45,55 @@ func WrapError(command string, err error) error {
 	}
 	return NewCLIError(command, "command failed", err)
 }
+
+// ExitError reports a non-zero exit status from a command.
+// Commands return it (instead of calling os.Exit) to signal failure without
+// an error message, e.g. a `test` whose condition is false.
+type ExitError struct {
+	Status int   // Exit status (non-zero)
+	Err    error // Optional underlying error
+}
+
+// Error implements the error interface
+func (e *ExitError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.Status)
+}
+
+// Unwrap returns the underlying error for error unwrapping
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// NewExitError creates an ExitError with the given status
+func NewExitError(status int) error {
+	return &ExitError{Status: status}
+}
+
+// ErrCommandNotAllowed is returned, wrapped with the command path, when the
+// session's policy does not permit a command.
+var ErrCommandNotAllowed = errors.New("command not allowed")
+
+// ExitStatus returns the exit status for an execution error:
+// 0 for nil, the status carried by an ExitError, 130 for an interrupted command,
+// 124 for a timed out command, 126 for a command that is not allowed and 1 for
+// any other error.
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.Status
+	case errors.Is(err, context.Canceled):
+		return 130
+	case errors.Is(err, context.DeadlineExceeded):
+		return 124
+	case errors.Is(err, ErrCommandNotAllowed):
+		return 126
+	}
+	return 1
+}
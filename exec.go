@@ -1,8 +1,8 @@
 package consolekit
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -20,7 +20,9 @@ func AddOSExec(exec *CommandExecutor) func(cmd *cobra.Command) {
 			Use:   "osexec [--out] [--background] [command] ",
 			Short: "Executes a command with options to run in the background and hide/show output",
 			Args:  cobra.ExactArgs(1),
-			Run: func(cmd *cobra.Command, args []string) {
+			// A failing process reports its exit status, not a usage error
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
 				// Local flags for the command
 				background, _ := cmd.Flags().GetBool("background")
 				showOutput, _ := cmd.Flags().GetBool("out")
@@ -28,64 +30,74 @@ func AddOSExec(exec *CommandExecutor) func(cmd *cobra.Command) {
 				cmdLine := strings.Fields(args[0])
 				if len(cmdLine) == 0 {
 					cmd.Printf("%s\n", fmt.Sprintf("No command provided"))
-					return
+					return nil
 				}
 
-				osCmd := osexec.Command(cmdLine[0], cmdLine[1:]...)
+				osCmd := osexec.CommandContext(cmd.Context(), cmdLine[0], cmdLine[1:]...)
+				if session := remoteSession(cmd.Context()); session != nil {
+					osCmd.Dir = session.Dir()
+				}
 
 				if background {
 					// Create context for cancellation
 					ctx, cancel := context.WithCancel(context.Background())
 					osCmd = osexec.CommandContext(ctx, cmdLine[0], cmdLine[1:]...)
+					if session := remoteSession(cmd.Context()); session != nil {
+						osCmd.Dir = session.Dir()
+					}
 
-					// Create output buffer for job tracking
-					outputBuf := &bytes.Buffer{}
+					// Capture output into the job, for "job <id> logs"
+					job := newJob(args[0], cancel)
+					jobOut := jobWriter{job}
 
 					if showOutput {
-						// Tee output to both stdout and buffer
-						osCmd.Stdout = io.MultiWriter(os.Stdout, outputBuf)
-						osCmd.Stderr = io.MultiWriter(os.Stderr, outputBuf)
+						// Tee output to both stdout and the job
+						osCmd.Stdout = io.MultiWriter(os.Stdout, jobOut)
+						osCmd.Stderr = io.MultiWriter(os.Stderr, jobOut)
 					} else {
-						// Only capture to buffer
-						osCmd.Stdout = outputBuf
-						osCmd.Stderr = outputBuf
+						// Only capture to the job
+						osCmd.Stdout = jobOut
+						osCmd.Stderr = jobOut
 					}
 
 					if err := osCmd.Start(); err != nil {
 						cmd.Printf("%s\n", fmt.Sprintf("Error starting command in background: %v", err))
 						cancel()
-						return
+						return nil
 					}
 
 					// Add to job manager
-					jobID := exec.JobManager.Add(args[0], ctx, cancel, osCmd)
+					jobID := exec.JobManager.addProcess(job, ctx, osCmd)
 					cmd.Printf("%s\n", fmt.Sprintf("Command started in background with PID %d (Job ID: %d)", osCmd.Process.Pid, jobID))
-
-					// Update the job's output buffer reference
-					if job, ok := exec.JobManager.Get(jobID); ok {
-						job.mu.Lock()
-						job.Output = outputBuf
-						job.mu.Unlock()
-					}
 				} else {
 					// Foreground execution
 					if !showOutput {
 						osCmd.Stdout = io.Discard
 						osCmd.Stderr = io.Discard
 					} else {
-						osCmd.Stdout = os.Stdout
-						osCmd.Stderr = os.Stderr
+						// Stream into the pipeline (or the transport) as output is produced
+						osCmd.Stdout = cmd.OutOrStdout()
+						osCmd.Stderr = cmd.ErrOrStderr()
+					}
+					if in := cmd.InOrStdin(); in != os.Stdin {
+						osCmd.Stdin = in
 					}
 
 					if err := osCmd.Run(); err != nil {
-						cmd.Printf("%s\n", fmt.Sprintf("Error executing command: %v", err))
+						// Propagate the process exit status
+						var exitErr *osexec.ExitError
+						if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+							return NewExitError(exitErr.ExitCode())
+						}
+						return fmt.Errorf("error executing command: %w", err)
 					}
 				}
+				return nil
 			},
 		}
 		osexecCmd.Flags().BoolP("background", "b", false, "Run command in background")
 		osexecCmd.Flags().BoolP("out", "o", false, "Show command output")
 
-		rootCmd.AddCommand(osexecCmd)
+		rootCmd.AddCommand(RequirePermission(osexecCmd, "os.exec"))
 	}
 }
@@ -5,12 +5,16 @@ import (
 	"bytes"
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -30,10 +34,16 @@ type CommandExecutor struct {
 	Variables *safemap.SafeMap[string, string]
 	VariableExpanders []func(string) (string, bool)
 	aliases        *safemap.SafeMap[string, string] // Per-instance aliases
+	functions      *safemap.SafeMap[string, *Function] // User-defined functions (see func)
 
 	// Command registration
 	rootInit []func(*cobra.Command)
 	replHiddenCommands []string // Commands to hide in REPL mode
+	middleware []Middleware // Wraps every line and pipeline stage (see Use)
+	treeCache treeCache // Built trees reused between executions
+	plugins atomic.Bool // Plugins are registered as commands (see AddPlugins)
+	pluginMu sync.Mutex
+	pluginList []*Plugin // Plugins registered, found once and at plugin reload
 
 	// Managers (dependency injection)
 	JobManager      *JobManager
@@ -43,9 +53,8 @@ type CommandExecutor struct {
 	NotificationManager   *NotificationManager
 	HistoryManager  *HistoryManager
 
-	// Recursion protection
+	// Recursion protection (depth is tracked per call chain in the context)
 	maxExecDepth int32
-	execDepth    atomic.Int32
 
 	// File handling (can be overridden for SSH chroot, etc.)
 	FileHandler FileHandler
@@ -55,9 +64,13 @@ type CommandExecutor struct {
 
 	// Display settings
 	NoColor bool // Disable color output (respects NO_COLOR env var)
+	colorOff bool // NoColor was set by settings.color = false
 
 	// Runtime mode
 	Interactive bool // True when running in REPL mode (set by transport handler)
+
+	// Access control (nil = commands require no permissions)
+	RBAC *RBAC
 }
 
 // FileHandler abstracts file I/O for redirection and script loading.
@@ -82,11 +95,28 @@ func (h *LocalFileHandler) ReadFile(path string) (string, error) {
 	return string(data), nil
 }
 
+func (h *LocalFileHandler) ReadDir(path string) ([]fs.DirEntry, error) {
+	return os.ReadDir(path)
+}
+
+func (h *LocalFileHandler) AppendFile(path string, content string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 // ExecutionResult contains command output and metadata.
 type ExecutionResult struct {
 	Output      string
 	Error       error
 	Success     bool
+	ExitStatus  int // 0 on success; see ExitStatus
 	Duration    time.Duration
 	CommandLine string
 }
@@ -112,20 +142,21 @@ func NewCommandExecutor(appName string, customizer func(*CommandExecutor) error)
 	if config != nil && config.Logging.LogFile != "" {
 		logFile = config.Logging.LogFile
 	}
-	if currentUser, err := user.Current(); err == nil {
+	if home, err := homeDir(); err == nil {
 		name := strings.ToLower(appName)
-		appDir := filepath.Join(currentUser.HomeDir, fmt.Sprintf(".%s", name))
+		appDir := filepath.Join(home, fmt.Sprintf(".%s", name))
 		if logFile == "" {
 			logFile = filepath.Join(appDir, "audit.log")
 		}
 		templatesDir = filepath.Join(appDir, "templates")
-		historyFile = filepath.Join(currentUser.HomeDir, fmt.Sprintf(".%s.history", name))
+		historyFile = filepath.Join(home, fmt.Sprintf(".%s.history", name))
 	}
 
 	exec := &CommandExecutor{
 		AppName:         appName,
 		Variables: safemap.New[string, string](),
 		aliases:         safemap.New[string, string](),
+		functions:       safemap.New[string, *Function](),
 		JobManager:      NewJobManager(),
 		Config:          config,
 		LogManager:      NewLogManager(logFile),
@@ -137,6 +168,10 @@ func NewCommandExecutor(appName string, customizer func(*CommandExecutor) error)
 		NoColor:         os.Getenv("NO_COLOR") != "", // Respect NO_COLOR env var
 	}
 
+	// Run the configured command hooks and audit every top-level command line,
+	// whichever transport it came from
+	exec.Use(HookMiddleware(exec), AuditMiddleware(exec.LogManager))
+
 	// Apply logging configuration from config file
 	if config != nil {
 		exec.applyLoggingConfig()
@@ -151,6 +186,14 @@ func NewCommandExecutor(appName string, customizer func(*CommandExecutor) error)
 		}
 	}
 
+	// Config aliases and variables take precedence over the customizer's defaults
+	exec.applyConfig()
+
+	// Saved functions, before any command tree is built
+	if err := exec.LoadFunctions(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
 	return exec, nil
 }
 
@@ -162,8 +205,10 @@ func (e *CommandExecutor) AddBuiltinCommands() {
 }
 
 // AddCommands registers a command customizer function.
+// Cached command trees are rebuilt on the next execution.
 func (e *CommandExecutor) AddCommands(cmds func(*cobra.Command)) {
 	e.rootInit = append(e.rootInit, cmds)
+	e.treeCache.invalidate()
 }
 
 // HideInREPL marks a command to be hidden when in REPL mode.
@@ -181,240 +226,470 @@ func (e *CommandExecutor) Execute(line string, scope *safemap.SafeMap[string, st
 
 // ExecuteWithContext executes a command line with context support for cancellation and timeout.
 func (e *CommandExecutor) ExecuteWithContext(ctx context.Context, line string, scope *safemap.SafeMap[string, string]) (string, error) {
-	// Track command execution time for logging
+	var output bytes.Buffer
+	err := e.ExecuteStream(ctx, line, scope, &output)
+	return output.String(), err
+}
+
+// ExecuteResult executes a command line and returns its output, error and exit status.
+func (e *CommandExecutor) ExecuteResult(ctx context.Context, line string, scope *safemap.SafeMap[string, string]) *ExecutionResult {
 	startTime := time.Now()
+	output, err := e.ExecuteWithContext(ctx, line, scope)
+	return &ExecutionResult{
+		Output:      output,
+		Error:       err,
+		Success:     err == nil,
+		ExitStatus:  ExitStatus(err),
+		Duration:    time.Since(startTime),
+		CommandLine: line,
+	}
+}
 
-	// Track recursion depth to prevent infinite loops (thread-safe)
-	depth := e.execDepth.Add(1)
-	defer e.execDepth.Add(-1)
+// ExecuteStream executes a command line and writes output to w as it is produced.
+// Pipe stages run concurrently, so transports can deliver output incrementally
+// instead of waiting for the whole line to finish.
+// The line and each of its pipeline stages run through the middleware chain (see Use).
+func (e *CommandExecutor) ExecuteStream(ctx context.Context, line string, scope *safemap.SafeMap[string, string], w io.Writer) error {
+	// Track recursion depth to prevent infinite loops. Nested executions inherit
+	// the depth through their context, so concurrent sessions do not add up.
+	depth := execDepth(ctx) + 1
+	ctx = context.WithValue(ctx, execDepthKey{}, depth)
 
 	if depth > e.maxExecDepth {
-		return "", fmt.Errorf("maximum execution depth exceeded (%d) - possible infinite recursion", e.maxExecDepth)
+		return fmt.Errorf("maximum execution depth exceeded (%d) - possible infinite recursion", e.maxExecDepth)
 	}
 
 	// Check if context is already cancelled
 	select {
 	case <-ctx.Done():
-		return "", fmt.Errorf("command cancelled: %w", ctx.Err())
+		return fmt.Errorf("command cancelled: %w", ctx.Err())
 	default:
 	}
 
-	rootCmd := e.RootCmd()
-	line = e.ExpandCommand(rootCmd, scope, line)
-
-	outputFile, commands, err := parser.ParseCommands(line)
-	if err != nil {
-		// Log failed command
-		if e.LogManager.IsEnabled() && depth == 1 {
-			_ = e.LogManager.Log(AuditLog{
-				Timestamp: startTime,
-				User:      e.getCurrentUser(),
-				Command:   line,
-				Duration:  time.Since(startTime),
-				Success:   false,
-				Error:     err.Error(),
-			})
-		}
-		return "", err
+	inv := &Invocation{
+		Line:     line,
+		Session:  SessionFromContext(ctx),
+		Scope:    scope,
+		Subshell: subshellScope(ctx),
+		Depth:    depth,
+		Start:    time.Now(),
+		Output:   w,
 	}
+	return e.invoke(ctx, inv, e.executeLine)
+}
 
-	output, err := e.executeCommandsWithContext(ctx, rootCmd, commands)
-
-	// Log command execution (only log top-level commands, not recursive calls)
-	if e.LogManager.IsEnabled() && depth == 1 {
-		logEntry := AuditLog{
-			Timestamp: startTime,
-			User:      e.getCurrentUser(),
-			Command:   line,
-			Output:    output,
-			Duration:  time.Since(startTime),
-			Success:   err == nil,
-		}
-		if err != nil {
-			logEntry.Error = err.Error()
+// executeLine expands, parses and runs a command line. It is the innermost
+// handler of a line invocation.
+func (e *CommandExecutor) executeLine(ctx context.Context, inv *Invocation) error {
+	rootCmd, gen := e.acquireTree()
+	defer e.releaseTree(rootCmd, gen)
+	rootCmd.SetContext(ctx) // Expansion reads the session from the command's context
+
+	// Heredocs and blocks become quoted words before expansion
+	line, err := parser.JoinLines(inv.Line)
+	if err == nil {
+		line, err = e.expandAliases(ctx, line)
+	}
+	var commands []*parser.ExecCmd
+	if err == nil {
+		var unbound string
+		line, unbound = e.expandLineVars(ctx, inv.Scope, line)
+		if unbound != "" && scriptOption(ctx, optNounset) {
+			e.SetVariable(ctx, lastStatusVar, "1")
+			return &ExitError{Status: 1, Err: fmt.Errorf("%s: unbound variable", unbound)}
 		}
-		_ = e.LogManager.Log(logEntry)
+		commands, err = parser.Parse(line)
 	}
-
 	if err != nil {
-		return output, err
+		// Syntax errors use status 2, like a shell
+		e.SetVariable(ctx, lastStatusVar, "2")
+		return &ExitError{Status: 2, Err: err}
 	}
 
-	// Handle file redirection if specified
-	if outputFile != "" {
-		err = e.FileHandler.WriteFile(outputFile, output)
-		if err != nil {
-			return output, fmt.Errorf("failed to write to file %s: %w", outputFile, err)
-		}
-	}
+	// Stages see the expanded line. Concurrent stages and groups share its output.
+	expanded := *inv
+	expanded.Line = line
+	expanded.Output = &syncWriter{w: inv.Output}
+	return e.executeCommandsWithContext(ctx, &expanded, rootCmd, commands, nil, expanded.Output, expanded.Output)
+}
+
+type execDepthKey struct{}
 
-	return output, nil
+// execDepth returns the nesting depth of the execution that ctx belongs to.
+func execDepth(ctx context.Context) int32 {
+	depth, _ := ctx.Value(execDepthKey{}).(int32)
+	return depth
 }
 
+// lastStatusVar holds the exit status of the last executed command.
+const lastStatusVar = "@?"
+
+// lastJobVar holds the ID of the last job started with "&".
+const lastJobVar = "@!"
+
 // executeCommandsWithContext executes parsed commands with context support for cancellation.
-func (e *CommandExecutor) executeCommandsWithContext(ctx context.Context, rootCmd *cobra.Command, commands []*parser.ExecCmd) (string, error) {
-	var output bytes.Buffer
-	var input io.Reader
+// Each top-level command runs to completion before the next one starts; the stages of a
+// pipe chain run concurrently and stream into each other.
+//
+// Commands chained with && or || run depending on the status of the last command that
+// ran. A failure that is not followed by && or || stops the remaining commands.
+//
+// in is the standard input of the commands (nil for a line; the input of a group) and
+// errOut receives their standard error.
+func (e *CommandExecutor) executeCommandsWithContext(ctx context.Context, line *Invocation, rootCmd *cobra.Command, commands []*parser.ExecCmd, in io.Reader, out, errOut io.Writer) error {
+	var err error
+	abort := false
+
+	for i, cmd := range commands {
+		switch cmd.Op {
+		case parser.OpAnd:
+			if err != nil {
+				continue
+			}
+		case parser.OpOr:
+			if err == nil {
+				continue
+			}
+		default:
+			if abort {
+				return err
+			}
+		}
 
-	for _, cmd := range commands {
 		// Check for cancellation before each command
 		select {
 		case <-ctx.Done():
-			return output.String(), fmt.Errorf("command execution cancelled: %w", ctx.Err())
+			return fmt.Errorf("command execution cancelled: %w", ctx.Err())
 		default:
 		}
 
-		var buf bytes.Buffer
-		curCmd := cmd
+		// The first command reuses the tree that was used for expansion
+		if i > 0 {
+			rootCmd = nil
+		}
 
-		for curCmd != nil {
-			// Check for cancellation in pipeline
-			select {
-			case <-ctx.Done():
-				return output.String(), fmt.Errorf("command execution cancelled: %w", ctx.Err())
-			default:
+		if f := frameFrom(ctx); f != nil {
+			f.setCommandLine(ctx, cmd.Line)
+			if f.state.option(optXtrace) {
+				_, _ = fmt.Fprintf(errOut, "+ %s\n", strings.TrimSpace(cmd.String()))
 			}
+		}
 
-			args := append([]string{curCmd.Cmd}, curCmd.Args...)
+		if cmd.Background {
+			err = e.startJob(ctx, line, cmd, errOut)
+		} else {
+			err = e.executePipeline(ctx, line, rootCmd, cmd, in, out, errOut)
+		}
+		if err == nil && ctx.Err() != nil {
+			// The command returned early because it was interrupted
+			err = fmt.Errorf("command cancelled: %w", ctx.Err())
+		}
+		e.SetVariable(ctx, lastStatusVar, strconv.Itoa(ExitStatus(err)))
 
-			rootCmd.SetArgs(args)
-			rootCmd.SetOut(&buf)
-			rootCmd.SetErr(&buf)
+		// A failure consumed by a following && or || is handled by the chain
+		abort = err != nil && (i+1 == len(commands) || commands[i+1].Op == "")
+	}
 
-			if input != nil {
-				rootCmd.SetIn(input)
+	return err
+}
+
+// executePipeline runs the stages of a pipe chain concurrently, each on its own command
+// tree, connected by synchronous pipes. A stage blocks while the next one is not reading
+// (backpressure). When a stage exits, the stages feeding it are cancelled and their
+// writes fail, so an infinite producer stops once its consumer is done.
+//
+// The pipeline's status is that of its last stage; an upstream stage that fails with
+// an error other than an exit status (e.g. an unknown command) also fails the pipeline,
+// and so does any failing stage in a script that ran "setopt pipefail".
+func (e *CommandExecutor) executePipeline(ctx context.Context, line *Invocation, rootCmd *cobra.Command, chain *parser.ExecCmd, in io.Reader, out, errOut io.Writer) error {
+	var stages []*parser.ExecCmd
+	for cur := chain; cur != nil; cur = cur.Pipe {
+		stages = append(stages, cur)
+	}
+
+	// Get the trees up front; command registration is not safe to run concurrently
+	roots := make([]*cobra.Command, len(stages))
+	for i := range stages {
+		if i == 0 && rootCmd != nil {
+			roots[i] = rootCmd
+			continue
+		}
+		root, gen := e.acquireTree()
+		defer e.releaseTree(root, gen)
+		roots[i] = root
+	}
+
+	// Single command: nothing to connect
+	if len(stages) == 1 {
+		return e.runStage(ctx, line, roots[0], stages[0], in, out, errOut)
+	}
+
+	readers := make([]*io.PipeReader, len(stages)-1)
+	writers := make([]*io.PipeWriter, len(stages)-1)
+	for i := range readers {
+		readers[i], writers[i] = io.Pipe()
+	}
+
+	cancels := make([]context.CancelFunc, len(stages))
+	finished := make([]atomic.Bool, len(stages))
+	stopped := make([]atomic.Bool, len(stages))
+	errs := make([]error, len(stages))
+
+	var wg sync.WaitGroup
+	for i, stage := range stages {
+		stageCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+
+		stageIn := in
+		if i > 0 {
+			stageIn = readers[i-1]
+		}
+		stageOut := out
+		if i < len(stages)-1 {
+			stageOut = writers[i]
+		}
+
+		wg.Add(1)
+		go func(i int, stage *parser.ExecCmd) {
+			defer wg.Done()
+
+			errs[i] = e.runStage(stageCtx, line, roots[i], stage, stageIn, stageOut, errOut)
+			finished[i].Store(true)
+
+			// Signal EOF downstream
+			if i < len(writers) {
+				_ = writers[i].Close()
 			}
 
-			if err := rootCmd.Execute(); err != nil {
-				return buf.String(), err
+			// Stop everything feeding this stage
+			for j := i - 1; j >= 0; j-- {
+				if !finished[j].Load() {
+					stopped[j].Store(true)
+				}
+				_ = readers[j].CloseWithError(errPipelineClosed)
+				cancels[j]()
 			}
+		}(i, stage)
+	}
+
+	wg.Wait()
+	for _, cancel := range cancels {
+		cancel()
+	}
+
+	if err := errs[len(errs)-1]; err != nil {
+		return err
+	}
 
-			input = &buf
-			curCmd = curCmd.Pipe
+	// With "setopt pipefail", the rightmost failing stage fails the pipeline
+	if scriptOption(ctx, optPipefail) {
+		for i := len(errs) - 2; i >= 0; i-- {
+			if errs[i] != nil && !stopped[i].Load() {
+				return errs[i]
+			}
 		}
+	}
 
-		output.Write(buf.Bytes())
+	// Report the first upstream failure, ignoring stages that were stopped by their consumer
+	for i, err := range errs[:len(errs)-1] {
+		var exitErr *ExitError
+		if err != nil && !stopped[i].Load() && !errors.As(err, &exitErr) {
+			return err
+		}
 	}
 
-	return output.String(), nil
+	return nil
 }
 
-// ExpandCommand performs token replacement including aliases, defaults, and custom replacers.
-// Use this for full command line processing before execution.
-func (e *CommandExecutor) ExpandCommand(cmd *cobra.Command, scope *safemap.SafeMap[string, string], input string) string {
-	// Check if entire line matches an alias
-	e.aliases.ForEach(func(k string, v string) bool {
-		if k == input {
-			input = v
-			return true
+// errPipelineClosed is returned to writes on a pipe whose reading stage has exited.
+var errPipelineClosed = errors.New("pipeline closed by downstream command")
+
+// runStage runs a single pipeline stage of line through the middleware chain.
+// The stage's standard error goes to errOut (normally the line's output) rather
+// than down the pipe; its redirections are applied last, inside the chain.
+func (e *CommandExecutor) runStage(ctx context.Context, line *Invocation, rootCmd *cobra.Command, stage *parser.ExecCmd, in io.Reader, out, errOut io.Writer) error {
+	inv := *line
+	inv.Stage = stage
+	inv.Start = time.Now()
+	inv.Input = in
+	inv.Output = out
+	inv.ErrOutput = errOut
+
+	return e.invoke(ctx, &inv, func(ctx context.Context, inv *Invocation) error {
+		// The command reads and sets the variables of its subshell
+		if inv.Subshell != nil {
+			ctx = withSubshell(ctx, inv.Subshell)
 		}
-		return false
-	})
 
-	// Also check if first word matches an alias (for cases like "pp|grep u")
-	// Split by first space or special chars to get the first command
-	firstWord := input
-	if idx := strings.IndexAny(input, " |>;"); idx != -1 {
-		firstWord = input[:idx]
-	}
+		// Help and completion leave out commands the session can't run
+		unhide := e.hideUnauthorized(ctx, rootCmd)
+		defer unhide()
 
-	e.aliases.ForEach(func(k string, v string) bool {
-		if k == firstWord && k != input { // Don't double-replace exact matches
-			// Replace first word with alias value
-			input = v + input[len(firstWord):]
-			return true
+		if err := e.authorizeStage(ctx, rootCmd, inv.Stage); err != nil {
+			return err
 		}
-		return false
+
+		// Output redirected to files is also shown on the line's output
+		sio := &stageIO{in: inv.Input, out: inv.Output, err: inv.ErrOutput}
+		if err := e.redirect(ctx, inv.Stage.Redirects, sio, line.Output); err != nil {
+			return err
+		}
+
+		var err error
+		if inv.Stage.Group != nil {
+			err = e.executeGroup(ctx, line, inv.Stage, sio)
+		} else {
+			err = executeStage(ctx, rootCmd, inv.Stage, sio.in, sio.out, sio.err)
+		}
+		if werr := e.writeFiles(sio.files); werr != nil && err == nil {
+			err = werr
+		}
+		return err
 	})
+}
 
-	e.Variables.ForEach(func(k string, v string) bool {
-		input = strings.ReplaceAll(input, k, v)
-		return false
+// executeGroup runs the commands of a "( )" or "{ }" group with the group's
+// streams. A "( )" group runs in a subshell: variables it sets are discarded.
+func (e *CommandExecutor) executeGroup(ctx context.Context, line *Invocation, group *parser.ExecCmd, sio *stageIO) error {
+	if group.Subshell {
+		sub := *line
+		sub.Subshell = e.newSubshell(ctx)
+		line = &sub
+	}
+	return e.executeCommandsWithContext(ctx, line, nil, group.Group, sio.in, sio.out, sio.err)
+}
+
+// startJob starts a "cmd &" pipeline as an in-process job of the JobManager and
+// sets @! to its ID. The job runs in a subshell on its own command trees, and its
+// output is captured for "job <id> logs" rather than written to the line.
+func (e *CommandExecutor) startJob(ctx context.Context, line *Invocation, cmd *parser.ExecCmd, errOut io.Writer) error {
+	fg := *cmd
+	fg.Background = false
+	command := strings.TrimSpace(fg.String())
+
+	id := e.JobManager.AddFunc(ctx, command, func(ctx context.Context, out io.Writer) error {
+		jobLine := *line
+		jobLine.Output = out
+		jobLine.Subshell = e.newSubshell(ctx)
+		return e.executePipeline(ctx, &jobLine, nil, &fg, nil, out, out)
 	})
+	e.SetVariable(ctx, lastJobVar, strconv.Itoa(id))
+	_, _ = fmt.Fprintf(errOut, "[%d] %s\n", id, command)
+	return nil
+}
 
-	// Replace scoped variables (scope) before custom replacers
-	if scope != nil {
-		scope.ForEach(func(k string, v string) bool {
-			input = strings.ReplaceAll(input, k, v)
-			return false
-		})
+// authorizeStage checks the command a stage resolves to (e.g. "job kill")
+// against the policy and roles of the session in ctx.
+func (e *CommandExecutor) authorizeStage(ctx context.Context, rootCmd *cobra.Command, stage *parser.ExecCmd) error {
+	// The commands of a group are authorized as they run
+	if !e.restricted(ctx) || stage.Group != nil {
+		return nil
 	}
 
-	for _, replacer := range e.VariableExpanders {
-		input, stop := replacer(input)
-		if stop {
-			return input
+	cmd, _, err := rootCmd.Find(append([]string{stage.Cmd}, stage.Args...))
+	if err != nil || cmd == rootCmd {
+		// Unknown commands fail in cobra, unless the policy denies the name first
+		if policy := SessionFromContext(ctx).Policy; policy != nil && !policy.IsCommandAllowed(stage.Cmd) {
+			return fmt.Errorf("%w: %s", ErrCommandNotAllowed, stage.Cmd)
 		}
+		return nil
 	}
-	input = e.replaceToken(scope, input)
+	return e.authorize(ctx, cmd)
+}
 
-	return input
+// commandPath returns the path of cmd below the root, e.g. "job kill".
+func commandPath(cmd *cobra.Command) string {
+	var names []string
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		names = append([]string{c.Name()}, names...)
+	}
+	return strings.Join(names, " ")
 }
 
-// ExpandVariables replaces only variables (@tokens), NOT aliases.
-// Use this for processing command arguments to prevent alias expansion in the middle of commands.
-func (e *CommandExecutor) ExpandVariables(cmd *cobra.Command, scope *safemap.SafeMap[string, string], input string) string {
-	// Replace variables from Defaults (with @ prefix)
-	e.Variables.ForEach(func(k string, v string) bool {
-		input = strings.ReplaceAll(input, k, v)
-		return false
-	})
+// executeStage executes a single pipeline stage on the given command tree.
+func executeStage(ctx context.Context, rootCmd *cobra.Command, stage *parser.ExecCmd, in io.Reader, out, errOut io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("command %s panicked: %v", stage.Cmd, r)
+		}
+	}()
+
+	args := append([]string{stage.Cmd}, stage.Args...)
 
-	// Replace scoped variables (scope)
-	if scope != nil {
-		scope.ForEach(func(k string, v string) bool {
-			input = strings.ReplaceAll(input, k, v)
-			return false
-		})
+	rootCmd.SetArgs(args)
+	rootCmd.SetOut(out)
+	rootCmd.SetErr(errOut)
+
+	if in != nil {
+		rootCmd.SetIn(in)
 	}
 
-	// Apply custom token replacers
-	for _, replacer := range e.VariableExpanders {
-		input, stop := replacer(input)
-		if stop {
-			return input
-		}
+	return rootCmd.ExecuteContext(ctx)
+}
+
+// ExpandCommand performs token replacement including aliases, defaults, and custom replacers.
+// Use this for full command line processing before execution.
+// Session-local aliases and variables are taken from the session in cmd's context.
+func (e *CommandExecutor) ExpandCommand(cmd *cobra.Command, scope *safemap.SafeMap[string, string], input string) string {
+	ctx := cmdContext(cmd)
+
+	// An alias matching the entire line or its first word (for cases like
+	// "pp|grep u"); the line is left as it is if the alias can't be expanded
+	if expanded, err := e.expandAliases(ctx, input); err == nil {
+		input = expanded
 	}
 
-	// Replace built-in tokens (@env:, @exec:, etc.)
-	input = e.replaceToken(scope, input)
+	return e.expandLine(ctx, scope, input)
+}
 
+// ExpandVariables replaces only variables (@tokens), NOT aliases.
+// Use this for command arguments where alias expansion is not desired.
+// The result is a value rather than a command line: expansions are not quoted.
+func (e *CommandExecutor) ExpandVariables(cmd *cobra.Command, scope *safemap.SafeMap[string, string], input string) string {
+	input, _ = e.expandWith(&expander{e: e, ctx: cmdContext(cmd), scope: scope}, input)
 	return input
 }
 
-// replaceToken handles token replacement for environment variables, command execution, and defaults.
-func (e *CommandExecutor) replaceToken(scope *safemap.SafeMap[string, string], token string) string {
-	if strings.HasPrefix(token, "@env:") {
-		envVar := strings.TrimPrefix(token, "@env:")
-		if value, exists := os.LookupEnv(envVar); exists {
-			return value
-		}
-		return token
-	}
+// expandLine expands the variables and substitutions of input in one pass
+// (see expander), then runs the custom VariableExpanders over the result.
+func (e *CommandExecutor) expandLine(ctx context.Context, scope *safemap.SafeMap[string, string], input string) string {
+	input, _ = e.expandLineVars(ctx, scope, input)
+	return input
+}
 
-	if strings.HasPrefix(token, "@exec:") {
-		toExec := strings.TrimPrefix(token, "@exec:")
-		res, _ := e.Execute(toExec, scope)
-		return res
-	}
+// expandLineVars is expandLine that also returns the first undefined variable
+// input references, for "set -u".
+func (e *CommandExecutor) expandLineVars(ctx context.Context, scope *safemap.SafeMap[string, string], input string) (string, string) {
+	return e.expandWith(&expander{e: e, ctx: ctx, scope: scope, words: true}, input)
+}
 
-	v, ok := e.Variables.Get(token)
-	if ok {
-		return v
-	}
+// expandWith expands input with x, then runs the custom VariableExpanders over
+// the result. It also returns the first undefined variable input references.
+func (e *CommandExecutor) expandWith(x *expander, input string) (string, string) {
+	input = x.expand(input)
 
-	if scope != nil {
-		v, ok := scope.Get(token)
-		if ok {
-			return v
+	for _, replacer := range e.VariableExpanders {
+		var stop bool
+		input, stop = replacer(input)
+		if stop {
+			break
 		}
 	}
 
-	return token
+	if len(x.unbound) > 0 {
+		return input, x.unbound[0]
+	}
+	return input, ""
+}
+
+// cmdContext returns the context of a command, or context.Background() if it has none.
+func cmdContext(cmd *cobra.Command) context.Context {
+	if cmd != nil && cmd.Context() != nil {
+		return cmd.Context()
+	}
+	return context.Background()
 }
 
-// RootCmd creates a new root Cobra command with all registered subcommands.
-// Returns a fresh command instance on each call.
 // globalFlagsTemplateBlock is the "Global Flags" section of cobra's default
 // usage template (spf13/cobra v1.10.2). It is stripped in RootCmd so inherited
 // persistent/startup flags don't clutter every subcommand's help in the REPL.
@@ -423,6 +698,13 @@ const globalFlagsTemplateBlock = `{{if .HasAvailableInheritedFlags}}
 Global Flags:
 {{.InheritedFlags.FlagUsages | trimTrailingWhitespaces}}{{end}}`
 
+// isolateCommandLine clears pflag.CommandLine once. Cobra merges it into the
+// root's persistent flags on every execution, which would share the host's flag
+// values between concurrently running command trees.
+var isolateCommandLine sync.Once
+
+// RootCmd creates a new root Cobra command with all registered subcommands.
+// Returns a fresh command instance on each call; executions reuse cached trees instead.
 func (e *CommandExecutor) RootCmd() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:                "",
@@ -443,11 +725,15 @@ func (e *CommandExecutor) RootCmd() *cobra.Command {
 	// block, the match simply fails and the section reappears (no breakage).
 	rootCmd.SetUsageTemplate(strings.Replace(rootCmd.UsageTemplate(), globalFlagsTemplateBlock, "", 1))
 
-	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	isolateCommandLine.Do(func() {
+		pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	})
 
 	for _, init := range e.rootInit {
 		init(rootCmd)
 	}
+	e.addScriptCommands(rootCmd)
+	e.addPluginCommands(rootCmd)
 
 	SetRecursiveHelpFunc(rootCmd)
 	return rootCmd
@@ -456,7 +742,14 @@ func (e *CommandExecutor) RootCmd() *cobra.Command {
 // GetAvailableCommands returns a list of all available command names.
 // This includes all registered commands and their subcommands.
 func (e *CommandExecutor) GetAvailableCommands() []string {
-	root := e.RootCmd()
+	return e.AvailableCommands(context.Background())
+}
+
+// AvailableCommands returns the command names available to the session in ctx,
+// leaving out commands its policy or roles don't allow, followed by its aliases.
+func (e *CommandExecutor) AvailableCommands(ctx context.Context) []string {
+	root, release := e.visibleTree(ctx)
+	defer release()
 	commands := make([]string, 0)
 
 	// Helper function to recursively collect command names
@@ -486,16 +779,13 @@ func (e *CommandExecutor) GetAvailableCommands() []string {
 	collectCommands(root, "")
 
 	// Also include aliases
-	e.aliases.SortedForEach(func(alias, _ string) bool {
-		commands = append(commands, alias)
-		return true // Continue iteration
-	})
+	commands = append(commands, sortedKeys(e.VisibleAliases(ctx))...)
 
 	return commands
 }
 
-// getCurrentUser returns the current username for logging.
-func (e *CommandExecutor) getCurrentUser() string {
+// currentUser returns the current username for logging.
+func currentUser() string {
 	if u, err := user.Current(); err == nil {
 		return u.Username
 	}
@@ -545,6 +835,77 @@ func (e *CommandExecutor) applyNotificationConfig() {
 	}
 }
 
+// applyConfig applies the aliases, variables and settings of the config file.
+// Hooks and the prompt are read from the config each time they are used.
+func (e *CommandExecutor) applyConfig() {
+	if e.Config == nil {
+		return
+	}
+
+	for k, v := range e.Config.Aliases {
+		e.aliases.Set(k, v)
+	}
+
+	for k, v := range e.Config.Variables {
+		if !strings.HasPrefix(k, "@") {
+			k = "@" + k
+		}
+		e.Variables.Set(k, v)
+	}
+
+	e.applyColor()
+	if e.HistoryManager != nil {
+		e.HistoryManager.SetMaxEntries(e.Config.Settings.HistorySize)
+	}
+
+	// The script path may have changed
+	e.treeCache.invalidate()
+}
+
+// applyConfigKey applies a single key changed by "config set", leaving
+// aliases and variables set at runtime alone.
+func (e *CommandExecutor) applyConfigKey(key string) {
+	if e.Config == nil {
+		return
+	}
+
+	switch {
+	case key == "settings.color":
+		e.applyColor()
+	case key == "settings.history_size":
+		if e.HistoryManager != nil {
+			e.HistoryManager.SetMaxEntries(e.Config.Settings.HistorySize)
+		}
+	case key == "settings.script_path":
+		e.treeCache.invalidate()
+	case strings.HasPrefix(key, "logging."):
+		e.applyLoggingConfig()
+	}
+}
+
+// applyColor applies settings.color. Turning color back on only clears
+// NoColor when the setting disabled it, so NO_COLOR and non-TTY output
+// stay uncolored.
+func (e *CommandExecutor) applyColor() {
+	switch {
+	case !e.Config.Settings.Color:
+		if !e.NoColor {
+			e.NoColor, e.colorOff = true, true
+		}
+	case e.colorOff:
+		e.NoColor, e.colorOff = false, false
+	}
+}
+
+// PromptText returns the REPL prompt: settings.prompt of the config file, with
+// "%s" replaced by the application name.
+func (e *CommandExecutor) PromptText() string {
+	if e.Config == nil || e.Config.Settings.Prompt == "" {
+		return e.AppName + " > "
+	}
+	return strings.ReplaceAll(e.Config.Settings.Prompt, "%s", e.AppName)
+}
+
 // LoadAliases loads aliases from the ~/.{appname}.aliases file.
 func (e *CommandExecutor) LoadAliases() error {
 	// Get the user's home directory
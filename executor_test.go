@@ -1,11 +1,21 @@
 package consolekit
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/alexj212/consolekit/safemap"
+	"github.com/spf13/cobra"
 )
 
 func TestExecute(t *testing.T) {
@@ -201,6 +211,53 @@ func TestContextCancellation(t *testing.T) {
 	}
 }
 
+func TestRunningCommandCancellation(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "sleep", line: "sleep --quiet 30"},
+		{name: "sleep with progress", line: "sleep 30"},
+		{name: "waitfor", line: "waitfor --target 10 --interval 30"},
+		{name: "repeat", line: "repeat --count -1 --sleep 30 'print tick'"},
+		{name: "watch", line: "watch --interval 30s 'print tick'"},
+		{name: "while", line: `while "test 1 -eq 1" "sleep --quiet 30"`},
+		{name: "for", line: `for i in 1 2 3 do "sleep --quiet 30"`},
+		{name: "pipeline", line: "sleep --quiet 30 | grep x"},
+		{name: "chain", line: "sleep --quiet 30; print chained"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			result := executor.ExecuteResult(ctx, tt.line, nil)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("command ran for %v after its context expired", elapsed)
+			}
+			if !errors.Is(result.Error, context.DeadlineExceeded) {
+				t.Errorf("Error = %v, want context.DeadlineExceeded", result.Error)
+			}
+			if result.ExitStatus != 124 {
+				t.Errorf("ExitStatus = %d, want 124", result.ExitStatus)
+			}
+			if strings.Contains(result.Output, "chained") {
+				t.Errorf("command after the interrupted one ran: %q", result.Output)
+			}
+		})
+	}
+}
+
 func TestRecursionProtection(t *testing.T) {
 	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
 		exec.AddBuiltinCommands()
@@ -210,17 +267,16 @@ func TestRecursionProtection(t *testing.T) {
 		t.Fatalf("Failed to create executor: %v", err)
 	}
 
-	// Create circular token reference using @exec:
-	// When @recursive is expanded, it triggers another execution of the same variable
-	executor.Variables.Set("@recursive", "@exec:print @recursive")
+	// Create a circular reference: expanding the alias runs the alias again.
+	// Expanded values are not expanded again, so a variable can't recurse.
+	executor.aliases.Set("recursive", "print x$(recursive)")
 
-	output, err := executor.Execute("print @recursive", nil)
+	output, err := executor.Execute("recursive", nil)
 	// The recursion protection works by limiting depth (maxExecDepth = 10)
-	// After 10 recursive calls, it stops but doesn't propagate the error through @exec: token replacement
-	// So we check that it stopped after a reasonable number of iterations (10 newlines = 10 executions)
-	newlineCount := strings.Count(output, "\n")
-	if newlineCount != 10 {
-		t.Errorf("Expected recursion to stop after 10 iterations, got %d newlines in output: %q", newlineCount, output)
+	// After 10 recursive calls, it stops but doesn't propagate the error through $() substitution
+	// So we check that it stopped after a reasonable number of iterations (10 x's = 10 executions)
+	if count := strings.Count(output, "x"); count != 10 {
+		t.Errorf("Expected recursion to stop after 10 iterations, got %d in output: %q", count, output)
 	}
 	if err != nil {
 		t.Logf("Got error (expected behavior): %v", err)
@@ -271,6 +327,247 @@ func TestFileRedirection(t *testing.T) {
 	}
 }
 
+// memFiles is an in-memory FileHandler, standing in for a sandboxed transport.
+type memFiles struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func (m *memFiles) WriteFile(path string, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[path] = content
+	return nil
+}
+
+func (m *memFiles) ReadFile(path string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.files[path]
+	if !ok {
+		return "", fs.ErrNotExist
+	}
+	return content, nil
+}
+
+func TestRedirection(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		line    string
+		want    string            // Output returned to the caller
+		files   map[string]string // Expected file contents afterwards
+		wantErr bool
+	}{
+		{
+			name:  "output is shown and written",
+			line:  "print one > /f",
+			want:  "one\n",
+			files: map[string]string{"/f": "one\n"},
+		},
+		{
+			name:  "append",
+			line:  "print one > /f; print two >> /f",
+			want:  "one\ntwo\n",
+			files: map[string]string{"/f": "one\ntwo\n"},
+		},
+		{
+			name:  "append creates the file",
+			line:  "print new >> /new",
+			want:  "new\n",
+			files: map[string]string{"/new": "new\n"},
+		},
+		{
+			name: "input",
+			line: "print a > /in; print b >> /in; grep b < /in",
+			want: "a\nb\nb\n",
+		},
+		{
+			name:    "missing input",
+			line:    "grep b < /missing",
+			wantErr: true,
+		},
+		{
+			name:  "stderr to a file",
+			line:  "print oops 2> /err >&2",
+			want:  "",
+			files: map[string]string{"/err": "oops\n"},
+		},
+		{
+			name: "stderr bypasses the pipe",
+			line: "print oops >&2 | grep zzz",
+			want: "oops\n",
+		},
+		{
+			name: "stderr into the pipe",
+			line: "print oops 2>&1 >&2 | grep zzz",
+			want: "",
+		},
+		{
+			name:  "per command in a chain",
+			line:  "print a > /a ; print b > /b",
+			want:  "a\nb\n",
+			files: map[string]string{"/a": "a\n", "/b": "b\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := &memFiles{files: make(map[string]string)}
+			executor.FileHandler = files
+
+			out, err := executor.Execute(tt.line, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			}
+			if out != tt.want {
+				t.Errorf("output = %q, want %q", out, tt.want)
+			}
+			for path, want := range tt.files {
+				if got := files.files[path]; got != want {
+					t.Errorf("%s = %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestScriptBlocks(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(AddRun(exec, nil))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	script := `for i in a b do {
+    print item-@i
+}
+grep two <<EOF
+one
+two
+EOF
+case x y {
+    print wrong
+} x {
+    print matched
+}
+`
+	lines, err := ReadLines(strings.NewReader(script))
+	if err != nil {
+		t.Fatalf("ReadLines failed: %v", err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("ReadLines returned %d commands, want 3: %q", len(lines), lines)
+	}
+
+	path := filepath.Join(t.TempDir(), "blocks.run")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	out, err := executor.Execute("run --quiet "+path, nil)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	for _, want := range []string{"item-a", "item-b", "two", "matched"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"one", "wrong"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("output contains %q:\n%s", unwanted, out)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	files := &memFiles{files: make(map[string]string)}
+	executor.FileHandler = files
+
+	tests := []struct {
+		name  string
+		line  string
+		want  string
+		files map[string]string // Expected file contents afterwards
+	}{
+		{name: "subshell piped", line: "( print a; print b ) | grep b", want: "b\n"},
+		{name: "group redirected", line: "{ print a; print b; } > /g", want: "a\nb\n", files: map[string]string{"/g": "a\nb\n"}},
+		{name: "group stderr", line: "{ print a; print b >&2; } 2> /err | grep nothing", files: map[string]string{"/err": "b\n"}},
+		{name: "chain in a group", line: "( nosuchcmd || print fallback ) | grep fall", want: "fallback\n"},
+		{name: "nested", line: "( print a; { print b; print c; } | grep c )", want: "a\nc\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := executor.Execute(tt.line, nil)
+			if err != nil {
+				t.Fatalf("Execute(%q) failed: %v", tt.line, err)
+			}
+			if out != tt.want {
+				t.Errorf("output = %q, want %q", out, tt.want)
+			}
+			for path, want := range tt.files {
+				if got := files.files[path]; got != want {
+					t.Errorf("%s = %q, want %q", path, got, want)
+				}
+			}
+		})
+	}
+
+	// Variables set in a subshell are discarded with it; a brace group shares the scope
+	if _, err := executor.Execute("let x=outer", nil); err != nil {
+		t.Fatalf("let failed: %v", err)
+	}
+	out, err := executor.Execute("( let x=inner; vars )", nil)
+	if err != nil || !strings.Contains(out, "inner") {
+		t.Errorf("subshell vars = %q (err %v), want x = inner", out, err)
+	}
+	if out, _ := executor.Execute("print @x", nil); out != "outer\n" {
+		t.Errorf("after subshell x = %q, want outer", out)
+	}
+
+	// The subshell's variables are passed to the stages of its commands
+	var subshell []string
+	executor.Use(func(next ExecHandler) ExecHandler {
+		return func(ctx context.Context, inv *Invocation) error {
+			if inv.IsStage() && inv.Subshell != nil {
+				x, _ := inv.Subshell.Get("@x")
+				subshell = append(subshell, inv.Stage.Cmd+" "+x)
+			}
+			return next(ctx, inv)
+		}
+	})
+	if _, err := executor.Execute("print a; ( let x=inner; print b )", nil); err != nil {
+		t.Fatalf("subshell failed: %v", err)
+	}
+	if got := strings.Join(subshell, ", "); got != "let outer, print inner" {
+		t.Errorf("stages in the subshell = %q, want let outer, print inner", got)
+	}
+	if _, err := executor.Execute("{ let x=changed; }", nil); err != nil {
+		t.Fatalf("group failed: %v", err)
+	}
+	if out, _ := executor.Execute("print @x", nil); out != "changed\n" {
+		t.Errorf("after group x = %q, want changed", out)
+	}
+}
+
 func BenchmarkExecute(b *testing.B) {
 	executor, err := NewCommandExecutor("bench-app", func(exec *CommandExecutor) error {
 		exec.AddBuiltinCommands()
@@ -323,3 +620,320 @@ func TestConcurrentExecution(t *testing.T) {
 		}
 	}
 }
+
+func TestConcurrentFlagIsolation(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	executor.Variables.Set("@nested", "@exec:print @exec:print @exec:print deep")
+
+	// Each goroutine runs the same commands with different flag values in its own session
+	const concurrent = 20
+	var wg sync.WaitGroup
+	for i := 0; i < concurrent; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			ctx := WithSession(context.Background(), NewSession(strconv.Itoa(id), "test", ""))
+			count := id%4 + 1
+
+			out, err := executor.ExecuteWithContext(ctx, fmt.Sprintf("watch --count %d --interval 1ms 'print %d'", count, id), nil)
+			if err != nil {
+				t.Errorf("watch %d failed: %v", id, err)
+			}
+			if want := fmt.Sprintf("Watch completed after %d iterations", count); !strings.Contains(out, want) {
+				t.Errorf("watch %d: output missing %q: %q", id, want, out)
+			}
+
+			delim := ""
+			if id%2 == 0 {
+				delim = " --delim ,"
+			}
+			out, err = executor.ExecuteWithContext(ctx, "print a,b | table"+delim, nil)
+			if err != nil {
+				t.Errorf("table %d failed: %v", id, err)
+			}
+			if split := !strings.Contains(out, "a,b"); split != (delim != "") {
+				t.Errorf("table %d (delim %q): got %q", id, delim, out)
+			}
+
+			if _, err := executor.ExecuteWithContext(ctx, fmt.Sprintf("let v=%d", id), nil); err != nil {
+				t.Errorf("let %d failed: %v", id, err)
+			}
+			out, _ = executor.ExecuteWithContext(ctx, "print @v", nil)
+			if want := fmt.Sprintf("%d\n", id); out != want {
+				t.Errorf("session %d: @v = %q, want %q", id, out, want)
+			}
+
+			// Nesting depth is per call chain, not shared by concurrent executions
+			out, err = executor.ExecuteWithContext(ctx, "print @nested", nil)
+			if err != nil || !strings.Contains(out, "deep") {
+				t.Errorf("nested %d: got %q, err %v", id, out, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// firstWriteRecorder signals when the first chunk of output arrives.
+type firstWriteRecorder struct {
+	mu    sync.Mutex
+	buf   strings.Builder
+	first chan struct{}
+}
+
+func (r *firstWriteRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.buf.Len() == 0 {
+		close(r.first)
+	}
+	return r.buf.Write(p)
+}
+
+func TestPipelineStreaming(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	out := &firstWriteRecorder{first: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() {
+		done <- executor.ExecuteStream(context.Background(), `watch --count 3 --interval 300ms "print tick" | grep Iteration`, nil, out)
+	}()
+
+	// Output must arrive before the upstream stage has finished
+	select {
+	case <-out.first:
+	case err := <-done:
+		t.Fatalf("command finished before any output was streamed (err=%v)", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for streamed output")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("ExecuteStream() error = %v", err)
+	}
+	if got := strings.Count(out.buf.String(), "Iteration"); got != 3 {
+		t.Errorf("expected 3 iteration lines, got %d in %q", got, out.buf.String())
+	}
+}
+
+func TestPipelineDownstreamExit(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(func(rootCmd *cobra.Command) {
+			rootCmd.AddCommand(&cobra.Command{
+				Use: "firstline",
+				Run: func(cmd *cobra.Command, args []string) {
+					line, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+					cmd.Print(line)
+				},
+			})
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	// An endless producer must be stopped once its consumer exits
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	output, err := executor.ExecuteWithContext(ctx, `watch --interval 10ms "print tick" | grep Iteration | firstline`, nil)
+	if err != nil {
+		t.Fatalf("ExecuteWithContext() error = %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("upstream stages were not cancelled when the downstream stage exited")
+	}
+	if !strings.HasPrefix(output, "Iteration 1 ") || strings.Count(output, "\n") != 1 {
+		t.Errorf("output = %q, want the first iteration line only", output)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		line       string
+		wantStatus int
+		wantOut    string // Exact expected output
+	}{
+		{name: "true test", line: "test 1 -eq 1", wantStatus: 0},
+		{name: "false test", line: "test 1 -eq 2", wantStatus: 1},
+		{name: "unknown operator", line: "test 1 -xx 2", wantStatus: 2},
+		{name: "and skipped", line: "test 1 -eq 2 && print yes", wantStatus: 1},
+		{name: "or runs", line: "test 1 -eq 2 || print no", wantStatus: 0, wantOut: "no\n"},
+		{name: "and then or", line: "test 1 -eq 1 && print yes || print no", wantStatus: 0, wantOut: "yes\n"},
+		{name: "failed and falls to or", line: "test 1 -eq 2 && print yes || print no", wantStatus: 0, wantOut: "no\n"},
+		{name: "unhandled failure stops line", line: "test 1 -eq 2; print after", wantStatus: 1},
+		{name: "handled failure continues", line: "test 1 -eq 2 && print x; print after", wantStatus: 0, wantOut: "after\n"},
+		{name: "syntax error", line: "print a ||", wantStatus: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := executor.ExecuteResult(context.Background(), tt.line, nil)
+			if result.ExitStatus != tt.wantStatus {
+				t.Errorf("ExitStatus = %d, want %d (err=%v)", result.ExitStatus, tt.wantStatus, result.Error)
+			}
+			if result.Output != tt.wantOut {
+				t.Errorf("Output = %q, want %q", result.Output, tt.wantOut)
+			}
+
+			// @? exposes the status of the last command
+			status, _ := executor.Execute("print @?", nil)
+			if want := strconv.Itoa(tt.wantStatus) + "\n"; status != want {
+				t.Errorf("@? = %q, want %q", status, want)
+			}
+		})
+	}
+}
+
+func TestSessionIsolation(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	ctxA := WithSession(context.Background(), NewSession("a", "test", "alice"))
+	ctxB := WithSession(context.Background(), NewSession("b", "test", "bob"))
+
+	if _, err := executor.ExecuteWithContext(ctxA, "let x=1", nil); err != nil {
+		t.Fatalf("let failed: %v", err)
+	}
+
+	if out, _ := executor.ExecuteWithContext(ctxA, "print @x", nil); out != "1\n" {
+		t.Errorf("session a: @x = %q, want %q", out, "1\n")
+	}
+	if out, _ := executor.ExecuteWithContext(ctxB, "print @x", nil); out != "@x\n" {
+		t.Errorf("session b: @x = %q, want it unset", out)
+	}
+
+	// --global is visible to every session
+	if _, err := executor.ExecuteWithContext(ctxA, "let --global y=2", nil); err != nil {
+		t.Fatalf("let --global failed: %v", err)
+	}
+	if out, _ := executor.ExecuteWithContext(ctxB, "print @y", nil); out != "2\n" {
+		t.Errorf("session b: @y = %q, want %q", out, "2\n")
+	}
+
+	// Session aliases
+	if _, err := executor.ExecuteWithContext(ctxA, "alias add hi 'print hello'", nil); err != nil {
+		t.Fatalf("alias add failed: %v", err)
+	}
+	if out, _ := executor.ExecuteWithContext(ctxA, "hi", nil); out != "hello\n" {
+		t.Errorf("session a: hi = %q, want %q", out, "hello\n")
+	}
+	if _, ok := executor.GetAlias(ctxB, "hi"); ok {
+		t.Error("session b: alias hi should not be visible")
+	}
+
+	// Exit status is per session
+	executor.ExecuteResult(ctxA, "test 1 -eq 2", nil)
+	executor.ExecuteResult(ctxB, "test 1 -eq 1", nil)
+	if out, _ := executor.ExecuteWithContext(ctxA, "print @?", nil); out != "1\n" {
+		t.Errorf("session a: @? = %q, want %q", out, "1\n")
+	}
+	if out, _ := executor.ExecuteWithContext(ctxB, "print @?", nil); out != "0\n" {
+		t.Errorf("session b: @? = %q, want %q", out, "0\n")
+	}
+
+	// Working directory is per session
+	dir := t.TempDir()
+	if _, err := executor.ExecuteWithContext(ctxA, "cd "+dir, nil); err != nil {
+		t.Fatalf("cd failed: %v", err)
+	}
+	if out, _ := executor.ExecuteWithContext(ctxA, "pwd", nil); out != dir+"\n" {
+		t.Errorf("session a: pwd = %q, want %q", out, dir+"\n")
+	}
+	wd, _ := os.Getwd()
+	if out, _ := executor.ExecuteWithContext(ctxB, "pwd", nil); out != wd+"\n" {
+		t.Errorf("session b: pwd = %q, want %q", out, wd+"\n")
+	}
+}
+
+func TestSessionPolicy(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	script := filepath.Join(t.TempDir(), "script.txt")
+	if err := os.WriteFile(script, []byte("date\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	session := NewSession("s1", "test", "alice")
+	session.Policy = &TransportConfig{
+		DeniedCommands: []string{"date", "log clear", "risky"},
+		CommandGroups:  map[string][]string{"risky": {"os*", "clip*"}},
+	}
+	ctx := WithSession(context.Background(), session)
+	if _, err := executor.ExecuteWithContext(ctx, "alias add d date", nil); err != nil {
+		t.Fatalf("alias add failed: %v", err)
+	}
+
+	year := time.Now().Format("2006")
+	tests := []struct {
+		name    string
+		line    string
+		denied  bool   // Expect ErrCommandNotAllowed
+		noMatch string // Output must not contain this
+	}{
+		{name: "allowed", line: "print hi"},
+		{name: "denied", line: "date", denied: true},
+		{name: "after semicolon", line: "print hi; date", denied: true},
+		{name: "downstream stage", line: "print x | date", denied: true},
+		{name: "upstream stage", line: "date | grep " + year, denied: true},
+		{name: "alias", line: "d", denied: true},
+		{name: "subcommand", line: "log clear", denied: true},
+		{name: "sibling subcommand", line: "log status"},
+		{name: "group glob", line: "osexec ls", denied: true},
+		{name: "nested exec", line: "print @exec:date", noMatch: year},
+		{name: "script", line: "run " + script, noMatch: year},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := executor.ExecuteWithContext(ctx, tt.line, nil)
+			if got := errors.Is(err, ErrCommandNotAllowed); got != tt.denied {
+				t.Fatalf("denied = %v (err %v), want %v", got, err, tt.denied)
+			}
+			if tt.denied && ExitStatus(err) != 126 {
+				t.Errorf("ExitStatus = %d, want 126", ExitStatus(err))
+			}
+			if tt.noMatch != "" && strings.Contains(out, tt.noMatch) {
+				t.Errorf("denied command ran: %q", out)
+			}
+		})
+	}
+
+	// The same lines run without a policy
+	if out, err := executor.Execute("date", nil); err != nil || !strings.Contains(out, year) {
+		t.Errorf("date without policy = %q, %v", out, err)
+	}
+}
@@ -14,8 +14,6 @@ import (
 func AddFormatCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return func(rootCmd *cobra.Command) {
 		// table command - format output as table
-		var tableDelim string
-		var tableHeaders bool
 		var tableCmd = &cobra.Command{
 			Use:   "table",
 			Short: "Format input as a table",
@@ -27,6 +25,9 @@ Examples:
   print "Name,Age\\nJohn,30\\nJane,25" | table --delim ","
   print "Name Age\\nJohn 30\\nJane 25" | table --headers`,
 			Run: func(cmd *cobra.Command, args []string) {
+				tableDelim, _ := cmd.Flags().GetString("delim")
+				tableHeaders, _ := cmd.Flags().GetBool("headers")
+
 				scanner := bufio.NewScanner(cmd.InOrStdin())
 				var rows [][]string
 
@@ -94,11 +95,10 @@ Examples:
 				ResetAllFlags(cmd)
 			},
 		}
-		tableCmd.Flags().StringVarP(&tableDelim, "delim", "d", "", "Column delimiter (default: whitespace)")
-		tableCmd.Flags().BoolVarP(&tableHeaders, "headers", "H", false, "First line is headers")
+		tableCmd.Flags().StringP("delim", "d", "", "Column delimiter (default: whitespace)")
+		tableCmd.Flags().BoolP("headers", "H", false, "First line is headers")
 
 		// highlight command - highlight matching text
-		var highlightColor string
 		var highlightCmd = &cobra.Command{
 			Use:   "highlight [pattern]",
 			Short: "Highlight matching text in input",
@@ -110,6 +110,8 @@ Examples:
   print "192.168.1.1\\n10.0.0.1" | highlight "\\d+\\.\\d+\\.\\d+\\.\\d+" --color red`,
 			Args: cobra.ExactArgs(1),
 			Run: func(cmd *cobra.Command, args []string) {
+				highlightColor, _ := cmd.Flags().GetString("color")
+
 				pattern := args[0]
 				re, err := regexp.Compile(pattern)
 				if err != nil {
@@ -156,10 +158,9 @@ Examples:
 			},
 		}
 		// no -c shorthand: host apps commonly bind a persistent -c (e.g. --config).
-		highlightCmd.Flags().StringVar(&highlightColor, "color", "yellow", "Highlight color: red, green, blue, yellow, magenta, cyan")
+		highlightCmd.Flags().String("color", "yellow", "Highlight color: red, green, blue, yellow, magenta, cyan")
 
 		// page command - paginate output
-		var pageSize int
 		var pageCmd = &cobra.Command{
 			Use:   "page",
 			Short: "Paginate input",
@@ -170,6 +171,8 @@ Examples:
   print "$(cat large_file.txt)" | page
   print "$(cat large_file.txt)" | page --size 20`,
 			Run: func(cmd *cobra.Command, args []string) {
+				pageSize, _ := cmd.Flags().GetInt("size")
+
 				scanner := bufio.NewScanner(cmd.InOrStdin())
 				var lines []string
 
@@ -215,10 +218,9 @@ Examples:
 				ResetAllFlags(cmd)
 			},
 		}
-		pageCmd.Flags().IntVarP(&pageSize, "size", "s", 20, "Lines per page")
+		pageCmd.Flags().IntP("size", "s", 20, "Lines per page")
 
 		// column command - columnize output
-		var colCount int
 		var colCmd = &cobra.Command{
 			Use:   "column",
 			Short: "Format input into columns",
@@ -228,6 +230,8 @@ Examples:
   print "apple\\nbanana\\ncherry\\ndate\\nelder" | column --count 2
   print "1\\n2\\n3\\n4\\n5\\n6" | column -c 3`,
 			Run: func(cmd *cobra.Command, args []string) {
+				colCount, _ := cmd.Flags().GetInt("count")
+
 				scanner := bufio.NewScanner(cmd.InOrStdin())
 				var items []string
 
@@ -267,7 +271,7 @@ Examples:
 			},
 		}
 		// no -c shorthand: host apps commonly bind a persistent -c (e.g. --config).
-		colCmd.Flags().IntVar(&colCount, "count", 2, "Number of columns")
+		colCmd.Flags().Int("count", 2, "Number of columns")
 
 		rootCmd.AddCommand(tableCmd)
 		rootCmd.AddCommand(highlightCmd)
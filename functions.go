@@ -0,0 +1,459 @@
+package consolekit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/alexj212/consolekit/parser"
+	"github.com/kballard/go-shellquote"
+	"github.com/spf13/cobra"
+)
+
+// Function is a user-defined function: command lines run as a command of their
+// own. Its flags and positional arguments are set as variables while it runs.
+type Function struct {
+	Name  string
+	Flags []FunctionParam // Declared flags, set as @name
+	Args  []FunctionParam // Declared positional arguments, set as @name
+	Body  string          // The command lines it runs
+}
+
+// FunctionParam is a declared flag or positional argument of a function.
+// A flag without a default is required, and so is an argument; a flag whose
+// default is true or false is a boolean flag.
+type FunctionParam struct {
+	Name       string
+	Default    string
+	HasDefault bool
+}
+
+// functionAnnotation marks the commands of user-defined functions.
+const functionAnnotation = "function"
+
+// parseFunction parses the words of a definition, after "func": the name, its
+// "--flag[=default]" flags and "arg[=default]" arguments, then the body, as a
+// single word or as the words between "{" and "}".
+func parseFunction(words []string) (*Function, error) {
+	if len(words) < 2 {
+		return nil, fmt.Errorf("usage: func name [--flag[=default] ...] [arg[=default] ...] { body }")
+	}
+	f := &Function{Name: words[0]}
+	if !validFunctionName(f.Name) {
+		return nil, fmt.Errorf("invalid function name %q", f.Name)
+	}
+
+	params, body := words[1:len(words)-1], words[len(words)-1]
+	if body == "}" {
+		open := -1
+		for i, w := range words[1:] {
+			if w == "{" {
+				open = i + 1
+				break
+			}
+		}
+		if open == -1 {
+			return nil, fmt.Errorf("func %s: unmatched \"}\"", f.Name)
+		}
+		// Words are quoted again, so the body parses back to the same words
+		inner := slices.Clone(words[open+1 : len(words)-1])
+		for i, w := range inner {
+			inner[i] = quoteWord(w)
+		}
+		params, body = words[1:open], strings.Join(inner, " ")
+	}
+	f.Body = strings.TrimSpace(body)
+	if f.Body == "" {
+		return nil, fmt.Errorf("func %s: empty body", f.Name)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range params {
+		isFlag := strings.HasPrefix(p, "--")
+		name, def, hasDefault := strings.Cut(strings.TrimPrefix(p, "--"), "=")
+		if !validFunctionName(name) || (isFlag && name == "help") {
+			return nil, fmt.Errorf("func %s: invalid parameter %q", f.Name, p)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("func %s: duplicate parameter %q", f.Name, name)
+		}
+		seen[name] = true
+
+		param := FunctionParam{Name: name, Default: def, HasDefault: hasDefault}
+		if isFlag {
+			f.Flags = append(f.Flags, param)
+			continue
+		}
+		if n := len(f.Args); n > 0 && f.Args[n-1].HasDefault && !hasDefault {
+			return nil, fmt.Errorf("func %s: argument %q follows an optional argument", f.Name, name)
+		}
+		f.Args = append(f.Args, param)
+	}
+	return f, nil
+}
+
+// validFunctionName reports whether name can name a function or parameter:
+// a letter followed by letters, digits, "_" and "-".
+func validFunctionName(name string) bool {
+	for i, c := range name {
+		if !isNameRune(c) && (c != '-' || i == 0) {
+			return false
+		}
+	}
+	return name != "" && unicode.IsLetter([]rune(name)[0])
+}
+
+// Definition returns the func command that defines f.
+func (f *Function) Definition() string {
+	words := []string{"func", f.Name}
+	for _, p := range f.Flags {
+		words = append(words, "--"+p.String())
+	}
+	for _, p := range f.Args {
+		words = append(words, p.String())
+	}
+	return shellquote.Join(words...) + " {\n" + f.Body + "\n}"
+}
+
+// String returns the parameter as it is declared, without the "--" of a flag.
+func (p FunctionParam) String() string {
+	if p.HasDefault {
+		return p.Name + "=" + p.Default
+	}
+	return p.Name
+}
+
+// isBool reports whether the flag is a boolean flag.
+func (p FunctionParam) isBool() bool {
+	return p.HasDefault && (p.Default == "true" || p.Default == "false")
+}
+
+// usage returns the argument part of the function's usage line.
+func (f *Function) usage() string {
+	var b strings.Builder
+	b.WriteString(f.Name)
+	for _, p := range f.Args {
+		if p.HasDefault {
+			fmt.Fprintf(&b, " [%s]", p.Name)
+		} else {
+			fmt.Fprintf(&b, " %s", p.Name)
+		}
+	}
+	return b.String()
+}
+
+// command returns the cobra command that runs f.
+func (f *Function) command(exec *CommandExecutor) *cobra.Command {
+	required := 0
+	for _, p := range f.Args {
+		if !p.HasDefault {
+			required++
+		}
+	}
+
+	short, _, multiline := strings.Cut(f.Body, "\n")
+	if len(short) > 60 {
+		short, multiline = short[:57], true
+	}
+	if multiline {
+		short = strings.TrimSpace(short) + "..."
+	}
+
+	cmd := &cobra.Command{
+		Use:          f.usage(),
+		Short:        "Function: " + short,
+		Long:         "User-defined function.\n\n" + f.Definition(),
+		Args:         cobra.MinimumNArgs(required),
+		Annotations:  map[string]string{functionAnnotation: "true"},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vars := argVars(args)
+			for _, p := range f.Flags {
+				vars["@"+p.Name] = cmd.Flags().Lookup(p.Name).Value.String()
+			}
+			for i, p := range f.Args {
+				vars["@"+p.Name] = p.Default
+				if i < len(args) {
+					vars["@"+p.Name] = args[i]
+				}
+			}
+			return exec.runLines(cmd.Context(), f.Name, frameFunction, f.Body, vars, cmd.OutOrStdout())
+		},
+	}
+
+	for _, p := range f.Flags {
+		if p.isBool() {
+			cmd.Flags().Bool(p.Name, p.Default == "true", "Function flag")
+			continue
+		}
+		cmd.Flags().String(p.Name, p.Default, "Function flag")
+		if !p.HasDefault {
+			_ = cmd.MarkFlagRequired(p.Name)
+		}
+	}
+	return cmd
+}
+
+// argVars returns the variables holding the arguments of a function or script
+// command: @arg0, @arg1, ... and @args, the list of them all.
+func argVars(args []string) map[string]string {
+	vars := map[string]string{"@args": formatValue(append([]string{}, args...))}
+	for i, arg := range args {
+		vars[fmt.Sprintf("@arg%d", i)] = arg
+	}
+	return vars
+}
+
+// runLines runs the lines of body in a new frame of kind (see runScript), named
+// name in its errors. They run in a scope of their own holding vars: variables
+// they set are local to it.
+func (e *CommandExecutor) runLines(ctx context.Context, name, kind, body string, vars map[string]string, w io.Writer) error {
+	lines, err := ReadScript(strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	f := e.newFrame(withSubshell(ctx, e.newSubshell(ctx)), name, kind)
+	for k, v := range vars {
+		e.SetVariable(f.ctx, k, v)
+	}
+	return e.runScript(f, lines, nil, w)
+}
+
+// AddFunctions registers the func command and a command for each
+// user-defined function. NewCommandExecutor loads the functions file.
+func AddFunctions(exec *CommandExecutor) func(cmd *cobra.Command) {
+	return func(rootCmd *cobra.Command) {
+		funcCmd := &cobra.Command{
+			Use:   "func name [--flag[=default] ...] [arg[=default] ...] { body }",
+			Short: "Define a function",
+			Long: `Define a function: a command that runs the command lines of its body.
+
+Its flags and positional arguments are declared before the body and set as
+variables while it runs, along with @arg0, @arg1, ... and @args (the list of
+all its arguments). Variables it sets are local to it.
+  --name          - A required flag
+  --name=default  - A flag with a default; true or false makes it a boolean flag
+  name            - A required argument
+  name=default    - An optional argument
+
+The body is a block, on one line or spanning lines, or a quoted word.
+Functions are commands like any other: they show in help, tab completion,
+which and MCP tools/list, and are saved to the functions file.
+
+Examples:
+  func greet --greeting=hello name 'print "@greeting, @name"'
+  func deploy --dry-run=false env tag=latest {
+    print "deploying @tag to @env"
+    test @dry-run = false && run deploy.run @env @tag
+  }
+  greet --greeting hi world`,
+			DisableFlagParsing: true, // The definition's flags are not func's
+			SilenceUsage:       true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+					return cmd.Help()
+				}
+				f, err := parseFunction(args)
+				if err != nil {
+					return err
+				}
+				if c := subcommand(rootCmd, f.Name); c != nil && c.Annotations[functionAnnotation] == "" {
+					return fmt.Errorf("func %s: there is a command of that name", f.Name)
+				}
+
+				exec.setFunction(f)
+				if err := exec.SaveFunctions(); err != nil {
+					cmd.Printf("error saving functions, %v\n", err)
+				}
+				cmd.Printf("Defined function `%s`\n", f.Name)
+				return nil
+			},
+		}
+
+		funcListCmd := &cobra.Command{
+			Use:     "list",
+			Aliases: []string{"ls"},
+			Short:   "List the functions",
+			Args:    cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				functions := exec.sortedFunctions()
+				if len(functions) == 0 {
+					cmd.Printf("No functions defined\n")
+					return
+				}
+				for _, f := range functions {
+					cmd.Printf("%s\n", f.command(exec).UseLine())
+				}
+			},
+		}
+
+		funcShowCmd := &cobra.Command{
+			Use:     "show [name]",
+			Aliases: []string{"print"},
+			Short:   "Print the definition of a function",
+			Args:    cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				f, ok := exec.GetFunction(args[0])
+				if !ok {
+					return fmt.Errorf("function `%s` not found", args[0])
+				}
+				cmd.Printf("%s\n", f.Definition())
+				return nil
+			},
+		}
+
+		funcDeleteCmd := &cobra.Command{
+			Use:     "delete [name]",
+			Aliases: []string{"del"},
+			Short:   "Delete a function",
+			Args:    cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if !exec.deleteFunction(args[0]) {
+					return fmt.Errorf("function `%s` not found", args[0])
+				}
+				if err := exec.SaveFunctions(); err != nil {
+					cmd.Printf("error saving functions, %v\n", err)
+				}
+				cmd.Printf("removed function `%s`\n", args[0])
+				return nil
+			},
+		}
+
+		funcPathCmd := &cobra.Command{
+			Use:   "path",
+			Short: "Show the path to the functions file",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				path, err := exec.functionsFile()
+				if err != nil {
+					return err
+				}
+				cmd.Printf("%s\n", path)
+				return nil
+			},
+		}
+
+		// Defining and deleting functions changes the functions file
+		RequirePermission(funcCmd, "functions.manage")
+		RequirePermission(funcListCmd, "")
+		RequirePermission(funcShowCmd, "")
+		RequirePermission(funcPathCmd, "")
+		funcCmd.AddCommand(funcListCmd, funcShowCmd, funcDeleteCmd, funcPathCmd)
+		rootCmd.AddCommand(funcCmd)
+
+		for _, f := range exec.sortedFunctions() {
+			rootCmd.AddCommand(f.command(exec))
+		}
+	}
+}
+
+// GetFunction returns the user-defined function of the given name.
+func (e *CommandExecutor) GetFunction(name string) (*Function, bool) {
+	return e.functions.Get(name)
+}
+
+// sortedFunctions returns the user-defined functions sorted by name.
+func (e *CommandExecutor) sortedFunctions() []*Function {
+	var functions []*Function
+	e.functions.SortedForEach(func(_ string, f *Function) bool {
+		functions = append(functions, f)
+		return false
+	})
+	return functions
+}
+
+// setFunction defines or replaces a function. Cached command trees are
+// rebuilt with its command on the next execution.
+func (e *CommandExecutor) setFunction(f *Function) {
+	e.functions.Set(f.Name, f)
+	e.treeCache.invalidate()
+}
+
+// deleteFunction removes a function. Returns false if it was not defined.
+func (e *CommandExecutor) deleteFunction(name string) bool {
+	if _, ok := e.functions.Get(name); !ok {
+		return false
+	}
+	e.functions.Delete(name)
+	e.treeCache.invalidate()
+	return true
+}
+
+// functionsFile returns the path of the ~/.{appname}.functions file.
+func (e *CommandExecutor) functionsFile() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, fmt.Sprintf(".%s.functions", strings.ToLower(e.AppName))), nil
+}
+
+// LoadFunctions loads functions from the ~/.{appname}.functions file, which
+// holds their func definitions. Invalid definitions are skipped, and returned
+// as the error.
+func (e *CommandExecutor) LoadFunctions() error {
+	path, err := e.functionsFile()
+	if err != nil {
+		return nil // No home directory, so no functions saved
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil // No functions saved yet
+	}
+	defer file.Close()
+
+	definitions, err := ReadLines(file)
+	if err != nil {
+		return fmt.Errorf("error reading functions file: %w", err)
+	}
+	var skipped []error
+	for _, definition := range definitions {
+		line, err := parser.JoinLines(definition)
+		if err != nil || strings.TrimSpace(line) == "" {
+			continue
+		}
+		words, err := shellquote.Split(line)
+		var f *Function
+		if err == nil && len(words) > 0 && words[0] == "func" {
+			f, err = parseFunction(words[1:])
+		}
+		if f == nil {
+			skipped = append(skipped, fmt.Errorf("skipping invalid function - file `%s`: %s (%v)", path, strings.TrimSpace(definition), err))
+			continue
+		}
+		e.functions.Set(f.Name, f)
+	}
+	e.treeCache.invalidate()
+	return errors.Join(skipped...)
+}
+
+// SaveFunctions saves functions to the ~/.{appname}.functions file.
+func (e *CommandExecutor) SaveFunctions() error {
+	path, err := e.functionsFile()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create functions file: %w", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, f := range e.sortedFunctions() {
+		fmt.Fprintf(writer, "%s\n\n", f.Definition())
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush functions file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package consolekit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFunction(t *testing.T) {
+	tests := []struct {
+		name    string
+		words   []string
+		want    string // Definition
+		wantErr bool
+	}{
+		{name: "quoted body", words: []string{"greet", "print hi"}, want: "func greet {\nprint hi\n}"},
+		{name: "braced body", words: []string{"greet", "{", "print", "hi there", "}"}, want: "func greet {\nprint 'hi there'\n}"},
+		{name: "quoted operators", words: []string{"f", "{", "print", "a;b", "a|b", "a && b", "a>b", "}"},
+			want: "func f {\nprint 'a;b' 'a|b' 'a && b' 'a>b'\n}"},
+		{name: "params", words: []string{"deploy", "--dry-run=false", "--region", "env", "tag=latest", "print @env"},
+			want: "func deploy --dry-run=false --region env tag=latest {\nprint @env\n}"},
+		{name: "default with spaces", words: []string{"say", "--text=a b", "print @text"}, want: "func say '--text=a b' {\nprint @text\n}"},
+		{name: "missing body", words: []string{"greet"}, wantErr: true},
+		{name: "empty body", words: []string{"greet", "{", "}"}, wantErr: true},
+		{name: "bad name", words: []string{"9lives", "print x"}, wantErr: true},
+		{name: "bad parameter", words: []string{"f", "--a.b", "print x"}, wantErr: true},
+		{name: "help flag", words: []string{"f", "--help", "print x"}, wantErr: true},
+		{name: "duplicate parameter", words: []string{"f", "--x", "x", "print x"}, wantErr: true},
+		{name: "required after optional", words: []string{"f", "a=1", "b", "print x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseFunction(tt.words)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFunction(%q) error = %v, wantErr %v", tt.words, err, tt.wantErr)
+			}
+			if err == nil && f.Definition() != tt.want {
+				t.Errorf("Definition() = %q, want %q", f.Definition(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // The functions file
+	newExecutor := func() *CommandExecutor {
+		executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+			exec.AddBuiltinCommands()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to create executor: %v", err)
+		}
+		return executor
+	}
+	executor := newExecutor()
+
+	definitions := []string{
+		`func greet --greeting=hello name 'print "@greeting, @name"'`,
+		"func deploy --dry-run=false env tag=latest {\n  print deploying @tag to @env\n  test @dry-run = true || print done\n}",
+		"func each { for x in @args[*] do 'print item-@x' }",
+		"func setx {\n  let x=inner\n  print @x\n}",
+	}
+	for _, line := range definitions {
+		if out, err := executor.Execute(line, nil); err != nil || !strings.Contains(out, "Defined function") {
+			t.Fatalf("Execute(%q) = %q, %v", line, out, err)
+		}
+	}
+	_, _ = executor.Execute("let name=global; let x=outer", nil)
+
+	// A single-line body keeps its quoted operators, and is expanded when the
+	// function is called rather than when it is defined
+	for _, line := range []string{`func ops { print "a;b" "a|b" "a && b" "a>b" }`, "func later { print @name }"} {
+		if out, err := executor.Execute(line, nil); err != nil || !strings.Contains(out, "Defined function") {
+			t.Fatalf("Execute(%q) = %q, %v", line, out, err)
+		}
+	}
+	_, _ = executor.Execute("let name=changed", nil)
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"greet world", "hello, world\n"},
+		{"greet --greeting hi 'big world'", "hi, big world\n"},
+		{"deploy prod", "deploying latest to prod\ndone\n"},
+		{"deploy --dry-run prod v2", "deploying v2 to prod\n"},
+		{"each a b", "item-a\nitem-b\n"},
+		{"setx", "x = inner\ninner\n"},
+		{"print @x", "outer\n"},
+		{"greet world | grep hello", "hello, world\n"},
+		{"ops", "a;b a|b a && b a>b\n"},
+		{"later", "changed\n"},
+	}
+	for _, tt := range tests {
+		out, err := executor.Execute(tt.line, nil)
+		if err != nil {
+			t.Errorf("Execute(%q) failed: %v", tt.line, err)
+		}
+		if out != tt.want {
+			t.Errorf("Execute(%q) = %q, want %q", tt.line, out, tt.want)
+		}
+	}
+
+	if _, err := executor.Execute("greet", nil); err == nil {
+		t.Error("greet without its argument should fail")
+	}
+	if _, err := executor.Execute("func print 'print x'", nil); err == nil {
+		t.Error("a function should not replace a built-in command")
+	}
+
+	// Functions are commands: help, which and MCP see them
+	if out, _ := executor.Execute("help", nil); !strings.Contains(out, "greet") {
+		t.Errorf("help does not list greet:\n%s", out)
+	}
+	if out, _ := executor.Execute("which deploy", nil); !strings.HasPrefix(out, "deploy: function\nfunc deploy") {
+		t.Errorf("which deploy = %q", out)
+	}
+	server := NewMCPServer(executor, "test-app", "1.0")
+	resp := server.Process(nil, &JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "tools/list"})
+	data, _ := json.Marshal(resp.Result)
+	var result ToolsListResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	found := false
+	for _, tool := range result.Tools {
+		if tool.Name == "deploy" {
+			found = strings.Contains(string(mustJSON(t, tool.InputSchema)), "dry-run")
+		}
+	}
+	if !found {
+		t.Error("tools/list is missing deploy and its flags")
+	}
+
+	// Functions are saved and loaded by a new executor
+	if _, err := executor.Execute("func delete setx", nil); err != nil {
+		t.Fatalf("func delete failed: %v", err)
+	}
+	loaded := newExecutor()
+	if out, err := loaded.Execute("deploy --dry-run staging", nil); err != nil || out != "deploying latest to staging\n" {
+		t.Errorf("loaded deploy = %q, %v", out, err)
+	}
+	if _, ok := loaded.GetFunction("setx"); ok {
+		t.Error("deleted function was loaded")
+	}
+}
+
+func TestLoadFunctions(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	file := "func hi { print hi }\n\nfunc 'bad name' { print bad }\n"
+	if err := os.WriteFile(filepath.Join(home, ".test-app.functions"), []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	if _, ok := executor.GetFunction("hi"); !ok {
+		t.Fatal("hi was not loaded")
+	}
+	if err := executor.LoadFunctions(); err == nil || !strings.Contains(err.Error(), "bad name") {
+		t.Errorf("LoadFunctions = %v, want the invalid definition", err)
+	}
+
+	// Loading doesn't drop the tree built by the first execution
+	if out, err := executor.Execute("hi", nil); err != nil || out != "hi\n" {
+		t.Errorf("hi = %q, %v", out, err)
+	}
+	if len(executor.treeCache.trees) == 0 {
+		t.Error("the trees of the first execution were dropped")
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
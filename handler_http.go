@@ -1,17 +1,20 @@
 package consolekit
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"embed"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -75,11 +78,12 @@ type WebSession struct {
 	CreatedAt    time.Time
 	LastActivity time.Time
 	mu           sync.Mutex
+	state        *Session // Session-local variables, aliases and working directory
 }
 
 // ReplMessage represents a WebSocket REPL message.
 type ReplMessage struct {
-	Type    string `json:"type"`    // "input", "output", "error"
+	Type    string `json:"type"`    // "input", "interrupt", "chunk", "done", "error"
 	Message string `json:"message"` // Command or result
 }
 
@@ -136,8 +140,8 @@ func (h *HTTPHandler) Start() error {
 	// Start session cleanup
 	h.startSessionCleanup()
 
-	// Create server
-	h.server = &http.Server{
+	// Create server; Stop reads it under the lock
+	server := &http.Server{
 		Addr:              h.addr,
 		Handler:           h.router,
 		ReadTimeout:       60 * time.Second,
@@ -145,6 +149,9 @@ func (h *HTTPHandler) Start() error {
 		ReadHeaderTimeout: 15 * time.Second,
 		IdleTimeout:       300 * time.Second,
 	}
+	h.mu.Lock()
+	h.server = server
+	h.mu.Unlock()
 
 	// Use custom listener if provided
 	var listener net.Listener
@@ -165,7 +172,7 @@ func (h *HTTPHandler) Start() error {
 	}
 
 	// Serve
-	err = h.server.Serve(listener)
+	err = server.Serve(listener)
 	h.mu.Lock()
 	h.isRunning = false
 	h.mu.Unlock()
@@ -184,9 +191,10 @@ func (h *HTTPHandler) Stop() error {
 		h.mu.Unlock()
 		return nil
 	}
+	server := h.server
 	h.mu.Unlock()
 
-	if h.server == nil {
+	if server == nil {
 		return nil
 	}
 
@@ -194,7 +202,7 @@ func (h *HTTPHandler) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	return h.server.Shutdown(ctx)
+	return server.Shutdown(ctx)
 }
 
 // Name returns the transport type.
@@ -295,7 +303,10 @@ func (h *HTTPHandler) loginHandler(w http.ResponseWriter, r *http.Request) {
 			CreatedAt:    now,
 			LastActivity: now,
 			Expires:      time.Now().Add(24 * time.Hour),
+			state:        NewSession(sessionToken, "http", h.httpUser),
 		}
+		session.state.RemoteAddr = r.RemoteAddr
+		session.state.Policy = h.config
 		h.sessions.Set(sessionToken, session)
 
 		// Set session cookie
@@ -412,6 +423,37 @@ func (h *HTTPHandler) replHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("New WebSocket REPL connection from %s (user: %s)\n",
 		r.RemoteAddr, session.Username)
 
+	// Commands run in the background so the connection can still receive an
+	// "interrupt" message. Closing the connection cancels the running command.
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	// The command goroutine and this loop both write; the connection allows one writer
+	var writeMu sync.Mutex
+	send := func(msg ReplMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return h.sendJSON(conn, msg)
+	}
+
+	var runMu sync.Mutex
+	var interrupt context.CancelFunc // Cancels the running command; nil when idle
+
+	// Session hooks of the config; on_startup's output greets the terminal
+	sessionCtx := WithSession(ctx, session.state)
+	var greeting bytes.Buffer
+	if err := h.executor.RunHook(sessionCtx, HookOnStartup, &greeting, nil); err != nil {
+		fmt.Fprintln(&greeting, err)
+	}
+	if greeting.Len() > 0 {
+		send(ReplMessage{
+			Type:    "output",
+			Message: strings.TrimSuffix(greeting.String(), "\n"),
+		})
+	}
+
 	// Handle WebSocket messages
 	for {
 		_, data, err := conn.ReadMessage()
@@ -422,7 +464,7 @@ func (h *HTTPHandler) replHandler(w http.ResponseWriter, r *http.Request) {
 
 		var msg ReplMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
-			h.sendJSON(conn, ReplMessage{
+			send(ReplMessage{
 				Type:    "error",
 				Message: "Invalid JSON format",
 			})
@@ -431,32 +473,74 @@ func (h *HTTPHandler) replHandler(w http.ResponseWriter, r *http.Request) {
 
 		switch msg.Type {
 		case "input":
-			output, err := h.runCommand(session, msg.Message)
-			if err != nil {
-				h.sendJSON(conn, ReplMessage{
+			runMu.Lock()
+			if interrupt != nil {
+				runMu.Unlock()
+				send(ReplMessage{
 					Type:    "error",
-					Message: err.Error(),
-				})
-			} else {
-				h.sendJSON(conn, ReplMessage{
-					Type:    "output",
-					Message: output,
+					Message: "a command is already running",
 				})
+				continue
 			}
+			cmdCtx, cmdCancel := context.WithCancel(ctx)
+			interrupt = cmdCancel
+			runMu.Unlock()
+
+			wg.Add(1)
+			go func(line string) {
+				defer wg.Done()
+
+				// Stream output as "chunk" messages, then finish with "done" or "error"
+				out := &chunkWriter{send: func(chunk string) error {
+					return send(ReplMessage{
+						Type:    "chunk",
+						Message: chunk,
+					})
+				}}
+				err := h.runCommand(cmdCtx, session, line, out)
+
+				// Accept the next command before reporting completion
+				runMu.Lock()
+				interrupt = nil
+				runMu.Unlock()
+				cmdCancel()
+
+				if err != nil {
+					send(ReplMessage{
+						Type:    "error",
+						Message: err.Error(),
+					})
+				} else {
+					send(ReplMessage{
+						Type: "done",
+					})
+				}
+			}(msg.Message)
+
+		case "interrupt":
+			runMu.Lock()
+			if interrupt != nil {
+				interrupt()
+			}
+			runMu.Unlock()
 
 		default:
-			h.sendJSON(conn, ReplMessage{
+			send(ReplMessage{
 				Type:    "error",
 				Message: "Unknown message type: " + msg.Type,
 			})
 		}
 	}
 
+	if err := h.executor.RunHook(sessionCtx, HookOnExit, io.Discard, nil); err != nil {
+		log.Printf("WebSocket REPL connection for %s: %v\n", session.Username, err)
+	}
 	log.Printf("WebSocket REPL connection closed for %s\n", session.Username)
 }
 
-// runCommand executes a command and returns the output.
-func (h *HTTPHandler) runCommand(session *WebSession, input string) (string, error) {
+// runCommand executes a command, streaming its output to w.
+// Cancelling ctx interrupts the command.
+func (h *HTTPHandler) runCommand(ctx context.Context, session *WebSession, input string, w io.Writer) error {
 	// Update activity timestamp
 	session.mu.Lock()
 	session.LastActivity = time.Now()
@@ -467,41 +551,14 @@ func (h *HTTPHandler) runCommand(session *WebSession, input string) (string, err
 	scope.Set("@http:user", session.Username)
 	scope.Set("@http:session_id", session.SessionID)
 
-	// Log command execution
-	startTime := time.Now()
-
-	// Execute command
-	output, err := h.executor.Execute(input, scope)
-
-	// Log the execution result
-	if h.executor.LogManager != nil && h.executor.LogManager.IsEnabled() {
-		duration := time.Since(startTime)
-		errStr := ""
-		if err != nil {
-			errStr = err.Error()
-		}
-		h.executor.LogManager.Log(AuditLog{
-			Command:   fmt.Sprintf("[WebSocket] %s", input),
-			Timestamp: startTime,
-			Duration:  duration,
-			Success:   err == nil,
-			Output:    output,
-			Error:     errStr,
-			User:      session.Username,
-		})
-	}
-
-	if err != nil {
-		return "", err
-	}
-
-	return output, nil
+	// Execute command (audited by the executor's middleware)
+	return h.executor.ExecuteStream(WithSession(ctx, session.state), input, scope, w)
 }
 
 // sendJSON sends a JSON message over WebSocket.
-func (h *HTTPHandler) sendJSON(conn *websocket.Conn, msg ReplMessage) {
+func (h *HTTPHandler) sendJSON(conn *websocket.Conn, msg ReplMessage) error {
 	data, _ := json.Marshal(msg)
-	conn.WriteMessage(websocket.TextMessage, data)
+	return conn.WriteMessage(websocket.TextMessage, data)
 }
 
 // generateSessionToken generates a random session token.
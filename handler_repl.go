@@ -2,10 +2,12 @@ package consolekit
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
 	"strconv"
@@ -17,9 +19,8 @@ import (
 )
 
 // errPipelineHandled is a sentinel error returned by the pre-command hook
-// when it has already executed a command containing pipes or redirects
-// through the CommandExecutor. This prevents reeflective from also
-// passing the command to cobra.
+// when it has already executed a line through the CommandExecutor. This
+// prevents reeflective from also passing the command to cobra.
 var errPipelineHandled = errors.New("pipeline handled")
 
 // REPLHandler implements TransportHandler for local interactive REPL.
@@ -38,7 +39,7 @@ type REPLHandler struct {
 	// REPL-specific state
 	historyFile   string
 	promptFunc    func() string // Dynamic prompt function
-	pendingOutput string        // For pipe/redirect detection
+	session       *Session      // Local session (shares the executor's global state)
 
 	// Display formatting
 	NoColor       bool
@@ -61,14 +62,12 @@ func NewREPLHandler(executor *CommandExecutor) *REPLHandler {
 		WarningString: color.New(color.FgYellow).SprintfFunc(),
 	}
 
-	// Set default prompt function
-	handler.promptFunc = func() string {
-		return fmt.Sprintf("%s > ", executor.AppName)
-	}
+	// Set default prompt function (settings.prompt, read on each prompt)
+	handler.promptFunc = executor.PromptText
 
-	// Detect TTY for color support
+	// Detect TTY for color support; "color = false" in the config also disables it
 	isTTY := isatty.IsTerminal(os.Stdout.Fd())
-	handler.NoColor = os.Getenv("NO_COLOR") != "" || !isTTY
+	handler.NoColor = os.Getenv("NO_COLOR") != "" || !isTTY || executor.NoColor
 
 	// Sync NoColor setting with executor
 	executor.NoColor = handler.NoColor
@@ -83,10 +82,14 @@ func NewREPLHandler(executor *CommandExecutor) *REPLHandler {
 
 	// Set up history file path
 	currentUser, err := user.Current()
+	handler.session = NewLocalSession("repl", "repl", "")
 	if err == nil {
+		handler.session.User = currentUser.Username
+	}
+	if home, err := homeDir(); err == nil {
 		name := strings.ToLower(executor.AppName)
 		fileName := fmt.Sprintf(".%s.history", name)
-		handler.historyFile = filepath.Join(currentUser.HomeDir, fileName)
+		handler.historyFile = filepath.Join(home, fileName)
 	}
 
 	// Create and configure display adapter (default: reeflective/console)
@@ -116,81 +119,10 @@ func NewREPLHandler(executor *CommandExecutor) *REPLHandler {
 		ra.SuppressError(errPipelineHandled)
 	}
 
-	// Add a pre-command hook for alias expansion and pipe/redirect handling.
-	// When the input contains pipes (|) or redirects (>), the command must be
-	// executed through CommandExecutor.Execute() which handles ParseCommands.
-	// Without this, reeflective passes args directly to cobra, which routes to
-	// subcommands without processing pipes/redirects.
-	handler.display.AddPreCommandHook(func(args []string) ([]string, error) {
-		// Skip empty input
-		if len(args) == 0 {
-			return args, nil
-		}
-
-		// Reconstruct the line
-		line := strings.Join(args, " ")
-
-		// Skip comments
-		if strings.HasPrefix(line, "#") {
-			return nil, nil
-		}
-
-		// Check for alias expansion
-		originalLine := line
-
-		// Check aliases - exact match
-		executor.aliases.ForEach(func(k string, v string) bool {
-			if k == line {
-				line = v
-				return true
-			}
-			return false
-		})
-
-		// Check if first word matches an alias
-		firstWord := line
-		if idx := strings.IndexAny(line, " |>;"); idx != -1 {
-			firstWord = line[:idx]
-		}
-
-		executor.aliases.ForEach(func(k string, v string) bool {
-			if k == firstWord && k != line {
-				line = v + line[len(firstWord):]
-				return true
-			}
-			return false
-		})
-
-		// If alias changed, update stored line and re-split
-		if line != originalLine {
-			args = strings.Fields(line)
-		}
-
-		// Check if line contains pipes or redirects.
-		// These must go through executor.Execute() for proper handling,
-		// since cobra subcommand routing bypasses pipe/redirect processing.
-		if strings.Contains(line, "|") || strings.Contains(line, ">") {
-			output, err := executor.Execute(line, nil)
-			if output != "" {
-				fmt.Print(output)
-				if !strings.HasSuffix(output, "\n") {
-					fmt.Println()
-				}
-			}
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			}
-			// Flush stdout to ensure output is visible before next prompt
-			os.Stdout.Sync()
-			handler.pendingOutput = ""
-			return nil, errPipelineHandled
-		}
-
-		// Store line for root command to check for @token expansion
-		handler.pendingOutput = line
-
-		return args, nil
-	})
+	// Every line runs through the executor, which parses pipes, chains,
+	// redirects, groups and background jobs. Without this, reeflective passes
+	// the args directly to cobra, which sees the operators as arguments.
+	handler.display.AddPreCommandHook(handler.preCommand)
 
 	return handler
 }
@@ -208,21 +140,14 @@ func (h *REPLHandler) buildREPLRootCmd() func() *cobra.Command {
 			}
 		}
 
-		// Override root command RunE to handle pipes/redirects/@tokens
+		// Override root command RunE to run lines that reach it (from displays
+		// without pre-command hooks) through the executor
 		baseCmd.RunE = func(cmd *cobra.Command, args []string) error {
-			// Check if we have a line with pipes/redirects/@tokens
-			line := h.pendingOutput
-			if line != "" && (strings.Contains(line, "|") || strings.Contains(line, ">") || strings.Contains(line, "@")) {
-				// Execute through ExecuteLine which handles pipes/redirects
-				output, err := h.executor.Execute(line, nil)
-
-				// Print output with guaranteed trailing newline
-				if output != "" {
-					cmd.Print(output)
-					if !strings.HasSuffix(output, "\n") {
-						cmd.Println()
-					}
-				}
+			if line := strings.Join(args, " "); line != "" {
+				// Stream output with guaranteed trailing newline
+				out := NewOutputWriter(cmd.OutOrStdout(), false)
+				err := h.executor.ExecuteStream(WithSession(cmd.Context(), h.session), line, nil, out)
+				out.Terminate()
 
 				// Add extra newline to ensure cursor is not on last terminal row
 				// This prevents readline prompt rendering issues when at bottom of screen
@@ -231,8 +156,6 @@ func (h *REPLHandler) buildREPLRootCmd() func() *cobra.Command {
 				// Explicitly sync stdout to ensure terminal has processed all output
 				// before readline tries to draw the prompt. Critical for last-row rendering.
 				os.Stdout.Sync()
-
-				h.pendingOutput = "" // Clear
 				return err
 			}
 
@@ -246,11 +169,30 @@ func (h *REPLHandler) buildREPLRootCmd() func() *cobra.Command {
 
 // Start begins the REPL loop (blocking).
 // This is the main entry point for interactive REPL mode.
+// The on_startup and on_exit hooks run around it.
 func (h *REPLHandler) Start() error {
 	h.executor.Interactive = true
+	if hm := h.executor.HistoryManager; hm != nil {
+		_ = hm.Trim() // Keep the history file within settings.history_size
+	}
+
+	h.runHook(HookOnStartup)
+	defer h.runHook(HookOnExit)
 	return h.display.Start()
 }
 
+// runHook runs a session hook of the config, writing to the terminal.
+func (h *REPLHandler) runHook(hook string) {
+	ctx, stop := h.interruptContext(context.Background())
+	defer stop()
+	out := NewOutputWriter(os.Stdout, false)
+	err := h.executor.RunHook(ctx, hook, out, nil)
+	out.Terminate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", h.ErrorString("%v", err))
+	}
+}
+
 // Stop gracefully shuts down the REPL.
 func (h *REPLHandler) Stop() error {
 	// REPL doesn't have active connections to close
@@ -351,21 +293,42 @@ func (h *REPLHandler) hasNonFlagArgs() bool {
 }
 
 // ExecuteArgs executes command-line arguments directly using Cobra.
+// Ctrl+C interrupts the command.
 func (h *REPLHandler) ExecuteArgs(args []string) error {
+	ctx, stop := h.interruptContext(context.Background())
+	defer stop()
+
 	rootCmd := h.executor.RootCmd()
 	rootCmd.SetArgs(args)
-	return rootCmd.Execute()
+	return rootCmd.ExecuteContext(ctx)
+}
+
+// interruptContext returns a context carrying the REPL session that is cancelled
+// when the user presses Ctrl+C. Call stop once the command returns to restore
+// the default signal handling.
+func (h *REPLHandler) interruptContext(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(WithSession(parent, h.session), os.Interrupt)
 }
 
 // RunBatch reads commands from stdin and executes them line by line.
 // This enables piping scripts for automated testing: cat script.run | ./app
+// The lines run as a script named <stdin>: "set -e" stops it at the first
+// failure, and its EXIT trap runs at the end.
 func (h *REPLHandler) RunBatch() error {
+	h.runHook(HookOnStartup)
+	defer h.runHook(HookOnExit)
+
+	ctx, stop := h.interruptContext(context.Background())
+	defer stop()
+	f := h.executor.newFrame(ctx, "<stdin>", frameScript)
+
 	scanner := bufio.NewScanner(os.Stdin)
 	lineNum := 0
 	successCount := 0
 	errorCount := 0
+	stopped := false
 
-	for scanner.Scan() {
+	for !stopped && scanner.Scan() {
 		lineNum++
 		line := strings.TrimSpace(scanner.Text())
 
@@ -377,23 +340,28 @@ func (h *REPLHandler) RunBatch() error {
 		// Show the command being executed
 		fmt.Printf("%s\n", h.InfoString("→ %s", line))
 
-		output, err := h.executor.Execute(line, nil)
-		if output != "" {
-			fmt.Print(output)
-			if !strings.HasSuffix(output, "\n") {
-				fmt.Println()
-			}
-		}
+		out := NewOutputWriter(os.Stdout, false)
+		var err error
+		stopped, err = f.runLine(ScriptLine{Text: line, Line: lineNum}, nil, out)
+		out.Terminate()
 
 		if err != nil {
 			errorCount++
-			fmt.Fprintf(os.Stderr, "%s\n", h.ErrorString("✗ Error at line %d: %v", lineNum, err))
-			// Continue on error to run all test commands
-		} else {
+			fmt.Fprintf(os.Stderr, "%s\n", h.ErrorString("✗ %v", err))
+			// Continue on error to run all test commands, unless set -e stops them
+		} else if !stopped {
 			successCount++
 		}
 	}
 
+	out := NewOutputWriter(os.Stdout, false)
+	err := f.finish(out)
+	out.Terminate()
+	if stopped {
+		// Stopped by set -e or exit
+		return err
+	}
+
 	if err := scanner.Err(); err != nil {
 		return fmt.Errorf("error reading stdin: %w", err)
 	}
@@ -408,6 +376,8 @@ func (h *REPLHandler) RunBatch() error {
 
 // Exit handles program exit.
 func (h *REPLHandler) Exit(caller string, code int) {
+	h.runHook(HookOnExit)
+
 	if h.OnExit != nil {
 		h.OnExit(caller, code)
 	}
@@ -469,74 +439,38 @@ func (h *REPLHandler) SetDisplayAdapter(adapter DisplayAdapter) {
 		ra.SuppressError(errPipelineHandled)
 	}
 
-	// Re-add pre-command hook for alias expansion and pipe/redirect handling
-	h.display.AddPreCommandHook(func(args []string) ([]string, error) {
-		// Skip empty input
-		if len(args) == 0 {
-			return args, nil
-		}
-
-		// Reconstruct the line
-		line := strings.Join(args, " ")
-
-		// Skip comments
-		if strings.HasPrefix(line, "#") {
-			return nil, nil
-		}
-
-		// Check for alias expansion
-		originalLine := line
-
-		// Check aliases - exact match
-		h.executor.aliases.ForEach(func(k string, v string) bool {
-			if k == line {
-				line = v
-				return true
-			}
-			return false
-		})
-
-		// Check if first word matches an alias
-		firstWord := line
-		if idx := strings.IndexAny(line, " |>;"); idx != -1 {
-			firstWord = line[:idx]
-		}
+	// Re-add the pre-command hook that runs lines through the executor
+	h.display.AddPreCommandHook(h.preCommand)
+}
 
-		h.executor.aliases.ForEach(func(k string, v string) bool {
-			if k == firstWord && k != line {
-				line = v + line[len(firstWord):]
-				return true
-			}
-			return false
-		})
+// preCommand is the display's pre-command hook. It runs every line through
+// the executor and returns errPipelineHandled, so the display doesn't pass
+// it to cobra as well.
+func (h *REPLHandler) preCommand(args []string) ([]string, error) {
+	// Skip empty input
+	if len(args) == 0 {
+		return args, nil
+	}
 
-		// If alias changed, update stored line and re-split
-		if line != originalLine {
-			args = strings.Fields(line)
-		}
+	// Reconstruct the line
+	line := strings.Join(args, " ")
 
-		// Check if line contains pipes or redirects
-		if strings.Contains(line, "|") || strings.Contains(line, ">") {
-			output, err := h.executor.Execute(line, nil)
-			if output != "" {
-				fmt.Print(output)
-				if !strings.HasSuffix(output, "\n") {
-					fmt.Println()
-				}
-			}
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			}
-			os.Stdout.Sync()
-			h.pendingOutput = ""
-			return nil, errPipelineHandled
-		}
-
-		// Store line for root command to check for @token expansion
-		h.pendingOutput = line
+	// Skip comments
+	if strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
 
-		return args, nil
-	})
+	ctx, stop := h.interruptContext(context.Background())
+	out := NewOutputWriter(os.Stdout, false)
+	err := h.executor.ExecuteStream(ctx, line, nil, out)
+	stop()
+	out.Terminate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	// Flush stdout to ensure output is visible before next prompt
+	os.Stdout.Sync()
+	return nil, errPipelineHandled
 }
 
 // History and bookmark methods (REPL-specific functionality)
@@ -606,10 +540,9 @@ func (h *REPLHandler) saveBookmarks(bookmarks map[string]*HistoryBookmark) error
 	}
 
 	// Create parent directory if needed
-	currentUser, err := user.Current()
-	if err == nil {
+	if home, err := homeDir(); err == nil {
 		name := strings.ToLower(h.executor.AppName)
-		dir := filepath.Join(currentUser.HomeDir, fmt.Sprintf(".%s", name))
+		dir := filepath.Join(home, fmt.Sprintf(".%s", name))
 		_ = os.MkdirAll(dir, 0755)
 	}
 
@@ -0,0 +1,39 @@
+package consolekit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestREPLPreCommand(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	h := &REPLHandler{executor: executor, session: NewLocalSession("repl", "repl", "")}
+
+	// Lines the display splits into args still run as command lines
+	if _, err := h.preCommand(strings.Fields("test 1 -eq 1 && let ok=yes")); !errors.Is(err, errPipelineHandled) {
+		t.Fatalf("preCommand = %v, want errPipelineHandled", err)
+	}
+	if v, _ := executor.Variables.Get("@ok"); v != "yes" {
+		t.Errorf("@ok = %q, want yes", v)
+	}
+
+	start := time.Now()
+	if _, err := h.preCommand(strings.Fields("sleep 5 &")); !errors.Is(err, errPipelineHandled) {
+		t.Fatalf("preCommand = %v, want errPipelineHandled", err)
+	}
+	defer executor.JobManager.KillAll()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("sleep 5 & took %s, want it in the background", elapsed)
+	}
+	if jobs := executor.JobManager.List(); len(jobs) != 1 || !strings.HasPrefix(jobs[0].Command, "sleep 5") {
+		t.Errorf("jobs = %v, want the sleep", jobs)
+	}
+}
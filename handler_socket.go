@@ -2,11 +2,13 @@ package consolekit
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -33,9 +35,10 @@ type SocketHandler struct {
 	config   *TransportConfig
 
 	// Socket config
-	network   string // "unix" or "tcp"
-	addr      string // socket path or host:port
-	authToken string // required for TCP, empty for unix
+	network    string            // "unix" or "tcp"
+	addr       string            // socket path or host:port
+	authToken  string            // required for TCP, empty for unix
+	userTokens map[string]string // token -> user, for RBAC
 
 	// Connection management
 	listener    net.Listener
@@ -65,6 +68,7 @@ type SocketConnection struct {
 	startTime     time.Time
 	lastActivity  time.Time
 	mu            sync.Mutex
+	state         *Session // Session-local variables, aliases and working directory
 }
 
 // SocketRequest is the JSON request format for the socket protocol.
@@ -73,6 +77,7 @@ type SocketRequest struct {
 	Command string `json:"command"`           // Command line to execute
 	Token   string `json:"token,omitempty"`   // Auth token (required for TCP)
 	Timeout int    `json:"timeout,omitempty"` // Optional per-request timeout in seconds (0 = no timeout)
+	Stream  bool   `json:"stream,omitempty"`  // Stream output as partial responses before the final one
 }
 
 // SocketResponse is the JSON response format for the socket protocol.
@@ -81,6 +86,7 @@ type SocketResponse struct {
 	Output  string `json:"output"`            // Command output
 	Error   string `json:"error,omitempty"`   // Error message, empty on success
 	Success bool   `json:"success"`           // True if command succeeded
+	Partial bool   `json:"partial,omitempty"` // True for streamed output chunks; the final response follows
 }
 
 // NewSocketHandler creates a socket server handler.
@@ -107,9 +113,29 @@ func (h *SocketHandler) SetAuthToken(token string) {
 	h.authToken = token
 }
 
+// AddUserToken adds a token that authenticates a connection as user.
+// The user's roles then apply to its commands (see CommandExecutor.RBAC).
+// On unix sockets, which need no token, it still identifies the user.
+func (h *SocketHandler) AddUserToken(token, user string) {
+	if h.userTokens == nil {
+		h.userTokens = make(map[string]string)
+	}
+	h.userTokens[token] = user
+}
+
+// SetCustomListener sets a custom listener for Start to serve, instead of
+// listening on the network and address of the handler.
+func (h *SocketHandler) SetCustomListener(listener net.Listener) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listener = listener
+}
+
 // ActualAddr returns the listener's actual address, useful when binding to port 0.
 // Returns empty string if the server is not running.
 func (h *SocketHandler) ActualAddr() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	if h.listener == nil {
 		return h.addr
 	}
@@ -181,10 +207,11 @@ func (h *SocketHandler) Start() error {
 		return fmt.Errorf("socket server already running")
 	}
 	h.isRunning = true
+	listener := h.listener
 	h.mu.Unlock()
 
 	// For Unix sockets: remove stale socket file
-	if h.network == "unix" {
+	if listener == nil && h.network == "unix" {
 		// Check if something is already listening
 		testConn, err := net.DialTimeout("unix", h.addr, 500*time.Millisecond)
 		if err == nil {
@@ -198,17 +225,25 @@ func (h *SocketHandler) Start() error {
 		os.Remove(h.addr)
 	}
 
-	listener, err := net.Listen(h.network, h.addr)
-	if err != nil {
-		h.mu.Lock()
-		h.isRunning = false
-		h.mu.Unlock()
-		return fmt.Errorf("failed to listen on %s %s: %w", h.network, h.addr, err)
+	custom := listener != nil
+	if !custom {
+		var err error
+		listener, err = net.Listen(h.network, h.addr)
+		if err != nil {
+			h.mu.Lock()
+			h.isRunning = false
+			h.mu.Unlock()
+			return fmt.Errorf("failed to listen on %s %s: %w", h.network, h.addr, err)
+		}
 	}
+	// Update addr to actual address (important for port 0)
+	h.mu.Lock()
 	h.listener = listener
+	h.addr = listener.Addr().String()
+	h.mu.Unlock()
 
 	// Set Unix socket permissions
-	if h.network == "unix" {
+	if !custom && h.network == "unix" {
 		mode := h.SocketMode
 		if mode == 0 {
 			mode = 0600
@@ -216,9 +251,6 @@ func (h *SocketHandler) Start() error {
 		os.Chmod(h.addr, mode)
 	}
 
-	// Update addr to actual address (important for port 0)
-	h.addr = h.listener.Addr().String()
-
 	log.Printf("Socket server listening on %s %s\n", h.network, h.addr)
 
 	// Write info file for tool/skill discovery
@@ -275,8 +307,11 @@ func (h *SocketHandler) Stop() error {
 
 	close(h.stopCh)
 
-	if h.listener != nil {
-		h.listener.Close()
+	h.mu.Lock()
+	listener, addr := h.listener, h.addr
+	h.mu.Unlock()
+	if listener != nil {
+		listener.Close()
 	}
 
 	// Cancel and close all active connections
@@ -300,7 +335,7 @@ func (h *SocketHandler) Stop() error {
 	}
 
 	if h.network == "unix" {
-		os.Remove(h.addr)
+		os.Remove(addr)
 	}
 
 	h.removeInfoFile()
@@ -332,7 +367,10 @@ func (h *SocketHandler) handleConnection(conn net.Conn) {
 		cancel:        cancel,
 		startTime:     time.Now(),
 		lastActivity:  time.Now(),
+		state:         NewSession(connID, "socket", ""),
 	}
+	sc.state.RemoteAddr = sc.remoteAddr
+	sc.state.Policy = h.config
 	h.connections.Set(connID, sc)
 	defer h.connections.Delete(connID)
 
@@ -364,6 +402,15 @@ func (h *SocketHandler) handleConnection(conn net.Conn) {
 		}()
 	}
 
+	// Session hooks of the config run once the connection is authenticated;
+	// the protocol has nowhere to show their output
+	started := false
+	defer func() {
+		if started {
+			_ = h.executor.RunHook(WithSession(context.Background(), sc.state), HookOnExit, io.Discard, nil)
+		}
+	}()
+
 	scanner := bufio.NewScanner(conn)
 	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024) // 1MB max line
 
@@ -390,6 +437,12 @@ func (h *SocketHandler) handleConnection(conn net.Conn) {
 			continue
 		}
 
+		// A user token identifies the connection's user
+		if user, ok := h.userTokens[req.Token]; ok && req.Token != "" && sc.state.User == "" {
+			sc.state.User = user
+			sc.authenticated = true
+		}
+
 		// Handle TCP authentication
 		if !sc.authenticated {
 			if h.authToken == "" || req.Token != h.authToken {
@@ -402,6 +455,10 @@ func (h *SocketHandler) handleConnection(conn net.Conn) {
 			}
 			sc.authenticated = true
 		}
+		if !started {
+			started = true
+			_ = h.executor.RunHook(WithSession(ctx, sc.state), HookOnStartup, io.Discard, nil)
+		}
 
 		// Built-in ping/health check — bypasses executor and allow/deny
 		if req.Command == "ping" {
@@ -433,20 +490,6 @@ func (h *SocketHandler) handleConnection(conn net.Conn) {
 			continue
 		}
 
-		// Check command allow/deny
-		cmdName := req.Command
-		if idx := strings.IndexAny(cmdName, " |>;"); idx != -1 {
-			cmdName = cmdName[:idx]
-		}
-		if h.config != nil && !h.config.IsCommandAllowed(cmdName) {
-			h.writeResponse(conn, SocketResponse{
-				ID:      req.ID,
-				Error:   fmt.Sprintf("command '%s' is not allowed", cmdName),
-				Success: false,
-			})
-			continue
-		}
-
 		// Update activity
 		sc.mu.Lock()
 		sc.lastActivity = time.Now()
@@ -460,6 +503,8 @@ func (h *SocketHandler) handleConnection(conn net.Conn) {
 }
 
 // runCommand executes a command and returns the response.
+// For streaming requests, output is written to the connection as partial
+// responses while the command runs and the final response carries no output.
 func (h *SocketHandler) runCommand(sc *SocketConnection, req SocketRequest) SocketResponse {
 	// Create session-specific scope
 	scope := safemap.New[string, string]()
@@ -467,35 +512,38 @@ func (h *SocketHandler) runCommand(sc *SocketConnection, req SocketRequest) Sock
 	scope.Set("@socket:remote_addr", sc.remoteAddr)
 	scope.Set("@socket:network", h.network)
 
-	startTime := time.Now()
-
-	// Apply per-request timeout if specified
-	execCtx := sc.ctx
+	// Apply per-request timeout if specified. The command is cancelled when it
+	// times out, the connection closes or the handler stops.
+	execCtx, cancel := context.WithCancel(sc.ctx)
+	defer cancel()
 	if req.Timeout > 0 {
 		var timeoutCancel context.CancelFunc
-		execCtx, timeoutCancel = context.WithTimeout(sc.ctx, time.Duration(req.Timeout)*time.Second)
+		execCtx, timeoutCancel = context.WithTimeout(execCtx, time.Duration(req.Timeout)*time.Second)
 		defer timeoutCancel()
 	}
 
-	output, err := h.executor.ExecuteWithContext(execCtx, req.Command, scope)
-
-	// Audit log
-	if h.executor.LogManager != nil && h.executor.LogManager.IsEnabled() {
-		duration := time.Since(startTime)
-		errStr := ""
-		if err != nil {
-			errStr = err.Error()
-		}
-		h.executor.LogManager.Log(AuditLog{
-			Command:   fmt.Sprintf("[Socket:%s] %s", sc.remoteAddr, req.Command),
-			Timestamp: startTime,
-			Duration:  duration,
-			Success:   err == nil,
-			Output:    output,
-			Error:     errStr,
-		})
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	if req.Stream {
+		out = &chunkWriter{send: func(chunk string) error {
+			err := h.writeResponse(sc.conn, SocketResponse{
+				ID:      req.ID,
+				Output:  chunk,
+				Success: true,
+				Partial: true,
+			})
+			if err != nil {
+				// The client is gone; stop the command
+				cancel()
+			}
+			return err
+		}}
 	}
 
+	// Audited by the executor's middleware
+	err := h.executor.ExecuteStream(WithSession(execCtx, sc.state), req.Command, scope, out)
+	output := buf.String()
+
 	if err != nil {
 		return SocketResponse{
 			ID:      req.ID,
@@ -513,14 +561,15 @@ func (h *SocketHandler) runCommand(sc *SocketConnection, req SocketRequest) Sock
 }
 
 // writeResponse encodes and writes a JSON response followed by newline.
-func (h *SocketHandler) writeResponse(conn net.Conn, resp SocketResponse) {
+func (h *SocketHandler) writeResponse(conn net.Conn, resp SocketResponse) error {
 	data, err := json.Marshal(resp)
 	if err != nil {
 		log.Printf("Socket response marshal error: %v", err)
-		return
+		return err
 	}
 	data = append(data, '\n')
-	conn.Write(data)
+	_, err = conn.Write(data)
+	return err
 }
 
 // ActiveConnections returns a snapshot of active connection info.
@@ -7,6 +7,8 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -212,3 +214,91 @@ func TestSocketHandler_MultipleCommands(t *testing.T) {
 
 	handler.Stop()
 }
+
+func TestSocketHandler_ConcurrentSessions(t *testing.T) {
+	executor, err := NewCommandExecutor("socket-test-concurrent", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	sockPath := filepath.Join(os.TempDir(), "consolekit-test-concurrent.sock")
+	defer os.Remove(sockPath)
+
+	handler := NewSocketHandler(executor, "unix", sockPath)
+	go handler.Start()
+	defer handler.Stop()
+
+	dial := func() (net.Conn, error) {
+		var conn net.Conn
+		var err error
+		for i := 0; i < 50; i++ {
+			conn, err = net.Dial("unix", sockPath)
+			if err == nil {
+				return conn, nil
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil, err
+	}
+
+	// Socket clients and direct callers run the same flag-heavy commands at once;
+	// each socket connection keeps its own variables.
+	const clients = 8
+	const rounds = 10
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(2)
+
+		go func(id int) {
+			defer wg.Done()
+			conn, err := dial()
+			if err != nil {
+				t.Errorf("client %d: failed to connect: %v", id, err)
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(20 * time.Second))
+			scanner := bufio.NewScanner(conn)
+
+			send := func(command string) SocketResponse {
+				data, _ := json.Marshal(SocketRequest{Command: command})
+				conn.Write(append(data, '\n'))
+				var resp SocketResponse
+				if !scanner.Scan() {
+					t.Errorf("client %d: no response to %q: %v", id, command, scanner.Err())
+					return resp
+				}
+				json.Unmarshal(scanner.Bytes(), &resp)
+				return resp
+			}
+
+			send(fmt.Sprintf("let v=%d", id))
+			for r := 0; r < rounds; r++ {
+				resp := send(fmt.Sprintf("watch --count %d --interval 1ms 'print @v'", r%3+1))
+				if !resp.Success {
+					t.Errorf("client %d: watch failed: %s", id, resp.Error)
+				}
+				if want := fmt.Sprintf("Watch completed after %d iterations", r%3+1); !strings.Contains(resp.Output, want) {
+					t.Errorf("client %d: output missing %q: %q", id, want, resp.Output)
+				}
+				if resp := send("print @v"); resp.Output != fmt.Sprintf("%d\n", id) {
+					t.Errorf("client %d: @v = %q", id, resp.Output)
+				}
+			}
+		}(c)
+
+		go func(id int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				out, err := executor.Execute("print x y | table --headers", nil)
+				if err != nil || !strings.Contains(out, "x") {
+					t.Errorf("direct %d: got %q, err %v", id, out, err)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+}
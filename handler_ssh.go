@@ -99,6 +99,7 @@ type SSHSession struct {
 	startTime    time.Time     // Session start time
 	lastActivity time.Time     // Last activity timestamp
 	mu           sync.Mutex    // Mutex for updating timestamps
+	state        *Session      // Session-local variables, aliases and working directory
 }
 
 // ptyInfo stores PTY configuration.
@@ -164,14 +165,18 @@ func (h *SSHHandler) Start() error {
 	// Add host key
 	sshConfig.AddHostKey(h.hostKey)
 
-	// Start listening
-	listener, err := net.Listen("tcp", h.addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", h.addr, err)
+	// Start listening, unless a custom listener was set
+	listener := h.listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", h.addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", h.addr, err)
+		}
+		h.listener = listener
 	}
-	h.listener = listener
 
-	fmt.Printf("SSH server listening on %s\n", h.addr)
+	fmt.Printf("SSH server listening on %s\n", listener.Addr())
 
 	// Accept connections
 	for {
@@ -314,10 +319,14 @@ func (h *SSHHandler) handleConnection(nConn net.Conn, config *ssh.ServerConfig)
 			history:      initialHistory,
 			historyPos:   -1,
 			historyTemp:  "",
+			state:        NewSession(sessionID, "ssh", conn.User()),
 			startTime:    now,
 			lastActivity: now,
 		}
 
+		session.state.RemoteAddr = session.remoteIP
+		session.state.Policy = h.config
+
 		// Store session
 		h.sessionsMu.Lock()
 		h.sessions[sessionID] = session
@@ -538,6 +547,10 @@ func (h *SSHHandler) handleShell(session *SSHSession) {
 
 	h.sessionWrite(session, "\n")
 
+	// Session hooks of the config write to the session
+	h.runHook(session, HookOnStartup)
+	defer h.runHook(session, HookOnExit)
+
 	// Generate prompt using custom function or default
 	promptFunc := h.PromptFunc
 	if promptFunc == nil {
@@ -554,29 +567,39 @@ func (h *SSHHandler) handleShell(session *SSHSession) {
 	var cursorPos int // Current cursor position in the line buffer
 	var multiLine []string // Accumulated lines for multi-line commands
 	var inMultiLine bool
-	buf := make([]byte, 1)
 
-	for {
-		// Read one byte at a time
-		n, err := session.channel.Read(buf)
-		if err != nil {
-			if err != io.EOF {
-				fmt.Printf("[DEBUG] Read error: %v\n", err)
-			} else {
-				fmt.Printf("[DEBUG] EOF received\n")
-			}
-			return
+	// Input is read in the background so Ctrl+C can interrupt a running command.
+	// Bytes typed while a command runs are kept and processed after it returns.
+	input := h.readInput(session)
+	var pending []byte
+
+	// next returns the next input byte. It returns false when the session ends
+	// or the timeout fires.
+	next := func(timeout <-chan time.Time) (byte, bool) {
+		if len(pending) > 0 {
+			b := pending[0]
+			pending = pending[1:]
+			return b, true
 		}
+		select {
+		case b, ok := <-input:
+			return b, ok
+		case <-timeout:
+			return 0, false
+		case <-session.ctx.Done():
+			return 0, false
+		}
+	}
 
-		if n == 0 {
-			continue
+	for {
+		b, ok := next(nil)
+		if !ok {
+			return
 		}
 
 		// Update activity on input
 		h.updateActivity(session)
 
-		b := buf[0]
-
 		switch b {
 		case '\r', '\n':
 			// Enter pressed - check for line continuation
@@ -633,8 +656,8 @@ func (h *SSHHandler) handleShell(session *SSHSession) {
 			// Add to history (avoid duplicates of last command)
 			if len(session.history) == 0 || session.history[len(session.history)-1] != cmdLine {
 				session.history = append(session.history, cmdLine)
-				// Limit history size
-				if len(session.history) > 1000 {
+				// Limit history size (settings.history_size, at most 1000)
+				if len(session.history) > h.historySize() {
 					session.history = session.history[1:]
 				}
 			}
@@ -643,19 +666,18 @@ func (h *SSHHandler) handleShell(session *SSHSession) {
 
 			// Execute command and measure time
 			startTime := time.Now()
-			output, err := h.executeCommand(session, cmdLine)
+			out := NewOutputWriter(session.channel, session.pty != nil)
+			var interrupted bool
+			err := h.runForeground(session, input, &pending, &interrupted, func(ctx context.Context) error {
+				return h.executeCommand(ctx, session, cmdLine, out)
+			})
 			duration := time.Since(startTime)
-
-			// Write output
-			if output != "" {
-				h.sessionWrite(session, output)
-				if !strings.HasSuffix(output, "\n") {
-					h.sessionWrite(session, "\n")
-				}
-			}
+			out.Terminate()
 
 			// Write status indicator
-			if err != nil {
+			if interrupted {
+				h.sessionWrite(session, h.colorize(session, fmt.Sprintf("^C (%.2fs)\n", duration.Seconds()), colorYellow))
+			} else if err != nil {
 				errorMsg := fmt.Sprintf("[ERROR] %v (%.2fs)\n", err, duration.Seconds())
 				h.sessionWrite(session, h.colorize(session, errorMsg, colorRed))
 
@@ -673,7 +695,7 @@ func (h *SSHHandler) handleShell(session *SSHSession) {
 				}
 			} else {
 				// Only show [OK] for commands that produce output or take significant time
-				if output != "" || duration > 100*time.Millisecond {
+				if out.Wrote() || duration > 100*time.Millisecond {
 					okMsg := fmt.Sprintf("[OK] (%.2fs)\n", duration.Seconds())
 					h.sessionWrite(session, h.colorize(session, okMsg, colorGreen))
 				}
@@ -844,7 +866,7 @@ func (h *SSHHandler) handleShell(session *SSHSession) {
 			}
 
 			// Get all matching commands
-			allCommands := h.executor.GetAvailableCommands()
+			allCommands := h.executor.AvailableCommands(WithSession(session.ctx, session.state))
 			matches := make([]string, 0)
 			for _, cmd := range allCommands {
 				if strings.HasPrefix(cmd, wordToComplete) {
@@ -915,32 +937,15 @@ func (h *SSHHandler) handleShell(session *SSHSession) {
 			}
 
 		case 27: // ESC - start of escape sequence
-			// Read next two bytes to determine sequence type
-			// Use a channel with timeout to handle SSH buffering issues
+			// Read next two bytes to determine sequence type.
+			// A timeout means a standalone ESC key press or an incomplete sequence.
+			timeout := time.After(50 * time.Millisecond)
 			escBuf := make([]byte, 2)
-
-			// Read with a timeout to handle cases where bytes don't arrive atomically
-			type readResult struct {
-				n   int
-				err error
+			var ok bool
+			if escBuf[0], ok = next(timeout); !ok {
+				continue
 			}
-			readCh := make(chan readResult, 1)
-
-			go func() {
-				n, err := io.ReadFull(session.channel, escBuf)
-				readCh <- readResult{n, err}
-			}()
-
-			// Wait for read with timeout
-			select {
-			case result := <-readCh:
-				if result.err != nil || result.n != 2 {
-					// Incomplete escape sequence - consume and ignore
-					continue
-				}
-			case <-time.After(50 * time.Millisecond):
-				// Timeout waiting for escape sequence completion
-				// This is likely a standalone ESC key press
+			if escBuf[1], ok = next(timeout); !ok {
 				continue
 			}
 
@@ -1031,8 +1036,7 @@ func (h *SSHHandler) handleShell(session *SSHSession) {
 
 				case '3': // Delete key (ESC[3~)
 					// Read the trailing '~'
-					tildeBuf := make([]byte, 1)
-					if n, err := session.channel.Read(tildeBuf); err == nil && n == 1 && tildeBuf[0] == '~' {
+					if c, ok := next(time.After(50 * time.Millisecond)); ok && c == '~' {
 						if cursorPos < len(line) {
 							// Delete character at cursor
 							line = append(line[:cursorPos], line[cursorPos+1:]...)
@@ -1067,23 +1071,150 @@ func (h *SSHHandler) handleShell(session *SSHSession) {
 	}
 }
 
-// handleExec executes a single command and closes the session.
-func (h *SSHHandler) handleExec(session *SSHSession, cmd string) {
-	output, err := h.executeCommand(session, cmd)
+// readInput reads the session channel in the background and delivers it byte by byte.
+// The returned channel is closed when the client disconnects.
+func (h *SSHHandler) readInput(session *SSHSession) <-chan byte {
+	input := make(chan byte, 256)
+	go func() {
+		defer close(input)
+		buf := make([]byte, 256)
+		for {
+			n, err := session.channel.Read(buf)
+			for _, b := range buf[:n] {
+				select {
+				case input <- b:
+				case <-session.ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					fmt.Printf("[DEBUG] Read error: %v\n", err)
+				} else {
+					fmt.Printf("[DEBUG] EOF received\n")
+				}
+				return
+			}
+		}
+	}()
+	return input
+}
 
-	// Write output
-	if output != "" {
-		h.sessionWrite(session, output)
-		if !strings.HasSuffix(output, "\n") {
-			h.sessionWrite(session, "\n")
+// runForeground runs fn with a context that is cancelled when the user presses Ctrl+C
+// or the client disconnects. Other input received meanwhile is appended to pending,
+// unless the command reads lines from the session's terminal (the script debugger).
+func (h *SSHHandler) runForeground(session *SSHSession, input <-chan byte, pending *[]byte, interrupted *bool, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(session.ctx)
+	defer cancel()
+
+	term := &sshTerminal{
+		Writer:   NewOutputWriter(session.channel, session.pty != nil),
+		requests: make(chan sshLineRequest),
+	}
+	ctx = WithTerminal(ctx, term)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	// The line being read for the command, if any
+	var reading *sshLineRequest
+	var line []byte
+	var lastCR bool
+	feed := func(b byte) {
+		afterCR := lastCR
+		lastCR = b == '\r'
+		switch {
+		case b == '\n' && afterCR:
+			// The \n of a \r\n line ending
+		case reading == nil:
+			*pending = append(*pending, b)
+		case b == '\r' || b == '\n':
+			_, _ = fmt.Fprint(term, "\n")
+			reading.reply <- string(line)
+			reading, line = nil, nil
+		case b == 127 || b == 8: // Backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				_, _ = fmt.Fprint(term, "\b \b")
+			}
+		default:
+			line = append(line, b)
+			_, _ = term.Write([]byte{b})
+		}
+	}
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case req := <-term.requests:
+			reading = &req
+			_, _ = fmt.Fprint(term, req.prompt)
+			// Lines typed ahead are read first
+			for len(*pending) > 0 && reading != nil {
+				b := (*pending)[0]
+				*pending = (*pending)[1:]
+				feed(b)
+			}
+		case b, ok := <-input:
+			switch {
+			case !ok:
+				// Client disconnected
+				cancel()
+				input = nil
+			case b == 3: // Ctrl+C
+				*interrupted = true
+				cancel()
+			default:
+				feed(b)
+			}
 		}
 	}
+}
+
+// sshTerminal is the Terminal of a command run in the foreground of an SSH
+// shell. runForeground serves its line requests from the session's input.
+type sshTerminal struct {
+	io.Writer
+	requests chan sshLineRequest
+}
+
+// sshLineRequest asks runForeground for a line typed after prompt.
+type sshLineRequest struct {
+	prompt string
+	reply  chan string
+}
+
+// ReadLine implements Terminal.
+func (t *sshTerminal) ReadLine(ctx context.Context, prompt string) (string, error) {
+	req := sshLineRequest{prompt: prompt, reply: make(chan string, 1)}
+	select {
+	case t.requests <- req:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	select {
+	case line := <-req.reply:
+		return line, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// handleExec executes a single command and closes the session.
+// The exit status of the command is sent to the client.
+func (h *SSHHandler) handleExec(session *SSHSession, cmd string) {
+	out := NewOutputWriter(session.channel, session.pty != nil)
+	err := h.executeCommand(session.ctx, session, cmd, out)
+	out.Terminate()
 
 	// Write error
 	if err != nil {
 		errorMsg := fmt.Sprintf("Error: %v\n", err)
 		h.sessionWrite(session, h.colorize(session, errorMsg, colorRed))
-		session.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+		session.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(ExitStatus(err))}))
 		return
 	}
 
@@ -1091,18 +1222,27 @@ func (h *SSHHandler) handleExec(session *SSHSession, cmd string) {
 	session.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
 }
 
-// executeCommand runs a command in the session context.
-func (h *SSHHandler) executeCommand(session *SSHSession, cmd string) (string, error) {
-	// Check command filtering
-	cmdName := cmd
-	if idx := strings.IndexAny(cmd, " |>;"); idx != -1 {
-		cmdName = cmd[:idx]
+// runHook runs a session hook of the config, writing its output to the session.
+func (h *SSHHandler) runHook(session *SSHSession, hook string) {
+	out := NewOutputWriter(session.channel, session.pty != nil)
+	err := h.executor.RunHook(WithSession(session.ctx, session.state), hook, out, nil)
+	out.Terminate()
+	if err != nil {
+		h.sessionWrite(session, h.colorize(session, fmt.Sprintf("[ERROR] %v\n", err), colorRed))
 	}
+}
 
-	if h.config != nil && !h.config.IsCommandAllowed(cmdName) {
-		return "", fmt.Errorf("command '%s' is not allowed", cmdName)
+// historySize returns the number of commands a session's history keeps.
+func (h *SSHHandler) historySize() int {
+	if cfg := h.executor.Config; cfg != nil && cfg.Settings.HistorySize > 0 && cfg.Settings.HistorySize < 1000 {
+		return cfg.Settings.HistorySize
 	}
+	return 1000
+}
 
+// executeCommand runs a command in the session, streaming its output to w.
+// Cancelling ctx interrupts the command.
+func (h *SSHHandler) executeCommand(ctx context.Context, session *SSHSession, cmd string, w io.Writer) error {
 	// Create session-specific defaults (for environment variables, etc.)
 	scope := safemap.New[string, string]()
 
@@ -1116,31 +1256,8 @@ func (h *SSHHandler) executeCommand(session *SSHSession, cmd string) (string, er
 	scope.Set("@ssh:remote_ip", session.remoteIP)
 	scope.Set("@ssh:session_id", session.id)
 
-	// Log command execution (if logging is enabled)
-	startTime := time.Now()
-
-	// Execute command with session context
-	output, err := h.executor.ExecuteWithContext(session.ctx, cmd, scope)
-
-	// Log the execution result
-	if h.executor.LogManager != nil && h.executor.LogManager.IsEnabled() {
-		duration := time.Since(startTime)
-		errStr := ""
-		if err != nil {
-			errStr = err.Error()
-		}
-		h.executor.LogManager.Log(AuditLog{
-			Command:   fmt.Sprintf("[SSH:%s] %s", session.remoteIP, cmd),
-			Timestamp: startTime,
-			Duration:  duration,
-			Success:   err == nil,
-			Output:    output,
-			Error:     errStr,
-			User:      session.user,
-		})
-	}
-
-	return output, err
+	// Execute command with session context (audited by the executor's middleware)
+	return h.executor.ExecuteStream(WithSession(ctx, session.state), cmd, scope, w)
 }
 
 // parsePtyRequest parses a PTY request payload.
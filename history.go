@@ -5,11 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/user"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -28,6 +28,11 @@ type HistoryBookmark struct {
 type HistoryManager struct {
 	historyFile string
 	appName     string
+	maxEntries  int // Entries kept in the file; 0 keeps all
+
+	mu      sync.Mutex // Serializes the changes of the history file
+	entries int        // Lines of the file as of the last count, if counted
+	counted bool
 }
 
 // NewHistoryManager creates a new history manager.
@@ -40,7 +45,44 @@ func NewHistoryManager(appName, historyFile string) *HistoryManager {
 
 // SetHistoryFile sets the history file path.
 func (hm *HistoryManager) SetHistoryFile(path string) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
 	hm.historyFile = path
+	hm.counted = false
+}
+
+// SetMaxEntries sets the number of entries the history file keeps (the
+// history_size setting). Zero or less keeps them all.
+func (hm *HistoryManager) SetMaxEntries(n int) {
+	hm.maxEntries = max(n, 0)
+}
+
+// Trim removes the oldest entries of the history file beyond the maximum.
+// The REPL trims it when it starts; AppendHistory only does once the file is
+// well beyond the maximum, rather than rewriting it for every command.
+func (hm *HistoryManager) Trim() error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	return hm.trim()
+}
+
+// trim is Trim with hm.mu held.
+func (hm *HistoryManager) trim() error {
+	if hm.historyFile == "" || hm.maxEntries == 0 {
+		return nil
+	}
+	lines := hm.GetRawLines()
+	hm.entries, hm.counted = len(lines), true
+	if len(lines) <= hm.maxEntries {
+		return nil
+	}
+	return hm.writeRawLines(lines[len(lines)-hm.maxEntries:])
+}
+
+// trimSlack returns how many entries beyond the maximum AppendHistory lets the
+// history file hold before trimming it.
+func (hm *HistoryManager) trimSlack() int {
+	return max(hm.maxEntries/10, 1)
 }
 
 // historyEntry represents a JSON history entry written by reeflective/console.
@@ -89,6 +131,8 @@ func (hm *HistoryManager) GetHistory() []string {
 
 // AppendHistory adds a command to the history file.
 func (hm *HistoryManager) AppendHistory(command string) error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
 	if hm.historyFile == "" {
 		return nil // History disabled
 	}
@@ -103,12 +147,25 @@ func (hm *HistoryManager) AppendHistory(command string) error {
 	if err != nil {
 		return fmt.Errorf("failed to open history file: %w", err)
 	}
-	defer f.Close()
 
 	if _, err := f.WriteString(command + "\n"); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write to history: %w", err)
+	}
+	if err := f.Close(); err != nil {
 		return fmt.Errorf("failed to write to history: %w", err)
 	}
 
+	if hm.maxEntries == 0 {
+		return nil
+	}
+	if !hm.counted {
+		return hm.trim()
+	}
+	hm.entries++
+	if hm.entries > hm.maxEntries+hm.trimSlack() {
+		return hm.trim()
+	}
 	return nil
 }
 
@@ -150,6 +207,13 @@ func parseRawLine(line string) string {
 
 // WriteRawLines writes raw lines back to the history file.
 func (hm *HistoryManager) WriteRawLines(lines []string) error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	return hm.writeRawLines(lines)
+}
+
+// writeRawLines is WriteRawLines with hm.mu held.
+func (hm *HistoryManager) writeRawLines(lines []string) error {
 	if hm.historyFile == "" {
 		return fmt.Errorf("history file not configured")
 	}
@@ -160,16 +224,50 @@ func (hm *HistoryManager) WriteRawLines(lines []string) error {
 		buf.WriteByte('\n')
 	}
 
-	return os.WriteFile(hm.historyFile, []byte(buf.String()), 0644)
+	if err := writeFileAtomic(hm.historyFile, []byte(buf.String()), 0644); err != nil {
+		return err
+	}
+	hm.entries, hm.counted = len(lines), true
+	return nil
 }
 
 // ClearHistory removes all history.
 func (hm *HistoryManager) ClearHistory() error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
 	if hm.historyFile == "" {
 		return nil
 	}
 
-	return os.WriteFile(hm.historyFile, []byte{}, 0644)
+	if err := writeFileAtomic(hm.historyFile, nil, 0644); err != nil {
+		return err
+	}
+	hm.entries, hm.counted = 0, true
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// over path, so readers never see a partly written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
 // GetBookmarksFile returns the path to bookmarks file.
@@ -212,10 +310,9 @@ func (hm *HistoryManager) SaveBookmarks(bookmarks map[string]*HistoryBookmark) e
 	}
 
 	// Create parent directory if needed
-	currentUser, err := user.Current()
-	if err == nil {
+	if home, err := homeDir(); err == nil {
 		name := strings.ToLower(hm.appName)
-		dir := filepath.Join(currentUser.HomeDir, fmt.Sprintf(".%s", name))
+		dir := filepath.Join(home, fmt.Sprintf(".%s", name))
 		_ = os.MkdirAll(dir, 0755)
 	}
 
@@ -417,7 +514,7 @@ func AddHistory(exec *CommandExecutor) func(cmd *cobra.Command) {
 				command := history[index]
 				cmd.Printf("Replaying: %s\n", command)
 
-				output, err := exec.Execute(command, nil)
+				output, err := exec.ExecuteWithContext(cmd.Context(), command, nil)
 				if output != "" {
 					cmd.Print(output)
 					if !strings.HasSuffix(output, "\n") {
@@ -527,7 +624,7 @@ func AddHistory(exec *CommandExecutor) func(cmd *cobra.Command) {
 					command := history[i]
 					if rerun {
 						cmd.Printf("Replaying: %s\n", command)
-						output, err := exec.Execute(command, nil)
+						output, err := exec.ExecuteWithContext(cmd.Context(), command, nil)
 						if output != "" {
 							cmd.Print(output)
 							if !strings.HasSuffix(output, "\n") {
@@ -845,7 +942,7 @@ func AddHistory(exec *CommandExecutor) func(cmd *cobra.Command) {
 					return
 				}
 
-				output, err := exec.Execute(bm.Command, nil)
+				output, err := exec.ExecuteWithContext(cmd.Context(), bm.Command, nil)
 				if output != "" {
 					cmd.Print(output)
 					if !strings.HasSuffix(output, "\n") {
@@ -893,22 +990,22 @@ func AddHistory(exec *CommandExecutor) func(cmd *cobra.Command) {
 			},
 		}
 
-		bookmarkCmd.AddCommand(bookmarkAddCmd)
+		bookmarkCmd.AddCommand(RequirePermission(bookmarkAddCmd, "history.manage"))
 		bookmarkCmd.AddCommand(bookmarkListCmd)
 		bookmarkCmd.AddCommand(bookmarkRunCmd)
-		bookmarkCmd.AddCommand(bookmarkRemoveCmd)
+		bookmarkCmd.AddCommand(RequirePermission(bookmarkRemoveCmd, "history.manage"))
 
-		historyCmd.AddCommand(historyClearCmd)
+		historyCmd.AddCommand(RequirePermission(historyClearCmd, "history.manage"))
 		historyCmd.AddCommand(historySearchCmd)
 		historyCmd.AddCommand(historyLsCmd)
 		historyCmd.AddCommand(bookmarkCmd)
 		historyCmd.AddCommand(replayCmd)
 		historyCmd.AddCommand(statsCmd)
 		historyCmd.AddCommand(lastCmd)
-		historyCmd.AddCommand(deleteCmd)
-		historyCmd.AddCommand(dedupeCmd)
-		historyCmd.AddCommand(exportCmd)
-		historyCmd.AddCommand(trimCmd)
+		historyCmd.AddCommand(RequirePermission(deleteCmd, "history.manage"))
+		historyCmd.AddCommand(RequirePermission(dedupeCmd, "history.manage"))
+		historyCmd.AddCommand(RequirePermission(exportCmd, "history.manage"))
+		historyCmd.AddCommand(RequirePermission(trimCmd, "history.manage"))
 
 		rootCmd.AddCommand(historyCmd)
 	}
@@ -0,0 +1,99 @@
+package consolekit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/alexj212/consolekit/safemap"
+)
+
+// Hook names: the keys of the [hooks] section of config.toml.
+const (
+	HookOnStartup     = "on_startup"     // When a session starts (REPL, SSH shell, WebSocket, socket connection)
+	HookOnExit        = "on_exit"        // When the session ends
+	HookBeforeCommand = "before_command" // Before each command line a session submits
+	HookAfterCommand  = "after_command"  // After it, with its result
+)
+
+// Variables a hook runs with, in its scope.
+const (
+	hookEventVar    = "@hook:event"    // The hook name
+	hookLineVar     = "@hook:line"     // The command line (before_command, after_command)
+	hookStatusVar   = "@hook:status"   // Its exit status (after_command)
+	hookErrorVar    = "@hook:error"    // Its error, empty on success (after_command)
+	hookDurationVar = "@hook:duration" // How long it ran (after_command)
+)
+
+type inHookKey struct{}
+
+// inHook reports whether ctx belongs to a hook's execution.
+func inHook(ctx context.Context) bool {
+	v, _ := ctx.Value(inHookKey{}).(bool)
+	return v
+}
+
+// RunHook runs the command line configured for hook in the executor's config,
+// writing its output to w. vars are set in the hook's scope along with
+// @hook:event. Commands a hook runs don't trigger hooks themselves, and the
+// last exit status (@?) is left as it was. It does nothing if the hook is not
+// configured.
+func (e *CommandExecutor) RunHook(ctx context.Context, hook string, w io.Writer, vars map[string]string) error {
+	if e.Config == nil || inHook(ctx) {
+		return nil
+	}
+	line, err := e.Config.GetString("hooks." + hook)
+	if err != nil || line == "" {
+		return err
+	}
+
+	scope := safemap.New[string, string]()
+	scope.Set(hookEventVar, hook)
+	for k, v := range vars {
+		scope.Set(k, v)
+	}
+
+	status, hadStatus := e.GetVariable(ctx, lastStatusVar)
+	err = e.ExecuteStream(context.WithValue(ctx, inHookKey{}, true), line, scope, w)
+	if hadStatus {
+		e.SetVariable(ctx, lastStatusVar, status)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook: %w", hook, err)
+	}
+	return nil
+}
+
+// HookMiddleware runs the before_command and after_command hooks of the
+// executor's config around every top-level command line. The hooks write to
+// the line's output; their errors are reported there without changing the
+// line's result. NewCommandExecutor installs it.
+func HookMiddleware(e *CommandExecutor) Middleware {
+	return func(next ExecHandler) ExecHandler {
+		return func(ctx context.Context, inv *Invocation) error {
+			if inv.IsStage() || inv.Depth != 1 || inHook(ctx) {
+				return next(ctx, inv)
+			}
+
+			vars := map[string]string{hookLineVar: inv.Line}
+			if hookErr := e.RunHook(ctx, HookBeforeCommand, inv.Output, vars); hookErr != nil {
+				fmt.Fprintln(inv.Output, hookErr)
+			}
+
+			err := next(ctx, inv)
+
+			vars[hookStatusVar] = strconv.Itoa(ExitStatus(err))
+			vars[hookDurationVar] = time.Since(inv.Start).Round(time.Millisecond).String()
+			vars[hookErrorVar] = ""
+			if err != nil {
+				vars[hookErrorVar] = err.Error()
+			}
+			if hookErr := e.RunHook(ctx, HookAfterCommand, inv.Output, vars); hookErr != nil {
+				fmt.Fprintln(inv.Output, hookErr)
+			}
+			return err
+		}
+	}
+}
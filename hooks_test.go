@@ -0,0 +1,211 @@
+package consolekit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	executor.Config.Hooks = HooksConfig{
+		BeforeCommand: `print "before @hook:line"`,
+		AfterCommand:  `print "after @hook:status [@hook:error]"`,
+		OnStartup:     "let greeted=yes; print @hook:event",
+	}
+
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"command", "print hi", "before print hi\nhi\nafter 0 []\n"},
+		{"once per line", "print a; print b | grep b", "before print a; print b | grep b\na\nb\nafter 0 []\n"},
+		{"failure", "nosuchcmd", `before nosuchcmd` + "\n" + `after 1 [unknown command "nosuchcmd" for ""]` + "\n"},
+		{"nested executions", "repeat --count 2 'print x'", "before repeat --count 2 'print x'\nResult: x\n\nResult: x\n\nafter 0 []\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _ := executor.Execute(tt.line, nil)
+			if out != tt.want {
+				t.Errorf("output = %q, want %q", out, tt.want)
+			}
+		})
+	}
+
+	// The hooks leave the command's exit status
+	_, _ = executor.Execute("nosuchcmd", nil)
+	if status, _ := executor.Variables.Get("@?"); status != "1" {
+		t.Errorf("@? = %q, want 1", status)
+	}
+
+	// Session hooks run with RunHook; commands they run don't trigger the command hooks
+	var out strings.Builder
+	if err := executor.RunHook(context.Background(), HookOnStartup, &out, nil); err != nil {
+		t.Fatalf("RunHook failed: %v", err)
+	}
+	if out.String() != "greeted = yes\non_startup\n" {
+		t.Errorf("on_startup output = %q", out.String())
+	}
+	if v, _ := executor.Variables.Get("@greeted"); v != "yes" {
+		t.Errorf("@greeted = %q, want yes", v)
+	}
+	if err := executor.RunHook(context.Background(), HookOnExit, &out, nil); err != nil {
+		t.Errorf("unconfigured hook: %v", err)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	executor.Config.filePath = filepath.Join(t.TempDir(), "config.toml")
+
+	config := `
+[settings]
+prompt = "[%s]$ "
+color = false
+history_size = 5
+
+[aliases]
+hello = "print hello from config"
+
+[variables]
+region = "us-east"
+
+[hooks]
+after_command = "print done"
+`
+	if err := os.WriteFile(executor.Config.FilePath(), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := executor.Execute("config reload", nil); err != nil || !strings.Contains(out, "reloaded") {
+		t.Fatalf("config reload = %q, %v", out, err)
+	}
+
+	out, err := executor.Execute("hello @region", nil)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if out != "hello from config us-east\ndone\n" {
+		t.Errorf("output = %q", out)
+	}
+	if got := executor.PromptText(); got != "[test-app]$ " {
+		t.Errorf("PromptText() = %q", got)
+	}
+	if !executor.NoColor {
+		t.Error("color = false did not disable color")
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	executor.Config.filePath = filepath.Join(t.TempDir(), "config.toml")
+	executor.Config.Variables["region"] = "us-east"
+	executor.NoColor = false
+
+	if _, err := executor.Execute("set region eu-west", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := executor.Execute("config set settings.color false", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !executor.NoColor {
+		t.Error("color false did not disable color")
+	}
+	if _, err := executor.Execute("config set settings.color true", nil); err != nil {
+		t.Fatal(err)
+	}
+	if executor.NoColor {
+		t.Error("color true did not re-enable color")
+	}
+	if v, _ := executor.Variables.Get("@region"); v != "eu-west" {
+		t.Errorf("@region = %q, want the runtime value eu-west", v)
+	}
+
+	// NO_COLOR is not overridden by the setting
+	executor.NoColor = true
+	if _, err := executor.Execute("config set settings.color true", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !executor.NoColor {
+		t.Error("color true overrode NoColor it did not set")
+	}
+}
+
+func TestHistoryTrim(t *testing.T) {
+	hm := NewHistoryManager("test-app", filepath.Join(t.TempDir(), "history"))
+	hm.SetMaxEntries(3)
+	for _, cmd := range []string{"a", "b", "c", "d"} {
+		if err := hm.AppendHistory(cmd); err != nil {
+			t.Fatalf("AppendHistory failed: %v", err)
+		}
+	}
+	// The file isn't rewritten until it is past the slack
+	if got := strings.Join(hm.GetHistory(), ","); got != "a,b,c,d" {
+		t.Errorf("history = %s, want a,b,c,d", got)
+	}
+	if err := hm.AppendHistory("e"); err != nil {
+		t.Fatalf("AppendHistory failed: %v", err)
+	}
+	if got := strings.Join(hm.GetHistory(), ","); got != "c,d,e" {
+		t.Errorf("history = %s, want c,d,e", got)
+	}
+}
+
+func TestHistoryConcurrentAppends(t *testing.T) {
+	dir := t.TempDir()
+	hm := NewHistoryManager("test-app", filepath.Join(dir, "history"))
+	hm.SetMaxEntries(100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := hm.AppendHistory(fmt.Sprintf("print %d-%d", i, j)); err != nil {
+					t.Errorf("AppendHistory failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := hm.GetRawLines()
+	if len(lines) < 100 || len(lines) > 100+hm.trimSlack() {
+		t.Errorf("%d lines, want 100 to %d", len(lines), 100+hm.trimSlack())
+	}
+	for _, line := range lines {
+		var i, j int
+		if n, _ := fmt.Sscanf(line, "print %d-%d", &i, &j); n != 2 {
+			t.Errorf("garbled line %q", line)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+	if err := hm.Trim(); err != nil || len(hm.GetRawLines()) != 100 {
+		t.Errorf("Trim() = %v, %d lines", err, len(hm.GetRawLines()))
+	}
+}
@@ -17,7 +17,7 @@ func AddJobCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 		// jobs command - list all jobs
 		jobsCmd := &cobra.Command{
 			Use:   "jobs",
-			Short: "List all background jobs",
+			Short: "List all background jobs (osexec --background, cmd &)",
 			Run: func(cmd *cobra.Command, args []string) {
 				verbose, _ := cmd.Flags().GetBool("verbose")
 				showAll, _ := cmd.Flags().GetBool("all")
@@ -81,7 +81,12 @@ func AddJobCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 						statusStr = fmt.Sprintf("[%s]", status)
 					}
 
-					cmd.Printf("[%d] %s PID:%d Duration:%s\n", id, statusStr, pid, duration.Round(time.Second))
+					process := fmt.Sprintf("PID:%d", pid)
+					if pid < 0 {
+						process = "in-process"
+					}
+
+					cmd.Printf("[%d] %s %s Duration:%s\n", id, statusStr, process, duration.Round(time.Second))
 					cmd.Printf("    %s\n", command)
 
 					if verbose {
@@ -220,9 +225,9 @@ Actions:
 		}
 
 		rootCmd.AddCommand(jobsCmd)
-		rootCmd.AddCommand(jobCmd)
-		rootCmd.AddCommand(killallCmd)
-		rootCmd.AddCommand(jobcleanCmd)
+		rootCmd.AddCommand(RequirePermission(jobCmd, "jobs.manage"))
+		rootCmd.AddCommand(RequirePermission(killallCmd, "jobs.manage"))
+		rootCmd.AddCommand(RequirePermission(jobcleanCmd, "jobs.manage"))
 	}
 }
 
@@ -234,7 +239,11 @@ func showJobDetails(cmd *cobra.Command, exec *CommandExecutor, job *Job) {
 	cmd.Printf("Job ID: %d\n", job.ID)
 	cmd.Printf("Command: %s\n", job.Command)
 	cmd.Printf("Status: %s\n", job.Status)
-	cmd.Printf("PID: %d\n", job.PID)
+	if job.PID < 0 {
+		cmd.Println("PID: - (in-process)")
+	} else {
+		cmd.Printf("PID: %d\n", job.PID)
+	}
 	cmd.Printf("Started: %s\n", job.StartTime.Format("2006-01-02 15:04:05"))
 
 	if job.EndTime != nil {
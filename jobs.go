@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os/exec"
 	"sync"
 	"time"
+
+	"github.com/spf13/cobra"
 )
 
 // JobStatus represents the current state of a job
@@ -19,15 +22,15 @@ const (
 	JobKilled    JobStatus = "killed"
 )
 
-// Job represents a background process
+// Job represents a background OS process or in-process command
 type Job struct {
 	ID        int
 	Command   string
 	StartTime time.Time
 	EndTime   *time.Time
 	Status    JobStatus
-	PID       int
-	Output    *bytes.Buffer
+	PID       int           // -1 for in-process jobs
+	Output    *bytes.Buffer // Guarded by mu
 	Error     error
 	Cancel    context.CancelFunc
 	cmd       *exec.Cmd
@@ -52,25 +55,47 @@ func NewJobManager() *JobManager {
 	}
 }
 
-// Add creates a new job and starts tracking it
-func (jm *JobManager) Add(command string, ctx context.Context, cancel context.CancelFunc, cmd *exec.Cmd) int {
-	jm.mu.Lock()
-	defer jm.mu.Unlock()
+// maxJobOutput caps how much of a job's output is kept for Logs. Once a job
+// has written well beyond it, its oldest output is dropped.
+const maxJobOutput = 1 << 20
 
-	job := &Job{
-		ID:        jm.nextID,
+// newJob returns a running job for command, not yet tracked by a JobManager.
+func newJob(command string, cancel context.CancelFunc) *Job {
+	return &Job{
 		Command:   command,
 		StartTime: time.Now(),
 		Status:    JobRunning,
 		PID:       -1,
 		Output:    &bytes.Buffer{},
 		Cancel:    cancel,
-		cmd:       cmd,
 		done:      make(chan struct{}),
 	}
+}
 
+// track gives job the next ID and starts tracking it.
+func (jm *JobManager) track(job *Job) int {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	job.ID = jm.nextID
 	jm.jobs[jm.nextID] = job
 	jm.nextID++
+	return job.ID
+}
+
+// Add creates a new job and starts tracking it
+func (jm *JobManager) Add(command string, ctx context.Context, cancel context.CancelFunc, cmd *exec.Cmd) int {
+	return jm.addProcess(newJob(command, cancel), ctx, cmd)
+}
+
+// addProcess tracks job for the started process cmd. The process may already
+// write to the job's output through jobWriter.
+func (jm *JobManager) addProcess(job *Job, ctx context.Context, cmd *exec.Cmd) int {
+	job.cmd = cmd
+	if cmd.Process != nil {
+		job.PID = cmd.Process.Pid
+	}
+	id := jm.track(job)
 
 	// Start a goroutine to update job status when it completes
 	go func(j *Job) {
@@ -96,14 +121,74 @@ func (jm *JobManager) Add(command string, ctx context.Context, cancel context.Ca
 		close(j.done)
 	}(job)
 
-	// Set PID after process starts (if available)
-	if cmd.Process != nil {
-		job.PID = cmd.Process.Pid
-	}
+	return id
+}
+
+// AddFunc starts fn in a goroutine as an in-process job and starts tracking it.
+// fn runs on a context derived from ctx without its cancellation, which Kill
+// cancels, and its output is captured for Logs.
+func (jm *JobManager) AddFunc(ctx context.Context, command string, fn func(ctx context.Context, out io.Writer) error) int {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	job := newJob(command, cancel)
+	jm.track(job)
+
+	go func(j *Job) {
+		err := fn(ctx, jobWriter{j})
+
+		j.mu.Lock()
+		now := time.Now()
+		j.EndTime = &now
+		j.Error = err
+		switch {
+		case ctx.Err() != nil:
+			j.Status = JobKilled
+		case err != nil:
+			j.Status = JobFailed
+		default:
+			j.Status = JobCompleted
+		}
+		j.mu.Unlock()
+
+		cancel()
+		close(j.done)
+	}(job)
 
 	return job.ID
 }
 
+// jobWriter appends to a job's output while others may read it. It keeps the
+// last maxJobOutput bytes, trimming to a line start once the output is a tenth
+// over the cap rather than on every write.
+type jobWriter struct {
+	j *Job
+}
+
+func (w jobWriter) Write(p []byte) (int, error) {
+	w.j.mu.Lock()
+	defer w.j.mu.Unlock()
+	n, err := w.j.Output.Write(p)
+	if extra := w.j.Output.Len() - maxJobOutput; extra > maxJobOutput/10 {
+		w.j.Output.Next(extra)
+		if i := bytes.IndexByte(w.j.Output.Bytes(), '\n'); i >= 0 {
+			w.j.Output.Next(i + 1)
+		}
+	}
+	return n, err
+}
+
+// jobCommand returns a command for a job of the JobManager to run with after
+// the command that started it returns, when that command's tree is already
+// reused by another execution. It writes to out and carries ctx, the context
+// of the job.
+func jobCommand(ctx context.Context, out io.Writer) *cobra.Command {
+	bg := &cobra.Command{}
+	bg.SetOut(out)
+	bg.SetErr(out)
+	bg.SetContext(ctx)
+	return bg
+}
+
 // Get retrieves a job by ID
 func (jm *JobManager) Get(id int) (*Job, bool) {
 	jm.mu.RLock()
@@ -231,6 +316,13 @@ func (jm *JobManager) Clean() int {
 	return removed
 }
 
+// running reports whether the job is still running.
+func (j *Job) running() bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.Status == JobRunning
+}
+
 // Duration returns the duration of a job
 func (j *Job) Duration() time.Duration {
 	j.mu.RLock()
@@ -2,7 +2,13 @@ package consolekit
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -193,3 +199,232 @@ func TestJob_Duration(t *testing.T) {
 		t.Errorf("Expected duration > 50ms, got %s", duration)
 	}
 }
+
+func TestJobManager_AddFunc(t *testing.T) {
+	jm := NewJobManager()
+
+	// The job outlives the context it was started from
+	ctx, cancel := context.WithCancel(context.Background())
+	done := jm.AddFunc(ctx, "hello", func(ctx context.Context, out io.Writer) error {
+		_, _ = fmt.Fprintln(out, "hello")
+		return nil
+	})
+	cancel()
+	if err := jm.Wait(done); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if logs, _ := jm.Logs(done); logs != "hello\n" {
+		t.Errorf("logs = %q, want hello", logs)
+	}
+
+	errBoom := errors.New("boom")
+	failed := jm.AddFunc(context.Background(), "fail", func(ctx context.Context, out io.Writer) error {
+		return errBoom
+	})
+	if err := jm.Wait(failed); !errors.Is(err, errBoom) {
+		t.Errorf("Wait error = %v, want %v", err, errBoom)
+	}
+
+	killed := jm.AddFunc(context.Background(), "block", func(ctx context.Context, out io.Writer) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := jm.Kill(killed); err != nil {
+		t.Fatalf("Kill failed: %v", err)
+	}
+	_ = jm.Wait(killed)
+
+	for id, want := range map[int]JobStatus{done: JobCompleted, failed: JobFailed, killed: JobKilled} {
+		job, _ := jm.Get(id)
+		job.mu.RLock()
+		status, pid := job.Status, job.PID
+		job.mu.RUnlock()
+		if status != want || pid != -1 {
+			t.Errorf("job %d: status %s, PID %d, want %s, -1", id, status, pid, want)
+		}
+	}
+}
+
+func TestJobOutputLimit(t *testing.T) {
+	jm := NewJobManager()
+
+	// A long-running job keeps only the tail of its output
+	line := strings.Repeat("x", 99) + "\n"
+	id := jm.AddFunc(context.Background(), "chatty", func(ctx context.Context, out io.Writer) error {
+		for i := 0; i < 3*maxJobOutput/len(line); i++ {
+			_, _ = io.WriteString(out, line)
+		}
+		_, _ = fmt.Fprintln(out, "last")
+		return nil
+	})
+	if err := jm.Wait(id); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	logs, _ := jm.Logs(id)
+	if len(logs) > maxJobOutput+maxJobOutput/10 {
+		t.Errorf("kept %d bytes of output, want at most %d", len(logs), maxJobOutput+maxJobOutput/10)
+	}
+	if !strings.HasSuffix(logs, "last\n") || !strings.HasPrefix(logs, line) {
+		t.Errorf("output should keep whole lines up to the last one, got %q...%q", logs[:10], logs[len(logs)-10:])
+	}
+}
+
+func TestBackgroundOperator(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	out, err := executor.Execute("print in-job & print after", nil)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if out != "[1] print in-job\nafter\n" {
+		t.Errorf("output = %q", out)
+	}
+	if err := executor.JobManager.Wait(1); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if logs, _ := executor.JobManager.Logs(1); logs != "in-job\n" {
+		t.Errorf("logs = %q, want in-job", logs)
+	}
+
+	// A running job is listed, and can be killed through @!
+	if _, err := executor.Execute("sleep --quiet 30 &", nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if out, _ := executor.Execute("jobs", nil); !strings.Contains(out, "in-process") || !strings.Contains(out, "sleep --quiet 30") {
+		t.Errorf("jobs output:\n%s", out)
+	}
+	if out, _ := executor.Execute("job @! kill", nil); !strings.Contains(out, "Job 2 killed") {
+		t.Errorf("job kill output = %q", out)
+	}
+	_ = executor.JobManager.Wait(2)
+	job, _ := executor.JobManager.Get(2)
+	job.mu.RLock()
+	status := job.Status
+	job.mu.RUnlock()
+	if status != JobKilled {
+		t.Errorf("status = %s, want %s", status, JobKilled)
+	}
+
+	// Jobs run in a subshell
+	if _, err := executor.Execute("let x=outer; let x=inner &", nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	_ = executor.JobManager.Wait(3)
+	if out, _ := executor.Execute("print @x", nil); out != "outer\n" {
+		t.Errorf("x = %q, want outer", out)
+	}
+
+	// repeat --background is a job too
+	out, _ = executor.Execute("repeat --background --count 2 'print again'", nil)
+	if !strings.Contains(out, "Job ID: 4") {
+		t.Fatalf("repeat output = %q", out)
+	}
+	_ = executor.JobManager.Wait(4)
+	if logs, _ := executor.JobManager.Logs(4); strings.Count(logs, "again") != 2 {
+		t.Errorf("repeat logs = %q", logs)
+	}
+}
+
+func TestSpawnedAndScheduledJobs(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(AddRun(exec, nil))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	status := func(id int) JobStatus {
+		job, ok := executor.JobManager.Get(id)
+		if !ok {
+			t.Fatalf("job %d not found", id)
+		}
+		job.mu.RLock()
+		defer job.mu.RUnlock()
+		return job.Status
+	}
+
+	// spawn starts a job, which can be killed and waited on
+	out, _ := executor.Execute(`spawn "sleep --quiet 30"`, nil)
+	if out != "Started in background (Job ID: 1)\n" {
+		t.Fatalf("spawn output = %q", out)
+	}
+	if out, _ := executor.Execute("job @! kill", nil); !strings.Contains(out, "Job 1 killed") {
+		t.Errorf("job kill output = %q", out)
+	}
+	_ = executor.JobManager.Wait(1)
+	if s := status(1); s != JobKilled {
+		t.Errorf("spawned job status = %s, want %s", s, JobKilled)
+	}
+
+	// run --spawn keeps the output of the script
+	script := filepath.Join(t.TempDir(), "hi.run")
+	if err := os.WriteFile(script, []byte("print hi @arg0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, _ = executor.Execute("run --spawn --quiet "+script+" bob", nil)
+	if out != "Started in background (Job ID: 2)\n" {
+		t.Fatalf("run --spawn output = %q", out)
+	}
+	if err := executor.JobManager.Wait(2); err != nil {
+		t.Errorf("Wait failed: %v", err)
+	}
+	if logs, _ := executor.JobManager.Logs(2); logs != "hi bob\n" {
+		t.Errorf("run --spawn logs = %q", logs)
+	}
+
+	// Each run of a scheduled task is a job; cancelling the task kills it
+	out, _ = executor.Execute(`schedule every 10ms "sleep --quiet 30"`, nil)
+	if !strings.Contains(out, "task 3") {
+		t.Fatalf("schedule output = %q", out)
+	}
+	task := executor.JobManager.getScheduledTask(3)
+	var jobID int
+	for deadline := time.Now().Add(5 * time.Second); jobID == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the scheduled task did not run")
+		}
+		task.mu.RLock()
+		jobID = task.JobID
+		task.mu.RUnlock()
+	}
+	// The next ticks are skipped while the run is going
+	time.Sleep(50 * time.Millisecond)
+	if n := len(executor.JobManager.List()); n != 3 {
+		t.Errorf("%d jobs, want 3", n)
+	}
+	if out, _ := executor.Execute("schedule list", nil); !strings.Contains(out, fmt.Sprintf("last job %d", jobID)) {
+		t.Errorf("schedule list = %q", out)
+	}
+	executor.Execute("schedule cancel 3", nil)
+	_ = executor.JobManager.Wait(jobID)
+	if s := status(jobID); s != JobKilled {
+		t.Errorf("scheduled job status = %s, want %s", s, JobKilled)
+	}
+
+	// A one-time task's job logs its output
+	executor.Execute(`schedule in 1ms "print later"`, nil)
+	var later *Job
+	for deadline := time.Now().Add(5 * time.Second); later == nil; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the scheduled task did not run")
+		}
+		for _, job := range executor.JobManager.List() {
+			if job.Command == "print later" {
+				later = job
+			}
+		}
+	}
+	_ = executor.JobManager.Wait(later.ID)
+	if logs, _ := executor.JobManager.Logs(later.ID); logs != "later\n" {
+		t.Errorf("schedule in logs = %q", logs)
+	}
+}
@@ -61,18 +61,17 @@ func AddLogCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 		}
 
 		// log show
-		var (
-			showLast   int
-			showFailed bool
-			showSearch string
-			showSince  string
-			showJSON   bool
-		)
 		var showCmd = &cobra.Command{
 			Use:   "show",
 			Short: "Show command logs",
 			Long:  "Display command execution logs with optional filtering",
 			Run: func(cmd *cobra.Command, args []string) {
+				showLast, _ := cmd.Flags().GetInt("last")
+				showFailed, _ := cmd.Flags().GetBool("failed")
+				showSearch, _ := cmd.Flags().GetString("search")
+				showSince, _ := cmd.Flags().GetString("since")
+				showJSON, _ := cmd.Flags().GetBool("json")
+
 				var logs []AuditLog
 
 				// Apply filters
@@ -121,7 +120,11 @@ func AddLogCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 						duration := log.Duration.Round(time.Millisecond)
 						timestamp := log.Timestamp.Format("2006-01-02 15:04:05")
 
-						cmd.Printf("%s %s [%s] %s", status, timestamp, duration, log.Command)
+						cmd.Printf("%s %s [%s] ", status, timestamp, duration)
+						if log.Transport != "" {
+							cmd.Printf("[%s:%s] ", log.Transport, log.Remote)
+						}
+						cmd.Print(log.Command)
 
 						if !log.Success && log.Error != "" {
 							cmd.Printf(" - %s", log.Error)
@@ -136,11 +139,11 @@ func AddLogCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 				ResetAllFlags(cmd)
 			},
 		}
-		showCmd.Flags().IntVar(&showLast, "last", 0, "Show last N logs")
-		showCmd.Flags().BoolVar(&showFailed, "failed", false, "Show only failed commands")
-		showCmd.Flags().StringVar(&showSearch, "search", "", "Search logs by command text")
-		showCmd.Flags().StringVar(&showSince, "since", "", "Show logs since date (YYYY-MM-DD or RFC3339)")
-		showCmd.Flags().BoolVar(&showJSON, "json", false, "Output in JSON format")
+		showCmd.Flags().Int("last", 0, "Show last N logs")
+		showCmd.Flags().Bool("failed", false, "Show only failed commands")
+		showCmd.Flags().String("search", "", "Search logs by command text")
+		showCmd.Flags().String("since", "", "Show logs since date (YYYY-MM-DD or RFC3339)")
+		showCmd.Flags().Bool("json", false, "Output in JSON format")
 
 		// log clear
 		var clearCmd = &cobra.Command{
@@ -153,7 +156,6 @@ func AddLogCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 		}
 
 		// log export
-		var exportFormat string
 		var exportCmd = &cobra.Command{
 			Use:   "export",
 			Short: "Export logs to file",
@@ -171,7 +173,7 @@ func AddLogCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 				ResetAllFlags(cmd)
 			},
 		}
-		exportCmd.Flags().StringVar(&exportFormat, "format", "json", "Export format (json)")
+		exportCmd.Flags().String("format", "json", "Export format (json)")
 
 		// log load
 		var loadCmd = &cobra.Command{
@@ -249,14 +251,14 @@ func AddLogCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 		}
 
 		// Add subcommands
-		logCmd.AddCommand(enableCmd)
-		logCmd.AddCommand(disableCmd)
+		logCmd.AddCommand(RequirePermission(enableCmd, "log.manage"))
+		logCmd.AddCommand(RequirePermission(disableCmd, "log.manage"))
 		logCmd.AddCommand(statusCmd)
 		logCmd.AddCommand(showCmd)
-		logCmd.AddCommand(clearCmd)
+		logCmd.AddCommand(RequirePermission(clearCmd, "log.manage"))
 		logCmd.AddCommand(exportCmd)
-		logCmd.AddCommand(loadCmd)
-		logCmd.AddCommand(configCmd)
+		logCmd.AddCommand(RequirePermission(loadCmd, "log.manage"))
+		logCmd.AddCommand(RequirePermission(configCmd, "log.manage"))
 
 		rootCmd.AddCommand(logCmd)
 	}
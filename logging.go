@@ -19,6 +19,9 @@ type AuditLog struct {
 	Duration  time.Duration `json:"duration"`
 	Success   bool          `json:"success"`
 	Error     string        `json:"error,omitempty"`
+	Transport string        `json:"transport,omitempty"` // Transport of the originating session
+	Session   string        `json:"session,omitempty"`   // Originating session ID
+	Remote    string        `json:"remote,omitempty"`    // Remote address of the session
 }
 
 // LogManager handles command logging and audit trail
@@ -115,6 +115,7 @@ type MCPServer struct {
 	appVersion string
 	reader     *bufio.Reader
 	writer     io.Writer
+	session    *Session // Runs requests as this session (nil = unrestricted)
 }
 
 // NewMCPServer creates a new MCP server
@@ -163,13 +164,24 @@ func (s *MCPServer) ProcessBytes(ctx context.Context, data []byte) *JSONRPCRespo
 	return s.Process(ctx, &req)
 }
 
+// SetSession makes the server run tools as the given session, so its policy
+// and roles decide which tools are listed and may be called.
+func (s *MCPServer) SetSession(session *Session) {
+	s.session = session
+}
+
 // Process processes a single request and returns a response (or nil for notifications).
+// A session attached to ctx takes precedence over the one set with SetSession.
 func (s *MCPServer) Process(ctx context.Context, req *JSONRPCRequest) *JSONRPCResponse {
+	if s.session != nil && SessionFromContext(ctx) == nil {
+		ctx = WithSession(ctx, s.session)
+	}
+
 	switch req.Method {
 	case "initialize":
 		return s.handleInitialize(req)
 	case "tools/list":
-		return s.handleToolsList(req)
+		return s.handleToolsList(ctx, req)
 	case "tools/call":
 		return s.handleToolsCall(ctx, req)
 	case "resources/list":
@@ -205,14 +217,16 @@ func (s *MCPServer) handleInitialize(req *JSONRPCRequest) *JSONRPCResponse {
 }
 
 // handleToolsList returns the list of available tools (CLI commands)
-func (s *MCPServer) handleToolsList(req *JSONRPCRequest) *JSONRPCResponse {
+func (s *MCPServer) handleToolsList(ctx context.Context, req *JSONRPCRequest) *JSONRPCResponse {
 	if req.ID == nil {
 		return nil
 	}
 	tools := []Tool{}
 
 	// Get the root command
-	rootCmd := s.cli.RootCmd()
+	// Leave out commands the session can't run
+	rootCmd, release := s.cli.visibleTree(ctx)
+	defer release()
 
 	// Recursively collect all commands
 	s.collectCommands(rootCmd, "", &tools)
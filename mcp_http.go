@@ -28,6 +28,11 @@ func NewMCPHTTPServer(cli *CommandExecutor, appName, appVersion string) *MCPHTTP
 	}
 }
 
+// SetSession makes the server run tools as the given session (see MCPServer.SetSession).
+func (s *MCPHTTPServer) SetSession(session *Session) {
+	s.mcp.SetSession(session)
+}
+
 func (s *MCPHTTPServer) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", s.handleHealthz)
@@ -124,7 +124,8 @@ Example MCP client configuration (for Claude Desktop):
 
 				cmd.Println("Available Commands as Tools:")
 				// Get root command and count tools
-				rootCmd := exec.RootCmd()
+				rootCmd, release := exec.visibleTree(cmd.Context())
+				defer release()
 				toolCount := 0
 				countTools(rootCmd, &toolCount)
 				cmd.Printf("  Total: %d commands\n", toolCount)
@@ -156,19 +157,23 @@ Example MCP client configuration (for Claude Desktop):
 				cmd.Println(strings.Repeat("=", 60))
 
 				// Get root command
-				rootCmd := exec.RootCmd()
+				rootCmd, release := exec.visibleTree(cmd.Context())
+				defer release()
 
 				// Collect and display tools
 				displayTools(rootCmd, "", cmd)
 			},
 		}
 
-		mcpCmd.AddCommand(startCmd)
+		mcpCmd.AddCommand(RequirePermission(startCmd, "server.manage"))
 		mcpCmd.AddCommand(infoCmd)
 		mcpCmd.AddCommand(listToolsCmd)
 
 		// Make "start" the default action
 		mcpCmd.Run = startCmd.Run
+		RequirePermission(mcpCmd, "server.manage")
+		RequirePermission(infoCmd, "")
+		RequirePermission(listToolsCmd, "")
 
 		rootCmd.AddCommand(mcpCmd)
 	}
@@ -0,0 +1,109 @@
+package consolekit
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/alexj212/consolekit/parser"
+	"github.com/alexj212/consolekit/safemap"
+)
+
+// Invocation describes one execution passing through the middleware chain:
+// either a whole command line or a single pipeline stage of one.
+type Invocation struct {
+	Line     string                           // Command line as submitted (before expansion for a line, after for a stage)
+	Stage    *parser.ExecCmd                  // Pipeline stage being run; nil for a whole command line
+	Session  *Session                         // Originating session, nil if the caller attached none
+	Scope    *safemap.SafeMap[string, string] // Scoped variables for the line
+	Subshell *safemap.SafeMap[string, string] // Variables of the "( )" group or "&" job it runs in; nil outside one
+	Depth    int32                            // Nesting depth; 1 for a line submitted by a transport
+	Start    time.Time                        // When the invocation started
+
+	// Input is the stage's standard input (nil for a line and a first stage).
+	// Output is where the invocation writes, and ErrOutput where a stage writes
+	// its standard error (nil for a line). Middleware may replace them before
+	// calling the next handler, e.g. to capture or redact output. A stage's own
+	// redirections ("< in", "> out", "2>&1") are applied after the chain.
+	Input     io.Reader
+	Output    io.Writer
+	ErrOutput io.Writer
+}
+
+// IsStage reports whether the invocation is a single pipeline stage.
+func (inv *Invocation) IsStage() bool {
+	return inv.Stage != nil
+}
+
+// Args returns the stage's command name followed by its arguments, or nil for a line.
+func (inv *Invocation) Args() []string {
+	if inv.Stage == nil {
+		return nil
+	}
+	return append([]string{inv.Stage.Cmd}, inv.Stage.Args...)
+}
+
+// ExecHandler executes an invocation. The returned error is the invocation's
+// result; use ExitStatus to get its exit status.
+type ExecHandler func(ctx context.Context, inv *Invocation) error
+
+// Middleware wraps an ExecHandler. It can inspect or change the invocation,
+// refuse it by returning an error without calling next, or act on the result.
+type Middleware func(next ExecHandler) ExecHandler
+
+// Use appends middleware to the chain that wraps every command line and
+// pipeline stage, including nested executions. The first middleware added is
+// the outermost. Register middleware before serving commands.
+func (e *CommandExecutor) Use(mw ...Middleware) {
+	e.middleware = append(e.middleware, mw...)
+}
+
+// invoke runs inv through the middleware chain, ending in final.
+func (e *CommandExecutor) invoke(ctx context.Context, inv *Invocation, final ExecHandler) error {
+	h := final
+	for i := len(e.middleware) - 1; i >= 0; i-- {
+		h = e.middleware[i](h)
+	}
+	return h(ctx, inv)
+}
+
+// AuditMiddleware writes an audit log entry for every top-level command line,
+// with the session's user and transport and the line's output, status and duration.
+// NewCommandExecutor installs it with the executor's LogManager.
+func AuditMiddleware(lm *LogManager) Middleware {
+	return func(next ExecHandler) ExecHandler {
+		return func(ctx context.Context, inv *Invocation) error {
+			if lm == nil || !lm.IsEnabled() || inv.IsStage() || inv.Depth != 1 {
+				return next(ctx, inv)
+			}
+
+			logged := &limitedBuffer{max: maxLoggedOutput}
+			inv.Output = io.MultiWriter(inv.Output, logged)
+
+			err := next(ctx, inv)
+
+			entry := AuditLog{
+				Timestamp: inv.Start,
+				User:      currentUser(),
+				Command:   inv.Line,
+				Output:    logged.String(),
+				Duration:  time.Since(inv.Start),
+				Success:   err == nil,
+			}
+			if s := inv.Session; s != nil {
+				entry.Transport = s.Transport
+				entry.Session = s.ID
+				entry.Remote = s.RemoteAddr
+				if s.User != "" {
+					entry.User = s.User
+				}
+			}
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			_ = lm.Log(entry)
+
+			return err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package consolekit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	var mu sync.Mutex
+	var seen []string
+	executor.Use(func(next ExecHandler) ExecHandler {
+		return func(ctx context.Context, inv *Invocation) error {
+			mu.Lock()
+			if inv.IsStage() {
+				seen = append(seen, "stage:"+strings.Join(inv.Args(), " "))
+			} else {
+				seen = append(seen, "line:"+inv.Line)
+			}
+			mu.Unlock()
+			return next(ctx, inv)
+		}
+	})
+
+	if _, err := executor.Execute("print hi | grep hi", nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	want := map[string]bool{"line:print hi | grep hi": true, "stage:print hi": true, "stage:grep hi": true}
+	if len(seen) != len(want) {
+		t.Fatalf("middleware saw %v, want %v", seen, want)
+	}
+	for _, s := range seen {
+		if !want[s] {
+			t.Errorf("unexpected invocation %q", s)
+		}
+	}
+}
+
+func TestMiddlewareRefuseAndRedact(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	errRefused := errors.New("refused")
+	executor.Use(
+		// Refuse a command wherever it appears in the line
+		func(next ExecHandler) ExecHandler {
+			return func(ctx context.Context, inv *Invocation) error {
+				if inv.IsStage() && inv.Stage.Cmd == "date" {
+					return errRefused
+				}
+				return next(ctx, inv)
+			}
+		},
+		// Redact secrets from stage output
+		func(next ExecHandler) ExecHandler {
+			return func(ctx context.Context, inv *Invocation) error {
+				if !inv.IsStage() {
+					return next(ctx, inv)
+				}
+				var buf bytes.Buffer
+				out := inv.Output
+				inv.Output = &buf
+				err := next(ctx, inv)
+				_, _ = io.WriteString(out, strings.ReplaceAll(buf.String(), "secret", "******"))
+				return err
+			}
+		},
+	)
+
+	out, err := executor.Execute("print my secret", nil)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if out != "my ******\n" {
+		t.Errorf("output = %q, want redacted", out)
+	}
+
+	_, err = executor.Execute("print a; date", nil)
+	if !errors.Is(err, errRefused) {
+		t.Errorf("error = %v, want %v", err, errRefused)
+	}
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	executor.LogManager.SetLogFile(filepath.Join(t.TempDir(), "audit.log"))
+	executor.LogManager.Clear()
+	executor.LogManager.Enable()
+
+	session := NewSession("s1", "ssh", "alice")
+	session.RemoteAddr = "10.0.0.1:2222"
+	ctx := WithSession(context.Background(), session)
+
+	// The nested @exec: execution is not logged separately
+	if _, err := executor.ExecuteWithContext(ctx, "print @exec:print nested", nil); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	logs := executor.LogManager.GetLogs()
+	if len(logs) != 1 {
+		t.Fatalf("got %d log entries, want 1: %+v", len(logs), logs)
+	}
+	entry := logs[0]
+	if entry.User != "alice" || entry.Transport != "ssh" || entry.Session != "s1" || entry.Remote != "10.0.0.1:2222" {
+		t.Errorf("entry identity = %+v", entry)
+	}
+	if entry.Command != "print @exec:print nested" || strings.TrimSpace(entry.Output) != "nested" || !entry.Success {
+		t.Errorf("entry = %+v", entry)
+	}
+}
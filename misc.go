@@ -1,6 +1,7 @@
 package consolekit
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
@@ -48,12 +49,17 @@ Use '@' prefix to read embedded files from the scripts filesystem.`,
 					return nil
 				}
 
-				// Read from regular filesystem
-				content, err := os.ReadFile(filename)
+				// Stream from regular filesystem
+				file, err := os.Open(exec.ResolvePath(cmd.Context(), filename))
 				if err != nil {
 					return fmt.Errorf("could not read file: %s error: %v", filename, err)
 				}
-				cmd.Printf("%s\n\n", string(content))
+				defer file.Close()
+
+				if _, err := io.Copy(cmd.OutOrStdout(), file); err != nil {
+					return fmt.Errorf("could not read file: %s error: %v", filename, err)
+				}
+				cmd.Printf("\n\n")
 				return nil
 			},
 		}
@@ -79,20 +85,12 @@ Use '@' prefix to read embedded files from the scripts filesystem.`,
 					expression = strings.ToLower(expression)
 				}
 
-				inputBytes, err := io.ReadAll(cmd.InOrStdin())
-				if err != nil {
-					cmd.Print("Error reading input: ", err)
-					return
-				}
-
-				//cmd.Printf("inputBytes: %s\n", string(inputBytes))
-				//cmd.Printf("expression: %s\n", expression)
-				//cmd.Printf("inverse: %t\n", inverse)
-				//cmd.Printf("insensitive: %t\n", insensitive)
-				input := string(inputBytes)
-				lines := strings.Split(input, "\n")
+				// Match line by line so piped input streams through
+				scanner := bufio.NewScanner(cmd.InOrStdin())
+				scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 
-				for _, line := range lines {
+				for scanner.Scan() {
+					line := scanner.Text()
 					if len(line) == 0 {
 						continue
 					}
@@ -112,6 +110,9 @@ Use '@' prefix to read embedded files from the scripts filesystem.`,
 					}
 
 				}
+				if err := scanner.Err(); err != nil {
+					cmd.Print("Error reading input: ", err)
+				}
 			},
 		}
 
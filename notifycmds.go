@@ -16,8 +16,6 @@ func AddNotifyCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 		}
 
 		// notify send - send a desktop notification
-		var urgency string
-		var webhook bool
 		var sendCmd = &cobra.Command{
 			Use:   "send [title] [message]",
 			Short: "Send a desktop or webhook notification",
@@ -31,6 +29,9 @@ Examples:
   notify send "Alert" "Server down" --webhook`,
 			Args: cobra.ExactArgs(2),
 			Run: func(cmd *cobra.Command, args []string) {
+				urgency, _ := cmd.Flags().GetString("urgency")
+				webhook, _ := cmd.Flags().GetBool("webhook")
+
 				title := args[0]
 				message := args[1]
 
@@ -54,8 +55,8 @@ Examples:
 				ResetAllFlags(cmd)
 			},
 		}
-		sendCmd.Flags().StringVarP(&urgency, "urgency", "u", "normal", "Urgency level: low, normal, critical")
-		sendCmd.Flags().BoolVar(&webhook, "webhook", false, "Send to webhook instead of desktop")
+		sendCmd.Flags().StringP("urgency", "u", "normal", "Urgency level: low, normal, critical")
+		sendCmd.Flags().Bool("webhook", false, "Send to webhook instead of desktop")
 
 		// notify config - configure webhook
 		var configCmd = &cobra.Command{
@@ -0,0 +1,290 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// ErrIncomplete is returned by JoinLines when input ends inside a heredoc, a
+// block or a quoted string. Script readers use it to keep reading lines.
+var ErrIncomplete = errors.New("incomplete input")
+
+// JoinLines joins the physical lines of input into logical command lines,
+// separated by newlines:
+//   - a line ending in "\" continues on the next one, and so does a line ending
+//     inside quotes (the newline is part of the quoted word)
+//   - "cmd <<EOF" takes the following lines, up to one holding only EOF, as the
+//     command's standard input. They are written back as the here-string
+//     `<<< "text"`; "<<-EOF" strips their leading tabs and "<<'EOF'" single
+//     quotes the text
+//   - a "{" ending a line opens a block that runs to the matching line starting
+//     with "}". The block becomes a single quoted argument holding its lines, so
+//     for, while, case and if bodies can span lines; the line continues after
+//     the "}" (e.g. "} else {")
+//   - a "{ ... }" block on a single line (a "{" word that does not start a
+//     command, which would be a group) also becomes a single quoted argument
+//     holding its body, so the body is not expanded before it runs
+//
+// Comment lines outside heredocs and blocks are dropped.
+func JoinLines(input string) (string, error) {
+	joined, _, err := joinLines(input)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(joined, "\n"), nil
+}
+
+// joinLines returns the logical lines of input (see JoinLines) and the
+// numbers of the physical lines they start on, from 1.
+func joinLines(input string) ([]string, []int, error) {
+	lines := strings.Split(input, "\n")
+	var joined []string
+	var starts []int
+	var current string
+	start := 0
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if current == "" {
+			start = i + 1
+		}
+		switch {
+		case scanAll(current).open():
+			// Lines of a quoted word or a group are kept as they are
+			line = strings.TrimSuffix(lines[i], "\r")
+		case strings.HasPrefix(line, "#"):
+			// If we have accumulated content, save it before skipping comment
+			if current != "" {
+				joined, starts = append(joined, current), append(starts, start)
+				current = ""
+			}
+			continue
+		case strings.HasSuffix(line, "\\"):
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+
+		current += line
+		if !scanAll(current).inQuotes() {
+			var err error
+			current, i, err = readConstructs(current, lines, i)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if scanAll(current).open() {
+			current += "\n"
+			continue
+		}
+		if current != "" {
+			joined, starts = append(joined, current), append(starts, start)
+			current = ""
+		}
+	}
+	if st := scanAll(current); st.inQuotes() {
+		return nil, nil, fmt.Errorf("%w: unterminated quoted string", ErrIncomplete)
+	} else if st.open() {
+		return nil, nil, fmt.Errorf("%w: group is not closed", ErrIncomplete)
+	}
+	if current != "" {
+		joined, starts = append(joined, current), append(starts, start)
+	}
+
+	return joined, starts, nil
+}
+
+// readConstructs completes line, which ends on lines[i], with the heredocs and
+// blocks it opens. Returns the logical line and the index of its last line.
+func readConstructs(line string, lines []string, i int) (string, int, error) {
+	for {
+		var err error
+		line, i, err = readHeredocs(line, lines, i)
+		if err != nil {
+			return "", 0, err
+		}
+
+		open := blockOpen(line)
+		if open == -1 {
+			return quoteBlocks(line), i, nil
+		}
+
+		var body, rest string
+		body, rest, i, err = readBlock(lines, i+1)
+		if err != nil {
+			return "", 0, err
+		}
+		line = strings.TrimSpace(line[:open] + quoteSingle(body) + " " + rest)
+	}
+}
+
+// heredoc is a "<<DELIM" operator found in a line.
+type heredoc struct {
+	start, end int    // Rune range of the operator and its delimiter
+	delim      string // Unquoted delimiter
+	strip      bool   // "<<-": strip leading tabs
+	quoted     bool   // Quoted delimiter: the text is single quoted
+}
+
+// readHeredocs replaces the heredocs of line, which ends on lines[i], with
+// here-strings of the lines that follow. Returns the index of the last line read.
+func readHeredocs(line string, lines []string, i int) (string, int, error) {
+	for {
+		runes := []rune(line)
+		h, ok := findHeredoc(runes)
+		if !ok {
+			return line, i, nil
+		}
+
+		var body []string
+		j := i + 1
+		for ; j < len(lines); j++ {
+			l := strings.TrimSuffix(lines[j], "\r")
+			if strings.TrimSpace(l) == h.delim {
+				break
+			}
+			if h.strip {
+				l = strings.TrimLeft(l, "\t")
+			}
+			body = append(body, l)
+		}
+		if j == len(lines) {
+			return "", 0, fmt.Errorf("%w: heredoc delimited by `%s' is not terminated", ErrIncomplete, h.delim)
+		}
+
+		text := strings.Join(body, "\n")
+		quoted := quoteDouble(text)
+		if h.quoted {
+			quoted = quoteSingle(text)
+		}
+		line = string(runes[:h.start]) + "<<< " + quoted + string(runes[h.end:])
+		i = j
+	}
+}
+
+// findHeredoc finds the first unquoted "<<DELIM" operator outside groups,
+// leaving here-strings ("<<<") alone.
+func findHeredoc(runes []rune) (heredoc, bool) {
+	var st scanState
+	for i := 0; i < len(runes); i++ {
+		if !st.scan(runes, i) || runes[i] != '<' {
+			continue
+		}
+
+		// Skip "<<<" and "<" as a whole
+		n := 1
+		for i+n < len(runes) && runes[i+n] == '<' {
+			n++
+		}
+		if n != 2 {
+			i += n - 1
+			continue
+		}
+
+		h := heredoc{start: i}
+		j := i + 2
+		if j < len(runes) && runes[j] == '-' {
+			h.strip = true
+			j++
+		}
+		for j < len(runes) && (runes[j] == ' ' || runes[j] == '\t') {
+			j++
+		}
+		end := endOfWord(runes, j)
+		word := string(runes[j:end])
+		words, err := shellquote.Split(word)
+		if err != nil || len(words) != 1 {
+			// Left for Parse to report
+			return heredoc{}, false
+		}
+		h.delim = words[0]
+		h.quoted = strings.ContainsAny(word, `'"\`)
+		h.end = end
+		return h, true
+	}
+	return heredoc{}, false
+}
+
+// blockOpen returns the index of the unquoted "{" ending line, or -1. The "{"
+// must be a word of its own or follow "=" (as in "--if-true={"). A "{" starting
+// a command opens a group instead.
+func blockOpen(line string) int {
+	line = strings.TrimRight(line, " \t")
+	runes := []rune(line)
+	if !endsWithBrace(runes) || atCommandStart(runes, len(runes)-1) {
+		return -1
+	}
+	return len(line) - 1
+}
+
+// endsWithBrace reports whether runes end with an unquoted "{" word, opening
+// either a block or a group.
+func endsWithBrace(runes []rune) bool {
+	i := len(runes) - 1
+	return i >= 0 && isBraceOpen(runes, i) && !scanAll(string(runes[:i])).inQuotes()
+}
+
+// quoteBlocks replaces the blocks of line that close on it with single quoted
+// words of their bodies, as readConstructs does for blocks spanning lines.
+func quoteBlocks(line string) string {
+	runes := []rune(line)
+	var st scanState
+	for i := 0; i < len(runes); i++ {
+		if st.inQuotes() || st.escaped || i == 0 || !unicode.IsSpace(runes[i-1]) ||
+			!isBraceOpen(runes, i) || atCommandStart(runes, i) {
+			st.scan(runes, i)
+			continue
+		}
+		end := groupEnd(runes[i:])
+		if end == -1 {
+			st.scan(runes, i)
+			continue
+		}
+		quoted := []rune(quoteSingle(strings.TrimSpace(string(runes[i+1 : i+end]))))
+		runes = append(runes[:i:i], append(quoted, runes[i+end+1:]...)...)
+		i += len(quoted) - 1
+	}
+	return string(runes)
+}
+
+// readBlock reads the lines of a block from lines[start] to its closing "}".
+// It returns the block's lines, the rest of the closing line and its index.
+func readBlock(lines []string, start int) (body, rest string, end int, err error) {
+	depth := 1
+	for j := start; j < len(lines); j++ {
+		line := strings.TrimSpace(lines[j])
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "}") {
+			depth--
+			if depth == 0 {
+				return strings.Join(lines[start:j], "\n"), strings.TrimSpace(line[1:]), j, nil
+			}
+		}
+		if endsWithBrace([]rune(line)) {
+			depth++
+		}
+
+		// Heredoc lines can't close the block
+		if _, last, err := readHeredocs(line, lines, j); err == nil {
+			j = last
+		}
+	}
+	return "", "", 0, fmt.Errorf("%w: block opened with `{' is not closed", ErrIncomplete)
+}
+
+// quoteSingle quotes s as a single word whose contents are not expanded.
+func quoteSingle(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// doubleQuoteEscaper escapes text inside double quotes, backslashes first.
+var doubleQuoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quoteDouble quotes s as a single word whose contents are still expanded.
+func quoteDouble(s string) string {
+	return `"` + doubleQuoteEscaper.Replace(s) + `"`
+}
@@ -3,203 +3,470 @@ package parser
 import (
 	"errors"
 	"strings"
+	"unicode"
 
 	"github.com/kballard/go-shellquote"
 )
 
 type ExecCmd struct {
-	Cmd  string
-	Args []string
-	Pipe *ExecCmd
-	Line int // Line number for error reporting
+	Cmd        string
+	Args       []string
+	Pipe       *ExecCmd
+	Op         string     // Operator chaining this command to the previous one: "", "&&" or "||"
+	Redirects  []Redirect // I/O redirections, in the order they appear
+	Group      []*ExecCmd // Commands of a "( )" or "{ }" group; Cmd is empty
+	Subshell   bool       // "( )" group: variables set inside it are discarded afterwards
+	Background bool       // Followed by "&": runs as a background job
+	Line       int        // Line of the input the command starts on, from 1, for error reporting
 }
 
+// Redirect is an I/O redirection of a single command: "< file", "<<< text",
+// "> file", ">> file", "2> file", "2>> file", "2>&1" or ">&2". Heredocs are
+// turned into "<<< text" by JoinLines.
+type Redirect struct {
+	Fd     int    // Redirected descriptor: 0 (stdin), 1 (stdout) or 2 (stderr)
+	File   string // Target file; empty for a here-string or when duplicating a descriptor
+	Text   string // Standard input of a here-string, without its final newline
+	Append bool   // ">>": append to the file instead of truncating it
+	Dup    int    // Descriptor duplicated when File is empty ("2>&1" has Dup 1)
+}
+
+// Operators that chain commands on the previous command's exit status
+const (
+	OpAnd = "&&" // Run only if the previous command succeeded
+	OpOr  = "||" // Run only if the previous command failed
+)
+
 func (c *ExecCmd) String() string {
+	s := c.Cmd + " " + strings.Join(c.Args, " ")
+	if c.Group != nil {
+		cmds := make([]string, len(c.Group))
+		for i, cmd := range c.Group {
+			cmds[i] = cmd.String()
+		}
+		if c.Subshell {
+			s = "( " + strings.Join(cmds, "; ") + " )"
+		} else {
+			s = "{ " + strings.Join(cmds, "; ") + "; }"
+		}
+	}
 	if c.Pipe != nil {
-		return c.Cmd + " " + strings.Join(c.Args, " ") + " | " + c.Pipe.String()
+		s += " | " + c.Pipe.String()
+	}
+	if c.Background {
+		s += " &"
 	}
-	return c.Cmd + " " + strings.Join(c.Args, " ")
+	return s
 }
 
-// ParseCommands processes multi-line input into executable commands
+// ParseCommands processes multi-line input into executable commands, and
+// returns the file of its "> file" redirection, if any, which is removed from
+// the commands. Only one is allowed; other redirections stay in the Redirects
+// of their command. Use Parse to get every redirection of every command.
 func ParseCommands(input string) (string, []*ExecCmd, error) {
-	var outputFile string
-	var commands []*ExecCmd
+	commands, err := Parse(input)
+	if err != nil {
+		return "", nil, err
+	}
 
-	// Remove comments and handle multi-line commands
-	lines := strings.Split(input, "\n")
-	filteredLines := []string{}
-	var currentLine string
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "#") {
-			// If we have accumulated content, save it before skipping comment
-			if currentLine != "" {
-				filteredLines = append(filteredLines, currentLine)
-				currentLine = ""
+	var outputFile string
+	for _, cmd := range commands {
+		for stage := cmd; stage != nil; stage = stage.Pipe {
+			var kept []Redirect
+			for _, r := range stage.Redirects {
+				if r.Fd != 1 || r.File == "" || r.Append {
+					kept = append(kept, r)
+					continue
+				}
+				if outputFile != "" {
+					return "", nil, errors.New("multiple output redirections are not allowed")
+				}
+				outputFile = r.File
 			}
-			continue
-		}
-		if strings.HasSuffix(line, "\\") {
-			currentLine += strings.TrimSuffix(line, "\\") + " "
-			continue
-		}
-		currentLine += line
-		if currentLine != "" {
-			filteredLines = append(filteredLines, currentLine)
-			currentLine = ""
+			stage.Redirects = kept
 		}
 	}
+	return outputFile, commands, nil
+}
 
-	if len(filteredLines) == 0 {
-		return "", commands, nil
+// Parse processes multi-line input into executable commands.
+// Redirections belong to the command they follow, so each command of a
+// ";" chain or pipeline can have its own.
+func Parse(input string) ([]*ExecCmd, error) {
+	var commands []*ExecCmd
+
+	// Remove comments and handle multi-line commands
+	filteredLines, starts, err := joinLines(input)
+	if err != nil {
+		return nil, err
 	}
 
 	// Process each line
-	for _, line := range filteredLines {
+	for n, line := range filteredLines {
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
 
-		// Check for output redirection using quote-aware parsing
-		redirectIdx := findUnquotedChar(line, '>')
-		if redirectIdx != -1 {
-			if outputFile != "" {
-				return "", nil, errors.New("multiple output redirections are not allowed")
-			}
-			outputFile = strings.TrimSpace(line[redirectIdx+1:])
-			line = strings.TrimSpace(line[:redirectIdx])
+		// Split the input by ';' and '&' to handle multiple command chains (quote-aware)
+		lists, background, err := splitLists(line)
+		if err != nil {
+			return nil, err
 		}
 
-		// Split the input by ';' to handle multiple command chains (quote-aware)
-		commandGroups := splitByUnquotedChar(line, ';')
-
-		for _, group := range commandGroups {
-			group = strings.TrimSpace(group)
-			if group == "" {
+		for i, list := range lists {
+			list = strings.TrimSpace(list)
+			if list == "" {
 				continue
 			}
 
-			// Parse piped commands (quote-aware)
-			var prevCmd *ExecCmd
-			pipeParts := splitByUnquotedChar(group, '|')
-			for _, part := range pipeParts {
-				part = strings.TrimSpace(part)
-				if part == "" {
-					continue
-				}
+			chain, err := parseChain(list)
+			if err != nil {
+				return nil, err
+			}
+			for _, cmd := range chain {
+				numberLines(cmd, starts[n])
+			}
 
-				// Use shellquote to properly handle quoted arguments
-				cmdParts, err := shellquote.Split(part)
-				if err != nil {
-					return "", nil, errors.New("invalid command syntax: " + err.Error())
-				}
-				if len(cmdParts) == 0 {
-					return "", nil, errors.New("invalid command syntax")
+			if background[i] {
+				// A chain runs in the background as a whole
+				if len(chain) > 1 {
+					chain = []*ExecCmd{{Group: chain, Subshell: true, Line: starts[n]}}
 				}
+				chain[0].Background = true
+			}
+			commands = append(commands, chain...)
+		}
+	}
 
-				cmd := &ExecCmd{
-					Cmd:  cmdParts[0],
-					Args: cmdParts[1:],
-				}
+	return commands, nil
+}
 
-				if prevCmd != nil {
-					prevCmd.Pipe = cmd
-				} else {
-					commands = append(commands, cmd)
-				}
+// numberLines sets the line of cmd and its pipeline stages. The commands of
+// a group were numbered from the group's first line, so they are shifted.
+func numberLines(cmd *ExecCmd, line int) {
+	for stage := cmd; stage != nil; stage = stage.Pipe {
+		stage.Line = line
+		for _, c := range stage.Group {
+			shiftLines(c, line-1)
+		}
+	}
+}
+
+// shiftLines adds n to the lines of cmd, its stages and the commands of its groups.
+func shiftLines(cmd *ExecCmd, n int) {
+	for stage := cmd; stage != nil; stage = stage.Pipe {
+		stage.Line += n
+		for _, c := range stage.Group {
+			shiftLines(c, n)
+		}
+	}
+}
 
-				prevCmd = cmd
+// parseChain parses a "&&" / "||" chain of pipelines.
+func parseChain(list string) ([]*ExecCmd, error) {
+	var commands []*ExecCmd
+
+	// Split && / || chains before pipes so "||" is not read as two pipes
+	chainParts, ops, err := splitAndOr(list)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, chainPart := range chainParts {
+		// Parse piped commands (quote-aware)
+		var prevCmd *ExecCmd
+		pipeParts := splitByUnquotedChar(chainPart, '|')
+		for _, part := range pipeParts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
 			}
+
+			cmd, err := parseCommand(part)
+			if err != nil {
+				return nil, err
+			}
+
+			if prevCmd != nil {
+				prevCmd.Pipe = cmd
+			} else {
+				cmd.Op = ops[i]
+				commands = append(commands, cmd)
+			}
+
+			prevCmd = cmd
 		}
 	}
 
-	return outputFile, commands, nil
+	return commands, nil
 }
 
-// findUnquotedChar finds the first occurrence of char that's not inside quotes
-// Returns -1 if not found
-func findUnquotedChar(s string, char rune) int {
-	inSingleQuote := false
-	inDoubleQuote := false
-	escaped := false
+// parseCommand parses a single pipeline stage: a command and its arguments, or a
+// group, followed by its redirections.
+func parseCommand(part string) (*ExecCmd, error) {
+	if runes := []rune(part); runes[0] == '(' || isBraceOpen(runes, 0) {
+		return parseGroup(runes)
+	}
 
-	for i, c := range s {
-		if escaped {
-			escaped = false
-			continue
-		}
+	part, redirects, err := extractRedirects(part)
+	if err != nil {
+		return nil, err
+	}
 
-		if c == '\\' {
-			escaped = true
-			continue
-		}
+	// Use shellquote to properly handle quoted arguments
+	cmdParts, err := shellquote.Split(part)
+	if err != nil {
+		return nil, errors.New("invalid command syntax: " + err.Error())
+	}
+	if len(cmdParts) == 0 {
+		return nil, errors.New("invalid command syntax")
+	}
 
-		if c == '\'' && !inDoubleQuote {
-			inSingleQuote = !inSingleQuote
+	return &ExecCmd{
+		Cmd:       cmdParts[0],
+		Args:      cmdParts[1:],
+		Redirects: redirects,
+	}, nil
+}
+
+// parseGroup parses a "( cmds )" or "{ cmds; }" group and the redirections
+// following it, which apply to the group as a whole.
+func parseGroup(runes []rune) (*ExecCmd, error) {
+	end := groupEnd(runes)
+	if end == -1 {
+		return nil, errors.New("syntax error: `" + string(runes[0]) + "' is not closed")
+	}
+
+	group, err := Parse(string(runes[1:end]))
+	if err != nil {
+		return nil, err
+	}
+	if len(group) == 0 {
+		return nil, errors.New("syntax error: empty group")
+	}
+
+	rest, redirects, err := extractRedirects(string(runes[end+1:]))
+	if err != nil {
+		return nil, err
+	}
+	if rest = strings.TrimSpace(rest); rest != "" {
+		return nil, errors.New("syntax error near unexpected `" + rest + "' after group")
+	}
+
+	return &ExecCmd{
+		Group:     group,
+		Subshell:  runes[0] == '(',
+		Redirects: redirects,
+	}, nil
+}
+
+// splitByUnquotedChar splits a string by char, but only at positions where char
+// is not quoted or inside a group
+func splitByUnquotedChar(s string, char rune) []string {
+	var result []string
+	var current strings.Builder
+	var st scanState
+
+	runes := []rune(s)
+	for i, c := range runes {
+		if st.scan(runes, i) && c == char {
+			result = append(result, current.String())
+			current.Reset()
 			continue
 		}
 
-		if c == '"' && !inSingleQuote {
-			inDoubleQuote = !inDoubleQuote
+		current.WriteRune(c)
+	}
+
+	// Add the last part
+	if current.Len() > 0 || len(result) > 0 {
+		result = append(result, current.String())
+	}
+
+	return result
+}
+
+// splitLists splits a line on unquoted ";" and "&" outside groups, leaving
+// "&&" and descriptor duplications ("2>&1") alone. background[i] reports
+// whether lists[i] is followed by "&".
+func splitLists(s string) (lists []string, background []bool, err error) {
+	var current strings.Builder
+	var st scanState
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if !st.scan(runes, i) || (c != ';' && c != '&') {
+			current.WriteRune(c)
 			continue
 		}
 
-		if !inSingleQuote && !inDoubleQuote && c == char {
-			return i
+		if c == '&' {
+			if i+1 < len(runes) && runes[i+1] == '&' {
+				current.WriteString("&&")
+				i++
+				continue
+			}
+			if i > 0 && runes[i-1] == '>' {
+				current.WriteRune(c)
+				continue
+			}
+			if strings.TrimSpace(current.String()) == "" {
+				return nil, nil, errors.New("syntax error near unexpected token `&'")
+			}
 		}
+		lists = append(lists, current.String())
+		background = append(background, c == '&')
+		current.Reset()
+	}
+
+	// Add the last part
+	if current.Len() > 0 || len(lists) == 0 {
+		lists = append(lists, current.String())
+		background = append(background, false)
 	}
 
-	return -1
+	return lists, background, nil
 }
 
-// splitByUnquotedChar splits a string by char, but only at positions where char is not quoted
-func splitByUnquotedChar(s string, char rune) []string {
-	var result []string
+// splitAndOr splits a command group on unquoted "&&" and "||" operators.
+// ops[i] is the operator preceding parts[i] ("" for the first part).
+func splitAndOr(s string) (parts []string, ops []string, err error) {
 	var current strings.Builder
-	inSingleQuote := false
-	inDoubleQuote := false
-	escaped := false
+	var st scanState
+	op := ""
 
-	for _, c := range s {
-		if escaped {
-			current.WriteRune(c)
-			escaped = false
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		if st.scan(runes, i) && (c == '&' || c == '|') && i+1 < len(runes) && runes[i+1] == c {
+			part := strings.TrimSpace(current.String())
+			if part == "" {
+				return nil, nil, errors.New("syntax error near unexpected token `" + string(c) + string(c) + "'")
+			}
+			parts = append(parts, part)
+			ops = append(ops, op)
+			op = string(c) + string(c)
+			current.Reset()
+			i++
 			continue
 		}
 
-		if c == '\\' {
-			current.WriteRune(c)
-			escaped = true
+		current.WriteRune(c)
+	}
+
+	part := strings.TrimSpace(current.String())
+	if part == "" && op != "" {
+		return nil, nil, errors.New("syntax error: missing command after `" + op + "'")
+	}
+	parts = append(parts, part)
+	ops = append(ops, op)
+
+	return parts, ops, nil
+}
+
+// extractRedirects removes the unquoted redirections outside groups from a
+// single command and returns the remaining command text and the redirections in order.
+func extractRedirects(s string) (string, []Redirect, error) {
+	var rest strings.Builder
+	var redirects []Redirect
+	var st scanState
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		if !st.scan(runes, i) {
+			rest.WriteRune(c)
 			continue
 		}
 
-		if c == '\'' && !inDoubleQuote {
-			inSingleQuote = !inSingleQuote
-			current.WriteRune(c)
+		// Operator: "<", ">", ">>" or a descriptor ("1>", "2>>") starting a word
+		j := i
+		r := Redirect{Fd: -1}
+		hereString := false
+		switch {
+		case c == '<' && j+2 < len(runes) && runes[j+1] == '<' && runes[j+2] == '<':
+			r.Fd = 0
+			hereString = true
+			j += 2
+		case c == '<':
+			r.Fd = 0
+		case c == '>':
+			r.Fd = 1
+		case (c == '1' || c == '2') && j+1 < len(runes) && runes[j+1] == '>' && (i == 0 || unicode.IsSpace(runes[i-1])):
+			r.Fd = int(c - '0')
+			j++
+		}
+		if r.Fd == -1 {
+			rest.WriteRune(c)
 			continue
 		}
+		j++
 
-		if c == '"' && !inSingleQuote {
-			inDoubleQuote = !inDoubleQuote
-			current.WriteRune(c)
-			continue
+		if r.Fd != 0 && j < len(runes) && runes[j] == '>' {
+			r.Append = true
+			j++
 		}
 
-		if !inSingleQuote && !inDoubleQuote && c == char {
-			result = append(result, current.String())
-			current.Reset()
+		// Descriptor duplication: "2>&1", ">&2"
+		if r.Fd != 0 && !r.Append && j+1 < len(runes) && runes[j] == '&' && (runes[j+1] == '1' || runes[j+1] == '2') {
+			r.Dup = int(runes[j+1] - '0')
+			redirects = append(redirects, r)
+			rest.WriteRune(' ')
+			i = j + 1
 			continue
 		}
 
-		current.WriteRune(c)
+		// Target file
+		for j < len(runes) && unicode.IsSpace(runes[j]) {
+			j++
+		}
+		end := endOfWord(runes, j)
+		words, err := shellquote.Split(string(runes[j:end]))
+		if err != nil || len(words) != 1 {
+			missing := "file name"
+			if hereString {
+				missing = "text"
+			}
+			return "", nil, errors.New("syntax error: missing " + missing + " after `" + strings.TrimSpace(string(runes[i:j])) + "'")
+		}
+		if hereString {
+			r.Text = words[0]
+		} else {
+			r.File = words[0]
+		}
+		redirects = append(redirects, r)
+		rest.WriteRune(' ')
+		i = end - 1
 	}
 
-	// Add the last part
-	if current.Len() > 0 || len(result) > 0 {
-		result = append(result, current.String())
-	}
+	return rest.String(), redirects, nil
+}
 
-	return result
+// endOfWord returns the index just past the word starting at start: up to the
+// next unquoted space or redirection operator.
+func endOfWord(runes []rune, start int) int {
+	inSingleQuote := false
+	inDoubleQuote := false
+	escaped := false
+
+	for i := start; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !inSingleQuote:
+			escaped = true
+		case c == '\'' && !inDoubleQuote:
+			inSingleQuote = !inSingleQuote
+		case c == '"' && !inSingleQuote:
+			inDoubleQuote = !inDoubleQuote
+		case !inSingleQuote && !inDoubleQuote && (unicode.IsSpace(c) || c == '<' || c == '>'):
+			return i
+		}
+	}
+	return len(runes)
 }
@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -107,6 +110,115 @@ func TestParseCommands(t *testing.T) {
 			}
 		})
 	}
+
+	// The output file is taken out of its command, other redirections stay
+	_, cmds, err := ParseCommands("echo test > output.txt 2> err.txt")
+	if err != nil {
+		t.Fatalf("ParseCommands() error = %v", err)
+	}
+	if want := []Redirect{{Fd: 2, File: "err.txt"}}; !reflect.DeepEqual(cmds[0].Redirects, want) {
+		t.Errorf("redirects = %+v, want %+v", cmds[0].Redirects, want)
+	}
+	// Parse keeps a redirection per command
+	if cmds, err := Parse("echo test > file1.txt\necho test2 > file2.txt"); err != nil || len(cmds) != 2 {
+		t.Errorf("Parse() = %d commands, %v", len(cmds), err)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantArgs  []string // Command and arguments of the first stage
+		wantRedir []Redirect
+		wantErr   bool
+	}{
+		{
+			name:      "output",
+			input:     "echo test > out.txt",
+			wantArgs:  []string{"echo", "test"},
+			wantRedir: []Redirect{{Fd: 1, File: "out.txt"}},
+		},
+		{
+			name:      "append without spaces",
+			input:     "echo test>>out.txt",
+			wantArgs:  []string{"echo", "test"},
+			wantRedir: []Redirect{{Fd: 1, File: "out.txt", Append: true}},
+		},
+		{
+			name:      "input",
+			input:     "grep x < in.txt",
+			wantArgs:  []string{"grep", "x"},
+			wantRedir: []Redirect{{Fd: 0, File: "in.txt"}},
+		},
+		{
+			name:      "stderr and duplication in order",
+			input:     "cmd 2> err.txt > out.txt 2>&1",
+			wantArgs:  []string{"cmd"},
+			wantRedir: []Redirect{{Fd: 2, File: "err.txt"}, {Fd: 1, File: "out.txt"}, {Fd: 2, Dup: 1}},
+		},
+		{
+			name:      "stdout to stderr",
+			input:     "echo oops >&2",
+			wantArgs:  []string{"echo", "oops"},
+			wantRedir: []Redirect{{Fd: 1, Dup: 2}},
+		},
+		{
+			name:      "quoted file name",
+			input:     `echo hi > "my file.txt"`,
+			wantArgs:  []string{"echo", "hi"},
+			wantRedir: []Redirect{{Fd: 1, File: "my file.txt"}},
+		},
+		{
+			name:     "quoted operators are arguments",
+			input:    `echo "a > b" '2>&1' c\>d`,
+			wantArgs: []string{"echo", "a > b", "2>&1", "c>d"},
+		},
+		{
+			name:      "digit inside a word",
+			input:     "echo x2>y",
+			wantArgs:  []string{"echo", "x2"},
+			wantRedir: []Redirect{{Fd: 1, File: "y"}},
+		},
+		{
+			name:    "missing file",
+			input:   "echo test >",
+			wantErr: true,
+		},
+		{
+			name:    "missing file before pipe",
+			input:   "echo test > | grep t",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := append([]string{cmds[0].Cmd}, cmds[0].Args...)
+			if !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("args = %q, want %q", got, tt.wantArgs)
+			}
+			if !reflect.DeepEqual(cmds[0].Redirects, tt.wantRedir) {
+				t.Errorf("redirects = %+v, want %+v", cmds[0].Redirects, tt.wantRedir)
+			}
+		})
+	}
+
+	// Each command of a chain keeps its own redirections
+	cmds, err := Parse("a > one.txt; b | c >> two.txt")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cmds[0].Redirects[0].File != "one.txt" || cmds[1].Redirects != nil || cmds[1].Pipe.Redirects[0].File != "two.txt" {
+		t.Errorf("chain redirects = %+v, %+v, %+v", cmds[0].Redirects, cmds[1].Redirects, cmds[1].Pipe.Redirects)
+	}
 }
 
 func TestPipeChain(t *testing.T) {
@@ -139,9 +251,9 @@ func TestPipeChain(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, cmds, err := ParseCommands(tt.input)
+			cmds, err := Parse(tt.input)
 			if err != nil {
-				t.Fatalf("ParseCommands() error = %v", err)
+				t.Fatalf("Parse() error = %v", err)
 			}
 			if len(cmds) != 1 {
 				t.Fatalf("Expected 1 command chain, got %d", len(cmds))
@@ -162,6 +274,82 @@ func TestPipeChain(t *testing.T) {
 	}
 }
 
+func TestAndOrChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantCmd []string // Expected first command of each chain element
+		wantOps []string // Expected operator for each chain element
+		wantErr bool
+	}{
+		{
+			name:    "and",
+			input:   "test 1 -eq 1 && print yes",
+			wantCmd: []string{"test", "print"},
+			wantOps: []string{"", "&&"},
+		},
+		{
+			name:    "or",
+			input:   "test 1 -eq 2 || print no",
+			wantCmd: []string{"test", "print"},
+			wantOps: []string{"", "||"},
+		},
+		{
+			name:    "mixed with pipes",
+			input:   "print a | grep a && print b || print c",
+			wantCmd: []string{"print", "print", "print"},
+			wantOps: []string{"", "&&", "||"},
+		},
+		{
+			name:    "quoted operators",
+			input:   `print "a && b || c"`,
+			wantCmd: []string{"print"},
+			wantOps: []string{""},
+		},
+		{
+			name:    "semicolon resets chain",
+			input:   "print a && print b; print c",
+			wantCmd: []string{"print", "print", "print"},
+			wantOps: []string{"", "&&", ""},
+		},
+		{
+			name:    "missing left operand",
+			input:   "&& print a",
+			wantErr: true,
+		},
+		{
+			name:    "missing right operand",
+			input:   "print a ||",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var gotCmd, gotOps []string
+			for _, cmd := range cmds {
+				gotCmd = append(gotCmd, cmd.Cmd)
+				gotOps = append(gotOps, cmd.Op)
+			}
+
+			if !reflect.DeepEqual(gotCmd, tt.wantCmd) {
+				t.Errorf("commands = %v, want %v", gotCmd, tt.wantCmd)
+			}
+			if !reflect.DeepEqual(gotOps, tt.wantOps) {
+				t.Errorf("operators = %v, want %v", gotOps, tt.wantOps)
+			}
+		})
+	}
+}
+
 func TestQuoteHandling(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -197,9 +385,9 @@ func TestQuoteHandling(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, cmds, err := ParseCommands(tt.input)
+			cmds, err := Parse(tt.input)
 			if err != nil {
-				t.Fatalf("ParseCommands() error = %v", err)
+				t.Fatalf("Parse() error = %v", err)
 			}
 			if len(cmds) == 0 {
 				t.Fatal("Expected at least one command")
@@ -212,55 +400,6 @@ func TestQuoteHandling(t *testing.T) {
 	}
 }
 
-func TestFindUnquotedChar(t *testing.T) {
-	tests := []struct {
-		name  string
-		input string
-		char  rune
-		want  int
-	}{
-		{
-			name:  "not quoted",
-			input: "echo | grep",
-			char:  '|',
-			want:  5,
-		},
-		{
-			name:  "in double quotes",
-			input: `echo "test | pipe"`,
-			char:  '|',
-			want:  -1,
-		},
-		{
-			name:  "in single quotes",
-			input: `echo 'test | pipe'`,
-			char:  '|',
-			want:  -1,
-		},
-		{
-			name:  "escaped",
-			input: `echo test \| pipe`,
-			char:  '|',
-			want:  -1,
-		},
-		{
-			name:  "after quotes",
-			input: `echo "test" | grep`,
-			char:  '|',
-			want:  12,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := findUnquotedChar(tt.input, tt.char)
-			if got != tt.want {
-				t.Errorf("findUnquotedChar() = %d, want %d", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestSplitByUnquotedChar(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -316,8 +455,256 @@ func BenchmarkParseCommands(b *testing.B) {
 	for _, input := range inputs {
 		b.Run(input, func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
-				_, _, _ = ParseCommands(input)
+				_, _ = Parse(input)
+			}
+		})
+	}
+}
+
+func TestJoinLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "plain lines",
+			input: "print a\n  # comment\nprint b",
+			want:  "print a\nprint b",
+		},
+		{
+			name:  "heredoc",
+			input: "grep b <<EOF\na\n  b \"q\"\nEOF\nprint done",
+			want:  "grep b <<< \"a\n  b \\\"q\\\"\"\nprint done",
+		},
+		{
+			name:  "quoted heredoc strips tabs",
+			input: "cat <<-'END' > out.txt\n\t@x\n\tEND",
+			want:  "cat <<< '@x' > out.txt",
+		},
+		{
+			name:  "here-string is left alone",
+			input: "grep a <<< 'a b'",
+			want:  "grep a <<< 'a b'",
+		},
+		{
+			name:  "block",
+			input: "for i in 1 2 do {\n  print @i\n  print 'x'\n}\nprint done",
+			want:  "for i in 1 2 do '  print @i\n  print '\"'\"'x'\"'\"''\nprint done",
+		},
+		{
+			name:  "nested blocks and continued line",
+			input: "case @v a {\n  for i in 1 do {\n    print @i\n  }\n} * {\n  print other\n}",
+			want:  "case @v a '  for i in 1 do {\n    print @i\n  }' * '  print other'",
+		},
+		{
+			name:  "flag block",
+			input: "if @x 1 --if-true={\nprint yes\n}",
+			want:  "if @x 1 --if-true='print yes'",
+		},
+		{
+			name:  "single-line block",
+			input: `func f { print "a;b" @x }; print @y`,
+			want:  `func f 'print "a;b" @x'; print @y`,
+		},
+		{
+			name:  "group and value braces are left alone",
+			input: "{ print a; } && let x={ a }",
+			want:  "{ print a; } && let x={ a }",
+		},
+		{
+			name:  "brace inside a word or quotes",
+			input: "print a{\nprint '{'",
+			want:  "print a{\nprint '{'",
+		},
+		{
+			name:  "quoted string spanning lines",
+			input: "print 'a\n  b'\nprint c",
+			want:  "print 'a\n  b'\nprint c",
+		},
+		{
+			name:    "unterminated heredoc",
+			input:   "cat <<EOF\na",
+			wantErr: ErrIncomplete,
+		},
+		{
+			name:    "unclosed block",
+			input:   "while true {\nprint x",
+			wantErr: ErrIncomplete,
+		},
+		{
+			name:    "unterminated quote",
+			input:   "print 'a",
+			wantErr: ErrIncomplete,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := JoinLines(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("JoinLines() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("JoinLines() = %q, want %q", got, tt.want)
+			}
+
+			// Joined lines parse to the same commands
+			if err == nil {
+				again, _ := JoinLines(got)
+				if again != got {
+					t.Errorf("JoinLines() is not idempotent: %q", again)
+				}
+			}
+		})
+	}
+
+	// The block is a single argument and the heredoc a here-string
+	cmds, err := Parse("for i in 1 2 do {\nprint @i\nprint x\n}\ngrep b <<EOF\na\nb\nEOF")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(cmds) != 2 {
+		t.Fatalf("got %d commands, want 2", len(cmds))
+	}
+	if want := []string{"i", "in", "1", "2", "do", "print @i\nprint x"}; !reflect.DeepEqual(cmds[0].Args, want) {
+		t.Errorf("for args = %q, want %q", cmds[0].Args, want)
+	}
+	if want := []Redirect{{Fd: 0, Text: "a\nb"}}; !reflect.DeepEqual(cmds[1].Redirects, want) {
+		t.Errorf("heredoc = %+v, want %+v", cmds[1].Redirects, want)
+	}
+
+	// Backslashes and quotes of a heredoc body are kept as written
+	for _, body := range []string{`say \"hi\"`, `C:\path\to`, `ends with \`, "a\\\nb \\\" c"} {
+		cmds, err := Parse("cat <<EOF\n" + body + "\nEOF")
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", body, err)
+		}
+		if want := []Redirect{{Fd: 0, Text: body}}; !reflect.DeepEqual(cmds[0].Redirects, want) {
+			t.Errorf("heredoc %q = %+v, want %+v", body, cmds[0].Redirects, want)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		subshell bool
+		group    int // Number of commands in the first command's group
+		pipe     string
+		redirect string // File of the group's first redirection
+		args     []string
+		wantErr  bool
+	}{
+		{name: "subshell piped", input: "( print a; print b ) | grep b", subshell: true, group: 2, pipe: "grep"},
+		{name: "brace group redirected", input: "{ print a; print b; } > out.txt", group: 2, redirect: "out.txt"},
+		{name: "multi-line group", input: "{\n  print a\n  print b\n} > out.txt", group: 2, redirect: "out.txt"},
+		{name: "nested", input: "(print a && (print b; print c))", subshell: true, group: 2},
+		{name: "chain inside a group", input: "( print a || print b )", subshell: true, group: 2},
+		{name: "block inside a group", input: "(\n  for i in 1 do {\n    print @i\n  }\n)", subshell: true, group: 1},
+		{name: "parentheses in arguments", input: "print (a) :(", args: []string{"(a)", ":("}},
+		{name: "braces in arguments", input: "print {a} ${b}", args: []string{"{a}", "${b}"}},
+		{name: "unclosed", input: "( print a", wantErr: true},
+		{name: "text after group", input: "{ print a; } extra", wantErr: true},
+		{name: "empty group", input: "( )", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(cmds) != 1 {
+				t.Fatalf("got %d commands, want 1", len(cmds))
+			}
+			cmd := cmds[0]
+			if len(cmd.Group) != tt.group || cmd.Subshell != tt.subshell {
+				t.Errorf("group = %d commands (subshell %v), want %d (subshell %v)", len(cmd.Group), cmd.Subshell, tt.group, tt.subshell)
+			}
+			if tt.pipe != "" && (cmd.Pipe == nil || cmd.Pipe.Cmd != tt.pipe) {
+				t.Errorf("pipe = %v, want %s", cmd.Pipe, tt.pipe)
+			}
+			if tt.redirect != "" && (len(cmd.Redirects) != 1 || cmd.Redirects[0].File != tt.redirect) {
+				t.Errorf("redirects = %+v, want %s", cmd.Redirects, tt.redirect)
+			}
+			if tt.args != nil && !reflect.DeepEqual(cmd.Args, tt.args) {
+				t.Errorf("args = %q, want %q", cmd.Args, tt.args)
+			}
+		})
+	}
+}
+
+func TestBackground(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string // String() of each command
+		wantErr bool
+	}{
+		{name: "single", input: "sleep 5 &", want: []string{"sleep 5 &"}},
+		{name: "followed by a command", input: "sleep 5 & print hi", want: []string{"sleep 5 &", "print hi"}},
+		{name: "pipeline", input: "print a | grep a &", want: []string{"print a | grep a &"}},
+		{name: "chain runs as a whole", input: "print a && print b &", want: []string{"( print a; print b ) &"}},
+		{name: "not with and", input: "print a && print b", want: []string{"print a", "print b"}},
+		{name: "not in redirections", input: "print a 2>&1 >&2", want: []string{"print a"}},
+		{name: "quoted", input: "print 'a & b'", want: []string{"print a & b"}},
+		{name: "inside a group", input: "{ sleep 5 & print hi; }", want: []string{"{ sleep 5 &; print hi; }"}},
+		{name: "missing command", input: "& print a", wantErr: true},
+		{name: "doubled", input: "sleep 5 & & print a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var got []string
+			for _, cmd := range cmds {
+				got = append(got, strings.TrimSpace(cmd.String()))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commands = %q, want %q", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestLineNumbers(t *testing.T) {
+	input := "# setup\nprint a; print b\n\nprint c | \\\n  grep c\n{\n  print d\n  ( print e\n    print f )\n}\nsleep 1 && print g &"
+	cmds, err := Parse(input)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var got []string
+	var walk func(cmds []*ExecCmd)
+	walk = func(cmds []*ExecCmd) {
+		for _, cmd := range cmds {
+			for stage := cmd; stage != nil; stage = stage.Pipe {
+				name := stage.Cmd
+				if stage.Group != nil {
+					name = "group"
+				}
+				got = append(got, fmt.Sprintf("%s:%d", name, stage.Line))
+				walk(stage.Group)
+			}
+		}
+	}
+	walk(cmds)
+
+	want := []string{"print:2", "print:2", "print:4", "grep:4", "group:6", "print:7", "group:8", "print:8", "print:9", "group:11", "sleep:11", "print:11"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// scanState tracks quotes, escapes and command groups while a line is scanned
+// rune by rune, so operators inside them are left alone.
+type scanState struct {
+	inSingleQuote bool
+	inDoubleQuote bool
+	escaped       bool
+	parens        int // Open "(" groups and "$(" substitutions
+	braces        int // Open "{" groups and blocks
+}
+
+// scan advances the state over runes[i] and reports whether it may start an
+// operator: it is not quoted, escaped, a quote or group character, or inside a group.
+func (st *scanState) scan(runes []rune, i int) bool {
+	c := runes[i]
+	switch {
+	case st.escaped:
+		st.escaped = false
+		return false
+	case c == '\\' && !st.inSingleQuote:
+		st.escaped = true
+		return false
+	case c == '\'' && !st.inDoubleQuote:
+		st.inSingleQuote = !st.inSingleQuote
+		return false
+	case c == '"' && !st.inSingleQuote:
+		st.inDoubleQuote = !st.inDoubleQuote
+		return false
+	case st.inSingleQuote || st.inDoubleQuote:
+		return false
+	case c == '(' && (st.parens > 0 || atCommandStart(runes, i) || (i > 0 && runes[i-1] == '$')):
+		// A subshell, "$(...)", or parentheses nested in them
+		st.parens++
+		return false
+	case c == ')' && st.parens > 0:
+		st.parens--
+		return false
+	case isBraceOpen(runes, i):
+		st.braces++
+		return false
+	case isBraceClose(runes, i) && st.braces > 0:
+		st.braces--
+		return false
+	}
+	return st.parens == 0 && st.braces == 0
+}
+
+// inQuotes reports whether the scanned text ends inside quotes.
+func (st scanState) inQuotes() bool {
+	return st.inSingleQuote || st.inDoubleQuote
+}
+
+// open reports whether the scanned text ends inside quotes or a group.
+func (st scanState) open() bool {
+	return st.inQuotes() || st.parens > 0 || st.braces > 0
+}
+
+// scanAll scans s and returns the state at its end.
+func scanAll(s string) scanState {
+	var st scanState
+	runes := []rune(s)
+	for i := range runes {
+		st.scan(runes, i)
+	}
+	return st
+}
+
+// isBraceOpen reports whether runes[i] is a "{" word: a group at the start of
+// a command or a block ending a line ("do {", "--if-true={").
+func isBraceOpen(runes []rune, i int) bool {
+	if runes[i] != '{' || (i+1 < len(runes) && !unicode.IsSpace(runes[i+1])) {
+		return false
+	}
+	return i == 0 || strings.ContainsRune(" \t\n;|&(=", runes[i-1])
+}
+
+// isBraceClose reports whether runes[i] is a "}" word closing a group or block.
+func isBraceClose(runes []rune, i int) bool {
+	if runes[i] != '}' || (i > 0 && !strings.ContainsRune(" \t\n;", runes[i-1])) {
+		return false
+	}
+	return i+1 == len(runes) || strings.ContainsRune(" \t\n;|&<>)", runes[i+1])
+}
+
+// atCommandStart reports whether runes[i] starts a command: only spaces
+// separate it from the start of the line or from an operator.
+func atCommandStart(runes []rune, i int) bool {
+	j := i - 1
+	for j >= 0 && (runes[j] == ' ' || runes[j] == '\t') {
+		j--
+	}
+	return j < 0 || strings.ContainsRune("\n;|&({", runes[j])
+}
+
+// groupEnd returns the index of the ")" or "}" closing the group that part
+// starts with, or -1 if it is not closed.
+func groupEnd(runes []rune) int {
+	var st scanState
+	for i := range runes {
+		st.scan(runes, i)
+		if st.parens == 0 && st.braces == 0 && !st.inQuotes() {
+			return i
+		}
+	}
+	return -1
+}
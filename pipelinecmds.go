@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	osexec "os/exec"
 
+	"github.com/kballard/go-shellquote"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 )
 
@@ -12,7 +15,6 @@ import (
 func AddPipelineCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return func(rootCmd *cobra.Command) {
 		// tee command - read from stdin and write to both stdout and file
-		var teeAppend bool
 		var teeCmd = &cobra.Command{
 			Use:   "tee [file]",
 			Short: "Read from stdin and write to both stdout and file(s)",
@@ -26,6 +28,8 @@ Examples:
   env | tee file1.txt file2.txt     # Write to multiple files`,
 			Args: cobra.MinimumNArgs(1),
 			Run: func(cmd *cobra.Command, args []string) {
+				teeAppend, _ := cmd.Flags().GetBool("append")
+
 				// Open all output files
 				var writers []io.Writer
 				writers = append(writers, cmd.OutOrStdout())
@@ -71,8 +75,46 @@ Examples:
 				ResetAllFlags(cmd)
 			},
 		}
-		teeCmd.Flags().BoolVarP(&teeAppend, "append", "a", false, "Append to file instead of overwriting")
+		teeCmd.Flags().BoolP("append", "a", false, "Append to file instead of overwriting")
 
 		rootCmd.AddCommand(teeCmd)
+
+		// page command - show stdin through the configured pager
+		var pageCmd = &cobra.Command{
+			Use:   "page",
+			Short: "Show stdin through the pager (settings.pager)",
+			Long: `Show standard input through the pager set by settings.pager in the config
+file (default "less -R"). The pager only runs in the local interactive REPL on a
+terminal; elsewhere the input is written out unchanged.
+
+Examples:
+  history list | page
+  help | page`,
+			Args: cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				var pager []string
+				if exec.Config != nil {
+					pager, _ = shellquote.Split(exec.Config.Settings.Pager)
+				}
+				session := SessionFromContext(cmd.Context())
+				local := session == nil || session.IsLocal()
+				if len(pager) == 0 || !local || !exec.Interactive || !isatty.IsTerminal(os.Stdout.Fd()) {
+					if _, err := io.Copy(cmd.OutOrStdout(), cmd.InOrStdin()); err != nil {
+						cmd.PrintErrln(fmt.Sprintf("Failed to copy: %v", err))
+					}
+					return
+				}
+
+				pagerCmd := osexec.CommandContext(cmd.Context(), pager[0], pager[1:]...)
+				pagerCmd.Stdin = cmd.InOrStdin()
+				pagerCmd.Stdout = os.Stdout
+				pagerCmd.Stderr = os.Stderr
+				if err := pagerCmd.Run(); err != nil {
+					cmd.PrintErrf("Error running pager %q: %v\n", exec.Config.Settings.Pager, err)
+				}
+			},
+		}
+
+		rootCmd.AddCommand(pageCmd)
 	}
 }
@@ -0,0 +1,117 @@
+package consolekit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// AddPlugins registers the plugins as commands, with the plugin command, which
+// lists them. Plugins are executables named <app>-<name> in the plugin
+// directory (~/.<app>/plugins) or PATH; see Plugin.
+func AddPlugins(exec *CommandExecutor) func(cmd *cobra.Command) {
+	return func(rootCmd *cobra.Command) {
+		exec.plugins.Store(true) // RootCmd adds them after the other commands
+
+		pluginCmd := &cobra.Command{
+			Use:   "plugin",
+			Short: "Manage plugins: executables registered as commands",
+			Long: fmt.Sprintf(`Plugins are executables named %s-<name> in %s
+or a directory of PATH, registered as the command <name>. The first one of a
+name in the search path is used, and commands of that name take precedence.
+
+A plugin gets its arguments as typed, the output of the previous pipeline stage
+as its standard input, and these environment variables:
+  %-20s the application name
+  %-20s the command name of the plugin
+  %-20s the user of the session, if any
+  %-20s the variables, as a JSON object of "@name": "value"
+  %-20s the value of @name, for each variable
+Its exit status is the status of the command.`, strings.ToLower(exec.AppName), exec.PluginDir(),
+				PluginEnvApp, PluginEnvCommand, PluginEnvUser, PluginEnvVars, PluginEnvVar+"name"),
+			Args: cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return cmd.Help()
+			},
+		}
+
+		pluginListCmd := &cobra.Command{
+			Use:     "list",
+			Aliases: []string{"ls"},
+			Short:   "List the plugins",
+			Args:    cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				plugins := exec.Plugins()
+				if len(plugins) == 0 {
+					cmd.Printf("No plugins (executables named %s-<name> in %s or PATH)\n", strings.ToLower(exec.AppName), exec.PluginDir())
+					return
+				}
+				for _, p := range plugins {
+					note := ""
+					if status := pluginStatus(rootCmd, p); status != "" {
+						note = " (" + status + ")"
+					}
+					cmd.Printf("%-20s %s%s\n", p.Name, p.Path, note)
+				}
+			},
+		}
+
+		pluginInfoCmd := &cobra.Command{
+			Use:   "info [name]",
+			Short: "Show the executable of a plugin, and those it hides",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				for _, p := range exec.Plugins() {
+					if p.Name != args[0] {
+						continue
+					}
+					cmd.Printf("Name:       %s\n", p.Name)
+					cmd.Printf("Executable: %s\n", p.Path)
+					cmd.Printf("Permission: plugin.%s\n", p.Name)
+					if status := pluginStatus(rootCmd, p); status != "" {
+						cmd.Printf("Status:     %s\n", status)
+					} else {
+						cmd.Printf("Status:     registered\n")
+					}
+					for i, path := range p.Shadowed {
+						label := ""
+						if i == 0 {
+							label = "Hides:"
+						}
+						cmd.Printf("%-11s %s\n", label, path)
+					}
+					return nil
+				}
+				return fmt.Errorf("plugin `%s` not found", args[0])
+			},
+		}
+
+		pluginReloadCmd := &cobra.Command{
+			Use:   "reload",
+			Short: "Register the plugins again after they changed",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				cmd.Printf("Found %d plugins\n", len(exec.reloadPlugins()))
+			},
+		}
+
+		pluginCmd.AddCommand(pluginListCmd, pluginInfoCmd, pluginReloadCmd)
+		rootCmd.AddCommand(pluginCmd)
+	}
+}
+
+// pluginStatus returns why the plugin is not a command of the tree, or "" if
+// it is.
+func pluginStatus(rootCmd *cobra.Command, p *Plugin) string {
+	c := subcommand(rootCmd, p.Name)
+	switch {
+	case c == nil:
+		return "new, run plugin reload to register it"
+	case c.Annotations[pluginAnnotation] == p.Path:
+		return ""
+	case c.Annotations[scriptAnnotation] != "":
+		return "hidden by the script " + c.Annotations[scriptAnnotation]
+	}
+	return "hidden by a command of that name"
+}
@@ -0,0 +1,252 @@
+package consolekit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Plugin is an external executable registered as a command, git-style: an
+// executable named <app>-<name> in the plugin directory (~/.<app>/plugins) or a
+// directory of PATH is the command <name>.
+type Plugin struct {
+	Name     string   // Command name
+	Path     string   // Path of the executable
+	Shadowed []string // Executables of the same name later in the search path
+}
+
+// pluginAnnotation holds the path of a plugin command's executable.
+const pluginAnnotation = "plugin"
+
+// Environment variables a plugin runs with, along with the process environment.
+const (
+	PluginEnvApp     = "CONSOLEKIT_APP"     // The application name
+	PluginEnvCommand = "CONSOLEKIT_COMMAND" // The command name of the plugin
+	PluginEnvUser    = "CONSOLEKIT_USER"    // The user of the session, if any
+	PluginEnvVars    = "CONSOLEKIT_VARS"    // The variables, as a JSON object of "@name": "value"
+	PluginEnvVar     = "CONSOLEKIT_VAR_"    // Prefix of a variable: CONSOLEKIT_VAR_name for @name
+)
+
+// PluginDir returns the plugin directory of the app, ~/.<app>/plugins.
+func (e *CommandExecutor) PluginDir() string {
+	home, err := homeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, "."+strings.ToLower(e.AppName), "plugins")
+}
+
+// pluginDirs returns the directories searched for plugins: the plugin
+// directory, then those of PATH.
+func (e *CommandExecutor) pluginDirs() []string {
+	var dirs []string
+	if dir := e.PluginDir(); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Plugins returns the plugins found in the plugin directory and PATH, sorted
+// by name. An executable hides later ones of the same name.
+func (e *CommandExecutor) Plugins() []*Plugin {
+	prefix := strings.ToLower(e.AppName) + "-"
+	found := make(map[string]*Plugin)
+	seen := make(map[string]bool) // Directories listed twice in PATH
+	for _, dir := range e.pluginDirs() {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue // Missing directories are skipped, like in PATH
+		}
+		for _, entry := range entries {
+			name, ok := pluginName(entry.Name(), prefix)
+			if !ok {
+				continue
+			}
+			p := filepath.Join(dir, entry.Name())
+			if !isExecutable(p) {
+				continue
+			}
+			if plugin := found[name]; plugin != nil {
+				plugin.Shadowed = append(plugin.Shadowed, p)
+			} else {
+				found[name] = &Plugin{Name: name, Path: p}
+			}
+		}
+	}
+
+	plugins := make([]*Plugin, 0, len(found))
+	for _, p := range found {
+		plugins = append(plugins, p)
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins
+}
+
+// pluginName returns the command name of the executable file: its name after
+// prefix, without an executable extension on Windows.
+func pluginName(file, prefix string) (string, bool) {
+	if !strings.HasPrefix(strings.ToLower(file), prefix) {
+		return "", false
+	}
+	name := file[len(prefix):]
+	if runtime.GOOS == "windows" {
+		exts := os.Getenv("PATHEXT")
+		if exts == "" {
+			exts = ".com;.exe;.bat;.cmd"
+		}
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext == "" || !slices.Contains(strings.Split(strings.ToLower(exts), ";"), ext) {
+			return "", false
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name, validFunctionName(name)
+}
+
+// isExecutable reports whether the file at path is a regular file the user may
+// run. On Windows, pluginName checked its extension.
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return runtime.GOOS == "windows" || info.Mode().Perm()&0111 != 0
+}
+
+// command returns the cobra command that runs the plugin. Its arguments are
+// passed as typed, so the plugin handles its own flags, --help included.
+func (p *Plugin) command(exec *CommandExecutor) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   p.Name + " [args...]",
+		Short: fmt.Sprintf("Run the %s plugin", filepath.Base(p.Path)),
+		Long: fmt.Sprintf(`Run the plugin %s.
+
+The arguments are passed to it, and the output of the previous pipeline stage
+is its standard input. The variables are in its environment, as %sname
+for @name and all together as a JSON object in %s.
+Run "%s --help" for the plugin's own help.`, p.Path, PluginEnvVar, PluginEnvVars, p.Name),
+		DisableFlagParsing: true,
+		SilenceUsage:       true,
+		Annotations:        map[string]string{pluginAnnotation: p.Path},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exec.runPlugin(cmd, p, args)
+		},
+	}
+	return RequirePermission(cmd, "plugin."+p.Name)
+}
+
+// runPlugin runs the executable of the plugin with args, streaming its output
+// into the pipeline. A non-zero exit status is returned as an ExitError.
+func (e *CommandExecutor) runPlugin(cmd *cobra.Command, p *Plugin, args []string) error {
+	ctx := cmd.Context()
+	osCmd := osexec.CommandContext(ctx, p.Path, args...)
+	osCmd.Env = append(os.Environ(), e.pluginEnv(ctx, p)...)
+	osCmd.Stdout = cmd.OutOrStdout()
+	osCmd.Stderr = cmd.ErrOrStderr()
+	if in := cmd.InOrStdin(); in != os.Stdin {
+		osCmd.Stdin = in
+	}
+	if session := remoteSession(ctx); session != nil {
+		osCmd.Dir = session.Dir()
+	}
+
+	if err := osCmd.Run(); err != nil {
+		var exitErr *osexec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return NewExitError(exitErr.ExitCode())
+		}
+		return fmt.Errorf("plugin %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+// pluginEnv returns the environment variables describing the context of a
+// plugin run: the app, the command, the user and the variables visible in
+// ctx, including those of the running script.
+func (e *CommandExecutor) pluginEnv(ctx context.Context, p *Plugin) []string {
+	vars := e.VisibleVariables(ctx)
+	if f := frameFrom(ctx); f != nil && f.scope != nil {
+		f.scope.ForEach(func(k, v string) bool {
+			vars[k] = v
+			return false
+		})
+	}
+
+	env := []string{PluginEnvApp + "=" + e.AppName, PluginEnvCommand + "=" + p.Name}
+	if s := SessionFromContext(ctx); s != nil && s.User != "" {
+		env = append(env, PluginEnvUser+"="+s.User)
+	}
+	data, _ := json.Marshal(vars)
+	env = append(env, PluginEnvVars+"="+string(data))
+	for _, name := range sortedKeys(vars) {
+		env = append(env, PluginEnvVar+envName(strings.TrimPrefix(name, "@"))+"="+vars[name])
+	}
+	return env
+}
+
+// envName replaces the characters of name that can't be in an environment
+// variable name with "_".
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// registeredPlugins returns the plugins registered as commands. They are
+// searched for once, when the first tree is built, so building trees doesn't
+// scan the plugin directory and PATH; reloadPlugins searches again.
+func (e *CommandExecutor) registeredPlugins() []*Plugin {
+	e.pluginMu.Lock()
+	defer e.pluginMu.Unlock()
+	if e.pluginList == nil {
+		e.pluginList = e.Plugins()
+	}
+	return e.pluginList
+}
+
+// reloadPlugins searches for plugins again and rebuilds the command trees with
+// them. It returns the plugins found.
+func (e *CommandExecutor) reloadPlugins() []*Plugin {
+	plugins := e.Plugins()
+	e.pluginMu.Lock()
+	e.pluginList = plugins
+	e.pluginMu.Unlock()
+	e.treeCache.invalidate()
+	return plugins
+}
+
+// addPluginCommands adds the commands of the plugins to rootCmd, when
+// AddPlugins registered them. Plugins named like a command already in the
+// tree are left out.
+func (e *CommandExecutor) addPluginCommands(rootCmd *cobra.Command) {
+	if !e.plugins.Load() {
+		return
+	}
+	for _, p := range e.registeredPlugins() {
+		if subcommand(rootCmd, p.Name) == nil {
+			rootCmd.AddCommand(p.command(e))
+		}
+	}
+}
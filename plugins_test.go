@@ -0,0 +1,127 @@
+package consolekit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const helloPlugin = `#!/bin/sh
+if [ "$1" = fail ]; then
+  echo "failing" >&2
+  exit 3
+fi
+echo "hello $* from $CONSOLEKIT_COMMAND, x=$CONSOLEKIT_VAR_x"
+echo "$CONSOLEKIT_VARS"
+if [ "$1" = stdin ]; then
+  cat
+fi
+`
+
+func TestPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are shell scripts")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	pathDir := t.TempDir()
+	t.Setenv("PATH", pathDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	pluginDir := filepath.Join(home, ".test-app", "plugins")
+	files := map[string]string{
+		filepath.Join(pluginDir, "test-app-hello"): helloPlugin,
+		filepath.Join(pathDir, "test-app-hello"):   "#!/bin/sh\necho hidden\n",
+		filepath.Join(pathDir, "test-app-print"):   "#!/bin/sh\necho hidden\n",
+		filepath.Join(pathDir, "test-app-other"):   "#!/bin/sh\necho other\n",
+	}
+	for path, text := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not executable
+	if err := os.WriteFile(filepath.Join(pathDir, "test-app-data"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	executor.Variables.Set("@x", "5")
+
+	hello := filepath.Join(pluginDir, "test-app-hello")
+	tests := []struct {
+		line   string
+		want   string
+		status int
+	}{
+		{line: "hello bob --flag", want: "hello bob --flag from hello, x=5\n{\"@x\":\"5\"}\n"},
+		{line: "print piped | hello stdin", want: "hello stdin from hello, x=5\n{\"@?\":\"0\",\"@x\":\"5\"}\npiped\n"},
+		{line: "hello fail", want: "failing\n", status: 3},
+		{line: "other", want: "other\n"},
+		{line: "print a", want: "a\n"},
+		{line: "data", status: 1},
+		{line: "which hello", want: "hello: plugin " + hello + "\n"},
+	}
+	for _, tt := range tests {
+		out, err := executor.Execute(tt.line, nil)
+		if status := ExitStatus(err); status != tt.status {
+			t.Errorf("Execute(%q) status = %d, want %d (%v)", tt.line, status, tt.status, err)
+		}
+		if tt.want != "" && out != tt.want {
+			t.Errorf("Execute(%q) = %q, want %q", tt.line, out, tt.want)
+		}
+	}
+
+	out, _ := executor.Execute("plugin list", nil)
+	for _, want := range []string{"hello", hello, "other", "print", "(hidden by a command of that name)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plugin list is missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "data") {
+		t.Errorf("plugin list lists a file that isn't executable:\n%s", out)
+	}
+	out, _ = executor.Execute("plugin info hello", nil)
+	for _, want := range []string{"Permission: plugin.hello", "Status:     registered", "Hides:      " + filepath.Join(pathDir, "test-app-hello")} {
+		if !strings.Contains(out, want) {
+			t.Errorf("plugin info is missing %q:\n%s", want, out)
+		}
+	}
+
+	// New plugins are registered by plugin reload
+	if err := os.WriteFile(filepath.Join(pluginDir, "test-app-later"), []byte("#!/bin/sh\necho later\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := executor.Execute("plugin list", nil); !strings.Contains(out, "later") || !strings.Contains(out, "plugin reload") {
+		t.Errorf("plugin list before reload:\n%s", out)
+	}
+	executor.treeCache.invalidate() // Rebuilt trees keep the plugins found before
+	if _, err := executor.Execute("later", nil); err == nil {
+		t.Error("later ran before plugin reload")
+	}
+	executor.Execute("plugin reload", nil)
+	if out, err := executor.Execute("later", nil); out != "later\n" || err != nil {
+		t.Errorf("later = %q, %v", out, err)
+	}
+
+	if out, _ := executor.Execute("help", nil); !strings.Contains(out, "Run the test-app-hello plugin") {
+		t.Errorf("help is missing the hello plugin:\n%s", out)
+	}
+	server := NewMCPServer(executor, "test-app", "1.0")
+	resp := server.Process(nil, &JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "tools/list"})
+	data, _ := json.Marshal(resp.Result)
+	if !strings.Contains(string(data), `"name":"hello"`) {
+		t.Error("tools/list is missing the hello plugin")
+	}
+}
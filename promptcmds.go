@@ -102,13 +102,14 @@ func AddPromptCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 		}
 
 		// Input command - prompt for string input
-		var inputDefault string
 		var inputCmd = &cobra.Command{
 			Use:   "input [message]",
 			Short: "Prompt for text input",
 			Long:  "Ask the user to enter text",
 			Args:  cobra.MinimumNArgs(1),
 			Run: func(cmd *cobra.Command, args []string) {
+				inputDefault, _ := cmd.Flags().GetString("default")
+
 				message := args[0]
 				var result string
 				if inputDefault != "" {
@@ -122,16 +123,17 @@ func AddPromptCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 				ResetAllFlags(cmd)
 			},
 		}
-		inputCmd.Flags().StringVar(&inputDefault, "default", "", "Default value if user enters nothing")
+		inputCmd.Flags().String("default", "", "Default value if user enters nothing")
 
 		// Select command - single selection from list
-		var selectDefault int
 		var selectCmd = &cobra.Command{
 			Use:   "select [message] [option1] [option2] ...",
 			Short: "Prompt for single selection",
 			Long:  "Ask the user to select one option from a list",
 			Args:  cobra.MinimumNArgs(2),
 			Run: func(cmd *cobra.Command, args []string) {
+				selectDefault, _ := cmd.Flags().GetInt("default")
+
 				message := args[0]
 				options := args[1:]
 
@@ -150,7 +152,7 @@ func AddPromptCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 				ResetAllFlags(cmd)
 			},
 		}
-		selectCmd.Flags().IntVar(&selectDefault, "default", -1, "Default option index (0-based)")
+		selectCmd.Flags().Int("default", -1, "Default option index (0-based)")
 
 		// Multi-select command
 		var multiSelectCmd = &cobra.Command{
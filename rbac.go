@@ -0,0 +1,200 @@
+package consolekit
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// PermissionAnnotation is the cobra annotation holding the permission a command
+// requires, e.g. "jobs.manage". Subcommands inherit their parent's permission
+// unless they declare their own; an empty value requires none.
+const PermissionAnnotation = "consolekit.permission"
+
+// RequirePermission sets the permission cmd requires and returns cmd.
+func RequirePermission(cmd *cobra.Command, permission string) *cobra.Command {
+	if cmd.Annotations == nil {
+		cmd.Annotations = make(map[string]string)
+	}
+	cmd.Annotations[PermissionAnnotation] = permission
+	return cmd
+}
+
+// RequiredPermission returns the permission cmd requires: its own annotation or
+// that of its nearest annotated parent. Returns "" if none is required.
+func RequiredPermission(cmd *cobra.Command) string {
+	for c := cmd; c != nil; c = c.Parent() {
+		if perm, ok := c.Annotations[PermissionAnnotation]; ok {
+			return perm
+		}
+	}
+	return ""
+}
+
+// RBAC maps users to roles and roles to permissions. Set CommandExecutor.RBAC
+// to enforce it: remote sessions may then only run commands whose required
+// permission one of their roles grants. Commands without a permission stay
+// open to every session, so annotate the commands of the app that need one.
+type RBAC struct {
+	mu           sync.RWMutex
+	roles        map[string][]string // Role -> permission patterns
+	users        map[string][]string // User -> roles
+	defaultRoles []string            // Roles of users without an assignment
+}
+
+// NewRBAC creates an RBAC with no roles.
+func NewRBAC() *RBAC {
+	return &RBAC{
+		roles: make(map[string][]string),
+		users: make(map[string][]string),
+	}
+}
+
+// DefineRole grants permissions to a role. Permissions may be glob patterns
+// ("jobs.*", "*").
+func (r *RBAC) DefineRole(role string, permissions ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roles[role] = append(r.roles[role], permissions...)
+}
+
+// AssignRoles gives roles to a user (the Session.User set by the transport:
+// the SSH login, the HTTP login or the user of a socket token).
+func (r *RBAC) AssignRoles(user string, roles ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[user] = append(r.users[user], roles...)
+}
+
+// SetDefaultRoles sets the roles of users without an assignment, including
+// anonymous sessions.
+func (r *RBAC) SetDefaultRoles(roles ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultRoles = roles
+}
+
+// RolesFor returns the roles of a user.
+func (r *RBAC) RolesFor(user string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if roles, ok := r.users[user]; ok {
+		return roles
+	}
+	return r.defaultRoles
+}
+
+// HasPermission reports whether any of roles grants permission.
+func (r *RBAC) HasPermission(roles []string, permission string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, role := range roles {
+		for _, pattern := range r.roles[role] {
+			if ok, _ := path.Match(pattern, permission); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sessionRoles returns the session's explicit roles, or those of its user.
+func (r *RBAC) sessionRoles(s *Session) []string {
+	if s.Roles != nil {
+		return s.Roles
+	}
+	return r.RolesFor(s.User)
+}
+
+// authorize checks whether the session in ctx may run cmd, against both its
+// transport policy and RBAC. Calls without a session are trusted, and the local
+// REPL session is exempt from RBAC.
+func (e *CommandExecutor) authorize(ctx context.Context, cmd *cobra.Command) error {
+	s := SessionFromContext(ctx)
+	if s == nil {
+		return nil
+	}
+
+	cmdPath := commandPath(cmd)
+	if s.Policy != nil && !s.Policy.IsCommandAllowed(cmdPath) {
+		return fmt.Errorf("%w: %s", ErrCommandNotAllowed, cmdPath)
+	}
+
+	if e.RBAC == nil || s.IsLocal() {
+		return nil
+	}
+	if perm := RequiredPermission(cmd); perm != "" && !e.RBAC.HasPermission(e.RBAC.sessionRoles(s), perm) {
+		return fmt.Errorf("%w: %s requires permission %q", ErrCommandNotAllowed, cmdPath, perm)
+	}
+	return nil
+}
+
+// authorizeUse checks whether the session in ctx has permission for a use of a
+// command that reaches beyond the session, such as "let --global", when the
+// command itself is open. what names the use in the error.
+func (e *CommandExecutor) authorizeUse(ctx context.Context, what, permission string) error {
+	s := SessionFromContext(ctx)
+	if s == nil || e.RBAC == nil || s.IsLocal() {
+		return nil
+	}
+	if !e.RBAC.HasPermission(e.RBAC.sessionRoles(s), permission) {
+		return fmt.Errorf("%w: %s requires permission %q", ErrCommandNotAllowed, what, permission)
+	}
+	return nil
+}
+
+// restricted reports whether commands may be hidden from or denied to the session in ctx.
+func (e *CommandExecutor) restricted(ctx context.Context) bool {
+	s := SessionFromContext(ctx)
+	return s != nil && (s.Policy != nil || (e.RBAC != nil && !s.IsLocal()))
+}
+
+// hideUnauthorized hides the commands of a tree that the session in ctx may not
+// run, so help, completion and command listings leave them out. The returned
+// function unhides them.
+func (e *CommandExecutor) hideUnauthorized(ctx context.Context, root *cobra.Command) func() {
+	if !e.restricted(ctx) {
+		return func() {}
+	}
+
+	var hidden []*cobra.Command
+	// walk hides the subcommands of cmd that can't be run and reports whether any remain
+	var walk func(*cobra.Command) bool
+	walk = func(cmd *cobra.Command) bool {
+		visible := false
+		for _, sub := range cmd.Commands() {
+			if sub.Hidden {
+				continue
+			}
+			// A parent stays visible while one of its subcommands can run
+			if !walk(sub) && e.authorize(ctx, sub) != nil {
+				sub.Hidden = true
+				hidden = append(hidden, sub)
+				continue
+			}
+			visible = true
+		}
+		return visible
+	}
+	walk(root)
+
+	return func() {
+		for _, cmd := range hidden {
+			cmd.Hidden = false
+		}
+	}
+}
+
+// visibleTree acquires a cached command tree with the commands the session in
+// ctx may not run hidden. Call release when done with it.
+func (e *CommandExecutor) visibleTree(ctx context.Context) (root *cobra.Command, release func()) {
+	root, gen := e.acquireTree()
+	unhide := e.hideUnauthorized(ctx, root)
+	return root, func() {
+		unhide()
+		e.releaseTree(root, gen)
+	}
+}
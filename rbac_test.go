@@ -0,0 +1,248 @@
+package consolekit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestRequiredPermission(t *testing.T) {
+	root := &cobra.Command{Use: "root"}
+	parent := RequirePermission(&cobra.Command{Use: "parent"}, "parent.use")
+	inherits := &cobra.Command{Use: "inherits"}
+	own := RequirePermission(&cobra.Command{Use: "own"}, "own.use")
+	open := RequirePermission(&cobra.Command{Use: "open"}, "")
+	parent.AddCommand(inherits, own, open)
+	root.AddCommand(parent)
+
+	tests := []struct {
+		cmd  *cobra.Command
+		want string
+	}{
+		{root, ""},
+		{parent, "parent.use"},
+		{inherits, "parent.use"},
+		{own, "own.use"},
+		{open, ""},
+	}
+	for _, tt := range tests {
+		if got := RequiredPermission(tt.cmd); got != tt.want {
+			t.Errorf("RequiredPermission(%s) = %q, want %q", tt.cmd.Name(), got, tt.want)
+		}
+	}
+}
+
+func TestRBAC(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	executor.RBAC = NewRBAC()
+	executor.RBAC.DefineRole("operator", "jobs.*")
+	executor.RBAC.DefineRole("admin", "*")
+	executor.RBAC.AssignRoles("bob", "operator")
+	executor.RBAC.AssignRoles("root", "admin")
+
+	alice := WithSession(context.Background(), NewSession("a", "ssh", "alice"))
+	bob := WithSession(context.Background(), NewSession("b", "ssh", "bob"))
+	admin := WithSession(context.Background(), NewSession("r", "http", "root"))
+	local := WithSession(context.Background(), NewLocalSession("repl", "repl", "alice"))
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		line     string
+		denied   bool
+		contains string // Expected in the output
+	}{
+		{name: "open command", ctx: alice, line: "print hi"},
+		{name: "no role", ctx: alice, line: "killall", denied: true},
+		{name: "granted by glob", ctx: bob, line: "killall"},
+		{name: "other permission", ctx: bob, line: "schedule cancel 1", denied: true},
+		{name: "open subcommand", ctx: bob, line: "schedule list"},
+		{name: "nested execution", ctx: alice, line: "time killall", contains: "not allowed"},
+		{name: "admin", ctx: admin, line: "schedule cancel 1"},
+		{name: "local session", ctx: local, line: "killall"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := executor.ExecuteWithContext(tt.ctx, tt.line, nil)
+			if got := errors.Is(err, ErrCommandNotAllowed); got != tt.denied {
+				t.Fatalf("denied = %v (err %v), want %v", got, err, tt.denied)
+			}
+			if !strings.Contains(out, tt.contains) {
+				t.Errorf("output = %q, want it to contain %q", out, tt.contains)
+			}
+		})
+	}
+
+	// Help and command listings leave out what the session can't run
+	help, _ := executor.ExecuteWithContext(alice, "help", nil)
+	if strings.Contains(help, "killall") || !strings.Contains(help, "print") {
+		t.Errorf("alice's help should list print but not killall:\n%s", help)
+	}
+	help, _ = executor.ExecuteWithContext(bob, "help", nil)
+	if !strings.Contains(help, "killall") {
+		t.Errorf("bob's help should list killall:\n%s", help)
+	}
+
+	if cmds := executor.AvailableCommands(alice); slices.Contains(cmds, "killall") || !slices.Contains(cmds, "schedule list") {
+		t.Errorf("AvailableCommands(alice) = %v", cmds)
+	}
+
+	// The hidden marks don't leak into unrestricted executions
+	if cmds := executor.GetAvailableCommands(); !slices.Contains(cmds, "killall") {
+		t.Error("GetAvailableCommands() is missing killall")
+	}
+
+	server := NewMCPServer(executor, "test-app", "1.0")
+	resp := server.Process(alice, &JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "tools/list"})
+	data, _ := json.Marshal(resp.Result)
+	var result ToolsListResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	for _, tool := range result.Tools {
+		if tool.Name == "killall" || tool.Name == "osexec" {
+			t.Errorf("tools/list for alice includes %s", tool.Name)
+		}
+	}
+}
+
+func TestFunctionPermissions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	if _, err := executor.Execute("func hi { print hi }", nil); err != nil {
+		t.Fatalf("func failed: %v", err)
+	}
+
+	executor.RBAC = NewRBAC()
+	executor.RBAC.DefineRole("author", "functions.manage")
+	executor.RBAC.AssignRoles("bob", "author")
+	alice := WithSession(context.Background(), NewSession("a", "ssh", "alice"))
+	bob := WithSession(context.Background(), NewSession("b", "ssh", "bob"))
+
+	tests := []struct {
+		ctx    context.Context
+		line   string
+		denied bool
+	}{
+		{ctx: alice, line: "func evil { print evil }", denied: true},
+		{ctx: alice, line: "func hi { print replaced }", denied: true},
+		{ctx: alice, line: "func delete hi", denied: true},
+		{ctx: alice, line: "func list"},
+		{ctx: alice, line: "func show hi"},
+		{ctx: alice, line: "func path"},
+		{ctx: alice, line: "hi"},
+		{ctx: bob, line: "func bye { print bye }"},
+		{ctx: bob, line: "func delete bye"},
+	}
+	for _, tt := range tests {
+		_, err := executor.ExecuteWithContext(tt.ctx, tt.line, nil)
+		if got := errors.Is(err, ErrCommandNotAllowed); got != tt.denied {
+			t.Errorf("%s: denied = %v (err %v), want %v", tt.line, got, err, tt.denied)
+		}
+	}
+	if out, _ := executor.Execute("hi", nil); out != "hi\n" {
+		t.Errorf("hi = %q, want the original definition", out)
+	}
+	if _, ok := executor.GetFunction("evil"); ok {
+		t.Error("alice defined a function")
+	}
+}
+
+func TestPermissionsOfUses(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(AddRun(exec, nil))
+		exec.AddCommands(func(root *cobra.Command) {
+			root.AddCommand(&cobra.Command{
+				Use: "custom",
+				Run: func(cmd *cobra.Command, args []string) { cmd.Println("custom ran") },
+			})
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	script := filepath.Join(t.TempDir(), "hi.run")
+	if err := os.WriteFile(script, []byte("print hi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	executor.RBAC = NewRBAC()
+	executor.RBAC.DefineRole("editor", "aliases.manage", "variables.manage", "history.manage", "scripts.run")
+	executor.RBAC.AssignRoles("bob", "editor")
+	alice := WithSession(context.Background(), NewSession("a", "ssh", "alice"))
+	bob := WithSession(context.Background(), NewSession("b", "ssh", "bob"))
+
+	tests := []struct {
+		ctx    context.Context
+		line   string
+		denied bool
+	}{
+		// Commands without a permission are open to every session
+		{ctx: alice, line: "custom"},
+		{ctx: alice, line: "let x=1"},
+		{ctx: alice, line: "unset x"},
+		{ctx: alice, line: "alias add ll 'print ll'"},
+		{ctx: alice, line: "history search x"},
+		{ctx: alice, line: "run @"},
+		{ctx: alice, line: "let --global x=1", denied: true},
+		{ctx: alice, line: "unset --global x", denied: true},
+		{ctx: alice, line: "vars --load /vars.json", denied: true},
+		{ctx: alice, line: "alias add --global ll 'print ll'", denied: true},
+		{ctx: alice, line: "alias delete --global ll", denied: true},
+		{ctx: alice, line: "alias save", denied: true},
+		{ctx: alice, line: "history clear", denied: true},
+		{ctx: alice, line: "history trim 1", denied: true},
+		{ctx: alice, line: "history bookmark add b print b", denied: true},
+		{ctx: alice, line: "run " + script, denied: true},
+		{ctx: alice, line: ". " + script, denied: true},
+		{ctx: alice, line: "test-scripts " + script, denied: true},
+		{ctx: bob, line: "let --global y=1"},
+		{ctx: bob, line: "alias add --global ll 'print ll'"},
+		{ctx: bob, line: "history clear"},
+		{ctx: bob, line: "run " + script},
+		{ctx: bob, line: "test-scripts " + script},
+		{ctx: bob, line: "test-scripts --junit - " + script},
+		{ctx: bob, line: "test-scripts --update " + script, denied: true},
+		{ctx: bob, line: "test-scripts --junit report.xml " + script, denied: true},
+	}
+	for _, tt := range tests {
+		out, err := executor.ExecuteWithContext(tt.ctx, tt.line, nil)
+		if got := errors.Is(err, ErrCommandNotAllowed); got != tt.denied {
+			t.Errorf("%s: denied = %v (err %v), want %v", tt.line, got, err, tt.denied)
+		}
+		if tt.line == "custom" && out != "custom ran\n" {
+			t.Errorf("custom = %q", out)
+		}
+	}
+
+	if _, ok := executor.Variables.Get("@x"); ok {
+		t.Error("alice set a global variable")
+	}
+	if _, ok := executor.Variables.Get("@y"); !ok {
+		t.Error("bob didn't set a global variable")
+	}
+}
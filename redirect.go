@@ -0,0 +1,133 @@
+package consolekit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"github.com/alexj212/consolekit/parser"
+)
+
+// FileAppender is implemented by a FileHandler that can append to a file.
+// Without it, ">>" reads the file and writes it back with the output appended.
+type FileAppender interface {
+	AppendFile(path string, content string) error
+}
+
+// stageIO holds the streams of a pipeline stage while its redirections are applied.
+type stageIO struct {
+	in       io.Reader
+	out, err io.Writer
+	files    []*redirectFile
+}
+
+// redirectFile collects output redirected to a file. The file is written
+// through the FileHandler once the stage is done.
+type redirectFile struct {
+	name   string // As given on the command line
+	path   string // Resolved against the session's working directory
+	append bool
+	echo   io.Writer // Also receives the output (standard output only)
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (f *redirectFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.echo != nil {
+		_, _ = f.echo.Write(p)
+	}
+	return f.buf.Write(p)
+}
+
+// redirect applies redirections to sio in order, so "> f 2>&1" sends both
+// streams to f while "2>&1 > f" sends only standard output there. Standard
+// output redirected to a file is still shown on display.
+func (e *CommandExecutor) redirect(ctx context.Context, redirects []parser.Redirect, sio *stageIO, display io.Writer) error {
+	for _, r := range redirects {
+		switch {
+		case r.Fd == 0 && r.File == "":
+			// Here-string or heredoc
+			sio.in = strings.NewReader(r.Text + "\n")
+
+		case r.Fd == 0:
+			content, err := e.FileHandler.ReadFile(e.ResolvePath(ctx, r.File))
+			if err != nil {
+				return fmt.Errorf("failed to read from file %s: %w", r.File, err)
+			}
+			sio.in = strings.NewReader(content)
+
+		case r.File == "":
+			sio.setWriter(r.Fd, sio.writer(r.Dup))
+
+		default:
+			f := &redirectFile{name: r.File, path: e.ResolvePath(ctx, r.File), append: r.Append}
+			if r.Fd == 1 {
+				f.echo = display
+			}
+			sio.files = append(sio.files, f)
+			sio.setWriter(r.Fd, f)
+		}
+	}
+	return nil
+}
+
+func (sio *stageIO) writer(fd int) io.Writer {
+	if fd == 2 {
+		return sio.err
+	}
+	return sio.out
+}
+
+func (sio *stageIO) setWriter(fd int, w io.Writer) {
+	if fd == 2 {
+		sio.err = w
+	} else {
+		sio.out = w
+	}
+}
+
+// writeFiles writes the redirected output of a stage to its files.
+func (e *CommandExecutor) writeFiles(files []*redirectFile) error {
+	for _, f := range files {
+		if err := e.writeFile(f); err != nil {
+			return fmt.Errorf("failed to write to file %s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+func (e *CommandExecutor) writeFile(f *redirectFile) error {
+	content := f.buf.String()
+	if !f.append {
+		return e.FileHandler.WriteFile(f.path, content)
+	}
+	if appender, ok := e.FileHandler.(FileAppender); ok {
+		return appender.AppendFile(f.path, content)
+	}
+
+	existing, err := e.FileHandler.ReadFile(f.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return e.FileHandler.WriteFile(f.path, existing+content)
+}
+
+// syncWriter serializes writes from concurrent pipeline stages.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
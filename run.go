@@ -2,14 +2,19 @@ package consolekit
 
 import (
 	"bufio"
+	"context"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/alexj212/consolekit/parser"
 	"github.com/alexj212/consolekit/safemap"
 	"github.com/kballard/go-shellquote"
 	"github.com/spf13/cobra"
@@ -242,7 +247,7 @@ Full paths are displayed for all entries.`,
 			Run: viewScriptCmdFunc,
 		}
 
-		var runScriptCmdFunc = func(cmd *cobra.Command, args []string) {
+		var runScriptCmdFunc = func(cmd *cobra.Command, args []string) error {
 			// Create scoped defaults for script arguments to avoid leakage
 			scriptDefs := safemap.New[string, string]()
 			for i, arg := range args[1:] {
@@ -254,13 +259,13 @@ Full paths are displayed for all entries.`,
 				if scripts == nil {
 					cmd.Printf("Error: No embedded scripts available (scripts parameter was nil)\n")
 					cmd.Printf("Use filesystem paths instead of @ prefix\n")
-					return
+					return nil
 				}
 
 				f, err := scripts.ReadDir(".")
 				if err != nil {
 					cmd.Printf("Error reading scripts: %v\n", err)
-					return
+					return nil
 				}
 
 				cmd.Printf("Scripts Available:\n")
@@ -270,117 +275,175 @@ Full paths are displayed for all entries.`,
 					}
 					cmd.Printf("@%s\n", script.Name())
 				}
-				return
+				return nil
 			}
 
-			cmds, err := LoadScript(scripts, cmd, args[0])
+			// Embedded scripts are part of the app; files are whatever is on the server
+			if !strings.HasPrefix(args[0], "@") {
+				if err := exec.authorizeUse(cmd.Context(), "run of a script file", "scripts.run"); err != nil {
+					return err
+				}
+			}
+			lines, err := loadScript(scripts, cmd, args[0])
 			if err != nil {
 				cmd.Print(fmt.Sprintf("error loading file %s, %s\n", args[0], err))
-				return
+				return nil
+			}
+
+			if check, _ := cmd.Flags().GetBool("check"); check {
+				asJSON, _ := cmd.Flags().GetBool("json")
+				return checkScript(cmd, exec, args[0], lines, args[1:], asJSON)
 			}
 
 			spawn, err := cmd.Flags().GetBool("spawn")
 			if err != nil {
 				cmd.Print(fmt.Sprintf("unable to get flag spawn, %v\n", err))
-				return
+				return nil
 			}
 
 			quiet, err := cmd.Flags().GetBool("quiet")
 			if err != nil {
 				cmd.Print(fmt.Sprintf("unable to get flag quiet, %v\n", err))
-				return
+				return nil
+			}
+
+			trace, _ := cmd.Flags().GetBool("trace")
+			debug, _ := cmd.Flags().GetBool("debug")
+			breaks, _ := cmd.Flags().GetStringSlice("break")
+			var term Terminal
+			if debug || len(breaks) > 0 {
+				if spawn {
+					return errors.New("--debug can't be used with --spawn")
+				}
+				if term = exec.terminal(cmd.Context()); term == nil {
+					return errors.New("--debug needs a terminal (the REPL or an SSH shell)")
+				}
 			}
 
-			doExec := func() {
+			doExec := func(cmd *cobra.Command) error {
 				startTime := time.Now()
 				if !quiet {
-					cmd.Printf("%s\n", fmt.Sprintf("▶ Executing file: %s - %d commands", args[0], len(cmds)))
+					cmd.Printf("%s\n", fmt.Sprintf("▶ Executing file: %s - %d commands", args[0], len(lines)))
 				}
 
+				f := exec.newFrame(cmd.Context(), args[0], frameScript)
+				f.lines = lines
+				if trace {
+					f.state.setOption(optXtrace, true)
+				}
+				if term != nil {
+					if _, err := exec.newDebugger(f, term, breaks); err != nil {
+						f.cancel()
+						return err
+					}
+				}
 				execCount := 0
-				for _, cmdLine := range cmds {
-					if cmdLine == "" {
+				for _, line := range lines {
+					if strings.TrimSpace(line.Text) == "" {
 						continue
 					}
 
 					// Show the command being executed with arrow prefix (unless quiet)
 					if !quiet {
-						cmd.Printf("%s\n", fmt.Sprintf("  → %s", strings.TrimSpace(cmdLine)))
+						cmd.Printf("%s\n", fmt.Sprintf("  → %s", strings.TrimSpace(line.Text)))
 					}
 
-					res, err := exec.Execute(cmdLine, scriptDefs)
-					if res != "" {
-						cmd.Printf("%s\n", res)
-					}
-					if err != nil {
-						if !quiet {
-							cmd.Printf("%s\n", fmt.Sprintf("  ✗ Error executing command: %s", err))
-						}
+					execCount++
+					done, err := f.runLine(line, scriptDefs, cmd.OutOrStdout())
+					if done {
 						break
 					}
-					execCount++
+					if reportable(err) {
+						cmd.Printf("%s\n", fmt.Sprintf("  ✗ %s", err))
+					}
 				}
+				err := f.finish(cmd.OutOrStdout())
 
 				if !quiet {
 					elapsed := time.Since(startTime)
 					timeSince := HumanizeDuration(elapsed, false)
-					if execCount == len(cmds) {
+					if err == nil {
 						cmd.Printf("%s\n", fmt.Sprintf("✓ Script '%s' completed successfully - %d commands in %s", args[0], execCount, timeSince))
 					} else {
-						cmd.Printf("%s\n", fmt.Sprintf("⚠ Script '%s' partially completed - %d/%d commands in %s", args[0], execCount, len(cmds), timeSince))
+						cmd.Printf("%s\n", fmt.Sprintf("⚠ Script '%s' failed with status %d - %d/%d commands in %s", args[0], ExitStatus(err), execCount, len(lines), timeSince))
 					}
 				}
+				return err
 			}
 
 			if spawn {
-				go doExec()
-				return
+				// Tracked as a job: its output is kept for "job <id> logs"
+				id := exec.JobManager.AddFunc(cmdContext(cmd), "run "+strings.Join(args, " "), func(ctx context.Context, out io.Writer) error {
+					return doExec(jobCommand(ctx, out))
+				})
+				exec.SetVariable(cmd.Context(), lastJobVar, strconv.Itoa(id))
+				cmd.Printf("Started in background (Job ID: %d)\n", id)
+				return nil
 			}
-			doExec()
+			return doExec(cmd)
 		}
 
 		var runScriptCmd = &cobra.Command{
-			Use:   "run [--spawn] [--quiet] {file | @file | @ } [args]...",
+			Use:   "run [--spawn] [--quiet] [--trace] [--debug] [--break [script:]line]... [--check [--json]] {file | @file | @ } [args]...",
 			Short: "exec script file, use `@name` files for internal scripts. pass args that can be referenced in script as @arg0, @arg1, ...",
 			Long: `Execute a script file with optional flags.
 
 Flags:
-  --spawn    Run the script in the background
+  --spawn    Run the script in the background, as a job (see "jobs")
   --quiet    Suppress execution headers and command echoing, only show command output
-
-Arguments can be passed after the filename and referenced in the script as @arg0, @arg1, etc.`,
-			Args: cobra.MinimumNArgs(1),
+  --trace    Echo each command after alias and variable expansion, like "set -x"
+  --debug    Debug the script at the terminal, pausing at its first line
+  --break    Debug the script, pausing at [script:]line (repeatable)
+  --check    Check the script without running it (see below)
+  --json     With --check, print the diagnostics as JSON
+
+Arguments can be passed after the filename and referenced in the script as @arg0, @arg1, etc.
+
+A line that fails is reported with its location (script.run:12: ...) and the
+script goes on; its status is that of its last line. In the script:
+  set -e             stop at the first line that fails
+  set -u             fail lines that reference undefined variables
+  setopt pipefail    fail pipelines if any stage fails
+  trap 'cmd' ERR     run cmd after a line fails
+  trap 'cmd' EXIT    run cmd when the script finishes
+  return [status]    stop the script (or function) with status
+  exit [status]      stop the script with status
+
+--check parses and expands every line, and resolves its commands, subcommands,
+flags and arguments against the command tree. It reports errors (unknown
+commands or flags, bad arguments, unbalanced quotes, syntax errors) and
+warnings (variables used before they are defined) as file:line diagnostics,
+and fails if there are errors. Pass the script's arguments to define @arg0...
+
+While debugging, the script also pauses at "breakpoint" commands. At the
+(debug) prompt: step, next, continue, list, bt, break, delete and quit; other
+input runs as a command in the script's context, e.g. "print @x" or "let x=2".`,
+			Args:         cobra.MinimumNArgs(1),
+			SilenceUsage: true,
 			PostRun: func(cmd *cobra.Command, args []string) {
 				ResetHelpFlagRecursively(cmd)
 				ResetAllFlags(cmd)
 			},
 
-			Run: runScriptCmdFunc,
+			RunE: runScriptCmdFunc,
 		}
 
 		var spawnScriptCmdFunc = func(cmd *cobra.Command, args []string) {
-
-			go func() {
-				cmdLine := strings.Join(args, " ")
-				cmdLineArgs, err := shellquote.Split(cmdLine)
-				if err != nil {
-					cmd.Print(fmt.Sprintf("error splitting cmd `%s`, %s\n", cmdLine, err))
-					return
-				}
-				cmd.Printf("spawn cmd: %s | %s\n", rootCmd.Use, cmdLine)
-				rootCmd.SetArgs(cmdLineArgs)
-				if err := rootCmd.Execute(); err != nil {
-					cmd.Print(fmt.Sprintf("error %s executing command: %s, %s\n", rootCmd.Name(), cmdLine, err))
-					return
-				}
-			}()
-
+			cmdLine := strings.Join(args, " ")
+			id := exec.JobManager.AddFunc(cmdContext(cmd), cmdLine, func(ctx context.Context, out io.Writer) error {
+				return exec.ExecuteStream(ctx, cmdLine, nil, out)
+			})
+			exec.SetVariable(cmd.Context(), lastJobVar, strconv.Itoa(id))
+			cmd.Printf("Started in background (Job ID: %d)\n", id)
 		}
 
 		var spawnScriptCmd = &cobra.Command{
 			Use:   "spawn {cmd}",
-			Short: "exec command",
-			Args:  cobra.ExactArgs(1),
+			Short: "exec command in the background, as a job",
+			Long: `Run a command line in the background, as an in-process job. Its output
+is kept for "job <id> logs", and "job <id> kill" stops it. @! is set to the
+job ID.`,
+			Args: cobra.ExactArgs(1),
 
 			Run: spawnScriptCmdFunc,
 		}
@@ -391,6 +454,11 @@ Arguments can be passed after the filename and referenced in the script as @arg0
 
 		runScriptCmd.Flags().Bool("spawn", false, "Run script in background")
 		runScriptCmd.Flags().BoolP("quiet", "q", false, "Suppress execution headers and command echoing")
+		runScriptCmd.Flags().Bool("trace", false, "Echo each command after expansion")
+		runScriptCmd.Flags().Bool("debug", false, "Debug the script at the terminal")
+		runScriptCmd.Flags().StringSlice("break", nil, "Pause at [script:]line when debugging (implies --debug)")
+		runScriptCmd.Flags().Bool("check", false, "Check the script without running it")
+		runScriptCmd.Flags().Bool("json", false, "With --check, print the diagnostics as JSON")
 	}
 }
 
@@ -417,27 +485,98 @@ func HumanizeDuration(duration time.Duration, showMs bool) string {
 	return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
 }
 
+// ScriptLine is a command of a script.
+type ScriptLine struct {
+	Text string // The command, with its continuation lines
+	Line int    // Line of the script it starts on, from 1
+}
+
+// ReadLines splits a script into commands. A command continues on the next
+// line after a trailing backslash, and until its heredocs, "{ }" blocks and
+// quoted strings are closed (see parser.JoinLines).
 func ReadLines(rdr io.Reader) ([]string, error) {
+	lines, err := ReadScript(rdr)
+	results := make([]string, 0, len(lines))
+	for _, l := range lines {
+		results = append(results, l.Text)
+	}
+	return results, err
+}
+
+// checkScript prints the diagnostics of "run --check" for the script lines
+// loaded from file. It fails if the script has errors.
+func checkScript(cmd *cobra.Command, exec *CommandExecutor, file string, lines []ScriptLine, args []string, asJSON bool) error {
+	diags := exec.CheckScript(cmd.Context(), file, lines, args)
+	errs := 0
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			errs++
+		}
+	}
+
+	if asJSON {
+		if diags == nil {
+			diags = []ScriptDiagnostic{}
+		}
+		data, err := json.MarshalIndent(diags, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(data))
+	} else {
+		for _, d := range diags {
+			cmd.Println(d)
+		}
+		if len(diags) == 0 {
+			cmd.Printf("✓ %s: no problems found\n", file)
+		} else {
+			cmd.Printf("%s: %d error(s), %d warning(s)\n", file, errs, len(diags)-errs)
+		}
+	}
+
+	if errs > 0 {
+		return &ExitError{Status: 1}
+	}
+	return nil
+}
+
+// ReadScript splits a script into commands like ReadLines, along with the
+// lines they start on.
+func ReadScript(rdr io.Reader) ([]ScriptLine, error) {
 
 	// Prepare to read lines and accumulate multi-line commands
 	scanner := bufio.NewScanner(rdr)
 	var commandBuilder strings.Builder
-	results := make([]string, 0)
+	results := make([]ScriptLine, 0)
+	lineNum, start := 0, 0
 
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNum++
+		if commandBuilder.Len() == 0 {
+			start = lineNum
+		}
 
 		// Check if the line ends with a backslash, indicating a multi-line command
 		if strings.HasSuffix(line, "\\") {
 			// Replace the trailing backslash with a newline character
 			commandBuilder.WriteString(strings.TrimSuffix(line, "\\"))
-		} else {
-			commandBuilder.WriteString(line + "\n")
-			command := commandBuilder.String()
-			commandBuilder.Reset() // Clear the builder for the next command
-			// Execute the command
-			results = append(results, command)
+			continue
+		}
+
+		commandBuilder.WriteString(line + "\n")
+		if _, err := parser.JoinLines(commandBuilder.String()); errors.Is(err, parser.ErrIncomplete) {
+			continue
 		}
+		command := commandBuilder.String()
+		commandBuilder.Reset() // Clear the builder for the next command
+		// Execute the command
+		results = append(results, ScriptLine{Text: command, Line: start})
+	}
+
+	// An unterminated command is reported when it runs
+	if commandBuilder.Len() > 0 {
+		results = append(results, ScriptLine{Text: commandBuilder.String(), Line: start})
 	}
 	return results, scanner.Err()
 }
@@ -445,6 +584,20 @@ func ReadLines(rdr io.Reader) ([]string, error) {
 // LoadScript loads a script from embedded files (@prefix) or filesystem.
 // Pass nil for scripts if you only need external filesystem script support.
 func LoadScript(scripts *embed.FS, cmd *cobra.Command, filename string) ([]string, error) {
+	lines, err := loadScript(scripts, cmd, filename)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, len(lines))
+	for _, l := range lines {
+		results = append(results, l.Text)
+	}
+	return results, nil
+}
+
+// loadScript loads a script like LoadScript, along with the lines its
+// commands start on.
+func loadScript(scripts *embed.FS, cmd *cobra.Command, filename string) ([]ScriptLine, error) {
 
 	if len(filename) == 0 {
 		return nil, fmt.Errorf("no filename provided")
@@ -467,13 +620,13 @@ func LoadScript(scripts *embed.FS, cmd *cobra.Command, filename string) ([]strin
 		text := string(content)
 
 		// Split the text into lines
-		return ReadLines(strings.NewReader(text))
+		return ReadScript(strings.NewReader(text))
 	}
 
-	// Read the entire file content
-	content, err := os.ReadFile(filename)
+	// Read the entire file content (relative to the session's working directory)
+	content, err := os.ReadFile(resolvePath(cmdContext(cmd), filename))
 	if err != nil {
 		return nil, fmt.Errorf("LoadScript failed to read file: %w", err)
 	}
-	return ReadLines(strings.NewReader(string(content)))
+	return ReadScript(strings.NewReader(string(content)))
 }
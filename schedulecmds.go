@@ -1,7 +1,9 @@
 package consolekit
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,19 +20,47 @@ type ScheduledTask struct {
 	Interval time.Duration
 	Repeat   bool
 	Enabled  bool
+	JobID    int // The job of the last run, 0 before the task first runs
 	timer    *time.Timer
 	ticker   *time.Ticker
 	done     chan bool
 	mu       sync.RWMutex
 }
 
+// run starts the command of the task as an in-process job, so its output is
+// kept for "job <id> logs" and "job <id> kill" stops it. A paused task, or one
+// whose last run is still going, is skipped, as is one cancelled meanwhile.
+func (t *ScheduledTask) run(exec *CommandExecutor, ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.Enabled {
+		return
+	}
+	select {
+	case <-t.done:
+		return // Cancelled after the tick
+	default:
+	}
+	if job, ok := exec.JobManager.Get(t.JobID); ok && job.running() {
+		return
+	}
+	command := t.Command
+	t.JobID = exec.JobManager.AddFunc(ctx, command, func(ctx context.Context, out io.Writer) error {
+		return exec.ExecuteStream(ctx, command, nil, out)
+	})
+}
+
 // AddScheduleCommands adds command scheduling commands
 func AddScheduleCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return func(rootCmd *cobra.Command) {
 		var scheduleCmd = &cobra.Command{
 			Use:   "schedule",
 			Short: "Schedule commands to run at specific times",
-			Long:  "Schedule commands to run once, after a delay, or at regular intervals",
+			Long: `Schedule commands to run once, after a delay, or at regular intervals.
+
+Each run is a background job: "jobs" lists it, "job <id> logs" shows its
+output and "job <id> kill" stops it. "schedule list" shows the last job of
+each task, and "schedule cancel" also stops its run in progress.`,
 		}
 
 		// schedule at - run command at specific time
@@ -89,6 +119,9 @@ Examples:
 
 				duration := targetTime.Sub(now)
 
+				// Run later in the scheduling session, not the request that created it
+				taskCtx := WithSession(context.Background(), SessionFromContext(cmd.Context()))
+
 				task := &ScheduledTask{
 					ID:      exec.JobManager.getNextID(),
 					Command: command,
@@ -100,17 +133,8 @@ Examples:
 
 				// Start timer
 				task.timer = time.AfterFunc(duration, func() {
-					output, err := exec.Execute(task.Command, nil)
-					if output != "" {
-						fmt.Print(output)
-						if !strings.HasSuffix(output, "\n") {
-							fmt.Println()
-						}
-					}
-					if err != nil {
-						fmt.Printf("Scheduled command error: %v\n", err)
-					}
-					// Remove from schedule list after execution
+					task.run(exec, taskCtx)
+					// Remove from schedule list once started; the job tracks the run
 					exec.JobManager.removeScheduledTask(task.ID)
 				})
 
@@ -144,6 +168,9 @@ Examples:
 
 				targetTime := time.Now().Add(duration)
 
+				// Run later in the scheduling session, not the request that created it
+				taskCtx := WithSession(context.Background(), SessionFromContext(cmd.Context()))
+
 				task := &ScheduledTask{
 					ID:      exec.JobManager.getNextID(),
 					Command: command,
@@ -155,17 +182,8 @@ Examples:
 
 				// Start timer
 				task.timer = time.AfterFunc(duration, func() {
-					output, err := exec.Execute(task.Command, nil)
-					if output != "" {
-						fmt.Print(output)
-						if !strings.HasSuffix(output, "\n") {
-							fmt.Println()
-						}
-					}
-					if err != nil {
-						fmt.Printf("Scheduled command error: %v\n", err)
-					}
-					// Remove from schedule list after execution
+					task.run(exec, taskCtx)
+					// Remove from schedule list once started; the job tracks the run
 					exec.JobManager.removeScheduledTask(task.ID)
 				})
 
@@ -197,6 +215,9 @@ Examples:
 					return
 				}
 
+				// Run later in the scheduling session, not the request that created it
+				taskCtx := WithSession(context.Background(), SessionFromContext(cmd.Context()))
+
 				task := &ScheduledTask{
 					ID:       exec.JobManager.getNextID(),
 					Command:  command,
@@ -212,23 +233,7 @@ Examples:
 					for {
 						select {
 						case <-task.ticker.C:
-							task.mu.RLock()
-							enabled := task.Enabled
-							command := task.Command
-							task.mu.RUnlock()
-
-							if enabled {
-								output, err := exec.Execute(command, nil)
-								if output != "" {
-									fmt.Print(output)
-									if !strings.HasSuffix(output, "\n") {
-										fmt.Println()
-									}
-								}
-								if err != nil {
-									fmt.Printf("Scheduled command error: %v\n", err)
-								}
-							}
+							task.run(exec, taskCtx)
 						case <-task.done:
 							return
 						}
@@ -262,12 +267,16 @@ Examples:
 					command := task.Command
 					taskTime := task.Time
 					taskID := task.ID
+					jobID := task.JobID
 					task.mu.RUnlock()
 
 					status := "enabled"
 					if !enabled {
 						status = "disabled"
 					}
+					if jobID != 0 {
+						status += fmt.Sprintf(", last job %d", jobID)
+					}
 
 					if repeat {
 						cmd.Printf("[%d] Every %s - %s (%s)\n", taskID, interval, command, status)
@@ -306,6 +315,13 @@ Examples:
 				if task.done != nil {
 					close(task.done)
 				}
+				// And the run in progress, if any
+				task.mu.RLock()
+				jobID := task.JobID
+				task.mu.RUnlock()
+				if job, ok := exec.JobManager.Get(jobID); ok && job.running() {
+					_ = exec.JobManager.Kill(jobID)
+				}
 
 				exec.JobManager.removeScheduledTask(id)
 
@@ -373,13 +389,13 @@ Examples:
 			},
 		}
 
-		scheduleCmd.AddCommand(atCmd)
-		scheduleCmd.AddCommand(inCmd)
-		scheduleCmd.AddCommand(everyCmd)
+		scheduleCmd.AddCommand(RequirePermission(atCmd, "schedule.manage"))
+		scheduleCmd.AddCommand(RequirePermission(inCmd, "schedule.manage"))
+		scheduleCmd.AddCommand(RequirePermission(everyCmd, "schedule.manage"))
 		scheduleCmd.AddCommand(listCmd)
-		scheduleCmd.AddCommand(cancelCmd)
-		scheduleCmd.AddCommand(pauseCmd)
-		scheduleCmd.AddCommand(resumeCmd)
+		scheduleCmd.AddCommand(RequirePermission(cancelCmd, "schedule.manage"))
+		scheduleCmd.AddCommand(RequirePermission(pauseCmd, "schedule.manage"))
+		scheduleCmd.AddCommand(RequirePermission(resumeCmd, "schedule.manage"))
 
 		rootCmd.AddCommand(scheduleCmd)
 	}
@@ -0,0 +1,213 @@
+package consolekit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alexj212/consolekit/parser"
+	"github.com/alexj212/consolekit/safemap"
+	"github.com/spf13/cobra"
+)
+
+// Severities of script diagnostics.
+const (
+	SeverityError   = "error"   // The line fails when it runs
+	SeverityWarning = "warning" // The line may not do what was meant
+)
+
+// ScriptDiagnostic is a problem CheckScript found in a script.
+type ScriptDiagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// String formats the diagnostic as "file:line: severity: message".
+func (d ScriptDiagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, d.Message)
+}
+
+// CheckScript checks the lines of a script without running them. Each line is
+// expanded and parsed as it would be when it runs, and its commands are
+// resolved against the command tree, with their flags and arguments. Variables
+// the script references before defining them (with let, set, inc or dec) are
+// reported as warnings, since they may be set at runtime. args are the script
+// arguments, @arg0, @arg1, ...
+func (e *CommandExecutor) CheckScript(ctx context.Context, name string, lines []ScriptLine, args []string) []ScriptDiagnostic {
+	root, gen := e.acquireTree()
+	defer e.releaseTree(root, gen)
+	root.SetContext(ctx)
+
+	c := &scriptChecker{
+		e:         e,
+		ctx:       ctx,
+		root:      root,
+		name:      name,
+		vars:      safemap.New[string, string](),
+		aliases:   make(map[string]bool),
+		functions: make(map[string]bool),
+	}
+	for i, arg := range args {
+		c.vars.Set(fmt.Sprintf("@arg%d", i), arg)
+	}
+	for _, l := range lines {
+		if strings.TrimSpace(l.Text) != "" {
+			c.checkLine(l)
+		}
+	}
+	return c.diags
+}
+
+// scriptChecker follows the definitions of a script as CheckScript goes
+// through its lines.
+type scriptChecker struct {
+	e     *CommandExecutor
+	ctx   context.Context
+	root  *cobra.Command
+	name  string
+	diags []ScriptDiagnostic
+
+	vars      *safemap.SafeMap[string, string] // Variables the script defined
+	aliases   map[string]bool                  // Aliases the script added
+	functions map[string]bool                  // Functions the script defined
+}
+
+func (c *scriptChecker) report(line int, severity, format string, args ...any) {
+	c.diags = append(c.diags, ScriptDiagnostic{File: c.name, Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkLine checks a line of the script, as executeLine would run it.
+func (c *scriptChecker) checkLine(l ScriptLine) {
+	line, err := parser.JoinLines(l.Text)
+	if quote, at := unbalancedQuote(l.Text); err != nil && quote != 0 {
+		// The quote swallowed the rest of the script
+		c.report(l.Line+strings.Count(l.Text[:at], "\n"), SeverityError, "unbalanced %c quote", quote)
+		return
+	}
+	if err == nil {
+		line, err = c.e.expandAliases(c.ctx, line)
+	}
+	if err != nil {
+		c.report(l.Line, SeverityError, "%v", err)
+		return
+	}
+
+	// The variables of a function body are its parameters, checked when it runs
+	x := &expander{e: c.e, ctx: c.ctx, scope: c.vars, dryRun: true}
+	if words := strings.Fields(line); len(words) > 0 && words[0] == "func" {
+		x.unbound = nil
+	} else {
+		line = x.expand(line)
+	}
+	reported := make(map[string]bool)
+	for _, name := range x.unbound {
+		if !reported[name] {
+			reported[name] = true
+			c.report(l.Line, SeverityWarning, "undefined variable %s", name)
+		}
+	}
+
+	commands, err := parser.Parse(line)
+	if err != nil {
+		c.report(l.Line, SeverityError, "%v", err)
+		return
+	}
+	for _, cmd := range commands {
+		c.checkCommand(l, cmd)
+	}
+}
+
+// checkCommand checks the command of a pipeline stage, the stages after it and
+// the commands of its group.
+func (c *scriptChecker) checkCommand(l ScriptLine, stage *parser.ExecCmd) {
+	for ; stage != nil; stage = stage.Pipe {
+		if stage.Group != nil {
+			for _, cmd := range stage.Group {
+				c.checkCommand(l, cmd)
+			}
+			continue
+		}
+		c.checkStage(l.Line+max(stage.Line, 1)-1, stage)
+	}
+}
+
+func (c *scriptChecker) checkStage(line int, stage *parser.ExecCmd) {
+	// Names the script defined, and names that are variables left unexpanded
+	if c.aliases[stage.Cmd] || c.functions[stage.Cmd] || strings.HasPrefix(stage.Cmd, "@") {
+		return
+	}
+
+	cmd, args, err := c.root.Find(append([]string{stage.Cmd}, stage.Args...))
+	if err != nil || cmd == c.root {
+		c.report(line, SeverityError, "unknown command %q", stage.Cmd)
+		return
+	}
+	path := commandPath(cmd)
+
+	if !cmd.DisableFlagParsing {
+		cmd.InitDefaultHelpFlag()
+		if err := cmd.ParseFlags(args); err != nil {
+			c.report(line, SeverityError, "%s: %v", path, err)
+			return
+		}
+		args = cmd.Flags().Args()
+	}
+	if cmd.HasSubCommands() && !cmd.Runnable() {
+		if len(args) > 0 {
+			c.report(line, SeverityError, "unknown subcommand %q for %q", args[0], path)
+		}
+		return
+	}
+	if err := cmd.ValidateArgs(args); err != nil {
+		c.report(line, SeverityError, "%s: %v", path, err)
+		return
+	}
+	c.define(path, args)
+}
+
+// define records what a command of the script defines for the lines after it.
+func (c *scriptChecker) define(path string, args []string) {
+	switch {
+	case path == "let":
+		for _, arg := range args {
+			if name, value, ok := strings.Cut(arg, "="); ok {
+				c.vars.Set("@"+strings.TrimSpace(name), value)
+			}
+		}
+	case path == "set" && len(args) == 2:
+		c.vars.Set("@"+args[0], args[1])
+	case len(args) == 0:
+	case path == "inc" || path == "dec":
+		if _, defined := c.vars.Get("@" + args[0]); !defined {
+			c.vars.Set("@"+args[0], strconv.Itoa(0))
+		}
+	case path == "alias add":
+		c.aliases[args[0]] = true
+	case path == "func":
+		c.functions[args[0]] = true
+	}
+}
+
+// unbalancedQuote returns the quote that is left open in s and its offset,
+// or 0.
+func unbalancedQuote(s string) (rune, int) {
+	var quote rune
+	at := 0
+	escaped := false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote, at = c, i
+		case c == quote:
+			quote = 0
+		}
+	}
+	return quote, at
+}
@@ -0,0 +1,120 @@
+package consolekit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckScript(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // The functions file
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(AddRun(exec, nil))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		args   []string
+		want   []string
+	}{
+		{
+			name:   "clean script",
+			script: "# comment\nlet x=1\nprint @x | grep 1\ninc count\nprint @count\nfor i in 1 2 do 'print @i'\n",
+		},
+		{
+			name:   "unknown commands",
+			script: "print a\nnosuchcmd x\nprint b && \\\n  other\n{\n  print c\n  missing\n}\n",
+			want: []string{
+				`script.run:2: error: unknown command "nosuchcmd"`,
+				`script.run:3: error: unknown command "other"`,
+				`script.run:7: error: unknown command "missing"`,
+			},
+		},
+		{
+			name:   "subcommands",
+			script: "job list\nalias add ll 'ls -la'\nalias nosuch x\nll\n",
+			want:   []string{`script.run:3: error: unknown subcommand "nosuch" for "alias"`},
+		},
+		{
+			name:   "flags and arguments",
+			script: "vars --nosuch a\nrun --quiet\nlet -g x=1\n",
+			want: []string{
+				"script.run:1: error: vars: unknown flag: --nosuch",
+				"script.run:2: error: run: requires at least 1 arg(s), only received 0",
+			},
+		},
+		{
+			name:   "undefined variables",
+			script: "print @later @arg0 @arg1\nlet later=1\nprint @later \"@quoted\" '@single'\n",
+			args:   []string{"a"},
+			want: []string{
+				"script.run:1: warning: undefined variable @later",
+				"script.run:1: warning: undefined variable @arg1",
+				"script.run:3: warning: undefined variable @quoted",
+			},
+		},
+		{
+			name:   "syntax",
+			script: "print a &&\nprint \"it's\" 'multi\nline'\nprint b\n",
+			want:   []string{"script.run:1: error: syntax error: missing command after `&&'"},
+		},
+		{
+			name:   "unbalanced quotes",
+			script: "print a\nprint \"it's\" 'open\nprint b\n",
+			want:   []string{"script.run:2: error: unbalanced ' quote"},
+		},
+		{
+			name:   "functions",
+			script: "func greet name { print hi @name }\ngreet bob\n",
+		},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := ReadScript(strings.NewReader(tt.script))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range executor.CheckScript(context.Background(), "script.run", lines, tt.args) {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diagnostics = %q, want %q", got, tt.want)
+			}
+
+			// run --check fails on errors only, and doesn't run the script
+			path := filepath.Join(dir, "script.run")
+			if err := os.WriteFile(path, []byte(tt.script), 0644); err != nil {
+				t.Fatal(err)
+			}
+			out, err := executor.Execute("run --check --json "+path+" "+strings.Join(tt.args, " "), nil)
+			var diags []ScriptDiagnostic
+			if jerr := json.Unmarshal([]byte(out), &diags); jerr != nil {
+				t.Fatalf("invalid JSON %q: %v", out, jerr)
+			}
+			if len(diags) != len(tt.want) {
+				t.Errorf("run --check --json gave %d diagnostics, want %d", len(diags), len(tt.want))
+			}
+			wantErr := strings.Contains(strings.Join(tt.want, "\n"), ": error: ")
+			if (err != nil) != wantErr {
+				t.Errorf("run --check error = %v, want error: %v", err, wantErr)
+			}
+		})
+	}
+
+	if _, ok := executor.GetVariable(context.Background(), "@later"); ok {
+		t.Error("run --check ran the script")
+	}
+}
@@ -0,0 +1,384 @@
+package consolekit
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// ScriptMeta is the front matter of a script: a YAML header in the comment
+// lines between two "# ---" lines at the top of the file. Scripts with front
+// matter in Scripts or the script search path are registered as commands.
+//
+//	# ---
+//	# name: deploy
+//	# short: Deploy a build
+//	# flags:
+//	#   - {name: env, default: staging, choices: [staging, prod]}
+//	#   - {name: dry-run, type: bool}
+//	# args:
+//	#   - {name: tag, required: true}
+//	# ---
+type ScriptMeta struct {
+	Name  string       `yaml:"name"`  // Command name; defaults to the file name without its extension
+	Short string       `yaml:"short"` // One-line help
+	Long  string       `yaml:"long"`  // Full help
+	Flags []ScriptFlag `yaml:"flags"`
+	Args  []ScriptArg  `yaml:"args"`
+}
+
+// ScriptFlag is a flag of a script command, set as @name while it runs.
+type ScriptFlag struct {
+	Name      string   `yaml:"name"`
+	Shorthand string   `yaml:"shorthand"`
+	Type      string   `yaml:"type"` // string (the default), bool, int, float or duration
+	Default   string   `yaml:"default"`
+	Usage     string   `yaml:"usage"`
+	Required  bool     `yaml:"required"`
+	Choices   []string `yaml:"choices"` // Allowed values, if any
+}
+
+// ScriptArg is a positional argument of a script command, set as @name while
+// it runs. Required arguments come first.
+type ScriptArg struct {
+	Name     string `yaml:"name"`
+	Usage    string `yaml:"usage"`
+	Required bool   `yaml:"required"`
+	Default  string `yaml:"default"`
+}
+
+// ScriptCommand is a script registered as a command.
+type ScriptCommand struct {
+	ScriptMeta
+	Source string // The script's path, "embedded:" and its path in Scripts for an embedded one
+	Body   string // The script
+}
+
+// scriptAnnotation holds the source of a script command's script.
+const scriptAnnotation = "script"
+
+// frontMatterDelim opens and closes the front matter of a script.
+const frontMatterDelim = "# ---"
+
+// parseScript parses the front matter of a script read from source. It
+// returns nil if the script has none.
+func parseScript(source, text string) (*ScriptCommand, error) {
+	lines := strings.Split(text, "\n")
+	start := 0
+	for start < len(lines) && (strings.TrimSpace(lines[start]) == "" || strings.HasPrefix(lines[start], "#!")) {
+		start++
+	}
+	if start == len(lines) || strings.TrimSpace(lines[start]) != frontMatterDelim {
+		return nil, nil
+	}
+
+	var header []string
+	end := -1
+	for i := start + 1; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t\r")
+		if line == frontMatterDelim {
+			end = i
+			break
+		}
+		if !strings.HasPrefix(line, "#") {
+			return nil, fmt.Errorf("%s:%d: front matter line is not a comment", source, i+1)
+		}
+		line = strings.TrimPrefix(line, "#")
+		header = append(header, strings.TrimPrefix(line, " "))
+	}
+	if end == -1 {
+		return nil, fmt.Errorf("%s: front matter is not closed by %q", source, frontMatterDelim)
+	}
+
+	s := &ScriptCommand{Source: source, Body: text}
+	if err := yaml.Unmarshal([]byte(strings.Join(header, "\n")), &s.ScriptMeta); err != nil {
+		return nil, fmt.Errorf("%s: front matter: %w", source, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(path.Base(filepath.ToSlash(source)), path.Ext(source))
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return s, nil
+}
+
+// validate checks the names, types and defaults of the front matter.
+func (s *ScriptCommand) validate() error {
+	if !validFunctionName(s.Name) {
+		return fmt.Errorf("invalid command name %q", s.Name)
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range s.Flags {
+		if !validFunctionName(f.Name) || f.Name == "help" || seen[f.Name] {
+			return fmt.Errorf("invalid or duplicate flag %q", f.Name)
+		}
+		seen[f.Name] = true
+		if !slices.Contains(scriptFlagTypes, f.Type) {
+			return fmt.Errorf("flag %s: unknown type %q", f.Name, f.Type)
+		}
+		if len(f.Shorthand) > 1 {
+			return fmt.Errorf("flag %s: shorthand %q is more than one letter", f.Name, f.Shorthand)
+		}
+		if f.Default != "" {
+			if err := checkFlagValue(f, f.Default); err != nil {
+				return fmt.Errorf("flag %s: default: %w", f.Name, err)
+			}
+		}
+	}
+
+	for i, a := range s.Args {
+		if !validFunctionName(a.Name) || seen[a.Name] {
+			return fmt.Errorf("invalid or duplicate argument %q", a.Name)
+		}
+		seen[a.Name] = true
+		if a.Required && i > 0 && !s.Args[i-1].Required {
+			return fmt.Errorf("argument %q: a required argument follows an optional one", a.Name)
+		}
+	}
+	return nil
+}
+
+// scriptFlagTypes are the types of script flags; "" is a string.
+var scriptFlagTypes = []string{"", "string", "bool", "int", "float", "duration"}
+
+// checkFlagValue checks a value of the flag against its type and choices.
+func checkFlagValue(f ScriptFlag, value string) error {
+	var err error
+	switch f.Type {
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "int":
+		_, err = strconv.Atoi(value)
+	case "float":
+		_, err = strconv.ParseFloat(value, 64)
+	case "duration":
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", f.Type, value)
+	}
+	if len(f.Choices) > 0 && !slices.Contains(f.Choices, value) {
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(f.Choices, ", "))
+	}
+	return nil
+}
+
+// command returns the cobra command that runs the script. Its flags and
+// arguments are checked before the script runs.
+func (s *ScriptCommand) command(exec *CommandExecutor) *cobra.Command {
+	use := s.Name
+	required := 0
+	for _, a := range s.Args {
+		if a.Required {
+			use += " " + a.Name
+			required++
+		} else {
+			use += " [" + a.Name + "]"
+		}
+	}
+
+	long := s.Long
+	if long == "" {
+		long = s.Short
+	}
+	if len(s.Args) > 0 {
+		long = strings.TrimRight(long, "\n") + "\n\nArguments:"
+		for _, a := range s.Args {
+			long += fmt.Sprintf("\n  %-12s %s", a.Name, a.Usage)
+		}
+	}
+
+	cmd := &cobra.Command{
+		Use:          use,
+		Short:        s.Short,
+		Long:         long,
+		Args:         cobra.MinimumNArgs(required),
+		Annotations:  map[string]string{scriptAnnotation: s.Source},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vars := argVars(args)
+			for _, f := range s.Flags {
+				value := cmd.Flags().Lookup(f.Name).Value.String()
+				if cmd.Flags().Changed(f.Name) {
+					if err := checkFlagValue(f, value); err != nil {
+						return fmt.Errorf("--%s: %w", f.Name, err)
+					}
+				}
+				vars["@"+f.Name] = value
+			}
+			for i, a := range s.Args {
+				vars["@"+a.Name] = a.Default
+				if i < len(args) {
+					vars["@"+a.Name] = args[i]
+				}
+			}
+			return exec.runLines(cmd.Context(), s.Source, frameScript, s.Body, vars, cmd.OutOrStdout())
+		},
+	}
+
+	// Defaults were checked when the script was parsed
+	flags := cmd.Flags()
+	for _, f := range s.Flags {
+		switch f.Type {
+		case "bool":
+			v, _ := strconv.ParseBool(f.Default)
+			flags.BoolP(f.Name, f.Shorthand, v, f.Usage)
+		case "int":
+			v, _ := strconv.Atoi(f.Default)
+			flags.IntP(f.Name, f.Shorthand, v, f.Usage)
+		case "float":
+			v, _ := strconv.ParseFloat(f.Default, 64)
+			flags.Float64P(f.Name, f.Shorthand, v, f.Usage)
+		case "duration":
+			v, _ := time.ParseDuration(f.Default)
+			flags.DurationP(f.Name, f.Shorthand, v, f.Usage)
+		default:
+			flags.StringP(f.Name, f.Shorthand, f.Default, f.Usage)
+		}
+		if f.Required {
+			_ = cmd.MarkFlagRequired(f.Name)
+		}
+	}
+	return cmd
+}
+
+// scriptDirs returns the directories of the script search path, settings.script_path.
+func (e *CommandExecutor) scriptDirs() []string {
+	if e.Config == nil {
+		return nil
+	}
+	var dirs []string
+	for _, dir := range filepath.SplitList(e.Config.Settings.ScriptPath) {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, os.ExpandEnv(dir))
+		}
+	}
+	return dirs
+}
+
+// ScriptCommands returns the scripts with front matter: the ".run" files of
+// Scripts, then those of the directories of the script search path. A script
+// hides later ones of the same name. It also returns the errors of the scripts
+// whose front matter is invalid.
+func (e *CommandExecutor) ScriptCommands() ([]*ScriptCommand, []error) {
+	var scripts []*ScriptCommand
+	var errs []error
+	seen := make(map[string]bool)
+	add := func(source string, data []byte, err error) {
+		if err == nil {
+			var s *ScriptCommand
+			if s, err = parseScript(source, string(data)); s != nil && !seen[s.Name] {
+				seen[s.Name] = true
+				scripts = append(scripts, s)
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if e.Scripts != nil {
+		_ = fs.WalkDir(e.Scripts, ".", func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.HasSuffix(p, ".run") {
+				data, err := e.Scripts.ReadFile(p)
+				add("embedded:"+p, data, err)
+			}
+			return nil
+		})
+	}
+
+	for _, dir := range e.scriptDirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue // Missing directories are skipped, like in PATH
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".run") {
+				continue
+			}
+			p := filepath.Join(dir, entry.Name())
+			data, err := os.ReadFile(p)
+			add(p, data, err)
+		}
+	}
+	return scripts, errs
+}
+
+// addScriptCommands adds the commands of the scripts with front matter to
+// rootCmd. Scripts named like a command already in the tree are left out.
+func (e *CommandExecutor) addScriptCommands(rootCmd *cobra.Command) {
+	scripts, _ := e.ScriptCommands()
+	for _, s := range scripts {
+		if subcommand(rootCmd, s.Name) == nil {
+			rootCmd.AddCommand(s.command(e))
+		}
+	}
+}
+
+// subcommand returns the subcommand of cmd called name, or nil.
+func subcommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, c := range cmd.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// AddScriptCommands registers the scripts command, which lists the scripts
+// registered as commands.
+func AddScriptCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
+	return func(rootCmd *cobra.Command) {
+		scriptsCmd := &cobra.Command{
+			Use:   "scripts",
+			Short: "List the scripts registered as commands",
+			Long: `List the scripts with front matter registered as commands: the .run files of
+the embedded scripts, then those of the directories of settings.script_path.
+Scripts with invalid front matter are reported.`,
+			Args: cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				scripts, errs := exec.ScriptCommands()
+				if len(scripts) == 0 && len(errs) == 0 {
+					cmd.Printf("No script commands (search path: %s)\n", strings.Join(exec.scriptDirs(), string(os.PathListSeparator)))
+					return
+				}
+				for _, s := range scripts {
+					c := s.command(exec)
+					note := ""
+					if found := subcommand(rootCmd, s.Name); found != nil && found.Annotations[scriptAnnotation] != s.Source {
+						note = " (hidden by a command of that name)"
+					}
+					cmd.Printf("%-40s %s%s\n", c.UseLine(), s.Source, note)
+				}
+				for _, err := range errs {
+					cmd.Printf("error: %v\n", err)
+				}
+			},
+		}
+
+		scriptsReloadCmd := &cobra.Command{
+			Use:   "reload",
+			Short: "Register the scripts again after they changed",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				exec.treeCache.invalidate()
+				scripts, errs := exec.ScriptCommands()
+				cmd.Printf("Found %d script commands, %d invalid\n", len(scripts), len(errs))
+			},
+		}
+
+		scriptsCmd.AddCommand(scriptsReloadCmd)
+		rootCmd.AddCommand(scriptsCmd)
+	}
+}
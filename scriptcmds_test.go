@@ -0,0 +1,128 @@
+package consolekit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const deployScript = `#!/usr/bin/env myapp
+# ---
+# short: Deploy a build
+# long: |
+#   Deploy a build to an environment.
+# flags:
+#   - {name: env, shorthand: e, default: staging, choices: [staging, prod], usage: Target environment}
+#   - {name: dry-run, type: bool}
+#   - {name: replicas, type: int, default: 2}
+# args:
+#   - {name: tag, required: true, usage: Build tag}
+#   - {name: note, default: none}
+# ---
+print "deploying @tag to @env, @replicas replicas (@note)"
+test @dry-run = true || print applied
+`
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    string // Name
+		wantErr bool
+	}{
+		{name: "front matter", text: deployScript, want: "deploy"},
+		{name: "named", text: "# ---\n# name: ship\n# ---\nprint x\n", want: "ship"},
+		{name: "no front matter", text: "# a comment\nprint x\n"},
+		{name: "unclosed", text: "# ---\n# name: x\nprint x\n", wantErr: true},
+		{name: "bad yaml", text: "# ---\n# flags: {\n# ---\n", wantErr: true},
+		{name: "bad type", text: "# ---\n# flags: [{name: n, type: list}]\n# ---\n", wantErr: true},
+		{name: "bad default", text: "# ---\n# flags: [{name: n, type: int, default: x}]\n# ---\n", wantErr: true},
+		{name: "default not a choice", text: "# ---\n# flags: [{name: n, default: c, choices: [a, b]}]\n# ---\n", wantErr: true},
+		{name: "required after optional", text: "# ---\n# args: [{name: a}, {name: b, required: true}]\n# ---\n", wantErr: true},
+		{name: "bad name", text: "# ---\n# name: 'a b'\n# ---\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseScript("/scripts/deploy.run", tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := ""
+			if s != nil {
+				got = s.Name
+			}
+			if got != tt.want {
+				t.Errorf("name = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptCommands(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"deploy.run": deployScript,
+		"plain.run":  "print plain\n",
+		"broken.run": "# ---\n# flags: [{name: n, type: list}]\n# ---\n",
+		"print.run":  "# ---\n# short: Hidden by the print command\n# ---\n",
+	}
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	executor.Config.Settings.ScriptPath = filepath.Join(dir, "missing") + string(os.PathListSeparator) + dir
+
+	tests := []struct {
+		line    string
+		want    string
+		wantErr bool
+	}{
+		{line: "deploy v1", want: "deploying v1 to staging, 2 replicas (none)\napplied\n"},
+		{line: "deploy -e prod --replicas 3 --dry-run v2 'hot fix'", want: "deploying v2 to prod, 3 replicas (hot fix)\n"},
+		{line: "deploy", wantErr: true},
+		{line: "deploy --env qa v1", wantErr: true},
+		{line: "deploy --replicas many v1", wantErr: true},
+		{line: "which deploy", want: "deploy: script command " + filepath.Join(dir, "deploy.run") + "\n"},
+		{line: "which print", want: "print: built-in command\n"},
+	}
+	for _, tt := range tests {
+		out, err := executor.Execute(tt.line, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Execute(%q) error = %v, wantErr %v (%q)", tt.line, err, tt.wantErr, out)
+		}
+		if !tt.wantErr && out != tt.want {
+			t.Errorf("Execute(%q) = %q, want %q", tt.line, out, tt.want)
+		}
+	}
+
+	if out, _ := executor.Execute("deploy --help", nil); !strings.Contains(out, "Deploy a build to an environment.") || !strings.Contains(out, "Target environment") {
+		t.Errorf("deploy --help:\n%s", out)
+	}
+	out, _ := executor.Execute("scripts", nil)
+	for _, want := range []string{"deploy tag [note]", "hidden by a command", "broken.run", `unknown type "list"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scripts output is missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "plain") {
+		t.Errorf("scripts lists a script without front matter:\n%s", out)
+	}
+
+	server := NewMCPServer(executor, "test-app", "1.0")
+	resp := server.Process(nil, &JSONRPCRequest{JSONRPC: "2.0", ID: 1, Method: "tools/list"})
+	data, _ := json.Marshal(resp.Result)
+	if !strings.Contains(string(data), `"name":"deploy"`) || !strings.Contains(string(data), "replicas") {
+		t.Error("tools/list is missing deploy and its flags")
+	}
+}
@@ -0,0 +1,383 @@
+package consolekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alexj212/consolekit/safemap"
+	"github.com/spf13/cobra"
+)
+
+// Shell options a script sets with "set -e", "set -u", "set -x" and "setopt name".
+const (
+	optErrexit  = "errexit"  // Stop at the first line that fails
+	optNounset  = "nounset"  // Fail lines that reference undefined variables
+	optPipefail = "pipefail" // A pipeline fails if any of its stages does
+	optXtrace   = "xtrace"   // Echo commands after expansion, like "set -x"
+)
+
+// shellOptions are the options setopt and unsetopt know, in the order they list them.
+var shellOptions = []string{optErrexit, optNounset, optPipefail, optXtrace}
+
+// optionLetters are the options "set" turns on and off by letter.
+var optionLetters = map[rune]string{
+	'e': optErrexit,
+	'u': optNounset,
+	'x': optXtrace,
+}
+
+// Conditions a trap runs on.
+const (
+	trapERR  = "ERR"  // After a line of the script fails
+	trapEXIT = "EXIT" // When the script finishes, however it stops
+)
+
+// Kinds of script frames.
+const (
+	frameScript   = "script"   // A script run with run, a script command or RunBatch
+	frameFunction = "function" // A function call
+	frameSource   = "source"   // A script run in the caller's context with "."
+)
+
+// scriptState holds the options and traps of a script. The functions it calls
+// and the scripts it sources share it.
+type scriptState struct {
+	mu      sync.Mutex
+	options map[string]bool
+	traps   map[string]string
+	inTrap  bool
+}
+
+func newScriptState() *scriptState {
+	return &scriptState{options: make(map[string]bool), traps: make(map[string]string)}
+}
+
+func (s *scriptState) option(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.options[name]
+}
+
+func (s *scriptState) setOption(name string, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.options[name] = on
+}
+
+func (s *scriptState) trap(condition string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.traps[condition]
+}
+
+func (s *scriptState) setTrap(condition, command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if command == "" {
+		delete(s.traps, condition)
+		return
+	}
+	s.traps[condition] = command
+}
+
+// scriptFrame is a running script, function or sourced script. Its lines run
+// with its context, which is cancelled when it stops with return or exit.
+type scriptFrame struct {
+	e      *CommandExecutor
+	name   string // Script source or function name, used to locate errors
+	kind   string
+	parent *scriptFrame
+	state  *scriptState
+	owner  bool // The frame created its state and runs its EXIT trap
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lines []ScriptLine                     // The script, for the debugger
+	scope *safemap.SafeMap[string, string] // Scope its lines run with
+
+	mu      sync.Mutex
+	line    int   // Line of the command running
+	base    int   // Line the running script line starts on
+	depth   int32 // Execution depth of the running script line
+	stopped bool  // Stopped by return or exit
+	status  int   // Status it stopped with
+	err     error // Error of the last line
+}
+
+type scriptFrameKey struct{}
+
+// frameFrom returns the script frame that ctx belongs to, or nil.
+func frameFrom(ctx context.Context) *scriptFrame {
+	f, _ := ctx.Value(scriptFrameKey{}).(*scriptFrame)
+	return f
+}
+
+// scriptOption reports whether the script that ctx belongs to set an option.
+func scriptOption(ctx context.Context, name string) bool {
+	f := frameFrom(ctx)
+	return f != nil && f.state.option(name)
+}
+
+// newFrame starts a frame of kind below the frame of ctx, if any. Functions and
+// sourced scripts share the options and traps of their caller.
+func (e *CommandExecutor) newFrame(ctx context.Context, name, kind string) *scriptFrame {
+	f := &scriptFrame{e: e, name: name, kind: kind, parent: frameFrom(ctx)}
+	if kind != frameScript && f.parent != nil {
+		f.state = f.parent.state
+	} else {
+		f.state, f.owner = newScriptState(), true
+	}
+	ctx, f.cancel = context.WithCancel(ctx)
+	f.ctx = context.WithValue(ctx, scriptFrameKey{}, f)
+	return f
+}
+
+// setCommandLine records that the command on line of the running script line
+// (numbered from 1) started. Only commands of the line itself count, not those
+// of the blocks and functions it runs.
+func (f *scriptFrame) setCommandLine(ctx context.Context, line int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if line > 0 && execDepth(ctx) == f.depth {
+		f.line = f.base + line - 1
+	}
+}
+
+// currentLine returns the line of the command running.
+func (f *scriptFrame) currentLine() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.line
+}
+
+// stop stops the frame with status: its running line is cancelled and it
+// runs no more lines.
+func (f *scriptFrame) stop(status int) {
+	f.mu.Lock()
+	f.stopped, f.status = true, status
+	f.mu.Unlock()
+	f.cancel()
+}
+
+// stopStatus returns the status the frame was stopped with, if it was.
+func (f *scriptFrame) stopStatus() (int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status, f.stopped
+}
+
+// exit stops the frame and its callers up to the script they belong to.
+func (f *scriptFrame) exit(status int) {
+	for g := f; g != nil; g = g.parent {
+		g.stop(status)
+		if g.kind == frameScript {
+			return
+		}
+	}
+}
+
+// ScriptError locates an error in a script or function.
+type ScriptError struct {
+	Script string // Script source or function name
+	Line   int    // Line of the command that failed, from 1
+	Err    error
+}
+
+// Error implements the error interface
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.Script, e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error for error unwrapping
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// runLine runs a line of the script with scope, writing its output to w. It
+// returns whether the frame is done (it was stopped, interrupted, or the line
+// failed with errexit set) and the error of the line, located in the script.
+func (f *scriptFrame) runLine(l ScriptLine, scope *safemap.SafeMap[string, string], w io.Writer) (bool, error) {
+	f.mu.Lock()
+	f.line, f.base, f.depth = l.Line, l.Line, execDepth(f.ctx)+1
+	f.mu.Unlock()
+	f.scope = scope
+
+	if d := debuggerFrom(f.ctx); d != nil {
+		d.beforeLine(f, l)
+	}
+	if _, stopped := f.stopStatus(); stopped {
+		return true, nil
+	}
+
+	err := f.e.ExecuteStream(f.ctx, l.Text, scope, w)
+	if _, stopped := f.stopStatus(); stopped {
+		return true, nil
+	}
+	f.err = err
+	if err == nil {
+		return false, nil
+	}
+
+	var located *ScriptError
+	if !errors.As(err, &located) {
+		err = &ScriptError{Script: f.name, Line: f.currentLine(), Err: err}
+	}
+	f.err = err
+	f.runTrap(trapERR, w)
+	if f.ctx.Err() != nil {
+		return true, err
+	}
+	if f.state.option(optErrexit) {
+		return true, err
+	}
+	// Without errexit the script goes on; its status is that of its last line
+	f.err = &ExitError{Status: ExitStatus(err)}
+	return false, err
+}
+
+// runTrap runs the trap for condition, if one is set. Failing commands of a
+// trap don't run the ERR trap again.
+func (f *scriptFrame) runTrap(condition string, w io.Writer) {
+	command := f.state.trap(condition)
+	if command == "" {
+		return
+	}
+	f.state.mu.Lock()
+	if f.state.inTrap {
+		f.state.mu.Unlock()
+		return
+	}
+	f.state.inTrap = true
+	f.state.mu.Unlock()
+	defer func() {
+		f.state.mu.Lock()
+		f.state.inTrap = false
+		f.state.mu.Unlock()
+	}()
+
+	// The EXIT trap also runs after return or exit cancelled the frame
+	ctx := context.WithoutCancel(f.ctx)
+	if err := f.e.ExecuteStream(ctx, command, nil, w); err != nil {
+		_, _ = fmt.Fprintf(w, "%s trap: %v\n", condition, err)
+	}
+}
+
+// finish ends the frame and returns its result: the error errexit stopped it
+// with, the status return or exit gave, or the status of its last line. A
+// frame that owns its state runs the EXIT trap.
+func (f *scriptFrame) finish(w io.Writer) error {
+	defer f.cancel()
+
+	err := f.err
+	if status, stopped := f.stopStatus(); stopped {
+		err = nil
+		if status != 0 {
+			err = &ExitError{Status: status}
+		}
+	}
+	if f.owner {
+		f.e.SetVariable(f.ctx, lastStatusVar, strconv.Itoa(ExitStatus(err)))
+		f.runTrap(trapEXIT, w)
+	}
+	return err
+}
+
+// runScript runs lines in frame f, one after another, and finishes it. Each
+// line is expanded when it runs, after the lines before it. Errors of lines
+// that don't stop the script are written to w.
+func (e *CommandExecutor) runScript(f *scriptFrame, lines []ScriptLine, scope *safemap.SafeMap[string, string], w io.Writer) error {
+	f.lines = lines
+	for _, l := range lines {
+		if strings.TrimSpace(l.Text) == "" {
+			continue
+		}
+		done, err := f.runLine(l, scope, w)
+		if done {
+			break
+		}
+		if reportable(err) {
+			_, _ = fmt.Fprintln(w, err)
+		}
+	}
+	return f.finish(w)
+}
+
+// reportable reports whether err has a message to show: failures that only
+// carry an exit status, like a false "test", are silent.
+func reportable(err error) bool {
+	var exitErr *ExitError
+	return err != nil && (!errors.As(err, &exitErr) || exitErr.Err != nil)
+}
+
+// sortedTraps returns the conditions that have a trap, sorted.
+func (s *scriptState) sortedTraps() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conditions := make([]string, 0, len(s.traps))
+	for condition := range s.traps {
+		conditions = append(conditions, condition)
+	}
+	sort.Strings(conditions)
+	return conditions
+}
+
+// setShellOptions applies the shell options of a "set" command: -e, -u and -x
+// turn options on, and +e, +u and +x turn them off (setopt and unsetopt set
+// them by name). It reports whether args held options, leaving other uses of
+// set (variable defaults, "-o" to overwrite one) alone.
+func setShellOptions(cmd *cobra.Command, args []string) (bool, error) {
+	changes := make(map[string]bool)
+	for _, name := range []string{optErrexit, optNounset, optXtrace} {
+		if on, _ := cmd.Flags().GetBool(name); on {
+			changes[name] = true
+		}
+	}
+
+	switch {
+	case len(args) > 0 && strings.HasPrefix(args[0], "+") && len(args[0]) > 1:
+		for _, arg := range args {
+			if !strings.HasPrefix(arg, "+") || len(arg) == 1 {
+				return true, fmt.Errorf("set: unexpected argument %q", arg)
+			}
+			for _, letter := range arg[1:] {
+				name, ok := optionLetters[letter]
+				if !ok {
+					return true, fmt.Errorf("set: unknown option +%c", letter)
+				}
+				changes[name] = false
+			}
+		}
+	case len(changes) > 0 && len(args) > 0:
+		return true, fmt.Errorf("set: unexpected argument %q", args[0])
+	case len(changes) == 0:
+		return false, nil
+	}
+	return true, setOptions(cmd, changes)
+}
+
+// optionChanges returns the changes that turn the named options on or off.
+func optionChanges(names []string, on bool) map[string]bool {
+	changes := make(map[string]bool, len(names))
+	for _, name := range names {
+		changes[name] = on
+	}
+	return changes
+}
+
+// setOptions turns the shell options of the script cmd runs in on or off.
+func setOptions(cmd *cobra.Command, changes map[string]bool) error {
+	f := frameFrom(cmd.Context())
+	if f == nil {
+		return fmt.Errorf("%s: shell options can only be set in a script or function", cmd.Name())
+	}
+	for name, on := range changes {
+		f.state.setOption(name, on)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package consolekit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScriptErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // The functions file
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(AddRun(exec, nil))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	if _, err := executor.Execute("func fails { print in-func; test 1 -eq 2; print after }", nil); err != nil {
+		t.Fatalf("func failed: %v", err)
+	}
+	if _, err := executor.Execute("func early { print a; return 3; print b }", nil); err != nil {
+		t.Fatalf("func failed: %v", err)
+	}
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		script  string
+		want    string // Output
+		wantErr string // Error, empty if none
+		status  int
+	}{
+		{
+			name:   "goes on after failures",
+			script: "print a\n# comment\nnosuchcmd\nprint b\n",
+			want:   "a\n  ✗ script.run:3: unknown command \"nosuchcmd\" for \"\"\nb\n",
+		},
+		{
+			name:    "errexit",
+			script:  "set -e\nprint a\n\nnosuchcmd x\nprint b\n",
+			want:    "a\n",
+			wantErr: `script.run:4: unknown command "nosuchcmd" for ""`,
+			status:  1,
+		},
+		{
+			name:   "status of the last line",
+			script: "print a\ntest 1 -eq 2\n",
+			want:   "a\n",
+			status: 1,
+		},
+		{
+			name:    "line of a command in a multi-line line",
+			script:  "set -e\nprint a; \\\n  print b && \\\n  test 1 -eq 2\n{\n  print c\n  test 2 -eq 3\n}\n",
+			want:    "a\nb\n",
+			wantErr: "script.run:2: exit status 1",
+			status:  1,
+		},
+		{
+			name:    "line inside a group",
+			script:  "set -e\n{\n  print c\n  test 2 -eq 3\n}\n",
+			want:    "c\n",
+			wantErr: "script.run:4: exit status 1",
+			status:  1,
+		},
+		{
+			name:   "traps",
+			script: "trap 'print cleanup @?' EXIT\ntrap 'print failed @?' ERR\ntest 1 -eq 2\nprint ok\n",
+			want:   "failed 1\nok\ncleanup 0\n",
+		},
+		{
+			name:    "exit trap after errexit",
+			script:  "set -e\ntrap 'print cleanup' EXIT\nprint a\ntest 1 -eq 2\nprint b\n",
+			want:    "a\ncleanup\n",
+			wantErr: "script.run:4: exit status 1",
+			status:  1,
+		},
+		{
+			name:   "exit",
+			script: "trap 'print bye' EXIT\nprint a\nfor i in 1 2 do 'exit 4'\nprint b\n",
+			want:   "a\nbye\n",
+			status: 4,
+		},
+		{
+			name:   "exit status 0",
+			script: "print a\nexit\nprint b\n",
+			want:   "a\n",
+		},
+		{
+			name:   "return",
+			script: "print a\ntest 1 -eq 1 && return 5\nprint b\n",
+			want:   "a\n",
+			status: 5,
+		},
+		{
+			name:   "return from a function",
+			script: "early\nprint @?\n",
+			want:   "a\n3\n",
+		},
+		{
+			name:    "errexit in functions",
+			script:  "set -e\nprint start\nfails\nprint end\n",
+			want:    "start\nin-func\n",
+			wantErr: "fails:1: exit status 1",
+			status:  1,
+		},
+		{
+			name:   "functions share traps",
+			script: "trap 'print trapped' ERR\nfails\n",
+			want:   "in-func\ntrapped\ntrapped\n",
+			status: 1,
+		},
+		{
+			name:   "nounset",
+			script: "print @undefined\nset -u\nprint @undefined\nprint b\n",
+			want:   "@undefined\n  ✗ script.run:3: @undefined: unbound variable\nb\n",
+		},
+		{
+			name:   "pipefail",
+			script: "test 1 -eq 2 | page\nprint @?\nsetopt pipefail\ntest 1 -eq 2 | page\nprint @?\nunsetopt pipefail\ntest 1 -eq 2 | page\nprint @?\n",
+			want:   "0\n1\n0\n",
+		},
+		{
+			name:   "list options",
+			script: "set -eu\nset +e\nsetopt\n",
+			want:   "errexit    off\nnounset    on\npipefail   off\nxtrace     off\n",
+		},
+		{
+			name:   "set -o overwrites a default",
+			script: "set opt a\nset opt b\nset -o opt errexit\nprint @opt\nnosuchcmd\nprint after\n",
+			want:   "setting default: @opt\ndefault already set key: @opt\noverwriting default: @opt\nerrexit\n  ✗ script.run:5: unknown command \"nosuchcmd\" for \"\"\nafter\n",
+		},
+		{
+			name:   "scripts don't share options",
+			script: "set -e\nrun --quiet @dir/inner.run\nprint after\n",
+			want:   "inner-a\ninner-b\nafter\n",
+		},
+	}
+	inner := "print inner-a\ntest 1 -eq 2\nprint inner-b\n"
+	if err := os.WriteFile(filepath.Join(dir, "inner.run"), []byte(inner), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "script.run")
+			script := strings.ReplaceAll(tt.script, "@dir", dir)
+			if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+				t.Fatal(err)
+			}
+			out, err := executor.Execute("run --quiet "+path, nil)
+			out = strings.ReplaceAll(out, dir+"/", "")
+			if out != tt.want {
+				t.Errorf("output = %q, want %q", out, tt.want)
+			}
+			gotErr := ""
+			var located *ScriptError
+			if reportable(err) || errors.As(err, &located) {
+				gotErr = strings.ReplaceAll(err.Error(), dir+"/", "")
+			}
+			if gotErr != tt.wantErr {
+				t.Errorf("error = %q, want %q", gotErr, tt.wantErr)
+			}
+			if status := ExitStatus(err); status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	// The script's options and traps don't outlive it
+	for _, line := range []string{"set -e", "setopt pipefail", "trap 'print x' ERR", "return"} {
+		if _, err := executor.Execute(line, nil); err == nil {
+			t.Errorf("%q outside a script succeeded", line)
+		}
+	}
+	if scriptOption(context.Background(), optErrexit) {
+		t.Error("errexit set outside a script")
+	}
+
+	// Outside a script, set -o overwrites a default as it always did
+	_, _ = executor.Execute("set foo x", nil)
+	if out, err := executor.Execute("set -o foo bar", nil); err != nil || out != "overwriting default: @foo\n" {
+		t.Errorf("set -o foo bar = %q, %v", out, err)
+	}
+	if out, _ := executor.Execute("print @foo", nil); out != "bar\n" {
+		t.Errorf("after set -o foo bar, @foo = %q, want bar", out)
+	}
+}
@@ -0,0 +1,577 @@
+package consolekit
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexj212/consolekit/safemap"
+)
+
+// Directives of a script test, in comment lines after the command they check:
+//
+//	print hello @name
+//	#> hello bob         an output line
+//	#~ took \d+ms        an output line matching a regular expression
+//	#? 1                 the exit status (default 0)
+const (
+	directiveOutput = "#>"
+	directiveRegexp = "#~"
+	directiveStatus = "#?"
+)
+
+// FileLister is implemented by a FileHandler that can list directories.
+// Without it, test-scripts only takes files: directories are not searched.
+type FileLister interface {
+	ReadDir(path string) ([]fs.DirEntry, error)
+}
+
+// ScriptTestOptions configures RunScriptTests.
+type ScriptTestOptions struct {
+	// Update rewrites the expectations of the commands with their actual
+	// results. Expected output that matches, like regular expressions, is kept.
+	// It requires the scripts.manage permission.
+	Update bool
+}
+
+// ScriptTestReport is the result of RunScriptTests.
+type ScriptTestReport struct {
+	Files    []*ScriptTestFile
+	Duration time.Duration
+}
+
+// ScriptTestFile is the result of a script test file.
+type ScriptTestFile struct {
+	Path     string
+	Cases    []*ScriptTestCase
+	Err      error // The file could not be read or updated
+	Updated  bool  // The file was rewritten with --update
+	Duration time.Duration
+}
+
+// ScriptTestCase is a command of a script test and the result of checking it.
+type ScriptTestCase struct {
+	Line       int    // Line of the command, from 1
+	Command    string // The command as written
+	Output     []string
+	Status     int
+	WantStatus int
+	Passed     bool
+	Skipped    bool   // Not run: an earlier line stopped the script
+	Failure    string // Why it failed: the output diff and the status
+	Duration   time.Duration
+
+	expect []scriptExpectation
+	end    int      // Last line of the command, where its expectations go
+	raw    []string // Its directive lines, as written
+}
+
+// scriptExpectation is an expected output line, literal or a regular expression.
+type scriptExpectation struct {
+	text string
+	re   *regexp.Regexp
+}
+
+func (x scriptExpectation) match(line string) bool {
+	if x.re != nil {
+		return x.re.MatchString(line)
+	}
+	return x.text == line
+}
+
+func (x scriptExpectation) String() string {
+	if x.re != nil {
+		return "~ " + x.text
+	}
+	return x.text
+}
+
+// RunScriptTests runs the script tests in paths: .run files, and directories
+// searched for them. Each command of a file runs in turn, in a subshell of
+// ctx, and its output and exit status are compared with the directives that
+// follow it (see directiveOutput). Commands without expected output only have
+// their status checked. Error messages are part of the output, as "✗ message".
+// Files are read and updated through the executor's FileHandler.
+func RunScriptTests(ctx context.Context, exec *CommandExecutor, paths []string, opts ScriptTestOptions) (*ScriptTestReport, error) {
+	if opts.Update {
+		if err := exec.authorizeUse(ctx, "test-scripts --update", "scripts.manage"); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	files, err := findScriptTests(exec.FileHandler, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ScriptTestReport{}
+	for _, path := range files {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		report.Files = append(report.Files, exec.runScriptTest(ctx, path, opts))
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// findScriptTests returns the test files of paths, sorted within directories.
+// Directories are listed through the FileHandler, if it is a FileLister;
+// other paths are taken as files.
+func findScriptTests(files FileHandler, paths []string) ([]string, error) {
+	lister, ok := files.(FileLister)
+	var found []string
+	for _, path := range paths {
+		if !ok {
+			found = append(found, path)
+			continue
+		}
+		entries, err := lister.ReadDir(path)
+		if err != nil {
+			// Not a directory: reading it reports any error
+			found = append(found, path)
+			continue
+		}
+		tests, err := walkScriptTests(lister, path, entries)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, tests...)
+	}
+	return found, nil
+}
+
+// walkScriptTests returns the .run files under the directory dir, whose
+// entries are given, in lexical order.
+func walkScriptTests(lister FileLister, dir string, entries []fs.DirEntry) ([]string, error) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	var found []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() {
+			if strings.HasSuffix(path, ".run") {
+				found = append(found, path)
+			}
+			continue
+		}
+		sub, err := lister.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		tests, err := walkScriptTests(lister, path, sub)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, tests...)
+	}
+	return found, nil
+}
+
+// runScriptTest runs the test file at path.
+func (e *CommandExecutor) runScriptTest(ctx context.Context, path string, opts ScriptTestOptions) *ScriptTestFile {
+	start := time.Now()
+	file := &ScriptTestFile{Path: path}
+	defer func() { file.Duration = time.Since(start) }()
+
+	data, err := e.FileHandler.ReadFile(path)
+	if err != nil {
+		file.Err = err
+		return file
+	}
+	lines, err := ReadScript(strings.NewReader(data))
+	if err != nil {
+		file.Err = err
+		return file
+	}
+	file.Cases, err = parseScriptTest(lines)
+	if err != nil {
+		file.Err = fmt.Errorf("%s:%w", path, err)
+		return file
+	}
+
+	f := e.newFrame(withSubshell(ctx, e.newSubshell(ctx)), path, frameScript)
+	scope := safemap.New[string, string]()
+	stopped := false
+	for _, c := range file.Cases {
+		if stopped {
+			c.Skipped = true
+			c.Failure = "not run: the script stopped"
+			continue
+		}
+		stopped = c.run(f, scope)
+	}
+	_ = f.finish(io.Discard)
+
+	if opts.Update {
+		file.Updated, file.Err = e.updateScriptTest(path, data, file.Cases)
+	}
+	return file
+}
+
+// parseScriptTest splits the lines of a test file into its commands and
+// their expectations.
+func parseScriptTest(lines []ScriptLine) ([]*ScriptTestCase, error) {
+	var cases []*ScriptTestCase
+	var last *ScriptTestCase
+	for i, l := range lines {
+		text := strings.TrimSpace(l.Text)
+		directive, arg := scriptDirective(text)
+		switch {
+		case directive != "" && last == nil:
+			return nil, fmt.Errorf("%d: %s before any command", l.Line, directive)
+		case directive == directiveOutput:
+			last.expect = append(last.expect, scriptExpectation{text: arg})
+		case directive == directiveRegexp:
+			re, err := regexp.Compile("^(?:" + arg + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", l.Line, err)
+			}
+			last.expect = append(last.expect, scriptExpectation{text: arg, re: re})
+		case directive == directiveStatus:
+			status, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%d: invalid exit status %q", l.Line, arg)
+			}
+			last.WantStatus = status
+		case text == "" || strings.HasPrefix(text, "#"):
+			continue
+		default:
+			last = &ScriptTestCase{Line: l.Line, Command: text, end: l.Line + strings.Count(strings.TrimRight(l.Text, "\n"), "\n")}
+			if i+1 < len(lines) {
+				// Backslash continuations are joined in the text
+				last.end = lines[i+1].Line - 1
+			}
+			cases = append(cases, last)
+			continue
+		}
+		last.raw = append(last.raw, text)
+	}
+	return cases, nil
+}
+
+// scriptDirective returns the directive a line of a test file is, and its argument.
+func scriptDirective(text string) (string, string) {
+	for _, directive := range []string{directiveOutput, directiveRegexp, directiveStatus} {
+		if rest, ok := strings.CutPrefix(text, directive); ok && (rest == "" || rest[0] == ' ') {
+			return directive, strings.TrimPrefix(rest, " ")
+		}
+	}
+	return "", ""
+}
+
+// run runs the command in frame f and checks it. It reports whether the
+// frame is done.
+func (c *ScriptTestCase) run(f *scriptFrame, scope *safemap.SafeMap[string, string]) bool {
+	start := time.Now()
+	var out bytes.Buffer
+	done, err := f.runLine(ScriptLine{Text: c.Command, Line: c.Line}, scope, &out)
+	c.Duration = time.Since(start)
+
+	if text := strings.TrimRight(out.String(), "\n"); text != "" {
+		c.Output = strings.Split(text, "\n")
+	}
+	c.Status = ExitStatus(err)
+	if reportable(err) {
+		var located *ScriptError
+		if errors.As(err, &located) {
+			err = located.Err
+		}
+		c.Output = append(c.Output, "✗ "+err.Error())
+	}
+	if status, stopped := f.stopStatus(); stopped {
+		c.Status = status
+	}
+
+	var failure strings.Builder
+	if len(c.expect) > 0 && !c.outputMatches() {
+		failure.WriteString(diffLines(c.expect, c.Output))
+	}
+	if c.Status != c.WantStatus {
+		fmt.Fprintf(&failure, "exit status %d, want %d\n", c.Status, c.WantStatus)
+	}
+	c.Failure = failure.String()
+	c.Passed = c.Failure == ""
+	return done
+}
+
+func (c *ScriptTestCase) outputMatches() bool {
+	if len(c.expect) != len(c.Output) {
+		return false
+	}
+	for i, x := range c.expect {
+		if !x.match(c.Output[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// diffLines returns a line diff of the expected and actual output: common
+// lines start with "  ", missing lines with "- " and unexpected ones with "+ ".
+func diffLines(want []scriptExpectation, got []string) string {
+	// Longest common subsequence, from the end
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if want[i].match(got[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var b strings.Builder
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i].match(got[j]):
+			b.WriteString("  " + got[j] + "\n")
+			i++
+			j++
+		case j < len(got) && (i == len(want) || lcs[i][j+1] >= lcs[i+1][j]):
+			b.WriteString("+ " + got[j] + "\n")
+			j++
+		default:
+			b.WriteString("- " + want[i].String() + "\n")
+			i++
+		}
+	}
+	return b.String()
+}
+
+// updateScriptTest rewrites the test file at path, whose content is data, with
+// the actual results of its cases: their output, unless it matches what they
+// expect, and their status. It reports whether the file changed.
+func (e *CommandExecutor) updateScriptTest(path, data string, cases []*ScriptTestCase) (bool, error) {
+	directives := make(map[int]bool)
+	after := make(map[int][]string)
+	for _, c := range cases {
+		if c.Skipped {
+			after[c.end] = c.raw
+			continue
+		}
+		var lines []string
+		if len(c.expect) > 0 && c.outputMatches() {
+			// Regular expressions that match are kept
+			for _, line := range c.raw {
+				if directive, _ := scriptDirective(line); directive != directiveStatus {
+					lines = append(lines, line)
+				}
+			}
+		} else {
+			for _, out := range c.Output {
+				lines = append(lines, strings.TrimRight(directiveOutput+" "+out, " "))
+			}
+		}
+		if c.Status != 0 {
+			lines = append(lines, fmt.Sprintf("%s %d", directiveStatus, c.Status))
+		}
+		after[c.end] = lines
+	}
+
+	// The directives as written are dropped, and each case's put after it
+	lines, err := ReadScript(strings.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	for _, l := range lines {
+		if directive, _ := scriptDirective(strings.TrimSpace(l.Text)); directive != "" {
+			directives[l.Line] = true
+		}
+	}
+
+	var b strings.Builder
+	for n, line := range strings.SplitAfter(data, "\n") {
+		if line == "" {
+			continue
+		}
+		if !directives[n+1] {
+			b.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				b.WriteString("\n")
+			}
+		}
+		for _, directive := range after[n+1] {
+			b.WriteString(directive + "\n")
+		}
+	}
+
+	if b.String() == data {
+		return false, nil
+	}
+	return true, e.FileHandler.WriteFile(path, b.String())
+}
+
+// Passed returns the number of cases that passed.
+func (r *ScriptTestReport) Passed() int {
+	n := 0
+	for _, f := range r.Files {
+		for _, c := range f.Cases {
+			if c.Passed {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Failed returns the number of cases that failed, and of files with errors.
+func (r *ScriptTestReport) Failed() int {
+	n := 0
+	for _, f := range r.Files {
+		n += f.Failed()
+	}
+	return n
+}
+
+// Failed returns the number of cases of the file that failed, or 1 if the
+// file has an error. Failures that were updated don't count.
+func (f *ScriptTestFile) Failed() int {
+	switch {
+	case f.Err != nil:
+		return 1
+	case f.Updated:
+		return 0
+	}
+	n := 0
+	for _, c := range f.Cases {
+		if !c.Passed {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteTAP writes the report in the Test Anything Protocol, a test point per
+// case. Failures are followed by their diff as diagnostic lines.
+func (r *ScriptTestReport) WriteTAP(w io.Writer) error {
+	var b strings.Builder
+	total := 0
+	for _, f := range r.Files {
+		if f.Err != nil || len(f.Cases) == 0 {
+			total++
+		} else {
+			total += len(f.Cases)
+		}
+	}
+	fmt.Fprintf(&b, "TAP version 13\n1..%d\n", total)
+
+	n := 0
+	for _, f := range r.Files {
+		if f.Err != nil || len(f.Cases) == 0 {
+			n++
+			if f.Err != nil {
+				fmt.Fprintf(&b, "not ok %d - %s\n# %v\n", n, f.Path, f.Err)
+			} else {
+				fmt.Fprintf(&b, "ok %d - %s # SKIP no commands\n", n, f.Path)
+			}
+			continue
+		}
+		for _, c := range f.Cases {
+			n++
+			name := fmt.Sprintf("%s:%d %s", f.Path, c.Line, firstLine(c.Command))
+			switch {
+			case c.Passed:
+				fmt.Fprintf(&b, "ok %d - %s\n", n, name)
+			case f.Updated && !c.Skipped:
+				fmt.Fprintf(&b, "ok %d - %s # updated\n", n, name)
+			default:
+				fmt.Fprintf(&b, "not ok %d - %s\n", n, name)
+				for _, line := range strings.Split(strings.TrimRight(c.Failure, "\n"), "\n") {
+					fmt.Fprintf(&b, "# %s\n", line)
+				}
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// JUnit XML report elements
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+	Error    *junitFailure   `xml:"error,omitempty"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, a test suite per file and a test
+// case per command.
+func (r *ScriptTestReport) WriteJUnit(w io.Writer) error {
+	seconds := func(d time.Duration) string { return strconv.FormatFloat(d.Seconds(), 'f', 3, 64) }
+
+	suites := junitTestSuites{Time: seconds(r.Duration)}
+	for _, f := range r.Files {
+		suite := junitTestSuite{Name: f.Path, Tests: len(f.Cases), Time: seconds(f.Duration)}
+		if f.Err != nil {
+			suite.Errors = 1
+			suite.Error = &junitFailure{Message: f.Err.Error()}
+		}
+		for _, c := range f.Cases {
+			tc := junitTestCase{
+				Name:      fmt.Sprintf("%d: %s", c.Line, firstLine(c.Command)),
+				Classname: f.Path,
+				Time:      seconds(c.Duration),
+			}
+			if !c.Passed && (c.Skipped || !f.Updated) {
+				tc.Failure = &junitFailure{Message: firstLine(c.Failure), Text: c.Failure}
+				suite.Failures++
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+	return err
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
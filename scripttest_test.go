@@ -0,0 +1,168 @@
+package consolekit
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunScriptTests(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // The functions file
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		exec.AddCommands(AddRun(exec, nil))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		failed  int
+		failure string // Failure of the first failing case
+		updated string // The file after --update, if it changes
+	}{
+		{
+			name:   "passing",
+			script: "let x=5\n#> x = 5\nprint @x\n#> 5\n\n# Regular expressions and statuses\nprint took 12ms\n#~ took \\d+ms\ntest 1 -eq 2\n#? 1\nprint a; print b\n#> a\n#> b\n",
+		},
+		{
+			name:    "output differs",
+			script:  "print a; print c\n#> a\n#> b\n#> c\n",
+			failed:  1,
+			failure: "  a\n- b\n  c\n",
+			updated: "print a; print c\n#> a\n#> c\n",
+		},
+		{
+			name:    "status differs",
+			script:  "print x\ntest 1 -eq 2\nnosuchcmd\n#? 1\n",
+			failed:  1,
+			failure: "exit status 1, want 0\n",
+			updated: "print x\n#> x\ntest 1 -eq 2\n#? 1\nnosuchcmd\n#> ✗ unknown command \"nosuchcmd\" for \"\"\n#? 1\n",
+		},
+		{
+			name:    "regular expressions are kept",
+			script:  "print took 3ms\n#~ took \\d+ms\n#? 2\n",
+			failed:  1,
+			failure: "exit status 0, want 2\n",
+			updated: "print took 3ms\n#~ took \\d+ms\n",
+		},
+		{
+			name:    "exit stops the script",
+			script:  "print a\nexit 3\n#? 3\nprint b\n#> b\n",
+			failed:  1,
+			failure: "not run: the script stopped",
+			updated: "print a\n#> a\nexit 3\n#? 3\nprint b\n#> b\n",
+		},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".run")
+			if err := os.WriteFile(path, []byte(tt.script), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := RunScriptTests(context.Background(), executor, []string{path}, ScriptTestOptions{})
+			if err != nil {
+				t.Fatalf("RunScriptTests failed: %v", err)
+			}
+			if failed := report.Failed(); failed != tt.failed {
+				t.Errorf("failed = %d, want %d", failed, tt.failed)
+			}
+			for _, c := range report.Files[0].Cases {
+				if !c.Passed {
+					if c.Failure != tt.failure {
+						t.Errorf("failure = %q, want %q", c.Failure, tt.failure)
+					}
+					break
+				}
+			}
+
+			if _, err := RunScriptTests(context.Background(), executor, []string{path}, ScriptTestOptions{Update: true}); err != nil {
+				t.Fatalf("RunScriptTests --update failed: %v", err)
+			}
+			data, _ := os.ReadFile(path)
+			want := tt.updated
+			if want == "" {
+				want = tt.script
+			}
+			if string(data) != want {
+				t.Errorf("updated file = %q, want %q", data, want)
+			}
+		})
+	}
+
+	// Reports for the whole directory, after the updates
+	report, err := RunScriptTests(context.Background(), executor, []string{dir}, ScriptTestOptions{})
+	if err != nil {
+		t.Fatalf("RunScriptTests failed: %v", err)
+	}
+	if len(report.Files) != len(tests) || report.Failed() != 1 {
+		t.Errorf("got %d files, %d failed; want %d files, 1 failed", len(report.Files), report.Failed(), len(tests))
+	}
+
+	var tap bytes.Buffer
+	if err := report.WriteTAP(&tap); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tap.String(), "TAP version 13\n1..13\n") || !strings.Contains(tap.String(), "not ok 3 - "+filepath.Join(dir, "exit-stops-the-script.run")+":5 print b\n# not run") {
+		t.Errorf("unexpected TAP report:\n%s", tap.String())
+	}
+
+	var junit bytes.Buffer
+	if err := report.WriteJUnit(&junit); err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(junit.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid JUnit XML: %v\n%s", err, junit.String())
+	}
+	if suites.Tests != 13 || len(suites.Suites) != len(tests) || suites.Suites[0].Failures != 1 {
+		t.Errorf("unexpected JUnit report:\n%s", junit.String())
+	}
+
+	out, err := executor.Execute("test-scripts "+dir, nil)
+	if ExitStatus(err) != 1 || !strings.Contains(out, "FAIL: 5 file(s), 12 passed, 1 failed") {
+		t.Errorf("test-scripts = %q, %v", out, err)
+	}
+}
+
+func TestScriptTestFiles(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	files := &memFiles{files: map[string]string{"/tests/greet.run": "print hi\n#> bye\n"}}
+	executor.FileHandler = files
+
+	if _, err := executor.Execute("test-scripts --update --junit /report.xml /tests/greet.run", nil); err != nil {
+		t.Fatalf("test-scripts failed: %v", err)
+	}
+	if got := files.files["/tests/greet.run"]; got != "print hi\n#> hi\n" {
+		t.Errorf("updated file = %q", got)
+	}
+	if report := files.files["/report.xml"]; !strings.Contains(report, "<testsuites") {
+		t.Errorf("JUnit report = %q", report)
+	}
+
+	// A FileHandler that can't list directories doesn't reveal the host's
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "host.run"), []byte("print host\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := executor.Execute("test-scripts "+dir, nil)
+	if ExitStatus(err) != 1 || strings.Contains(out, "host.run") || !strings.Contains(out, "FAIL "+dir) {
+		t.Errorf("test-scripts on a host directory = %q, %v", out, err)
+	}
+}
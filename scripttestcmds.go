@@ -0,0 +1,148 @@
+package consolekit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// AddScriptTestCommands adds the test-scripts command, which runs script tests
+func AddScriptTestCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
+	return func(rootCmd *cobra.Command) {
+
+		testScriptsCmd := &cobra.Command{
+			Use:   "test-scripts [--update] [--verbose] [--tap] [--junit file] [path...]",
+			Short: "Run script tests: .run files with their expected output",
+			Long: `Run script tests: .run files whose commands are followed by the output and
+exit status they should give, in comment lines. Directories are searched for
+.run files; the default is the current directory.
+
+  print hello @name
+  #> hello bob            an output line
+  #~ took \d+ms           an output line matching a regular expression
+  #? 1                    the exit status (default 0)
+
+Each command of a file runs in turn, in a subshell, and fails if its output
+(when it has expected output) or its status differs; the differences are
+shown as a diff. Error messages are part of the output, as "✗ message".
+
+  --update   Rewrite the files with the actual output and status of their
+             commands; regular expressions that match are kept
+  --tap      Print a TAP report instead
+  --junit    Write a JUnit XML report to file ("-" for the output)
+
+Writing files, with --update or --junit to a file, requires the
+scripts.manage permission.
+
+Examples:
+  test-scripts tests/
+  test-scripts --update tests/greet.run
+  test-scripts --junit report.xml tests/`,
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				update, _ := cmd.Flags().GetBool("update")
+				verbose, _ := cmd.Flags().GetBool("verbose")
+				tap, _ := cmd.Flags().GetBool("tap")
+				junit, _ := cmd.Flags().GetString("junit")
+				if junit != "" && junit != "-" {
+					if err := exec.authorizeUse(cmd.Context(), "test-scripts --junit", "scripts.manage"); err != nil {
+						return err
+					}
+				}
+
+				if len(args) == 0 {
+					args = []string{"."}
+				}
+				paths := make([]string, len(args))
+				for i, arg := range args {
+					paths[i] = exec.ResolvePath(cmd.Context(), arg)
+				}
+
+				report, err := RunScriptTests(cmd.Context(), exec, paths, ScriptTestOptions{Update: update})
+				if err != nil {
+					return err
+				}
+
+				switch {
+				case tap:
+					if err := report.WriteTAP(cmd.OutOrStdout()); err != nil {
+						return err
+					}
+				case junit != "-":
+					printScriptTests(cmd, report, verbose)
+				}
+
+				if junit != "" {
+					var b bytes.Buffer
+					if err := report.WriteJUnit(&b); err != nil {
+						return err
+					}
+					if junit == "-" {
+						cmd.Print(b.String())
+					} else if err := exec.FileHandler.WriteFile(exec.ResolvePath(cmd.Context(), junit), b.String()); err != nil {
+						return err
+					}
+				}
+
+				if report.Failed() > 0 {
+					return &ExitError{Status: 1}
+				}
+				return nil
+			},
+		}
+		testScriptsCmd.Flags().Bool("update", false, "Rewrite the expected output and status with the actual ones")
+		testScriptsCmd.Flags().BoolP("verbose", "v", false, "Show every command, not only failures")
+		testScriptsCmd.Flags().Bool("tap", false, "Print a TAP report")
+		testScriptsCmd.Flags().String("junit", "", "Write a JUnit XML report to file (\"-\" for the output)")
+
+		rootCmd.AddCommand(RequirePermission(testScriptsCmd, "scripts.run"))
+	}
+}
+
+// printScriptTests prints the results of script tests: the failures (every
+// command if verbose) and a line per file, then a summary.
+func printScriptTests(cmd *cobra.Command, report *ScriptTestReport, verbose bool) {
+	for _, f := range report.Files {
+		for _, c := range f.Cases {
+			if c.Passed && !verbose {
+				continue
+			}
+			mark := "✓"
+			switch {
+			case c.Skipped:
+				mark = "-"
+			case !c.Passed && f.Updated:
+				mark = "↻"
+			case !c.Passed:
+				mark = "✗"
+			}
+			cmd.Printf("%s %s:%d %s\n", mark, f.Path, c.Line, firstLine(c.Command))
+			if !c.Passed && !f.Updated {
+				for _, line := range strings.Split(strings.TrimRight(c.Failure, "\n"), "\n") {
+					cmd.Printf("    %s\n", line)
+				}
+			}
+		}
+
+		status := "ok  "
+		switch {
+		case f.Err != nil:
+			cmd.Printf("FAIL %s: %v\n", f.Path, f.Err)
+			continue
+		case f.Updated:
+			status = "upd "
+		case f.Failed() > 0:
+			status = "FAIL"
+		}
+		cmd.Printf("%s %s  %d/%d passed (%s)\n", status, f.Path, len(f.Cases)-f.Failed(), len(f.Cases), HumanizeDuration(f.Duration, false))
+	}
+
+	failed := report.Failed()
+	result := "PASS"
+	if failed > 0 {
+		result = "FAIL"
+	}
+	cmd.Println(fmt.Sprintf("%s: %d file(s), %d passed, %d failed in %s", result, len(report.Files), report.Passed(), failed, HumanizeDuration(report.Duration, false)))
+}
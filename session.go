@@ -0,0 +1,276 @@
+package consolekit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alexj212/consolekit/safemap"
+)
+
+// Session holds the state of one user connection: session-local variables,
+// aliases, the last exit status and a working directory.
+// Each TransportHandler creates a Session per connection and attaches it to the
+// context passed to the executor (see WithSession). Lookups fall back from the
+// session to the executor's global Variables and aliases.
+type Session struct {
+	ID         string    // Transport-specific session identifier
+	Transport  string    // Transport name ("repl", "ssh", "http", "socket", ...)
+	User       string    // Authenticated user, if any
+	RemoteAddr string    // Remote address of the connection, if any
+	Created    time.Time // When the session was created
+
+	// Policy restricts the commands the session may run, including nested
+	// executions and tasks it schedules. Nil allows everything.
+	Policy *TransportConfig
+
+	// Roles of the session's user under CommandExecutor.RBAC.
+	// Nil looks them up by User.
+	Roles []string
+
+	// Session-local state. Nil for a local session, which uses the global maps directly.
+	Variables *safemap.SafeMap[string, string]
+	Aliases   *safemap.SafeMap[string, string]
+
+	mu  sync.Mutex
+	dir string // Working directory (remote sessions only)
+}
+
+// NewSession creates a session with its own variables, aliases and working directory.
+// The working directory starts at the process working directory.
+func NewSession(id, transport, user string) *Session {
+	dir, _ := os.Getwd()
+	return &Session{
+		ID:        id,
+		Transport: transport,
+		User:      user,
+		Created:   time.Now(),
+		Variables: safemap.New[string, string](),
+		Aliases:   safemap.New[string, string](),
+		dir:       dir,
+	}
+}
+
+// NewLocalSession creates a session for the local user (e.g. the REPL).
+// It reads and writes the executor's global variables and aliases, and its
+// working directory is the process working directory.
+func NewLocalSession(id, transport, user string) *Session {
+	return &Session{
+		ID:        id,
+		Transport: transport,
+		User:      user,
+		Created:   time.Now(),
+	}
+}
+
+// IsLocal reports whether the session shares the executor's global state.
+func (s *Session) IsLocal() bool {
+	return s.Variables == nil
+}
+
+// Dir returns the session's working directory.
+func (s *Session) Dir() string {
+	if s.IsLocal() {
+		dir, _ := os.Getwd()
+		return dir
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dir
+}
+
+// SetDir changes the session's working directory. Relative paths are resolved
+// against the current one. A local session changes the process working directory.
+func (s *Session) SetDir(dir string) error {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.Dir(), dir)
+	}
+	dir = filepath.Clean(dir)
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", dir)
+	}
+
+	if s.IsLocal() {
+		return os.Chdir(dir)
+	}
+
+	s.mu.Lock()
+	s.dir = dir
+	s.mu.Unlock()
+	return nil
+}
+
+type sessionKey struct{}
+
+// WithSession returns a copy of ctx carrying the session.
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// SessionFromContext returns the session attached to ctx, or nil.
+func SessionFromContext(ctx context.Context) *Session {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// remoteSession returns the session in ctx if it keeps its own state, or nil.
+func remoteSession(ctx context.Context) *Session {
+	if s := SessionFromContext(ctx); s != nil && !s.IsLocal() {
+		return s
+	}
+	return nil
+}
+
+type subshellKey struct{}
+
+// newSubshell returns the variables of a subshell started from ctx: a copy of
+// the variables visible in ctx. Variables the subshell sets or unsets stay in
+// the copy, which is discarded when the subshell ends.
+func (e *CommandExecutor) newSubshell(ctx context.Context) *safemap.SafeMap[string, string] {
+	vars := safemap.New[string, string]()
+	for k, v := range e.VisibleVariables(ctx) {
+		vars.Set(k, v)
+	}
+	return vars
+}
+
+// withSubshell returns a copy of ctx for commands that run in the subshell
+// with vars (see Invocation.Subshell).
+func withSubshell(ctx context.Context, vars *safemap.SafeMap[string, string]) context.Context {
+	return context.WithValue(ctx, subshellKey{}, vars)
+}
+
+// subshellScope returns the variables of the innermost subshell ctx runs in, or nil.
+func subshellScope(ctx context.Context) *safemap.SafeMap[string, string] {
+	scope, _ := ctx.Value(subshellKey{}).(*safemap.SafeMap[string, string])
+	return scope
+}
+
+// GetVariable looks up a variable (with its @ prefix) in the session, then globally.
+func (e *CommandExecutor) GetVariable(ctx context.Context, name string) (string, bool) {
+	if scope := subshellScope(ctx); scope != nil {
+		return scope.Get(name)
+	}
+	if s := remoteSession(ctx); s != nil {
+		if v, ok := s.Variables.Get(name); ok {
+			return v, true
+		}
+	}
+	return e.Variables.Get(name)
+}
+
+// SetVariable sets a variable (with its @ prefix) in the session, or globally without one.
+func (e *CommandExecutor) SetVariable(ctx context.Context, name, value string) {
+	if scope := subshellScope(ctx); scope != nil {
+		scope.Set(name, value)
+		return
+	}
+	if s := remoteSession(ctx); s != nil {
+		s.Variables.Set(name, value)
+		return
+	}
+	e.Variables.Set(name, value)
+}
+
+// DeleteVariable removes a variable from the session, or globally without one.
+// Returns false if the variable was not set there.
+func (e *CommandExecutor) DeleteVariable(ctx context.Context, name string) bool {
+	vars := e.Variables
+	if s := remoteSession(ctx); s != nil {
+		vars = s.Variables
+	}
+	if scope := subshellScope(ctx); scope != nil {
+		vars = scope
+	}
+	if _, ok := vars.Get(name); !ok {
+		return false
+	}
+	vars.Delete(name)
+	return true
+}
+
+// VisibleVariables returns the variables visible in ctx: global values overlaid
+// with session-local ones.
+func (e *CommandExecutor) VisibleVariables(ctx context.Context) map[string]string {
+	vars := make(map[string]string)
+	if scope := subshellScope(ctx); scope != nil {
+		scope.ForEach(func(k, v string) bool {
+			vars[k] = v
+			return false
+		})
+		return vars
+	}
+	e.Variables.ForEach(func(k, v string) bool {
+		vars[k] = v
+		return false
+	})
+	if s := remoteSession(ctx); s != nil {
+		s.Variables.ForEach(func(k, v string) bool {
+			vars[k] = v
+			return false
+		})
+	}
+	return vars
+}
+
+// GetAlias looks up an alias in the session, then globally.
+func (e *CommandExecutor) GetAlias(ctx context.Context, name string) (string, bool) {
+	if s := remoteSession(ctx); s != nil {
+		if v, ok := s.Aliases.Get(name); ok {
+			return v, true
+		}
+	}
+	return e.aliases.Get(name)
+}
+
+// VisibleAliases returns the aliases visible in ctx: global aliases overlaid
+// with session-local ones.
+func (e *CommandExecutor) VisibleAliases(ctx context.Context) map[string]string {
+	aliases := make(map[string]string)
+	e.aliases.ForEach(func(k, v string) bool {
+		aliases[k] = v
+		return false
+	})
+	if s := remoteSession(ctx); s != nil {
+		s.Aliases.ForEach(func(k, v string) bool {
+			aliases[k] = v
+			return false
+		})
+	}
+	return aliases
+}
+
+// ResolvePath resolves a relative path against the session's working directory.
+func (e *CommandExecutor) ResolvePath(ctx context.Context, path string) string {
+	return resolvePath(ctx, path)
+}
+
+func resolvePath(ctx context.Context, path string) string {
+	s := remoteSession(ctx)
+	if s == nil || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(s.Dir(), path)
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
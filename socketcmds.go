@@ -256,10 +256,10 @@ Examples:
 		}
 		scriptCmd.Flags().String("shell", "", "Script type: bash or powershell (auto-detected from OS)")
 
-		socketCmd.AddCommand(startCmd)
+		socketCmd.AddCommand(RequirePermission(startCmd, "server.manage"))
 		socketCmd.AddCommand(infoCmd)
 		socketCmd.AddCommand(statusCmd)
-		socketCmd.AddCommand(stopCmd)
+		socketCmd.AddCommand(RequirePermission(stopCmd, "server.manage"))
 		socketCmd.AddCommand(scriptCmd)
 
 		// Make "start" the default action
@@ -0,0 +1,122 @@
+package consolekit
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxLoggedOutput caps how much streamed output is kept for the audit log.
+const maxLoggedOutput = 64 * 1024
+
+// limitedBuffer keeps the first max bytes written to it and discards the rest.
+// Writes never fail, so it can sit in an io.MultiWriter next to a transport.
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// OutputWriter forwards streamed command output to a transport and remembers
+// whether anything was written and whether it ended with a newline, so the
+// transport can terminate partial output before printing a prompt.
+type OutputWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	crlf   bool
+	wrote  bool
+	lastNL bool
+	lastCR bool
+}
+
+// NewOutputWriter creates an OutputWriter that writes to w.
+// If crlf is true, bare "\n" line endings are converted to "\r\n" (for terminals in raw mode).
+func NewOutputWriter(w io.Writer, crlf bool) *OutputWriter {
+	return &OutputWriter{w: w, crlf: crlf}
+}
+
+// Write implements io.Writer.
+func (o *OutputWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	data := p
+	if o.crlf {
+		var buf bytes.Buffer
+		prevCR := o.lastCR
+		for _, c := range p {
+			if c == '\n' && !prevCR {
+				buf.WriteByte('\r')
+			}
+			buf.WriteByte(c)
+			prevCR = c == '\r'
+		}
+		data = buf.Bytes()
+	}
+
+	if _, err := o.w.Write(data); err != nil {
+		return 0, err
+	}
+
+	o.wrote = true
+	o.lastNL = p[len(p)-1] == '\n'
+	o.lastCR = p[len(p)-1] == '\r'
+	return len(p), nil
+}
+
+// Wrote reports whether any output has been written.
+func (o *OutputWriter) Wrote() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.wrote
+}
+
+// EndsWithNewline reports whether the last output written ended with a newline.
+func (o *OutputWriter) EndsWithNewline() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.lastNL
+}
+
+// Terminate writes a newline if output was written and did not end with one.
+func (o *OutputWriter) Terminate() {
+	if o.Wrote() && !o.EndsWithNewline() {
+		_, _ = o.Write([]byte("\n"))
+	}
+}
+
+// chunkWriter calls send for every chunk written to it.
+// It adapts message-based transports (WebSocket, socket) to io.Writer.
+type chunkWriter struct {
+	send func(string) error
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	if err := c.send(string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
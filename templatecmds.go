@@ -104,7 +104,7 @@ func AddTemplateCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 						continue
 					}
 
-					output, err := exec.Execute(line, nil)
+					output, err := exec.ExecuteWithContext(cmd.Context(), line, nil)
 					if err != nil {
 						cmd.PrintErrln(fmt.Sprintf("Error executing line '%s': %v", line, err))
 						return
@@ -1,5 +1,15 @@
 package consolekit
 
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
 // TransportHandler defines how commands are delivered to the executor.
 // Different implementations can serve commands via different protocols:
 // - REPLHandler: Interactive terminal REPL
@@ -34,30 +44,121 @@ type TransportConfig struct {
 	// DeniedCommands prevents specific commands (nil = none denied)
 	// Takes precedence over AllowedCommands
 	DeniedCommands []string
+
+	// CommandGroups names sets of command patterns that AllowedCommands and
+	// DeniedCommands can refer to by name, e.g. {"jobs": {"job", "jobs", "schedule *"}}
+	CommandGroups map[string][]string
 }
 
 // IsCommandAllowed checks if a command is permitted based on allow/deny lists.
 // Returns true if the command is allowed, false otherwise.
+//
+// commandName is a command path such as "job kill". A list entry matches the
+// path or any of its parent commands, so "job" covers "job kill". Entries may be
+// glob patterns ("job *", "os*") or the name of a CommandGroups entry.
+// The executor checks every command it runs against the policy of the
+// originating session (see Session.Policy).
 func (c *TransportConfig) IsCommandAllowed(commandName string) bool {
 	// Check deny list first (takes precedence)
-	if c.DeniedCommands != nil {
-		for _, denied := range c.DeniedCommands {
-			if denied == commandName {
-				return false
-			}
-		}
+	if c.matches(c.DeniedCommands, commandName) {
+		return false
 	}
 
 	// If allow list is specified, command must be in it
 	if c.AllowedCommands != nil {
-		for _, allowed := range c.AllowedCommands {
-			if allowed == commandName {
-				return true
-			}
-		}
-		return false // Not in allow list
+		return c.matches(c.AllowedCommands, commandName)
 	}
 
 	// No restrictions, allow by default
 	return true
 }
+
+// matches reports whether an entry of list matches the command path or one of its parents.
+func (c *TransportConfig) matches(list []string, commandName string) bool {
+	words := strings.Fields(commandName)
+	for _, entry := range list {
+		patterns := []string{entry}
+		if group, ok := c.CommandGroups[entry]; ok {
+			patterns = group
+		}
+
+		for _, pattern := range patterns {
+			for i := len(words); i > 0; i-- {
+				if ok, _ := path.Match(pattern, strings.Join(words[:i], " ")); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Terminal lets a command interact with the user at the terminal while it
+// runs, e.g. the script debugger. Output written to it goes to the terminal
+// rather than the command's (possibly piped) output. Transports attach one to
+// the context of the commands they run (see WithTerminal).
+type Terminal interface {
+	io.Writer
+
+	// ReadLine writes prompt and returns the next line the user types,
+	// without its line ending.
+	ReadLine(ctx context.Context, prompt string) (string, error)
+}
+
+type terminalKey struct{}
+
+// WithTerminal returns a copy of ctx carrying the terminal.
+func WithTerminal(ctx context.Context, t Terminal) context.Context {
+	return context.WithValue(ctx, terminalKey{}, t)
+}
+
+// TerminalFromContext returns the terminal attached to ctx, or nil.
+func TerminalFromContext(ctx context.Context) Terminal {
+	t, _ := ctx.Value(terminalKey{}).(Terminal)
+	return t
+}
+
+// terminal returns the terminal of the user running a command in ctx: the one
+// attached to ctx, or standard input and output for the local interactive
+// REPL. It returns nil if there is none.
+func (e *CommandExecutor) terminal(ctx context.Context) Terminal {
+	if t := TerminalFromContext(ctx); t != nil {
+		return t
+	}
+	session := SessionFromContext(ctx)
+	if (session == nil || session.IsLocal()) && e.Interactive && isatty.IsTerminal(os.Stdin.Fd()) {
+		return stdioTerminal{}
+	}
+	return nil
+}
+
+// stdioTerminal is the terminal of the local REPL.
+type stdioTerminal struct{}
+
+func (stdioTerminal) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+// ReadLine reads standard input a byte at a time, so input typed after the
+// line is left for the REPL. An interrupt is noticed once the line is entered.
+func (stdioTerminal) ReadLine(ctx context.Context, prompt string) (string, error) {
+	_, _ = os.Stdout.WriteString(prompt)
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n == 1 && buf[0] != '\n' {
+			line = append(line, buf[0])
+			continue
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if n == 1 || (err == io.EOF && len(line) > 0) {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package consolekit
+
+import (
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// maxCachedTrees caps how many idle command trees are kept for reuse.
+// Concurrent executions (pipe stages, sessions) each need their own tree.
+const maxCachedTrees = 16
+
+// treeCache keeps built command trees so executions don't rebuild the whole
+// tree each time. Trees are tagged with a generation; AddCommands bumps it so
+// trees built from an older set of registrations are dropped.
+type treeCache struct {
+	mu    sync.Mutex
+	gen   uint64
+	trees []*cobra.Command
+}
+
+// invalidate drops all cached trees.
+func (c *treeCache) invalidate() {
+	c.mu.Lock()
+	c.gen++
+	c.trees = nil
+	c.mu.Unlock()
+}
+
+// acquireTree returns a command tree for exclusive use by one execution,
+// building one if none is cached. Hand it back with releaseTree.
+func (e *CommandExecutor) acquireTree() (*cobra.Command, uint64) {
+	c := &e.treeCache
+	c.mu.Lock()
+	gen := c.gen
+	if n := len(c.trees); n > 0 {
+		root := c.trees[n-1]
+		c.trees = c.trees[:n-1]
+		c.mu.Unlock()
+		return root, gen
+	}
+	c.mu.Unlock()
+
+	return e.RootCmd(), gen
+}
+
+// releaseTree resets a tree acquired with acquireTree and caches it for the next
+// execution. Trees from an older generation, or with state that can't be reset,
+// are discarded.
+func (e *CommandExecutor) releaseTree(root *cobra.Command, gen uint64) {
+	if !resetTree(root) {
+		return
+	}
+
+	c := &e.treeCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen == c.gen && len(c.trees) < maxCachedTrees {
+		c.trees = append(c.trees, root)
+	}
+}
+
+// resetTree clears the per-execution state of a command tree: flag values,
+// contexts and streams. Returns false if a flag could not be restored to its default.
+func resetTree(root *cobra.Command) bool {
+	root.SetArgs(nil)
+	root.SetIn(nil)
+	root.SetOut(nil)
+	root.SetErr(nil)
+
+	return resetCommand(root)
+}
+
+func resetCommand(cmd *cobra.Command) bool {
+	// Cobra only sets a subcommand's context if it has none
+	cmd.SetContext(nil)
+
+	ok := resetFlags(cmd)
+	for _, sub := range cmd.Commands() {
+		if !resetCommand(sub) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// resetFlags restores changed flags of cmd to their defaults and clears their
+// Changed mark. Returns false if a flag has a type whose value accumulates
+// across Set calls (slices, maps) and was changed, by the command line or by
+// ResetAllFlags, so it can't be restored.
+func resetFlags(cmd *cobra.Command) bool {
+	ok := true
+	reset := func(flag *pflag.Flag) {
+		if !flag.Changed {
+			if !resettableFlagTypes[flag.Value.Type()] && flag.Value.String() != flag.DefValue {
+				ok = false
+			}
+			return
+		}
+		if !resettableFlagTypes[flag.Value.Type()] || flag.Value.Set(flag.DefValue) != nil {
+			ok = false
+			return
+		}
+		flag.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	return ok
+}
+
+// resettableFlagTypes are the pflag value types that are fully restored by
+// setting their default value again.
+var resettableFlagTypes = map[string]bool{
+	"bool": true, "string": true, "duration": true, "count": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"float32": true, "float64": true,
+}
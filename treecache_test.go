@@ -0,0 +1,184 @@
+package consolekit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func TestTreeCacheResetsFlags(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	out, err := executor.Execute("repeat --count 3 'print x'", nil)
+	if err != nil {
+		t.Fatalf("repeat failed: %v", err)
+	}
+	if got := strings.Count(out, "Result:"); got != 3 {
+		t.Fatalf("repeat --count 3 ran %d times", got)
+	}
+
+	// The next execution reuses the cached tree; --count must be back at its default
+	out, err = executor.Execute("repeat 'print x'", nil)
+	if err != nil {
+		t.Fatalf("repeat failed: %v", err)
+	}
+	if got := strings.Count(out, "Result:"); got != 1 {
+		t.Errorf("repeat after --count 3 ran %d times, want 1", got)
+	}
+}
+
+func TestTreeCacheInvalidation(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	if _, err := executor.Execute("print warm", nil); err != nil {
+		t.Fatalf("print failed: %v", err)
+	}
+
+	executor.AddCommands(func(root *cobra.Command) {
+		root.AddCommand(&cobra.Command{
+			Use: "late",
+			Run: func(cmd *cobra.Command, args []string) {
+				cmd.Println("registered late")
+			},
+		})
+	})
+
+	out, err := executor.Execute("late", nil)
+	if err != nil {
+		t.Fatalf("late command not found after AddCommands: %v", err)
+	}
+	if out != "registered late\n" {
+		t.Errorf("late = %q, want %q", out, "registered late\n")
+	}
+}
+
+func TestResetTree(t *testing.T) {
+	tests := []struct {
+		name   string
+		define func(cmd *cobra.Command)
+		args   []string
+		reset  bool // The command calls ResetAllFlags
+		want   bool // Whether the tree can be reused
+	}{
+		{
+			name:   "scalar flags",
+			define: func(cmd *cobra.Command) { cmd.Flags().String("s", "def", ""); cmd.Flags().Int("n", 1, "") },
+			args:   []string{"sub", "--s", "x", "--n", "5"},
+			want:   true,
+		},
+		{
+			name:   "unchanged slice flag",
+			define: func(cmd *cobra.Command) { cmd.Flags().StringSlice("list", []string{"a"}, "") },
+			args:   []string{"sub"},
+			want:   true,
+		},
+		{
+			name:   "changed slice flag",
+			define: func(cmd *cobra.Command) { cmd.Flags().StringSlice("list", []string{"a"}, "") },
+			args:   []string{"sub", "--list", "b"},
+			want:   false,
+		},
+		{
+			name:   "slice flag set by ResetAllFlags",
+			define: func(cmd *cobra.Command) { cmd.Flags().StringSlice("list", nil, "") },
+			args:   []string{"sub"},
+			reset:  true,
+			want:   false,
+		},
+		{
+			name:   "scalar flag set by ResetAllFlags",
+			define: func(cmd *cobra.Command) { cmd.Flags().String("s", "def", "") },
+			args:   []string{"sub", "--s", "x"},
+			reset:  true,
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := &cobra.Command{Use: "root"}
+			sub := &cobra.Command{Use: "sub", Run: func(cmd *cobra.Command, args []string) {
+				if tt.reset {
+					ResetAllFlags(cmd)
+				}
+			}}
+			tt.define(sub)
+			root.AddCommand(sub)
+
+			root.SetArgs(tt.args)
+			if err := root.Execute(); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			if got := resetTree(root); got != tt.want {
+				t.Fatalf("resetTree() = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+
+			sub.Flags().VisitAll(func(flag *pflag.Flag) {
+				if flag.Changed || flag.Value.String() != flag.DefValue {
+					t.Errorf("flag %s = %q (changed %v), want default %q", flag.Name, flag.Value.String(), flag.Changed, flag.DefValue)
+				}
+			})
+			if sub.Context() != nil {
+				t.Error("subcommand context was not cleared")
+			}
+		})
+	}
+}
+
+func BenchmarkRootCmd(b *testing.B) {
+	executor, err := NewCommandExecutor("bench-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("Failed to create executor: %v", err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		_ = executor.RootCmd()
+	}
+}
+
+// BenchmarkExecuteUncached measures execution when every command builds its own
+// tree, as before trees were cached. Compare with BenchmarkExecute.
+func BenchmarkExecuteUncached(b *testing.B) {
+	executor, err := NewCommandExecutor("bench-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("Failed to create executor: %v", err)
+	}
+
+	commands := []string{
+		"print hello",
+		"print test | grep test",
+	}
+
+	for _, cmd := range commands {
+		b.Run(cmd, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				executor.treeCache.invalidate()
+				_, _ = executor.Execute(cmd, nil)
+			}
+		})
+	}
+}
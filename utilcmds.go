@@ -18,20 +18,26 @@ func AddUtilityCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 		whichCmd := &cobra.Command{
 			Use:   "which [command]",
 			Short: "Show information about a command",
-			Long:  `Show if a command is an alias, variable, or built-in command`,
+			Long:  `Show if a command is an alias, variable, function, script command, plugin, or built-in command`,
 			Args:  cobra.ExactArgs(1),
 			Run: func(cmd *cobra.Command, args []string) {
 				cmdName := args[0]
 
 				// Check if it's an alias
-				if val, ok := exec.aliases.Get(cmdName); ok {
+				if val, ok := exec.GetAlias(cmd.Context(), cmdName); ok {
 					cmd.Printf("%s: alias for %q\n", cmdName, val)
 					return
 				}
 
+				// Check if it's a user-defined function
+				if f, ok := exec.GetFunction(cmdName); ok {
+					cmd.Printf("%s: function\n%s\n", cmdName, f.Definition())
+					return
+				}
+
 				// Check if it's a variable
 				varName := "@" + cmdName
-				if val, ok := exec.Variables.Get(varName); ok {
+				if val, ok := exec.GetVariable(cmd.Context(), varName); ok {
 					cmd.Printf("%s: variable with value %q\n", cmdName, val)
 					return
 				}
@@ -47,6 +53,14 @@ func AddUtilityCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 				// Check root command's children
 				for _, c := range rootCmd.Commands() {
 					if c.Name() == cmdName || contains(c.Aliases, cmdName) {
+						if source := c.Annotations[scriptAnnotation]; source != "" {
+							cmd.Printf("%s: script command %s\n", cmdName, source)
+							return
+						}
+						if path := c.Annotations[pluginAnnotation]; path != "" {
+							cmd.Printf("%s: plugin %s\n", cmdName, path)
+							return
+						}
 						cmd.Printf("%s: built-in command\n", cmdName)
 						return
 					}
@@ -71,7 +85,7 @@ func AddUtilityCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 
 				// Measure execution time
 				start := time.Now()
-				output, err := exec.Execute(line, nil)
+				output, err := exec.ExecuteWithContext(cmd.Context(), line, nil)
 				duration := time.Since(start)
 
 				// Print command output
@@ -94,48 +108,38 @@ func AddUtilityCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 
 		// dot command - execute script in current context (like bash source)
 		dotCmd := &cobra.Command{
-			Use:   ". [script]",
-			Short: "Execute script in current context",
-			Long:  `Execute a script file in the current context, similar to bash 'source' command`,
-			Args:  cobra.MinimumNArgs(1),
-			Run: func(cmd *cobra.Command, args []string) {
+			Use:          ". [script]",
+			Short:        "Execute script in current context",
+			Long:         `Execute a script file in the current context, similar to bash 'source' command`,
+			Args:         cobra.MinimumNArgs(1),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
 				scriptPath := args[0]
 				scriptArgs := args[1:]
 
 				// Load the script
-				lines, err := LoadScript(exec.Scripts, cmd, scriptPath)
+				if !strings.HasPrefix(scriptPath, "@") {
+					if err := exec.authorizeUse(cmd.Context(), ". of a script file", "scripts.run"); err != nil {
+						return err
+					}
+				}
+				lines, err := loadScript(exec.Scripts, cmd, scriptPath)
 				if err != nil {
 					cmd.PrintErrf("Error loading script: %v\n", err)
-					return
+					return nil
 				}
 
-				// Execute each line in the current context (no scoped defaults)
-				// This means variables set in the script remain after execution
-				for _, line := range lines {
-					line = strings.TrimSpace(line)
-					if line == "" || strings.HasPrefix(line, "#") {
-						continue
-					}
-
-					// Replace script arguments
-					for i, arg := range scriptArgs {
-						argToken := fmt.Sprintf("@arg%d", i)
-						line = strings.ReplaceAll(line, argToken, arg)
-					}
-
-					// Execute in current context
-					output, err := exec.Execute(line, nil)
-					if output != "" {
-						cmd.Print(output)
-						if !strings.HasSuffix(output, "\n") {
-							cmd.Println()
-						}
-					}
-					if err != nil {
-						cmd.PrintErrf("Error executing line: %v\n", err)
-						return
+				// Replace script arguments
+				for i := range lines {
+					for j, arg := range scriptArgs {
+						lines[i].Text = strings.ReplaceAll(lines[i].Text, fmt.Sprintf("@arg%d", j), arg)
 					}
 				}
+
+				// Execute the lines in the current context (no scoped defaults), with the
+				// caller's options and traps. Variables set in the script remain after execution
+				f := exec.newFrame(cmd.Context(), scriptPath, frameSource)
+				return exec.runScript(f, lines, nil, cmd.OutOrStdout())
 			},
 		}
 		rootCmd.AddCommand(dotCmd)
@@ -166,6 +170,50 @@ func AddUtilityCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 			},
 		}
 		rootCmd.AddCommand(editCmd)
+
+		// cd command - change the session's working directory
+		cdCmd := &cobra.Command{
+			Use:   "cd [dir]",
+			Short: "Change the working directory",
+			Long: `Change the working directory (defaults to the home directory).
+Remote sessions (SSH, WebSocket, socket) each have their own working directory;
+relative paths used by file commands and redirections resolve against it.`,
+			Args: cobra.MaximumNArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				dir, err := os.UserHomeDir()
+				if len(args) > 0 {
+					dir, err = args[0], nil
+				}
+				if err != nil {
+					return fmt.Errorf("cd: %w", err)
+				}
+
+				session := SessionFromContext(cmd.Context())
+				if session == nil {
+					session = NewLocalSession("", "", "")
+				}
+				if err := session.SetDir(dir); err != nil {
+					return fmt.Errorf("cd: %w", err)
+				}
+				return nil
+			},
+		}
+		rootCmd.AddCommand(cdCmd)
+
+		// pwd command - print the session's working directory
+		pwdCmd := &cobra.Command{
+			Use:   "pwd",
+			Short: "Print the working directory",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				session := SessionFromContext(cmd.Context())
+				if session == nil {
+					session = NewLocalSession("", "", "")
+				}
+				cmd.Println(session.Dir())
+			},
+		}
+		rootCmd.AddCommand(pwdCmd)
 	}
 }
 
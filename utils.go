@@ -1,6 +1,9 @@
 package consolekit
 
 import (
+	"context"
+	"time"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
@@ -57,3 +60,16 @@ func ResetHelpFlagRecursively(cmd *cobra.Command) {
 	}
 }
 
+// SleepContext pauses for d or until ctx is cancelled.
+// It returns ctx.Err() if the wait was cut short.
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,247 @@
+package consolekit
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Variables hold text. A value that is a JSON array or object is structured:
+// a list or a map whose elements expansion can reach with a path
+// ("@resp.items[0].name", "@hosts[*]") and count ("@#hosts"). let stores
+// lists and maps as compact JSON, and "[a,b,c]" as a list of strings.
+
+// parseValue decodes a structured value: a JSON array ([]any) or object
+// (map[string]any). Numbers are decoded as json.Number so they keep their text.
+func parseValue(s string) (any, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || (s[0] != '[' && s[0] != '{') {
+		return nil, false
+	}
+
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+// formatValue returns the text of a value: strings and numbers as they are,
+// lists and maps as compact JSON.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// structuredValue returns the text let stores for value: compact JSON for a
+// JSON array or object, and a list of strings for "[a, b, c]". Other values are
+// returned unchanged.
+func structuredValue(value string) string {
+	trimmed := strings.TrimSpace(value)
+	var buf bytes.Buffer
+	if _, ok := parseValue(trimmed); ok && json.Compact(&buf, []byte(trimmed)) == nil {
+		return buf.String()
+	}
+
+	if len(trimmed) < 2 || trimmed[0] != '[' || trimmed[len(trimmed)-1] != ']' {
+		return value
+	}
+	list := []string{}
+	if inner := strings.TrimSpace(trimmed[1 : len(trimmed)-1]); inner != "" {
+		for _, item := range strings.Split(inner, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+	}
+	return formatValue(list)
+}
+
+// pathStep is a step of a value path: ".key", "[n]" or "[*]".
+type pathStep struct {
+	key   string
+	index int
+	isKey bool
+	all   bool // "[*]": every element
+}
+
+// parsePath parses the path steps rest starts with. It returns them and the
+// number of runes they take.
+func parsePath(rest []rune) ([]pathStep, int) {
+	var steps []pathStep
+	i := 0
+	for i < len(rest) {
+		switch rest[i] {
+		case '.':
+			n := 0
+			for i+1+n < len(rest) && (isNameRune(rest[i+1+n]) || rest[i+1+n] == '-') {
+				n++
+			}
+			if n == 0 {
+				return steps, i
+			}
+			steps = append(steps, pathStep{key: string(rest[i+1 : i+1+n]), isKey: true})
+			i += n + 1
+
+		case '[':
+			end := i + 1
+			for end < len(rest) && rest[end] != ']' {
+				end++
+			}
+			if end == len(rest) {
+				return steps, i
+			}
+			inner := string(rest[i+1 : end])
+			if inner == "*" {
+				steps = append(steps, pathStep{all: true})
+			} else if n, err := strconv.Atoi(inner); err == nil {
+				steps = append(steps, pathStep{index: n})
+			} else {
+				return steps, i
+			}
+			i = end + 1
+
+		default:
+			return steps, i
+		}
+	}
+	return steps, i
+}
+
+// followPath returns the values the steps reach from v; "[*]" yields one per
+// element. Steps that don't match yield nothing.
+func followPath(v any, steps []pathStep) []any {
+	vals := []any{v}
+	for _, step := range steps {
+		var next []any
+		for _, val := range vals {
+			switch val := val.(type) {
+			case []any:
+				switch {
+				case step.all:
+					next = append(next, val...)
+				case !step.isKey:
+					i := step.index
+					if i < 0 {
+						i += len(val)
+					}
+					if i >= 0 && i < len(val) {
+						next = append(next, val[i])
+					}
+				}
+			case map[string]any:
+				switch {
+				case step.all:
+					keys := make([]string, 0, len(val))
+					for k := range val {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						next = append(next, val[k])
+					}
+				case step.isKey:
+					if elem, ok := val[step.key]; ok {
+						next = append(next, elem)
+					}
+				}
+			}
+		}
+		vals = next
+	}
+	return vals
+}
+
+// expandValue expands a variable's value with the path at the start of rest,
+// if the value is structured. It returns the text and the runes of rest used.
+// With count, it returns the number of elements (or characters) instead.
+func expandValue(value string, rest []rune, count bool) (string, int) {
+	if !count {
+		texts, n := valueTexts(value, rest)
+		return strings.Join(texts, " "), n
+	}
+
+	v, structured := parseValue(value)
+	if !structured {
+		return strconv.Itoa(utf8.RuneCountInString(value)), 0
+	}
+
+	steps, n := parsePath(rest)
+	vals := followPath(v, steps)
+	for _, step := range steps {
+		if step.all {
+			return strconv.Itoa(len(vals)), n
+		}
+	}
+	if len(vals) == 0 {
+		return "0", n
+	}
+	switch val := vals[0].(type) {
+	case []any:
+		return strconv.Itoa(len(val)), n
+	case map[string]any:
+		return strconv.Itoa(len(val)), n
+	}
+	return strconv.Itoa(utf8.RuneCountInString(formatValue(vals[0]))), n
+}
+
+// valueTexts returns the texts of the elements of value that rest selects,
+// and the number of runes of rest the path takes: the value itself when rest
+// does not start a path, several elements for a "[*]".
+func valueTexts(value string, rest []rune) ([]string, int) {
+	v, structured := parseValue(value)
+	if !structured || len(rest) == 0 || (rest[0] != '.' && rest[0] != '[') {
+		return []string{value}, 0
+	}
+	steps, n := parsePath(rest)
+	vals := followPath(v, steps)
+	texts := make([]string, len(vals))
+	for i, val := range vals {
+		texts[i] = formatValue(val)
+	}
+	return texts, n
+}
+
+// exportValue returns the JSON of a variable for "vars --json": structured
+// values and numbers as they are, anything else as a string.
+func exportValue(value string) json.RawMessage {
+	if _, ok := parseValue(value); ok {
+		return json.RawMessage(value)
+	}
+	if value != "" && (value[0] == '-' || (value[0] >= '0' && value[0] <= '9')) && json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	data, _ := json.Marshal(value)
+	return data
+}
+
+// importValue returns the variable text of a "vars --json" value.
+func importValue(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, raw) == nil {
+		return buf.String()
+	}
+	return string(raw)
+}
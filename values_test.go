@@ -0,0 +1,168 @@
+package consolekit
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStructuredValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"[a, b,c]", `["a","b","c"]`},
+		{"[]", `[]`},
+		{`{ "a": [1, 2] }`, `{"a":[1,2]}`},
+		{`[1, "x"]`, `[1,"x"]`},
+		{"plain", "plain"},
+		{"[unclosed", "[unclosed"},
+		{"42", "42"},
+	}
+	for _, tt := range tests {
+		if got := structuredValue(tt.value); got != tt.want {
+			t.Errorf("structuredValue(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExpandValue(t *testing.T) {
+	resp := `{"items":[{"name":"first","tags":["x","y"]},{"name":"second","tags":[]}],"total":2,"ok":true}`
+	tests := []struct {
+		name  string
+		value string
+		rest  string
+		count bool
+		want  string
+		used  int // Runes of rest used
+	}{
+		{name: "whole value", value: resp, want: resp},
+		{name: "nested key", value: resp, rest: ".items[0].name rest", want: "first", used: 14},
+		{name: "number", value: resp, rest: ".total", want: "2", used: 6},
+		{name: "bool", value: resp, rest: ".ok", want: "true", used: 3},
+		{name: "list element", value: resp, rest: ".items[1]", want: `{"name":"second","tags":[]}`, used: 9},
+		{name: "negative index", value: `["a","b","c"]`, rest: "[-1]", want: "c", used: 4},
+		{name: "spread", value: `["a","b","c"]`, rest: "[*]", want: "a b c", used: 3},
+		{name: "spread of keys", value: resp, rest: ".items[*].name", want: "first second", used: 14},
+		{name: "spread of a map", value: `{"b":2,"a":1}`, rest: "[*]", want: "1 2", used: 3},
+		{name: "missing key", value: resp, rest: ".nope", want: "", used: 5},
+		{name: "out of range", value: `["a"]`, rest: "[3]", want: "", used: 3},
+		{name: "key of a list", value: `["a"]`, rest: ".x", want: "", used: 2},
+		{name: "trailing dot", value: `["a"]`, rest: ".", want: `["a"]`},
+		{name: "string value", value: "report", rest: ".txt", want: "report"},
+		{name: "count list", value: `["a","b","c"]`, count: true, want: "3"},
+		{name: "count map", value: resp, count: true, want: "3"},
+		{name: "count nested", value: resp, rest: ".items[0].tags", count: true, want: "2", used: 14},
+		{name: "count spread", value: resp, rest: ".items[*]", count: true, want: "2", used: 9},
+		{name: "count string", value: "héllo", count: true, want: "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, used := expandValue(tt.value, []rune(tt.rest), tt.count)
+			if got != tt.want || used != tt.used {
+				t.Errorf("expandValue(%q, %q) = %q, %d; want %q, %d", tt.value, tt.rest, got, used, tt.want, tt.used)
+			}
+		})
+	}
+}
+
+func TestExportImportValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string // JSON
+	}{
+		{"hello", `"hello"`},
+		{"42", `42`},
+		{"-1.5", `-1.5`},
+		{"007", `"007"`},
+		{"", `""`},
+		{`["a","b"]`, `["a","b"]`},
+		{`{"a":1}`, `{"a":1}`},
+		{"[not json", `"[not json"`},
+	}
+	for _, tt := range tests {
+		raw := exportValue(tt.value)
+		if string(raw) != tt.want {
+			t.Errorf("exportValue(%q) = %s, want %s", tt.value, raw, tt.want)
+		}
+		if got := importValue(raw); got != tt.value {
+			t.Errorf("importValue(%s) = %q, want %q", raw, got, tt.value)
+		}
+	}
+}
+
+func TestStructuredVariables(t *testing.T) {
+	executor, err := NewCommandExecutor("test-app", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	files := &memFiles{files: make(map[string]string)}
+	executor.FileHandler = files
+
+	setup := []string{
+		"let hosts=[web1, web2,db1]",
+		`let resp='{"items": [{"name": "a b"}, {"name": "c"}]}'`,
+		"let name=@resp.items[1].name",
+		// Unquoted, the output of $( ) is still a single word with its quotes
+		`let sub=$(print '{"items": [{"name": "x  y"}]}')`,
+	}
+	for _, line := range setup {
+		if _, err := executor.Execute(line, nil); err != nil {
+			t.Fatalf("Execute(%q) failed: %v", line, err)
+		}
+	}
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`print "@hosts"`, `["web1","web2","db1"]` + "\n"},
+		{"print @#hosts @hosts[0] @hosts[-1]", "3 web1 db1\n"},
+		{`for h in @hosts[*] do "print host-@h"`, "host-web1\nhost-web2\nhost-db1\n"},
+		{`print "@resp.items[0].name"`, "a b\n"},
+		{"print @name", "c\n"},
+		{"print @resp.items[0].missing.", ".\n"},
+		{`print "@sub.items[0].name"`, "x  y\n"},
+		{"print $(print 'a; b | c')", "a; b | c\n"},
+		{"print $(( $(print 2) + 1 ))", "3\n"},
+	}
+	for _, tt := range tests {
+		out, err := executor.Execute(tt.line, nil)
+		if err != nil {
+			t.Fatalf("Execute(%q) failed: %v", tt.line, err)
+		}
+		if out != tt.want {
+			t.Errorf("Execute(%q) = %q, want %q", tt.line, out, tt.want)
+		}
+	}
+
+	// vars --json round-trips through --load
+	out, err := executor.Execute("vars --json > /vars.json", nil)
+	if err != nil {
+		t.Fatalf("vars --json failed: %v (%s)", err, out)
+	}
+	var exported map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(files.files["/vars.json"]), &exported); err != nil {
+		t.Fatalf("vars --json is not JSON: %v\n%s", err, files.files["/vars.json"])
+	}
+	if got := importValue(exported["hosts"]); got != `["web1","web2","db1"]` {
+		t.Errorf("exported hosts = %s", got)
+	}
+
+	want, _ := executor.Variables.Get("@resp")
+	executor.Variables.Clear()
+	if _, err := executor.Execute("vars --load /vars.json", nil); err != nil {
+		t.Fatalf("vars --load failed: %v", err)
+	}
+	if got, _ := executor.GetVariable(context.Background(), "@resp"); got != want {
+		t.Errorf("loaded resp = %q, want %q", got, want)
+	}
+	out, _ = executor.Execute("print @#hosts @name", nil)
+	if strings.TrimSpace(out) != "3 c" {
+		t.Errorf("after --load: %q", out)
+	}
+}
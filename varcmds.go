@@ -3,7 +3,6 @@ package consolekit
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 
@@ -21,12 +20,28 @@ func AddVariableCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 			Long: `Set a variable with enhanced features:
   let name=value           - Simple assignment
   let counter=0            - Numeric values
-  let result=$(print hi)   - Command substitution
+  let result=$(print hi)   - Command substitution, kept as a single word
   let path="$HOME/data"    - Environment variable expansion
   let counter=$((counter+1)) - Arithmetic operations
-  let "result=$((5 * 3))"  - Use quotes for expressions with spaces`,
-			Args: cobra.MinimumNArgs(1),
-			Run: func(cmd *cobra.Command, args []string) {
+  let "result=$((5 * 3))"  - Use quotes for expressions with spaces
+  let hosts=[a,b,c]        - A list
+  let resp=$(http --json example.com/api) - A JSON object or array
+
+Lists and maps are stored as JSON; expand their elements with @resp.items[0].name,
+all of them with @hosts[*], and count them with @#hosts.
+
+Variables are local to the session (SSH, WebSocket, socket connection).
+Use --global to set the value for every session.`,
+			Args:         cobra.MinimumNArgs(1),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				global, _ := cmd.Flags().GetBool("global")
+				if global {
+					if err := exec.authorizeUse(cmd.Context(), "let --global", "variables.manage"); err != nil {
+						return err
+					}
+				}
+
 				// Join args back together to handle cases where spaces split the expression
 				// Then split by unquoted = to find assignments
 				fullLine := strings.Join(args, " ")
@@ -54,93 +69,124 @@ func AddVariableCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 					// 3. Environment variable expansion $VAR
 					// 4. ConsoleKit variable expansion @var
 
-					var err error
-					value, err = processValueExpansions(value, exec)
-					if err != nil {
-						cmd.PrintErrf("Error processing '%s': %v\n", assignment, err)
-						continue
+					// Lists and maps are stored as compact JSON, without expanding their contents
+					if structured := structuredValue(value); structured != value {
+						value = structured
+					} else {
+						var err error
+						value, err = processValueExpansions(cmd.Context(), value, exec)
+						if err != nil {
+							cmd.PrintErrf("Error processing '%s': %v\n", assignment, err)
+							continue
+						}
 					}
 
 					// Store with @ prefix for consistency with token system
 					varName := "@" + name
-					exec.Variables.Set(varName, value)
+					if global {
+						exec.Variables.Set(varName, value)
+					} else {
+						exec.SetVariable(cmd.Context(), varName, value)
+					}
 					cmd.Printf("%s = %s\n", name, value)
 				}
+				return nil
 			},
 		}
+		letCmd.Flags().BoolP("global", "g", false, "Set the variable for all sessions")
 
 		// unset command - remove variables
 		unsetCmd := &cobra.Command{
-			Use:   "unset [name...]",
-			Short: "Remove one or more variables",
-			Args:  cobra.MinimumNArgs(1),
-			Run: func(cmd *cobra.Command, args []string) {
+			Use:          "unset [name...]",
+			Short:        "Remove one or more variables",
+			Args:         cobra.MinimumNArgs(1),
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				global, _ := cmd.Flags().GetBool("global")
+				if global {
+					if err := exec.authorizeUse(cmd.Context(), "unset --global", "variables.manage"); err != nil {
+						return err
+					}
+				}
+
 				for _, name := range args {
 					varName := "@" + name
-					if _, ok := exec.Variables.Get(varName); ok {
-						exec.Variables.Delete(varName)
+
+					removed := false
+					if global {
+						if _, ok := exec.Variables.Get(varName); ok {
+							exec.Variables.Delete(varName)
+							removed = true
+						}
+					} else {
+						removed = exec.DeleteVariable(cmd.Context(), varName)
+					}
+
+					if removed {
 						cmd.Printf("Removed variable: %s\n", name)
 					} else {
 						cmd.Printf("Variable not found: %s\n", name)
 					}
 				}
+				return nil
 			},
 		}
+		unsetCmd.Flags().BoolP("global", "g", false, "Remove the global variable")
 
 		// vars command - list all variables
 		varsCmd := &cobra.Command{
-			Use:   "vars",
-			Short: "List all variables",
-			Run: func(cmd *cobra.Command, args []string) {
+			Use:          "vars",
+			Short:        "List all variables",
+			SilenceUsage: true,
+			RunE: func(cmd *cobra.Command, args []string) error {
 				export, _ := cmd.Flags().GetBool("export")
 				jsonFormat, _ := cmd.Flags().GetBool("json")
+				load, _ := cmd.Flags().GetString("load")
+
+				if load != "" {
+					if err := exec.authorizeUse(cmd.Context(), "vars --load", "variables.manage"); err != nil {
+						return err
+					}
+					loadJSON(cmd, exec, load)
+					return nil
+				}
 
 				if jsonFormat {
 					exportJSON(cmd, exec)
-					return
+					return nil
 				}
 
 				if export {
 					exportShell(cmd, exec)
-					return
+					return nil
 				}
 
 				// Default: pretty print
-				vars := make(map[string]string)
-				exec.Variables.ForEach(func(k, v string) bool {
-					if strings.HasPrefix(k, "@") && !strings.HasPrefix(k, "@arg") && !strings.HasPrefix(k, "@env:") && !strings.HasPrefix(k, "@exec:") {
-						// Remove @ prefix for display
-						varName := strings.TrimPrefix(k, "@")
-						vars[varName] = v
-					}
-					return false
-				})
+				vars := visibleUserVariables(cmd, exec)
 
 				if len(vars) == 0 {
 					cmd.Println("No variables set")
-					return
+					return nil
 				}
 
 				cmd.Println("Variables:")
 				cmd.Println(strings.Repeat("-", 60))
 
 				// Sort and display
-				exec.Variables.SortedForEach(func(k, v string) bool {
-					if strings.HasPrefix(k, "@") && !strings.HasPrefix(k, "@arg") && !strings.HasPrefix(k, "@env:") && !strings.HasPrefix(k, "@exec:") {
-						varName := strings.TrimPrefix(k, "@")
-						// Truncate long values
-						displayValue := v
-						if len(displayValue) > 50 {
-							displayValue = displayValue[:47] + "..."
-						}
-						cmd.Printf("%-20s = %s\n", varName, displayValue)
+				for _, varName := range sortedKeys(vars) {
+					// Truncate long values
+					displayValue := vars[varName]
+					if len(displayValue) > 50 {
+						displayValue = displayValue[:47] + "..."
 					}
-					return false
-				})
+					cmd.Printf("%-20s = %s\n", varName, displayValue)
+				}
+				return nil
 			},
 		}
 		varsCmd.Flags().Bool("export", false, "Export variables as shell script")
 		varsCmd.Flags().Bool("json", false, "Export variables as JSON")
+		varsCmd.Flags().String("load", "", "Set the variables of a JSON file written by --json")
 
 		// increment command - increment a numeric variable
 		incCmd := &cobra.Command{
@@ -163,7 +209,7 @@ func AddVariableCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 
 				varName := "@" + name
 				currentValue := "0"
-				if val, ok := exec.Variables.Get(varName); ok {
+				if val, ok := exec.GetVariable(cmd.Context(), varName); ok {
 					currentValue = val
 				}
 
@@ -174,7 +220,7 @@ func AddVariableCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 				}
 
 				newValue := current + amount
-				exec.Variables.Set(varName, strconv.Itoa(newValue))
+				exec.SetVariable(cmd.Context(), varName, strconv.Itoa(newValue))
 				cmd.Printf("%s = %d\n", name, newValue)
 			},
 		}
@@ -200,7 +246,7 @@ func AddVariableCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 
 				varName := "@" + name
 				currentValue := "0"
-				if val, ok := exec.Variables.Get(varName); ok {
+				if val, ok := exec.GetVariable(cmd.Context(), varName); ok {
 					currentValue = val
 				}
 
@@ -211,7 +257,7 @@ func AddVariableCommands(exec *CommandExecutor) func(cmd *cobra.Command) {
 				}
 
 				newValue := current - amount
-				exec.Variables.Set(varName, strconv.Itoa(newValue))
+				exec.SetVariable(cmd.Context(), varName, strconv.Itoa(newValue))
 				cmd.Printf("%s = %d\n", name, newValue)
 			},
 		}
@@ -279,30 +325,34 @@ func parseAssignments(line string) []string {
 	return assignments
 }
 
+// visibleUserVariables returns the user variables visible to cmd's session, without the @ prefix.
+func visibleUserVariables(cmd *cobra.Command, exec *CommandExecutor) map[string]string {
+	vars := make(map[string]string)
+	for k, v := range exec.VisibleVariables(cmd.Context()) {
+		if strings.HasPrefix(k, "@") && !strings.HasPrefix(k, "@arg") && !strings.HasPrefix(k, "@env:") && !strings.HasPrefix(k, "@exec:") && k != lastStatusVar {
+			vars[strings.TrimPrefix(k, "@")] = v
+		}
+	}
+	return vars
+}
+
 // exportShell exports variables as shell script
 func exportShell(cmd *cobra.Command, exec *CommandExecutor) {
 	cmd.Println("# Variable export")
-	exec.Variables.SortedForEach(func(k, v string) bool {
-		if strings.HasPrefix(k, "@") && !strings.HasPrefix(k, "@arg") && !strings.HasPrefix(k, "@env:") && !strings.HasPrefix(k, "@exec:") {
-			varName := strings.TrimPrefix(k, "@")
-			// Escape quotes in value
-			escapedValue := strings.ReplaceAll(v, "\"", "\\\"")
-			cmd.Printf("export %s=\"%s\"\n", strings.ToUpper(varName), escapedValue)
-		}
-		return false
-	})
+	vars := visibleUserVariables(cmd, exec)
+	for _, varName := range sortedKeys(vars) {
+		// Escape quotes in value
+		escapedValue := strings.ReplaceAll(vars[varName], "\"", "\\\"")
+		cmd.Printf("export %s=\"%s\"\n", strings.ToUpper(varName), escapedValue)
+	}
 }
 
-// exportJSON exports variables as JSON
+// exportJSON exports variables as JSON, with lists, maps and numbers as JSON values
 func exportJSON(cmd *cobra.Command, exec *CommandExecutor) {
-	vars := make(map[string]string)
-	exec.Variables.ForEach(func(k, v string) bool {
-		if strings.HasPrefix(k, "@") && !strings.HasPrefix(k, "@arg") && !strings.HasPrefix(k, "@env:") && !strings.HasPrefix(k, "@exec:") {
-			varName := strings.TrimPrefix(k, "@")
-			vars[varName] = v
-		}
-		return false
-	})
+	vars := make(map[string]json.RawMessage)
+	for name, value := range visibleUserVariables(cmd, exec) {
+		vars[name] = exportValue(value)
+	}
 
 	data, err := json.MarshalIndent(vars, "", "  ")
 	if err != nil {
@@ -310,9 +360,27 @@ func exportJSON(cmd *cobra.Command, exec *CommandExecutor) {
 		return
 	}
 
-	fmt.Fprintln(os.Stdout, string(data))
+	cmd.Println(string(data))
 }
 
+// loadJSON sets the variables of a JSON object written by exportJSON
+func loadJSON(cmd *cobra.Command, exec *CommandExecutor, path string) {
+	content, err := exec.FileHandler.ReadFile(exec.ResolvePath(cmd.Context(), path))
+	if err != nil {
+		cmd.PrintErrf("Error reading %s: %v\n", path, err)
+		return
+	}
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &vars); err != nil {
+		cmd.PrintErrf("Error decoding %s: %v\n", path, err)
+		return
+	}
+	for _, name := range sortedKeys(vars) {
+		exec.SetVariable(cmd.Context(), "@"+name, importValue(vars[name]))
+	}
+	cmd.Printf("Loaded %d variable(s)\n", len(vars))
+}
 
 // evaluateArithmetic evaluates a simple arithmetic expression
 // Supports: +, -, *, /, %, (, )
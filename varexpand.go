@@ -1,152 +1,383 @@
 package consolekit
 
 import (
+	"context"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
-)
+	"unicode"
 
-// processValueExpansions handles all value expansions in order
-func processValueExpansions(value string, exec *CommandExecutor) (string, error) {
-	// Remove surrounding quotes if present
-	value = strings.Trim(value, "\"'")
+	"github.com/alexj212/consolekit/safemap"
+	"github.com/kballard/go-shellquote"
+)
 
-	// Process in order, but only once each to avoid infinite loops
-	// 1. Arithmetic expansion $((...))
-	value = expandArithmetic(value, exec)
+// expander expands the variables and substitutions of a line in a single pass,
+// left to right:
+//   - @name: a variable of the session (or global), then of the scope. The name
+//     is the longest defined one of its ":", "." or "-" separated parts, so
+//     "@ssh:user" and "@host:8080" both work; "@ab" never matches "@a"
+//   - @name.key[0], @name[*]: elements of a list or map variable (see values.go);
+//     "[*]" gives every element, separated by spaces
+//   - @#name: the number of elements of a list or map, or of characters
+//   - @? and @!: the last exit status and the last "&" job ID
+//   - @env:NAME: an environment variable
+//   - @exec:cmd: the output of cmd, which runs to the end of the word
+//   - $(cmd): the output of cmd
+//   - $((expr)): an arithmetic expression; bare names are variables
+//   - ${NAME}: an environment variable
+//
+// "@" only starts a reference at the start of a word or after punctuation
+// (not in "alice@example.com"). Nothing is expanded inside single quotes or
+// after a backslash, undefined variables are left as they are, and the text an
+// expansion produces is not expanded again. Inside double quotes, the quotes
+// and backslashes of that text are escaped. When expanding a command line, the
+// text of an expansion outside quotes is quoted, so it stays a single word
+// with its quotes and operators as they are: let resp=$(http --json url) keeps
+// the JSON intact, and a variable holding "a; b" can't start a second command.
+// The elements "[*]" gives are quoted one by one, so each is a word.
+type expander struct {
+	e          *CommandExecutor
+	ctx        context.Context
+	scope      *safemap.SafeMap[string, string]
+	words      bool     // Quote expansions outside quotes as single words of the line
+	dollarVars bool     // Also expand "$NAME" environment variables, as let does
+	dryRun     bool     // Don't run command substitutions; they expand to nothing
+	unbound    []string // The "@name"s left as they are because they are undefined
+}
 
-	// 2. Command substitution $(...)
-	value = expandCommandSubstitution(value, exec)
+// expand returns input with its expansions replaced.
+func (x *expander) expand(input string) string {
+	if !strings.ContainsAny(input, "@$") {
+		return input
+	}
 
-	// 3. Environment variable expansion $VAR or ${VAR}
-	value = expandEnvVars(value)
+	var b strings.Builder
+	b.Grow(len(input))
+	inSingleQuote, inDoubleQuote, escaped := false, false, false
 
-	// 4. ConsoleKit variable expansion @var
-	value = expandConsoleKitVars(value, exec)
+	runes := []rune(input)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !inSingleQuote:
+			escaped = true
+		case c == '\'' && !inDoubleQuote:
+			inSingleQuote = !inSingleQuote
+		case c == '"' && !inSingleQuote:
+			inDoubleQuote = !inDoubleQuote
+		case inSingleQuote:
+		case c == '$' || (c == '@' && (i == 0 || !isNameRune(runes[i-1]))):
+			text, n, ok := x.expansion(runes[i:], inDoubleQuote)
+			if !ok {
+				break
+			}
+			if inDoubleQuote {
+				text = doubleQuoteEscaper.Replace(text)
+			}
+			b.WriteString(text)
+			i += n - 1
+			continue
+		}
+		b.WriteRune(c)
+	}
 
-	return value, nil
+	return b.String()
 }
 
-// expandArithmetic handles $((...)) arithmetic expressions
-func expandArithmetic(value string, exec *CommandExecutor) string {
-	// Find all $((...)) patterns - need to handle nested parentheses
-	// Use a manual parser instead of regex for better handling
-	result := value
-	for {
-		start := strings.Index(result, "$((")
-		if start == -1 {
-			break
-		}
+// doubleQuoteEscaper escapes expanded text inside double quotes.
+var doubleQuoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// expansion expands the "@" or "$" reference that rest starts with. It returns
+// the replacement text and the number of runes it replaces, or false if rest
+// does not start with an expandable reference.
+func (x *expander) expansion(rest []rune, inDoubleQuote bool) (string, int, bool) {
+	if len(rest) < 2 {
+		return "", 0, false
+	}
 
-		// Find matching ))
-		depth := 0
-		end := -1
-		for i := start + 3; i < len(result)-1; i++ {
-			if result[i] == '(' {
-				depth++
-			} else if result[i] == ')' {
-				if result[i+1] == ')' && depth == 0 {
-					end = i + 2
-					break
-				}
-				if depth > 0 {
-					depth--
-				}
+	if rest[0] == '$' {
+		switch {
+		case rest[1] == '(':
+			end := closingParen(rest, 1)
+			if end == -1 {
+				return "", 0, false
 			}
-		}
+			if rest[2] == '(' && closingParen(rest, 2) == end-1 {
+				return x.arithmetic(string(rest[3 : end-1])), end + 1, true
+			}
+			return x.word(x.substitute(string(rest[2:end])), inDoubleQuote), end + 1, true
 
-		if end == -1 {
-			// No matching )), skip this one
-			break
+		case rest[1] == '{':
+			n := nameLen(rest[2:])
+			if n == 0 || 2+n >= len(rest) || rest[2+n] != '}' {
+				return "", 0, false
+			}
+			return x.word(os.Getenv(string(rest[2:2+n])), inDoubleQuote), n + 3, true
+
+		case x.dollarVars:
+			n := nameLen(rest[1:])
+			if n == 0 {
+				return "", 0, false
+			}
+			return x.word(os.Getenv(string(rest[1:1+n])), inDoubleQuote), n + 1, true
 		}
+		return "", 0, false
+	}
 
-		// Extract and evaluate
-		expr := result[start+3 : end-2]
-		expr = expandArithmeticVars(expr, exec)
+	switch {
+	case rest[1] == '?' || rest[1] == '!':
+		v, ok := x.lookup(string(rest[:2]))
+		return v, 2, ok
 
-		evalResult, err := evaluateArithmetic(expr)
-		if err != nil {
-			evalResult = 0 // Return 0 on error
+	case hasRunePrefix(rest, "@env:"):
+		n := nameLen(rest[5:])
+		v, ok := os.LookupEnv(string(rest[5 : 5+n]))
+		return x.word(v, inDoubleQuote), 5 + n, ok && n > 0
+
+	case hasRunePrefix(rest, "@exec:"):
+		end := execEnd(rest, 6, inDoubleQuote)
+		cmdLine := string(rest[6:end])
+		if !inDoubleQuote {
+			if words, err := shellquote.Split(cmdLine); err == nil {
+				cmdLine = strings.Join(words, " ")
+			}
 		}
+		if strings.TrimSpace(cmdLine) == "" {
+			return "", 0, false
+		}
+		return x.word(x.substitute(cmdLine), inDoubleQuote), end, true
+
+	case rest[1] == '#':
+		// "@#name": the number of elements of a list or map, or of characters
+		value, n, ok := x.variable(rest[1:])
+		if !ok {
+			return "", 0, false
+		}
+		text, m := expandValue(value, rest[1+n:], true)
+		return text, 1 + n + m, true
+	}
 
-		result = result[:start] + strconv.Itoa(evalResult) + result[end:]
+	value, n, ok := x.variable(rest)
+	if !ok {
+		return "", 0, false
 	}
+	texts, m := valueTexts(value, rest[n:])
+	for i, text := range texts {
+		texts[i] = x.word(text, inDoubleQuote)
+	}
+	return strings.Join(texts, " "), n + m, true
+}
 
-	return result
+// word returns the text of an expansion as it goes into the line: outside
+// double quotes, a command line gets it as a single word (see quoteWord).
+func (x *expander) word(text string, inDoubleQuote bool) string {
+	if x.words && !inDoubleQuote {
+		return quoteWord(text)
+	}
+	return text
 }
 
-// expandArithmeticVars expands variable names (without @) in arithmetic expressions
-func expandArithmeticVars(expr string, exec *CommandExecutor) string {
-	// Pattern for variable names (letters/underscore followed by alphanumeric)
-	// that are NOT already prefixed with @
-	pattern := regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_]*)\b`)
-	return pattern.ReplaceAllStringFunc(expr, func(match string) string {
-		// Try to get the variable with @ prefix
-		varName := "@" + match
-		if val, ok := exec.Variables.Get(varName); ok {
-			return val
+// variable looks up the variable named after rest[0]. The longest defined name
+// wins: "@a:b:c", then "@a:b", then "@a". It returns the value and the number
+// of runes of rest the reference takes.
+func (x *expander) variable(rest []rune) (string, int, bool) {
+	if len(rest) < 2 || (!unicode.IsLetter(rest[1]) && rest[1] != '_') {
+		return "", 0, false
+	}
+	var ends []int
+	for j := 1; ; j++ {
+		n := nameRunLen(rest[j:])
+		if n == 0 {
+			break
+		}
+		j += n
+		ends = append(ends, j)
+		if j == len(rest) || !strings.ContainsRune(":.-", rest[j]) {
+			break
 		}
-		// If not found, return original (might be a number or operator)
-		return match
-	})
+	}
+	for k := len(ends) - 1; k >= 0; k-- {
+		if v, ok := x.lookup("@" + string(rest[1:ends[k]])); ok {
+			return v, ends[k], true
+		}
+	}
+	if len(ends) > 0 {
+		x.unbound = append(x.unbound, "@"+string(rest[1:ends[0]]))
+	}
+	return "", 0, false
 }
 
-// expandCommandSubstitution handles $(...) command substitution
-func expandCommandSubstitution(value string, exec *CommandExecutor) string {
-	// Pattern that doesn't match $((...))
-	pattern := regexp.MustCompile(`\$\(([^(][^)]*)\)`)
+// lookup returns the value of a variable (with its @ prefix): the session's or
+// the global one, then the scope's.
+func (x *expander) lookup(name string) (string, bool) {
+	if v, ok := x.e.GetVariable(x.ctx, name); ok {
+		return v, true
+	}
+	if x.scope != nil {
+		return x.scope.Get(name)
+	}
+	return "", false
+}
+
+// substitute runs cmdLine and returns its output without surrounding whitespace.
+func (x *expander) substitute(cmdLine string) string {
+	if x.dryRun {
+		return ""
+	}
+	out, _ := x.e.ExecuteWithContext(x.ctx, cmdLine, x.scope)
+	return strings.TrimSpace(out)
+}
 
-	// Find all matches first
-	matches := pattern.FindAllStringSubmatchIndex(value, -1)
-	if len(matches) == 0 {
-		return value
+// arithmetic evaluates expr, whose bare names are variables. Invalid
+// expressions evaluate to 0.
+func (x *expander) arithmetic(expr string) string {
+	// Substitutions are operands here, not words of the line
+	inner := *x
+	inner.words = false
+	expr = expandArithmeticVars(x.ctx, inner.expand(expr), x.e)
+	x.unbound = inner.unbound
+	result, err := evaluateArithmetic(expr)
+	if err != nil {
+		result = 0
 	}
+	return strconv.Itoa(result)
+}
 
-	// Process in reverse to maintain indices
-	result := value
-	for i := len(matches) - 1; i >= 0; i-- {
-		match := matches[i]
-		cmdToExec := value[match[2]:match[3]]
+// quoteWord single-quotes text if it has characters the parser would split
+// or interpret, so it parses back to a single word of the same text.
+func quoteWord(text string) string {
+	if text == "" || !strings.ContainsAny(text, " \t\n\r'\"\\|&;<>(){}#*?[]~") {
+		return text
+	}
+	return "'" + strings.ReplaceAll(text, "'", `'"'"'`) + "'"
+}
 
-		cmdResult, err := exec.Execute(cmdToExec, nil)
-		if err != nil {
-			continue // Skip on error
+// closingParen returns the index of the ")" matching the "(" at runes[open],
+// ignoring quoted parentheses, or -1 if it is not closed.
+func closingParen(runes []rune, open int) int {
+	depth := 0
+	inSingleQuote, inDoubleQuote, escaped := false, false, false
+	for i := open; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !inSingleQuote:
+			escaped = true
+		case c == '\'' && !inDoubleQuote:
+			inSingleQuote = !inSingleQuote
+		case c == '"' && !inSingleQuote:
+			inDoubleQuote = !inDoubleQuote
+		case inSingleQuote || inDoubleQuote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
 		}
+	}
+	return -1
+}
 
-		result = result[:match[0]] + strings.TrimSpace(cmdResult) + result[match[1]:]
+// execEnd returns the end of the command of an "@exec:" reference starting at
+// rest[start]: the closing double quote inside double quotes, otherwise the
+// next unquoted space or operator.
+func execEnd(rest []rune, start int, inDoubleQuote bool) int {
+	inSingleQuote, escaped := false, false
+	for i := start; i < len(rest); i++ {
+		c := rest[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && !inSingleQuote:
+			escaped = true
+		case inDoubleQuote:
+			if c == '"' {
+				return i
+			}
+		case c == '\'':
+			inSingleQuote = !inSingleQuote
+		case c == '"':
+			// A quoted part of the word
+			for i++; i < len(rest) && rest[i] != '"'; i++ {
+			}
+		case inSingleQuote:
+		case unicode.IsSpace(c) || strings.ContainsRune(";|&<>()", c):
+			return i
+		}
 	}
+	return len(rest)
+}
 
-	return result
+// nameLen returns the length of the environment variable name rest starts with.
+func nameLen(rest []rune) int {
+	if len(rest) == 0 || (!unicode.IsLetter(rest[0]) && rest[0] != '_') {
+		return 0
+	}
+	return nameRunLen(rest)
 }
 
-// expandEnvVars expands environment variables in the format $VAR or ${VAR}
-func expandEnvVars(value string) string {
-	// Pattern for ${VAR}
-	bracePattern := regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
-	value = bracePattern.ReplaceAllStringFunc(value, func(match string) string {
-		varName := match[2 : len(match)-1]
-		return os.Getenv(varName)
-	})
+// nameRunLen returns the number of name runes rest starts with.
+func nameRunLen(rest []rune) int {
+	n := 0
+	for n < len(rest) && isNameRune(rest[n]) {
+		n++
+	}
+	return n
+}
 
-	// Pattern for $VAR (simpler, just alphanumeric after $)
-	simplePattern := regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)`)
-	value = simplePattern.ReplaceAllStringFunc(value, func(match string) string {
-		varName := match[1:]
-		return os.Getenv(varName)
-	})
+// isNameRune reports whether c can be part of a variable name.
+func isNameRune(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
 
-	return value
+// hasRunePrefix reports whether runes start with prefix.
+func hasRunePrefix(runes []rune, prefix string) bool {
+	p := []rune(prefix)
+	if len(runes) < len(p) {
+		return false
+	}
+	for i, c := range p {
+		if runes[i] != c {
+			return false
+		}
+	}
+	return true
 }
 
-// expandConsoleKitVars expands ConsoleKit variables in the format @var
-func expandConsoleKitVars(value string, exec *CommandExecutor) string {
-	pattern := regexp.MustCompile(`@([A-Za-z_][A-Za-z0-9_]*)`)
-	return pattern.ReplaceAllStringFunc(value, func(match string) string {
-		varName := match // Keep @ prefix
-		if val, ok := exec.Variables.Get(varName); ok {
-			return val
+// processValueExpansions expands the value of a let assignment, including
+// "$NAME" environment variables.
+func processValueExpansions(ctx context.Context, value string, exec *CommandExecutor) (string, error) {
+	// Remove surrounding quotes if present
+	value = strings.Trim(value, "\"'")
+
+	x := &expander{e: exec, ctx: ctx, dollarVars: true}
+	return x.expand(value), nil
+}
+
+// expandArithmeticVars expands variable names (without @) in arithmetic expressions
+func expandArithmeticVars(ctx context.Context, expr string, exec *CommandExecutor) string {
+	var b strings.Builder
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		n := nameLen(runes[i:])
+		if n == 0 || (i > 0 && isNameRune(runes[i-1])) {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+
+		// Try to get the variable with @ prefix; leave names that aren't variables
+		name := string(runes[i : i+n])
+		if val, ok := exec.GetVariable(ctx, "@"+name); ok {
+			name = val
 		}
-		return match // Return original if not found
-	})
+		b.WriteString(name)
+		i += n
+	}
+	return b.String()
 }
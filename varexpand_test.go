@@ -1,11 +1,22 @@
 package consolekit
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/alexj212/consolekit/safemap"
 )
 
 func TestExpandEnvVars(t *testing.T) {
+	exec, err := NewCommandExecutor("test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	x := &expander{e: exec, ctx: context.Background(), dollarVars: true}
+
 	// Set test environment variables
 	os.Setenv("TEST_VAR", "test_value")
 	os.Setenv("HOME", "/home/test")
@@ -51,9 +62,9 @@ func TestExpandEnvVars(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := expandEnvVars(tt.input)
+			result := x.expand(tt.input)
 			if result != tt.expected {
-				t.Errorf("expandEnvVars() = %q, want %q", result, tt.expected)
+				t.Errorf("expand() = %q, want %q", result, tt.expected)
 			}
 		})
 	}
@@ -109,9 +120,10 @@ func TestExpandConsoleKitVars(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := expandConsoleKitVars(tt.input, exec)
+			x := &expander{e: exec, ctx: context.Background()}
+			result := x.expand(tt.input)
 			if result != tt.expected {
-				t.Errorf("expandConsoleKitVars() = %q, want %q", result, tt.expected)
+				t.Errorf("expand() = %q, want %q", result, tt.expected)
 			}
 		})
 	}
@@ -161,7 +173,7 @@ func TestExpandArithmeticVars(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := expandArithmeticVars(tt.input, exec)
+			result := expandArithmeticVars(context.Background(), tt.input, exec)
 			if result != tt.expected {
 				t.Errorf("expandArithmeticVars() = %q, want %q", result, tt.expected)
 			}
@@ -232,9 +244,10 @@ func TestExpandArithmetic(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := expandArithmetic(tt.input, exec)
+			x := &expander{e: exec, ctx: context.Background()}
+			result := x.expand(tt.input)
 			if result != tt.expected {
-				t.Errorf("expandArithmetic() = %q, want %q", result, tt.expected)
+				t.Errorf("expand() = %q, want %q", result, tt.expected)
 			}
 		})
 	}
@@ -382,7 +395,7 @@ func TestProcessValueExpansions(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := processValueExpansions(tt.input, exec)
+			result, err := processValueExpansions(context.Background(), tt.input, exec)
 			if err != nil {
 				t.Errorf("processValueExpansions() error = %v", err)
 				return
@@ -437,3 +450,182 @@ func TestTokenizeArithmetic(t *testing.T) {
 		})
 	}
 }
+
+func TestExpander(t *testing.T) {
+	os.Setenv("CK_TEST", "env")
+	defer os.Unsetenv("CK_TEST")
+
+	exec, err := NewCommandExecutor("test", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	exec.Variables.Set("@a", "1")
+	exec.Variables.Set("@ab", "2")
+	exec.Variables.Set("@host", "example")
+	exec.Variables.Set("@ref", "@a")
+	exec.Variables.Set("@quoted", `say "hi"`)
+	exec.Variables.Set("@?", "0")
+
+	scope := safemap.New[string, string]()
+	scope.Set("@ssh:user", "alice")
+	x := &expander{e: exec, ctx: context.Background(), scope: scope}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"prefix of another name", "print @a @ab", "print 1 2"},
+		{"single quotes", "print '@a $(print x) ${CK_TEST}'", "print '@a $(print x) ${CK_TEST}'"},
+		{"double quotes", `print "@a-@ab"`, `print "1-2"`},
+		{"escaped", `print \@a`, `print \@a`},
+		{"inside a word", "mail alice@a.com", "mail alice@a.com"},
+		{"after punctuation", "print item-@a,(@ab)", "print item-1,(2)"},
+		{"namespaced scope variable", "print @ssh:user", "print alice"},
+		{"longest defined name", "connect @host:8080", "connect example:8080"},
+		{"undefined", "print @nope @", "print @nope @"},
+		{"not expanded again", "print @ref", "print @a"},
+		{"quotes escaped in double quotes", `print "@quoted"`, `print "say \"hi\""`},
+		{"status", "print @?", "print 0"},
+		{"environment", "print @env:CK_TEST/x @env:CK_UNSET", "print env/x @env:CK_UNSET"},
+		{"braced environment", "print ${CK_TEST}-${CK_UNSET}.", "print env-."},
+		{"bare dollar name", "print $CK_TEST $5 $", "print $CK_TEST $5 $"},
+		{"command substitution", "print $(print hi)!", "print hi!"},
+		{"nested substitution", "print $(print $(print in))", "print in"},
+		{"quoted parenthesis", "print $(print ')')", "print )"},
+		{"substitution in double quotes", `print "$(print a b)"`, `print "a b"`},
+		{"unclosed substitution", "print $(print", "print $(print"},
+		{"arithmetic", "print $((@a + ab * 2))", "print 5"},
+		{"exec word", `print @exec:"print a b" c`, "print a b c"},
+		{"exec in double quotes", `print "@exec:print a b"`, `print "a b"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := x.expand(tt.input); got != tt.want {
+				t.Errorf("expand(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpanderWords(t *testing.T) {
+	os.Setenv("CK_TEST", "a;b")
+	defer os.Unsetenv("CK_TEST")
+
+	exec, err := NewCommandExecutor("test", func(exec *CommandExecutor) error {
+		exec.AddBuiltinCommands()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	exec.Variables.Set("@semi", "a; print INJECTED")
+	exec.Variables.Set("@ops", "x | y && z > f")
+	exec.Variables.Set("@quote", `it's "hi"`)
+	exec.Variables.Set("@plain", "word")
+	exec.Variables.Set("@list", `["a b","c"]`)
+	x := &expander{e: exec, ctx: context.Background(), words: true}
+
+	// Expansions of a command line stay single words, whatever they hold
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"semicolon", "print @semi", `print 'a; print INJECTED'`},
+		{"operators", "print @ops", `print 'x | y && z > f'`},
+		{"quotes", "print @quote", `print 'it'"'"'s "hi"'`},
+		{"plain", "print @plain", "print word"},
+		{"in double quotes", `print "@semi"`, `print "a; print INJECTED"`},
+		{"each element", "print @list[*]", `print 'a b' c`},
+		{"exec", `print @exec:"print 'a;b'"`, `print 'a;b'`},
+		{"env", "print @env:CK_TEST ${CK_TEST}", `print 'a;b' 'a;b'`},
+		{"substitution", "print $(print 'a|b')", `print 'a|b'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := x.expand(tt.input); got != tt.want {
+				t.Errorf("expand(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	// A value with an operator doesn't run a second command
+	out, err := exec.Execute(`let x="a; print INJECTED"`, nil)
+	if err != nil {
+		t.Fatalf("let failed: %v (%s)", err, out)
+	}
+	if out, _ := exec.Execute("print @x", nil); out != "a; print INJECTED\n" {
+		t.Errorf("print @x = %q, want the value as one word", out)
+	}
+}
+
+func TestExpandCommand(t *testing.T) {
+	exec, err := NewCommandExecutor("test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	// An alias value starting with another alias is expanded again
+	exec.aliases.Set("first", "second x")
+	exec.aliases.Set("second", "print nope")
+	if got := exec.ExpandCommand(nil, nil, "first"); got != "print nope x" {
+		t.Errorf("ExpandCommand(first) = %q, want %q", got, "print nope x")
+	}
+	if got := exec.ExpandCommand(nil, nil, "first|grep x"); got != "print nope x|grep x" {
+		t.Errorf("ExpandCommand(first|grep x) = %q", got)
+	}
+
+	// Each custom expander sees the result of the previous one
+	exec.VariableExpanders = append(exec.VariableExpanders,
+		func(s string) (string, bool) { return strings.ReplaceAll(s, "%one", "1"), false },
+		func(s string) (string, bool) { return strings.ReplaceAll(s, "%two", "2"), true },
+		func(s string) (string, bool) { return strings.ReplaceAll(s, "%three", "3"), false },
+	)
+	if got := exec.ExpandVariables(nil, nil, "%one %two %three"); got != "1 2 %three" {
+		t.Errorf("ExpandVariables() = %q, want %q", got, "1 2 %three")
+	}
+}
+
+// benchmarkExpander returns an expander over 100 defined variables.
+func benchmarkExpander(b *testing.B) *expander {
+	exec, err := NewCommandExecutor("bench", nil)
+	if err != nil {
+		b.Fatalf("Failed to create executor: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		exec.Variables.Set(fmt.Sprintf("@var%d", i), fmt.Sprintf("value%d", i))
+	}
+	return &expander{e: exec, ctx: context.Background()}
+}
+
+func BenchmarkExpand(b *testing.B) {
+	x := benchmarkExpander(b)
+	line := `print @var1 "@var50 and @var99" '@var2' item-@var7 | grep @undefined > @var3.txt`
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.expand(line)
+	}
+}
+
+func BenchmarkExpandNoReferences(b *testing.B) {
+	x := benchmarkExpander(b)
+	line := `print "nothing to expand here" | grep nothing`
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.expand(line)
+	}
+}
+
+func BenchmarkExpandArithmetic(b *testing.B) {
+	x := benchmarkExpander(b)
+	x.e.Variables.Set("@n", "41")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.expand("let n=$((n + 1))")
+	}
+}
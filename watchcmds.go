@@ -11,10 +11,6 @@ import (
 // AddWatchCommand adds a watch command that repeatedly executes a command
 func AddWatchCommand(exec *CommandExecutor) func(cmd *cobra.Command) {
 	return func(rootCmd *cobra.Command) {
-		var interval time.Duration
-		var count int
-		var clearScreen bool
-
 		var watchCmd = &cobra.Command{
 			Use:   "watch [command]",
 			Short: "Execute a command repeatedly",
@@ -29,6 +25,10 @@ Examples:
   watch --clear "date"            # Clear screen before each run`,
 			Args: cobra.ExactArgs(1),
 			Run: func(cmd *cobra.Command, args []string) {
+				interval, _ := cmd.Flags().GetDuration("interval")
+				count, _ := cmd.Flags().GetInt("count")
+				clearScreen, _ := cmd.Flags().GetBool("clear")
+
 				command := args[0]
 				iteration := 0
 
@@ -42,7 +42,7 @@ Examples:
 
 					// Clear screen if requested
 					if clearScreen {
-						fmt.Print("\033[H\033[2J")
+						cmd.Print("\033[H\033[2J")
 					}
 
 					// Print iteration info
@@ -50,7 +50,10 @@ Examples:
 					cmd.Println(strings.Repeat("-", 60))
 
 					// Execute command
-					output, err := exec.Execute(command, nil)
+					output, err := exec.ExecuteWithContext(cmd.Context(), command, nil)
+					if cmd.Context().Err() != nil {
+						return
+					}
 					if err != nil {
 						cmd.PrintErrln(fmt.Sprintf("Error: %v", err))
 					} else if output != "" {
@@ -65,8 +68,12 @@ Examples:
 						break
 					}
 
-					// Wait for interval
-					time.Sleep(interval)
+					// Wait for interval; stop when cancelled (e.g. the next pipe stage exited)
+					select {
+					case <-cmd.Context().Done():
+						return
+					case <-time.After(interval):
+					}
 				}
 
 				cmd.Println()
@@ -77,11 +84,11 @@ Examples:
 			},
 		}
 
-		watchCmd.Flags().DurationVarP(&interval, "interval", "n", 2*time.Second, "Interval between executions (e.g., 2s, 500ms, 1m)")
+		watchCmd.Flags().DurationP("interval", "n", 2*time.Second, "Interval between executions (e.g., 2s, 500ms, 1m)")
 		// no -c shorthand: host apps commonly bind a persistent -c (e.g. --config),
 		// and cobra panics when merging a colliding persistent shorthand into this set.
-		watchCmd.Flags().IntVar(&count, "count", 0, "Number of times to execute (0 = infinite)")
-		watchCmd.Flags().BoolVar(&clearScreen, "clear", false, "Clear screen before each execution")
+		watchCmd.Flags().Int("count", 0, "Number of times to execute (0 = infinite)")
+		watchCmd.Flags().Bool("clear", false, "Clear screen before each execution")
 
 		rootCmd.AddCommand(watchCmd)
 	}
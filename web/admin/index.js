@@ -316,21 +316,37 @@ function startTerminal() {
     term.write("  Ctrl+K         - Clear line after cursor\r\n");
     term.write("  Ctrl+W         - Delete word before cursor\r\n");
     term.write("  Ctrl+L         - Clear screen\r\n");
-    term.write("  Ctrl+C         - Cancel current input\r\n");
+    term.write("  Ctrl+C         - Cancel current input or interrupt running command\r\n");
     term.write("  Ctrl+D         - Logout (on empty line)\r\n");
     term.write("  Up/Down        - Command history\r\n");
     term.write("  Left/Right     - Move cursor\r\n\r\n");
     term.write("Type 'help' for command help, 'exit' or 'quit' to logout\r\n\r\n$ ");
 
+    let streamWrote = false;
+    let streamEndsWithNewline = false;
+    let commandRunning = false;
+
     socket.onmessage = function (event) {
         try {
             const msg = JSON.parse(event.data);
-            if (msg.type === "output") {
+            if (msg.type === "chunk") {
+                // Partial output, streamed while the command runs
+                const output = msg.message.replace(/\n/g, "\r\n");
+                term.write(output);
+                streamEndsWithNewline = output.endsWith("\n");
+                streamWrote = true;
+            } else if (msg.type === "done") {
+                term.write((streamWrote && !streamEndsWithNewline ? "\r\n" : "") + "$ ");
+                streamWrote = false;
+                commandRunning = false;
+            } else if (msg.type === "output") {
                 const output = msg.message.replace(/\n/g, "\r\n");
                 term.write(output + "\r\n$ ");
             } else if (msg.type === "error") {
                 const errorMsg = msg.message.replace(/\n/g, "\r\n");
                 term.write("\r\n[Error] " + errorMsg + "\r\n$ ");
+                streamWrote = false;
+                commandRunning = false;
             }
         } catch (e) {
             term.write("\r\n[Invalid JSON received]\r\n$ ");
@@ -459,9 +475,14 @@ function startTerminal() {
                     }
                     return;
 
-                case "c":  // Ctrl+C: Cancel current input
+                case "c":  // Ctrl+C: Interrupt running command or cancel current input
                 case "C":
                     domEvent.preventDefault();
+                    if (commandRunning) {
+                        term.write("^C");
+                        socket.send(JSON.stringify({ type: "interrupt" }));
+                        return;
+                    }
                     term.write("^C\r\n$ ");
                     input = "";
                     cursorPos = 0;
@@ -511,6 +532,7 @@ function startTerminal() {
 
                     try {
                         socket.send(JSON.stringify(payload));
+                        commandRunning = true;
                     } catch (e) {
                         term.write("[Send error: disconnected]\r\n");
                         cleanupAndShowLogin("Lost connection during command.");